@@ -0,0 +1,62 @@
+package jetstream
+
+import (
+	"context"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// ErrAckDeadlineNotExtendable is returned by ExtendAckDeadline when ctx is not the context of a message
+// received by StreamingSubscriber, or the message is not acked (AckNone or Ordered).
+var ErrAckDeadlineNotExtendable = errors.New("ack deadline of the message can't be extended")
+
+type ackDeadlineContextKey struct{}
+
+// ackDeadline extends the ack deadline of a message being processed.
+type ackDeadline struct {
+	msg *nats.Msg
+
+	// extended is signaled after the in progress ack is sent, so the ack timeout of the subscriber is reset
+	extended chan struct{}
+}
+
+func newAckDeadline(m *nats.Msg) *ackDeadline {
+	return &ackDeadline{msg: m, extended: make(chan struct{}, 1)}
+}
+
+func (d *ackDeadline) extend() error {
+	if err := d.msg.InProgress(); err != nil {
+		return errors.Wrap(err, "cannot send in progress ack")
+	}
+
+	select {
+	case d.extended <- struct{}{}:
+	default:
+		// the previous extension was not handled yet, it resets the ack timeout as well
+	}
+
+	return nil
+}
+
+// ExtendAckDeadline sends an in progress ack of the message being processed, so it's not redelivered
+// for another ack wait, and resets the AckWaitTimeout of the subscriber.
+//
+// ctx is the context of the message received by StreamingSubscriber, msg.Context() in the handler:
+//
+//	if err := jetstream.ExtendAckDeadline(msg.Context()); err != nil {
+//		return err
+//	}
+//
+// It can be called as often as the handler reports progress, unlike AckProgressInterval which
+// extends the deadline also when the handler is stuck. It has no effect after the message is acked or nacked.
+// ErrAckDeadlineNotExtendable is returned when ctx is not the context of a received message,
+// or when messages are not acked (AckNone or Ordered).
+func ExtendAckDeadline(ctx context.Context) error {
+	d, ok := ctx.Value(ackDeadlineContextKey{}).(*ackDeadline)
+	if !ok {
+		return ErrAckDeadlineNotExtendable
+	}
+
+	return d.extend()
+}
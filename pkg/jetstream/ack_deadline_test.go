@@ -0,0 +1,78 @@
+package jetstream_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+)
+
+func TestExtendAckDeadline(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:    "durable",
+		AckWaitTimeout: time.Millisecond * 300,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	publishMessages(t, pub, topic, 1)
+
+	var msg *message.Message
+	select {
+	case msg = <-messages:
+	case <-time.After(time.Second * 5):
+		t.Fatal("message not received")
+	}
+
+	// processing takes longer than AckWaitTimeout, but reports progress
+	for i := 0; i < 10; i++ {
+		require.NoError(t, jetstream.ExtendAckDeadline(msg.Context()))
+
+		select {
+		case redelivered := <-messages:
+			t.Fatalf("message %s was redelivered while in progress", redelivered.UUID)
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+
+	msg.Ack()
+
+	require.Eventually(t, func() bool {
+		info, err := newJetstream(t).ConsumerInfo(topic, "durable")
+		require.NoError(t, err)
+		return info.NumAckPending == 0 && info.NumRedelivered == 0
+	}, time.Second*5, time.Millisecond*10, "message should be acked")
+}
+
+func TestExtendAckDeadline_not_extendable(t *testing.T) {
+	assert.ErrorIs(t, jetstream.ExtendAckDeadline(context.Background()), jetstream.ErrAckDeadlineNotExtendable)
+
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		AckPolicy: jetstream.AckNone,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	publishMessages(t, pub, topic, 1)
+
+	select {
+	case msg := <-messages:
+		assert.ErrorIs(t, jetstream.ExtendAckDeadline(msg.Context()), jetstream.ErrAckDeadlineNotExtendable)
+	case <-time.After(time.Second * 5):
+		t.Fatal("message not received")
+	}
+}
@@ -0,0 +1,29 @@
+package jetstream
+
+import (
+	"fmt"
+)
+
+// AckErrorsBufferSize is the size of the buffer of StreamingSubscriber.AckErrors channel.
+// When the buffer is full, the oldest error is dropped.
+const AckErrorsBufferSize = 100
+
+// AckError is an error of sending ack, nak or other acknowledgement of the received message to NATS.
+type AckError struct {
+	// MessageUUID is the UUID of the Watermill message.
+	MessageUUID string
+
+	// Topic is the NATS subject the message was received from.
+	Topic string
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e AckError) Error() string {
+	return fmt.Sprintf("acknowledgement of message %s from %s failed: %s", e.MessageUUID, e.Topic, e.Err)
+}
+
+func (e AckError) Unwrap() error {
+	return e.Err
+}
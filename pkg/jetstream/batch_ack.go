@@ -0,0 +1,102 @@
+package jetstream
+
+import (
+	"sync"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+
+	"github.com/ThreeDotsLabs/watermill"
+)
+
+// ackBatch collects results of messages fetched together with BatchAck.
+type ackBatch struct {
+	lock    sync.Mutex
+	msgs    []*nats.Msg
+	uuids   []string
+	acked   []bool
+	pending int
+
+	// done is closed after the batch is acked
+	done chan struct{}
+}
+
+func newAckBatch(msgs []*nats.Msg) *ackBatch {
+	return &ackBatch{
+		msgs:    msgs,
+		uuids:   make([]string, len(msgs)),
+		acked:   make([]bool, len(msgs)),
+		pending: len(msgs),
+		done:    make(chan struct{}),
+	}
+}
+
+// result records whether m was acked, it returns true when it's the last result of the batch.
+func (b *ackBatch) result(m *nats.Msg, msgUUID string, acked bool) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for i, batchMsg := range b.msgs {
+		if batchMsg == m {
+			b.uuids[i] = msgUUID
+			b.acked[i] = acked
+			b.pending--
+			break
+		}
+	}
+
+	return b.pending == 0
+}
+
+// recordBatchResult records the result of m, the batch is acked when it's the last result.
+func (s *StreamingSubscriber) recordBatchResult(
+	batch *ackBatch,
+	m *nats.Msg,
+	msgUUID string,
+	acked bool,
+	logFields watermill.LogFields,
+) {
+	if !batch.result(m, msgUUID, acked) {
+		return
+	}
+
+	s.ackBatch(batch, logFields)
+	close(batch.done)
+}
+
+// ackBatch acks messages of batch up to the first one which was not acked, with a single ack of the last of them.
+//
+// The first message which was not acked and all messages after it are nacked without delay, so they are redelivered
+// before new messages are fetched. Otherwise, the ack of a later message would acknowledge them as well.
+func (s *StreamingSubscriber) ackBatch(batch *ackBatch, logFields watermill.LogFields) {
+	acked := len(batch.msgs)
+	for i, ok := range batch.acked {
+		if !ok {
+			acked = i
+			break
+		}
+	}
+
+	logFields = logFields.Add(watermill.LogFields{"batch_size": len(batch.msgs), "batch_acked": acked})
+
+	if acked > 0 {
+		if err := s.ack(batch.msgs[acked-1]); err != nil {
+			err = errors.Wrap(err, "cannot send batch ack")
+			for i := 0; i < acked; i++ {
+				s.ackFailed(batch.msgs[i], batch.uuids[i], err, logFields)
+			}
+		} else {
+			s.logger.Trace("Batch acked", logFields)
+		}
+	}
+
+	for i := acked; i < len(batch.msgs); i++ {
+		// terminated messages are not redelivered, the nak is ignored then
+		if err := batch.msgs[i].Nak(); err != nil {
+			s.ackFailed(batch.msgs[i], batch.uuids[i], errors.Wrap(err, "cannot send nak"), logFields)
+		}
+	}
+	if acked < len(batch.msgs) {
+		s.logger.Debug("Batch not acked completely, messages from the first not acked one are nacked", logFields)
+	}
+}
@@ -0,0 +1,95 @@
+package jetstream_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+)
+
+func newBatchAckSubscriber(t *testing.T, topic string) <-chan *message.Message {
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:    "durable",
+		ConsumerType:   jetstream.PullConsumer,
+		AckPolicy:      jetstream.AckAll,
+		BatchAck:       true,
+		FetchBatchSize: 3,
+		// redelivered messages don't fill the batch, so the fetch waits until it times out
+		FetchTimeout: time.Millisecond * 500,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	return messages
+}
+
+func receiveMessage(t *testing.T, messages <-chan *message.Message) *message.Message {
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(time.Second * 5):
+		t.Fatal("message not received")
+		return nil
+	}
+}
+
+func TestBatchAck(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	published := publishMessages(t, pub, topic, 3)
+	messages := newBatchAckSubscriber(t, topic)
+
+	for i := 0; i < 2; i++ {
+		msg := receiveMessage(t, messages)
+		assert.Equal(t, published[i].UUID, msg.UUID)
+		msg.Ack()
+	}
+	last := receiveMessage(t, messages)
+
+	info, err := newJetstream(t).ConsumerInfo(topic, "durable")
+	require.NoError(t, err)
+	assert.Equal(t, 3, info.NumAckPending, "acks should wait for the whole batch")
+
+	last.Ack()
+
+	require.Eventually(t, func() bool {
+		info, err := newJetstream(t).ConsumerInfo(topic, "durable")
+		require.NoError(t, err)
+		return info.NumAckPending == 0 && info.AckFloor.Consumer == 3
+	}, time.Second*5, time.Millisecond*10, "batch should be acked")
+}
+
+func TestBatchAck_nack(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	published := publishMessages(t, pub, topic, 3)
+	messages := newBatchAckSubscriber(t, topic)
+
+	receiveMessage(t, messages).Ack()
+	receiveMessage(t, messages).Nack()
+	receiveMessage(t, messages).Ack()
+
+	// the first message is acked, the nacked one and the one after it are redelivered
+	redelivered := map[string]struct{}{}
+	for i := 0; i < 2; i++ {
+		msg := receiveMessage(t, messages)
+		redelivered[msg.UUID] = struct{}{}
+		msg.Ack()
+	}
+	assert.Equal(t, map[string]struct{}{published[1].UUID: {}, published[2].UUID: {}}, redelivered)
+
+	require.Eventually(t, func() bool {
+		info, err := newJetstream(t).ConsumerInfo(topic, "durable")
+		require.NoError(t, err)
+		return info.NumAckPending == 0
+	}, time.Second*5, time.Millisecond*10, "redelivered messages should be acked")
+}
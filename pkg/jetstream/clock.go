@@ -0,0 +1,95 @@
+package jetstream
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the current time and timers to the subscriber, so timeouts can be triggered
+// deterministically in tests with a fake implementation.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After waits for the duration to elapse and then sends the current time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+
+	// NewTimer creates a Timer which sends the current time on its channel after the duration elapses.
+	// Unlike After, the timer can be stopped when it's not needed anymore.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a timer created by Clock.NewTimer, like time.Timer.
+type Timer interface {
+	// C returns the channel on which the time is sent when the timer fires.
+	C() <-chan time.Time
+
+	// Stop prevents the timer from firing, it returns false when the timer already fired or was stopped.
+	Stop() bool
+}
+
+// RealClock is the Clock using the system time, it is used when StreamingSubscriberConfig.Clock is nil.
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (RealClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+// realTimer adapts time.Timer to Timer.
+type realTimer struct {
+	timer *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+func (t realTimer) Stop() bool {
+	return t.timer.Stop()
+}
+
+// newDeadline returns a channel closed after d elapses on clock, so the same deadline can bound more than one wait.
+// The returned function releases the deadline when it's not needed anymore.
+func newDeadline(clock Clock, d time.Duration) (<-chan struct{}, func()) {
+	deadline := make(chan struct{})
+	stop := make(chan struct{})
+
+	timer := clock.NewTimer(d)
+	go func() {
+		select {
+		case <-timer.C():
+			close(deadline)
+		case <-stop:
+			timer.Stop()
+		}
+	}()
+
+	var once sync.Once
+	return deadline, func() {
+		once.Do(func() { close(stop) })
+	}
+}
+
+// waitGroupTimeout waits for wg until timeout is closed, it returns true when timed out.
+func waitGroupTimeout(wg *sync.WaitGroup, timeout <-chan struct{}) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return false
+	case <-timeout:
+		return true
+	}
+}
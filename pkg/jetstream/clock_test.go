@@ -0,0 +1,236 @@
+package jetstream_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+)
+
+// fakeClock is a jetstream.Clock which moves forward only with Advance.
+type fakeClock struct {
+	lock   sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock    *fakeClock
+	deadline time.Time
+	c        chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+
+	for i, timer := range t.clock.timers {
+		if timer == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) jetstream.Timer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	timer := &fakeTimer{clock: c, deadline: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		timer.c <- c.now
+		return timer
+	}
+
+	c.timers = append(c.timers, timer)
+	return timer
+}
+
+// Advance moves the clock forward by d and fires timers which are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = c.now.Add(d)
+
+	var pending []*fakeTimer
+	for _, timer := range c.timers {
+		if timer.deadline.After(c.now) {
+			pending = append(pending, timer)
+			continue
+		}
+		timer.c <- c.now
+	}
+	c.timers = pending
+}
+
+// Waiters returns the number of timers which didn't fire and weren't stopped yet.
+func (c *fakeClock) Waiters() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.timers)
+}
+
+func TestClock_ack_wait_timeout(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+	clock := newFakeClock()
+
+	// with AckWaitJitter, timed out messages are nacked by the subscriber, so they are redelivered
+	// without waiting for the consumer ack wait
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:    "durable",
+		AckWaitTimeout: time.Hour,
+		AckWaitJitter:  time.Minute,
+		Clock:          clock,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	published := publishMessages(t, pub, topic, 1)
+
+	select {
+	case <-messages:
+		// not acked, so it times out
+	case <-time.After(time.Second * 5):
+		t.Fatal("message not received")
+	}
+
+	select {
+	case redelivered := <-messages:
+		t.Fatalf("message %s was redelivered before the ack timeout", redelivered.UUID)
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	require.Eventually(t, func() bool {
+		return clock.Waiters() == 1
+	}, time.Second*5, time.Millisecond*10, "ack timeout should be started")
+	clock.Advance(time.Hour + time.Minute)
+
+	redelivered := receiveMessages(t, messages, 1)[0]
+	assert.Equal(t, published[0].UUID, redelivered.UUID)
+}
+
+func TestClock_close_timeout(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+	clock := newFakeClock()
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:    "durable",
+		AckWaitTimeout: time.Hour * 2,
+		CloseTimeout:   time.Hour,
+		DrainOnClose:   true,
+		Clock:          clock,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	publishMessages(t, pub, topic, 1)
+	// not acked, so Close times out
+	receiveMessage(t, messages)
+
+	closed := make(chan error)
+	go func() {
+		closed <- sub.Close()
+	}()
+
+	// the ack timeout and the close deadline
+	require.Eventually(t, func() bool {
+		return clock.Waiters() == 2
+	}, time.Second*5, time.Millisecond*10, "close timeout should be started")
+	clock.Advance(time.Hour)
+
+	// draining and waiting for outputs share the deadline, so Close doesn't wait for another CloseTimeout
+	select {
+	case err := <-closed:
+		require.NoError(t, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("Close should return after CloseTimeout")
+	}
+	assert.LessOrEqual(t, clock.Waiters(), 1, "Close should not start another CloseTimeout")
+}
+
+func TestClock_ack_timer_stopped(t *testing.T) {
+	testCases := []struct {
+		Name string
+		// Finish ends processing of msg before the ack timeout.
+		Finish func(msg *message.Message, cancel context.CancelFunc)
+	}{
+		{
+			Name: "ack",
+			Finish: func(msg *message.Message, cancel context.CancelFunc) {
+				msg.Ack()
+			},
+		},
+		{
+			Name: "context_cancelled",
+			Finish: func(msg *message.Message, cancel context.CancelFunc) {
+				cancel()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			topic := newStream(t)
+			pub := newPublisher(t)
+			clock := newFakeClock()
+
+			sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+				AckWaitTimeout: time.Hour,
+				Clock:          clock,
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			messages, err := sub.Subscribe(ctx, topic)
+			require.NoError(t, err)
+
+			publishMessages(t, pub, topic, 1)
+			msg := receiveMessage(t, messages)
+
+			require.Eventually(t, func() bool {
+				return clock.Waiters() == 1
+			}, time.Second*5, time.Millisecond*10, "ack timeout should be started")
+
+			tc.Finish(msg, cancel)
+
+			assert.Eventually(t, func() bool {
+				return clock.Waiters() == 0
+			}, time.Second*5, time.Millisecond*10, "ack timer should be stopped")
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package jetstream
+
+import (
+	"sync"
+
+	nats "github.com/nats-io/nats.go"
+)
+
+// connHandlers dispatches callbacks of a connection to all subscribers using it.
+// The connection can be shared, so handlers set on it before the first subscriber are still called
+// and they are restored when the last subscriber is closed.
+type connHandlers struct {
+	reconnected nats.ConnHandler
+	asyncError  nats.ErrHandler
+
+	subscribers []*StreamingSubscriber
+}
+
+var (
+	connHandlersLock sync.Mutex
+	connHandlersMap  = map[*nats.Conn]*connHandlers{}
+)
+
+// addConnSubscriber installs handlers of conn on the first call for conn and makes s receive its callbacks.
+func addConnSubscriber(conn *nats.Conn, s *StreamingSubscriber) {
+	connHandlersLock.Lock()
+	defer connHandlersLock.Unlock()
+
+	handlers, ok := connHandlersMap[conn]
+	if !ok {
+		handlers = &connHandlers{
+			reconnected: conn.Opts.ReconnectedCB,
+			asyncError:  conn.Opts.AsyncErrorCB,
+		}
+		connHandlersMap[conn] = handlers
+
+		conn.SetReconnectHandler(func(c *nats.Conn) {
+			if handlers.reconnected != nil {
+				handlers.reconnected(c)
+			}
+			for _, s := range connSubscribers(c) {
+				go s.resubscribe()
+			}
+		})
+		conn.SetErrorHandler(func(c *nats.Conn, sub *nats.Subscription, err error) {
+			if handlers.asyncError != nil {
+				handlers.asyncError(c, sub, err)
+			}
+			for _, s := range connSubscribers(c) {
+				s.recordSubscriptionError(sub, err)
+			}
+		})
+	}
+
+	handlers.subscribers = append(handlers.subscribers, s)
+}
+
+// removeConnSubscriber stops callbacks of conn to s, handlers of conn are restored when s was the last subscriber.
+func removeConnSubscriber(conn *nats.Conn, s *StreamingSubscriber) {
+	connHandlersLock.Lock()
+	defer connHandlersLock.Unlock()
+
+	handlers, ok := connHandlersMap[conn]
+	if !ok {
+		return
+	}
+
+	for i, subscriber := range handlers.subscribers {
+		if subscriber == s {
+			handlers.subscribers = append(handlers.subscribers[:i], handlers.subscribers[i+1:]...)
+			break
+		}
+	}
+	if len(handlers.subscribers) > 0 {
+		return
+	}
+
+	delete(connHandlersMap, conn)
+	conn.SetReconnectHandler(handlers.reconnected)
+	conn.SetErrorHandler(handlers.asyncError)
+}
+
+// connSubscribers returns subscribers receiving callbacks of conn.
+func connSubscribers(conn *nats.Conn) []*StreamingSubscriber {
+	connHandlersLock.Lock()
+	defer connHandlersLock.Unlock()
+
+	handlers, ok := connHandlersMap[conn]
+	if !ok {
+		return nil
+	}
+
+	return append([]*StreamingSubscriber(nil), handlers.subscribers...)
+}
@@ -1,6 +1,37 @@
 package jetstream
 
-import nats "github.com/nats-io/nats.go"
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotConnected is returned by Ping when the NATS connection is not connected,
+	// for example when it is reconnecting or closed, and by JetStream when it is closed.
+	ErrNotConnected = errors.New("not connected to NATS")
+
+	// ErrPingTimeout is returned by Ping when the NATS server didn't respond before ctx was done.
+	ErrPingTimeout = errors.New("NATS ping timed out")
+)
+
+// ConnectionProvider creates NATS connections used by publishers and subscribers.
+type ConnectionProvider interface {
+	Connect() (*nats.Conn, error)
+}
+
+// ConnectionProviderFunc is a function implementing ConnectionProvider.
+type ConnectionProviderFunc func() (*nats.Conn, error)
+
+func (f ConnectionProviderFunc) Connect() (*nats.Conn, error) {
+	return f()
+}
 
 type NatsConnConfig struct {
 	// URL is the NATS URL.
@@ -29,3 +60,201 @@ func NewJetstreamConnection(config *NatsConnConfig) (nats.JetStreamContext, erro
 func NewNatsConnection(config *NatsConnConfig) (*nats.Conn, error) {
 	return nats.Connect(config.URL, config.NatsOptions...)
 }
+
+// serverURLs joins url and urls into the comma separated list of servers accepted by nats.Connect.
+func serverURLs(url string, urls []string) (string, error) {
+	var servers []string
+	if url != "" {
+		servers = append(servers, url)
+	}
+
+	for _, u := range urls {
+		if strings.TrimSpace(u) == "" {
+			return "", errors.New("URLs cannot contain empty URL")
+		}
+		servers = append(servers, u)
+	}
+
+	return strings.Join(servers, ","), nil
+}
+
+// connectWithProvider creates the connection with provider.
+func connectWithProvider(provider ConnectionProvider) (*nats.Conn, error) {
+	conn, err := provider.Connect()
+	if err != nil {
+		return nil, withKind(ErrConnect, errors.Wrap(err, "cannot connect to NATS with ConnectionProvider"))
+	}
+	if conn == nil {
+		return nil, withKind(ErrConnect, errors.New("ConnectionProvider returned no connection"))
+	}
+
+	return conn, nil
+}
+
+// defaultClientName returns the connection name used when ClientName is empty.
+func defaultClientName() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "watermill-jetstream"
+	}
+
+	return "watermill-jetstream-" + hostname
+}
+
+// credentialsOption returns an option authenticating with the NATS credentials file (JWT and NKey seed).
+func credentialsOption(credentialsFile string) (nats.Option, error) {
+	if _, err := os.Stat(credentialsFile); err != nil {
+		return nil, errors.Wrapf(err, "cannot use credentials file %s", credentialsFile)
+	}
+
+	return nats.UserCredentials(credentialsFile), nil
+}
+
+// nkeyOption returns an option authenticating with the NKey user seed, the nonce is signed with the seed
+// kept in memory. The seed is not included in errors, so it is not logged.
+func nkeyOption(seed []byte) (nats.Option, error) {
+	kp, err := nkeys.FromSeed(bytes.TrimSpace(seed))
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed NKey seed")
+	}
+
+	publicKey, err := kp.PublicKey()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get public key of NKey seed")
+	}
+	if !nkeys.IsValidPublicUserKey(publicKey) {
+		return nil, errors.New("NKey seed is not a user seed")
+	}
+
+	return nats.Nkey(publicKey, kp.Sign), nil
+}
+
+// connectionAuth holds authentication options of publisher and subscriber configs.
+type connectionAuth struct {
+	credentialsFile string
+	token           string
+	username        string
+	password        string
+	nkeySeed        string
+	nkeySeedFile    string
+}
+
+// validate checks that authentication methods which cannot be combined are not used together.
+// The values are not included in errors, so they are not logged.
+func (a connectionAuth) validate(withProvider bool) error {
+	nkey := a.nkeySeed != "" || a.nkeySeedFile != ""
+
+	if a.token != "" && (a.username != "" || a.password != "") {
+		return errors.New("Token cannot be used with Username and Password")
+	}
+	if a.password != "" && a.username == "" {
+		return errors.New("Password requires Username")
+	}
+	if a.nkeySeed != "" && a.nkeySeedFile != "" {
+		return errors.New("NKeySeed cannot be used with NKeySeedFile")
+	}
+	if nkey && (a.credentialsFile != "" || a.token != "" || a.username != "") {
+		return errors.New("NKeySeed and NKeySeedFile cannot be used with CredentialsFile, Token and Username")
+	}
+	if withProvider && (a.token != "" || a.username != "" || nkey) {
+		return errors.New("Token, Username, Password, NKeySeed and NKeySeedFile cannot be used with ConnectionProvider")
+	}
+
+	return nil
+}
+
+// options returns options authenticating with methods which are set.
+func (a connectionAuth) options() ([]nats.Option, error) {
+	var options []nats.Option
+
+	if a.credentialsFile != "" {
+		credentials, err := credentialsOption(a.credentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, credentials)
+	}
+
+	if a.token != "" {
+		options = append(options, nats.Token(a.token))
+	}
+	if a.username != "" {
+		options = append(options, nats.UserInfo(a.username, a.password))
+	}
+
+	seed := []byte(a.nkeySeed)
+	if a.nkeySeedFile != "" {
+		var err error
+		seed, err = os.ReadFile(a.nkeySeedFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot read NKeySeedFile")
+		}
+	}
+	if len(seed) > 0 {
+		nkey, err := nkeyOption(seed)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, nkey)
+	}
+
+	return options, nil
+}
+
+// handlerOptions returns options registering connection event handlers which are not nil,
+// so handlers passed with NatsOptions are not overridden.
+func handlerOptions(onDisconnect nats.ConnErrHandler, onReconnect, onClosed nats.ConnHandler) []nats.Option {
+	var options []nats.Option
+
+	if onDisconnect != nil {
+		options = append(options, nats.DisconnectErrHandler(onDisconnect))
+	}
+	if onReconnect != nil {
+		options = append(options, nats.ReconnectHandler(onReconnect))
+	}
+	if onClosed != nil {
+		options = append(options, nats.ClosedHandler(onClosed))
+	}
+
+	return options
+}
+
+// pingOptions returns options overriding nats.go defaults of pings which are not zero.
+func pingOptions(interval time.Duration, maxPingsOut int) []nats.Option {
+	var options []nats.Option
+
+	if interval > 0 {
+		options = append(options, nats.PingInterval(interval))
+	}
+	if maxPingsOut > 0 {
+		options = append(options, nats.MaxPingsOutstanding(maxPingsOut))
+	}
+
+	return options
+}
+
+// ping checks that conn is connected and makes a round-trip to the NATS server.
+// When ctx has no deadline, the connection timeout (nats.Timeout) is used.
+func ping(ctx context.Context, conn *nats.Conn) error {
+	if !conn.IsConnected() {
+		return errors.Wrapf(ErrNotConnected, "connection is %s", conn.Status())
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conn.Opts.Timeout)
+		defer cancel()
+	}
+
+	err := conn.FlushWithContext(ctx)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, nats.ErrConnectionClosed):
+		return errors.Wrap(ErrNotConnected, err.Error())
+	case ctx.Err() != nil:
+		return errors.Wrap(ErrPingTimeout, ctx.Err().Error())
+	default:
+		return errors.Wrap(err, "cannot flush connection")
+	}
+}
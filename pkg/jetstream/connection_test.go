@@ -0,0 +1,801 @@
+package jetstream_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+)
+
+// natsProxy forwards TCP connections to the NATS server,
+// allowing tests to simulate dropped connections.
+type natsProxy struct {
+	listener net.Listener
+	target   string
+
+	connsLock sync.Mutex
+	conns     []net.Conn
+
+	// refusing closes new connections, so clients can't reconnect
+	refusing atomic.Bool
+}
+
+func newNatsProxy(t *testing.T) *natsProxy {
+	natsURL, err := url.Parse(getNatsURL())
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	p := &natsProxy{listener: listener, target: natsURL.Host}
+	go p.serve()
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+		p.DropConnections()
+	})
+
+	return p
+}
+
+func (p *natsProxy) URL() string {
+	return "nats://" + p.listener.Addr().String()
+}
+
+func (p *natsProxy) serve() {
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		if p.refusing.Load() {
+			_ = client.Close()
+			continue
+		}
+
+		server, err := net.Dial("tcp", p.target)
+		if err != nil {
+			_ = client.Close()
+			continue
+		}
+
+		p.connsLock.Lock()
+		p.conns = append(p.conns, client, server)
+		p.connsLock.Unlock()
+
+		go func() { _, _ = io.Copy(server, client) }()
+		go func() { _, _ = io.Copy(client, server) }()
+	}
+}
+
+// DropConnections closes all proxied connections, clients are able to reconnect.
+func (p *natsProxy) DropConnections() {
+	p.connsLock.Lock()
+	defer p.connsLock.Unlock()
+
+	for _, conn := range p.conns {
+		_ = conn.Close()
+	}
+	p.conns = nil
+}
+
+// Refuse drops connections and refuses new ones until Accept is called.
+func (p *natsProxy) Refuse() {
+	p.refusing.Store(true)
+	p.DropConnections()
+}
+
+// Accept makes the proxy accept new connections after Refuse.
+func (p *natsProxy) Accept() {
+	p.refusing.Store(false)
+}
+
+func TestURLs_failover(t *testing.T) {
+	topic := newStream(t)
+
+	first := newNatsProxy(t)
+	second := newNatsProxy(t)
+
+	reconnected := make(chan string, 1)
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URLs:      []string{first.URL(), second.URL()},
+		Marshaler: jetstream.GobMarshaler{},
+		NatsOptions: []nats.Option{
+			nats.DontRandomize(),
+			nats.ReconnectWait(time.Millisecond * 10),
+		},
+		OnReconnect: func(conn *nats.Conn) {
+			reconnected <- conn.ConnectedUrl()
+		},
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+	defer func() {
+		_ = pub.Close()
+	}()
+
+	require.NoError(t, pub.Publish(topic, message.NewMessage(watermill.NewUUID(), nil)))
+
+	// the first server goes down
+	require.NoError(t, first.listener.Close())
+	first.DropConnections()
+
+	select {
+	case url := <-reconnected:
+		assert.Equal(t, second.URL(), url)
+	case <-time.After(time.Second * 5):
+		t.Fatal("not reconnected to the second server")
+	}
+
+	require.NoError(t, pub.Publish(topic, message.NewMessage(watermill.NewUUID(), nil)))
+}
+
+func TestURLs_empty_url(t *testing.T) {
+	_, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URLs:      []string{getNatsURL(), ""},
+		Marshaler: jetstream.GobMarshaler{},
+	}, nil)
+	assert.Error(t, err)
+
+	_, err = jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+		URLs:        []string{getNatsURL(), " "},
+		Unmarshaler: jetstream.GobMarshaler{},
+	}, nil)
+	assert.Error(t, err)
+}
+
+func TestCredentialsFile_missing(t *testing.T) {
+	credentialsFile := filepath.Join(t.TempDir(), "missing.creds")
+
+	_, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:             getNatsURL(),
+		CredentialsFile: credentialsFile,
+		Marshaler:       jetstream.GobMarshaler{},
+	}, nil)
+	assert.Error(t, err)
+
+	_, err = jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+		URL:             getNatsURL(),
+		CredentialsFile: credentialsFile,
+		Unmarshaler:     jetstream.GobMarshaler{},
+	}, nil)
+	assert.Error(t, err)
+}
+
+func TestAuth(t *testing.T) {
+	// auth options are read in OnClosed, as connections are not exposed
+	opts := make(chan nats.Options, 2)
+	onClosed := func(conn *nats.Conn) {
+		opts <- conn.Opts
+	}
+
+	// the test server doesn't require auth, so it accepts any credentials
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:       getNatsURL(),
+		Marshaler: jetstream.GobMarshaler{},
+		Token:     "secret-token",
+		OnClosed:  onClosed,
+	}, nil)
+	require.NoError(t, err)
+	require.NoError(t, pub.Close())
+
+	select {
+	case o := <-opts:
+		assert.Equal(t, "secret-token", o.Token)
+	case <-time.After(time.Second * 5):
+		t.Fatal("OnClosed was not called")
+	}
+
+	sub, err := jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+		URL:         getNatsURL(),
+		Unmarshaler: jetstream.GobMarshaler{},
+		Username:    "user",
+		Password:    "password",
+		OnClosed:    onClosed,
+	}, nil)
+	require.NoError(t, err)
+	require.NoError(t, sub.Close())
+
+	select {
+	case o := <-opts:
+		assert.Equal(t, "user", o.User)
+		assert.Equal(t, "password", o.Password)
+	case <-time.After(time.Second * 5):
+		t.Fatal("OnClosed was not called")
+	}
+}
+
+func TestAuth_invalid(t *testing.T) {
+	testCases := []struct {
+		Name               string
+		Token              string
+		Username           string
+		Password           string
+		ConnectionProvider jetstream.ConnectionProvider
+	}{
+		{
+			Name:     "token_with_username",
+			Token:    "secret-token",
+			Username: "user",
+			Password: "password",
+		},
+		{
+			Name:     "password_without_username",
+			Password: "password",
+		},
+		{
+			Name:  "connection_provider",
+			Token: "secret-token",
+			ConnectionProvider: jetstream.ConnectionProviderFunc(func() (*nats.Conn, error) {
+				return nil, errors.New("connection should not be created")
+			}),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+				URL:                getNatsURL(),
+				Marshaler:          jetstream.GobMarshaler{},
+				Token:              tc.Token,
+				Username:           tc.Username,
+				Password:           tc.Password,
+				ConnectionProvider: tc.ConnectionProvider,
+			}, nil)
+			assert.ErrorIs(t, err, jetstream.ErrInvalidConfig)
+			if err != nil {
+				assert.NotContains(t, err.Error(), "secret-token", "credentials should not be in errors")
+			}
+
+			_, err = jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+				URL:                getNatsURL(),
+				Unmarshaler:        jetstream.GobMarshaler{},
+				Token:              tc.Token,
+				Username:           tc.Username,
+				Password:           tc.Password,
+				ConnectionProvider: tc.ConnectionProvider,
+			}, nil)
+			assert.ErrorIs(t, err, jetstream.ErrInvalidConfig)
+		})
+	}
+}
+
+func TestNKeySeed(t *testing.T) {
+	kp, err := nkeys.CreateUser()
+	require.NoError(t, err)
+	seed, err := kp.Seed()
+	require.NoError(t, err)
+	publicKey, err := kp.PublicKey()
+	require.NoError(t, err)
+
+	// the test server doesn't support NKeys, so the option is applied directly
+	option, err := jetstream.NKeyOption(append(seed, '\n'))
+	require.NoError(t, err)
+
+	var opts nats.Options
+	require.NoError(t, option(&opts))
+	assert.Equal(t, publicKey, opts.Nkey)
+
+	nonce := []byte("nonce")
+	sig, err := opts.SignatureCB(nonce)
+	require.NoError(t, err)
+	assert.NoError(t, kp.Verify(nonce, sig), "the nonce should be signed with the seed")
+}
+
+func TestNKeySeed_invalid(t *testing.T) {
+	account, err := nkeys.CreateAccount()
+	require.NoError(t, err)
+	accountSeed, err := account.Seed()
+	require.NoError(t, err)
+
+	testCases := []struct {
+		Name         string
+		NKeySeed     string
+		NKeySeedFile string
+		Token        string
+	}{
+		{
+			Name:     "malformed",
+			NKeySeed: "SUAMALFORMEDSEED",
+		},
+		{
+			Name:     "account_seed",
+			NKeySeed: string(accountSeed),
+		},
+		{
+			Name:         "missing_file",
+			NKeySeedFile: filepath.Join(t.TempDir(), "missing.nk"),
+		},
+		{
+			Name:     "with_token",
+			NKeySeed: string(accountSeed),
+			Token:    "secret-token",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+				URL:          getNatsURL(),
+				Marshaler:    jetstream.GobMarshaler{},
+				NKeySeed:     tc.NKeySeed,
+				NKeySeedFile: tc.NKeySeedFile,
+				Token:        tc.Token,
+			}, nil)
+			assert.ErrorIs(t, err, jetstream.ErrInvalidConfig)
+			if err != nil && tc.NKeySeed != "" {
+				assert.NotContains(t, err.Error(), tc.NKeySeed, "the seed should not be in errors")
+			}
+
+			_, err = jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+				URL:          getNatsURL(),
+				Unmarshaler:  jetstream.GobMarshaler{},
+				NKeySeed:     tc.NKeySeed,
+				NKeySeedFile: tc.NKeySeedFile,
+				Token:        tc.Token,
+			}, nil)
+			assert.ErrorIs(t, err, jetstream.ErrInvalidConfig)
+		})
+	}
+}
+
+func TestPingOptions(t *testing.T) {
+	testCases := []struct {
+		Name                string
+		PingInterval        time.Duration
+		MaxPingsOutstanding int
+		ExpectedErr         bool
+	}{
+		{
+			Name:                "valid",
+			PingInterval:        time.Second * 10,
+			MaxPingsOutstanding: 5,
+		},
+		{
+			Name:         "negative_ping_interval",
+			PingInterval: -time.Second,
+			ExpectedErr:  true,
+		},
+		{
+			Name:                "negative_max_pings_outstanding",
+			MaxPingsOutstanding: -1,
+			ExpectedErr:         true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+				URL:                 getNatsURL(),
+				Marshaler:           jetstream.GobMarshaler{},
+				PingInterval:        tc.PingInterval,
+				MaxPingsOutstanding: tc.MaxPingsOutstanding,
+			}, nil)
+			if tc.ExpectedErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.NoError(t, pub.Close())
+			}
+
+			sub, err := jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+				URL:                 getNatsURL(),
+				Unmarshaler:         jetstream.GobMarshaler{},
+				PingInterval:        tc.PingInterval,
+				MaxPingsOutstanding: tc.MaxPingsOutstanding,
+			}, nil)
+			if tc.ExpectedErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.NoError(t, sub.Close())
+			}
+		})
+	}
+}
+
+func TestClientName(t *testing.T) {
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	testCases := []struct {
+		Name         string
+		ClientName   string
+		NatsOptions  []nats.Option
+		ExpectedName string
+	}{
+		{
+			Name:         "default",
+			ExpectedName: "watermill-jetstream-" + hostname,
+		},
+		{
+			Name:         "client_name",
+			ClientName:   "orders-service",
+			ExpectedName: "orders-service",
+		},
+		{
+			Name:         "nats_options",
+			NatsOptions:  []nats.Option{nats.Name("from-options")},
+			ExpectedName: "from-options",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			// the connection name is read in OnClosed, as connections are not exposed
+			names := make(chan string, 2)
+			onClosed := func(conn *nats.Conn) {
+				names <- conn.Opts.Name
+			}
+
+			pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+				URL:         getNatsURL(),
+				Marshaler:   jetstream.GobMarshaler{},
+				ClientName:  tc.ClientName,
+				NatsOptions: tc.NatsOptions,
+				OnClosed:    onClosed,
+			}, nil)
+			require.NoError(t, err)
+			require.NoError(t, pub.Close())
+
+			sub, err := jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+				URL:         getNatsURL(),
+				Unmarshaler: jetstream.GobMarshaler{},
+				ClientName:  tc.ClientName,
+				NatsOptions: tc.NatsOptions,
+				OnClosed:    onClosed,
+			}, nil)
+			require.NoError(t, err)
+			require.NoError(t, sub.Close())
+
+			for i := 0; i < 2; i++ {
+				select {
+				case name := <-names:
+					assert.Equal(t, tc.ExpectedName, name)
+				case <-time.After(time.Second * 5):
+					t.Fatal("OnClosed was not called")
+				}
+			}
+		})
+	}
+}
+
+func TestConnectionHandlers(t *testing.T) {
+	proxy := newNatsProxy(t)
+
+	disconnected := make(chan struct{}, 1)
+	reconnected := make(chan struct{}, 1)
+	closed := make(chan struct{}, 1)
+
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:         proxy.URL(),
+		NatsOptions: []nats.Option{nats.ReconnectWait(time.Millisecond * 10)},
+		Marshaler:   jetstream.GobMarshaler{},
+		OnDisconnect: func(_ *nats.Conn, _ error) {
+			disconnected <- struct{}{}
+		},
+		OnReconnect: func(_ *nats.Conn) {
+			reconnected <- struct{}{}
+		},
+		OnClosed: func(_ *nats.Conn) {
+			closed <- struct{}{}
+		},
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+
+	proxy.DropConnections()
+
+	for name, ch := range map[string]chan struct{}{"OnDisconnect": disconnected, "OnReconnect": reconnected} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second * 5):
+			t.Fatalf("%s was not called", name)
+		}
+	}
+
+	require.NoError(t, pub.Close())
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second * 5):
+		t.Fatal("OnClosed was not called")
+	}
+}
+
+func TestPing(t *testing.T) {
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:       getNatsURL(),
+		Marshaler: jetstream.GobMarshaler{},
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+
+	sub, err := jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+		URL:         getNatsURL(),
+		Unmarshaler: jetstream.GobMarshaler{},
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+
+	pingers := map[string]interface {
+		Ping(ctx context.Context) error
+		Close() error
+	}{
+		"publisher":  pub,
+		"subscriber": sub,
+	}
+
+	for name, p := range pingers {
+		t.Run(name, func(t *testing.T) {
+			assert.NoError(t, p.Ping(context.Background()))
+
+			ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+			defer cancel()
+			assert.ErrorIs(t, p.Ping(ctx), jetstream.ErrPingTimeout)
+
+			require.NoError(t, p.Close())
+			assert.ErrorIs(t, p.Ping(context.Background()), jetstream.ErrNotConnected)
+		})
+	}
+}
+
+func TestJetStream(t *testing.T) {
+	topic := newStream(t)
+
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:       getNatsURL(),
+		Marshaler: jetstream.GobMarshaler{},
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+
+	sub, err := jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+		URL:         getNatsURL(),
+		Unmarshaler: jetstream.GobMarshaler{},
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+
+	providers := map[string]interface {
+		JetStream() (nats.JetStreamContext, error)
+		Close() error
+	}{
+		"publisher":  pub,
+		"subscriber": sub,
+	}
+
+	for name, p := range providers {
+		t.Run(name, func(t *testing.T) {
+			js, err := p.JetStream()
+			require.NoError(t, err)
+
+			info, err := js.StreamInfo(topic)
+			require.NoError(t, err)
+			assert.Equal(t, topic, info.Config.Name)
+
+			require.NoError(t, p.Close())
+			_, err = p.JetStream()
+			assert.ErrorIs(t, err, jetstream.ErrNotConnected)
+		})
+	}
+}
+
+func TestConnectionProvider(t *testing.T) {
+	var conns []*nats.Conn
+	provider := jetstream.ConnectionProviderFunc(func() (*nats.Conn, error) {
+		conn, err := nats.Connect(getNatsURL())
+		if err == nil {
+			conns = append(conns, conn)
+		}
+		return conn, err
+	})
+
+	// URL is ignored, as the connection is created by the provider
+	unreachableURL := "nats://127.0.0.1:1"
+
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:                unreachableURL,
+		Marshaler:          jetstream.GobMarshaler{},
+		ConnectionProvider: provider,
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+
+	sub, err := jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+		URL:                unreachableURL,
+		Unmarshaler:        jetstream.GobMarshaler{},
+		ConnectionProvider: provider,
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+
+	topic := newStream(t)
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	published := publishMessages(t, pub, topic, 1)
+	received := receiveMessages(t, messages, 1)
+	assert.Equal(t, published[0].UUID, received[0].UUID)
+
+	require.NoError(t, pub.Close())
+	require.NoError(t, sub.Close())
+
+	require.Len(t, conns, 2)
+	for _, conn := range conns {
+		assert.True(t, conn.IsClosed(), "connection created by the provider should be closed")
+	}
+}
+
+func TestConnectionProvider_error(t *testing.T) {
+	provider := jetstream.ConnectionProviderFunc(func() (*nats.Conn, error) {
+		return nil, nats.ErrNoServers
+	})
+
+	_, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		Marshaler:          jetstream.GobMarshaler{},
+		ConnectionProvider: provider,
+	}, watermill.NewStdLogger(true, false))
+	assert.ErrorIs(t, err, nats.ErrNoServers)
+
+	_, err = jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+		Unmarshaler:        jetstream.GobMarshaler{},
+		ConnectionProvider: provider,
+	}, watermill.NewStdLogger(true, false))
+	assert.ErrorIs(t, err, nats.ErrNoServers)
+}
+
+func TestNoEcho(t *testing.T) {
+	// the option is read in OnClosed, as connections are not exposed
+	noEcho := make(chan bool, 2)
+	onClosed := func(conn *nats.Conn) {
+		noEcho <- conn.Opts.NoEcho
+	}
+
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:       getNatsURL(),
+		Marshaler: jetstream.GobMarshaler{},
+		NoEcho:    true,
+		OnClosed:  onClosed,
+	}, nil)
+	require.NoError(t, err)
+
+	sub, err := jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+		URL:         getNatsURL(),
+		Unmarshaler: jetstream.GobMarshaler{},
+		NoEcho:      true,
+		OnClosed:    onClosed,
+	}, nil)
+	require.NoError(t, err)
+
+	// messages delivered by JetStream are still received
+	topic := newStream(t)
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	published := publishMessages(t, pub, topic, 1)
+	received := receiveMessages(t, messages, 1)
+	assert.Equal(t, published[0].UUID, received[0].UUID)
+
+	require.NoError(t, pub.Close())
+	require.NoError(t, sub.Close())
+
+	for i := 0; i < 2; i++ {
+		select {
+		case enabled := <-noEcho:
+			assert.True(t, enabled)
+		case <-time.After(time.Second * 5):
+			t.Fatal("OnClosed was not called")
+		}
+	}
+}
+
+func TestNoEcho_connection_provider(t *testing.T) {
+	provider := jetstream.ConnectionProviderFunc(func() (*nats.Conn, error) {
+		t.Fatal("connection should not be created")
+		return nil, nil
+	})
+
+	_, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		Marshaler:          jetstream.GobMarshaler{},
+		NoEcho:             true,
+		ConnectionProvider: provider,
+	}, nil)
+	assert.Error(t, err)
+
+	_, err = jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+		Unmarshaler:        jetstream.GobMarshaler{},
+		NoEcho:             true,
+		ConnectionProvider: provider,
+	}, nil)
+	assert.Error(t, err)
+}
+
+func TestReconnectBufferSize(t *testing.T) {
+	topic := newStream(t)
+	proxy := newNatsProxy(t)
+
+	disconnected := make(chan struct{}, 1)
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:                 proxy.URL(),
+		Marshaler:           jetstream.GobMarshaler{},
+		NatsOptions:         []nats.Option{nats.ReconnectWait(time.Millisecond * 10), nats.MaxReconnects(-1)},
+		ReconnectBufferSize: 64 * 1024,
+		OnDisconnect: func(_ *nats.Conn, _ error) {
+			disconnected <- struct{}{}
+		},
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+	defer func() {
+		_ = pub.Close()
+	}()
+
+	disconnect := func() {
+		proxy.Refuse()
+		select {
+		case <-disconnected:
+		case <-time.After(time.Second * 5):
+			t.Fatal("OnDisconnect was not called")
+		}
+	}
+
+	t.Run("buffered", func(t *testing.T) {
+		disconnect()
+
+		published := make(chan error, 1)
+		go func() {
+			published <- pub.Publish(topic, message.NewMessage(watermill.NewUUID(), []byte("payload")))
+		}()
+
+		select {
+		case err := <-published:
+			t.Fatalf("message was published while disconnected: %v", err)
+		case <-time.After(time.Millisecond * 200):
+		}
+
+		proxy.Accept()
+
+		select {
+		case err := <-published:
+			require.NoError(t, err)
+		case <-time.After(time.Second * 5):
+			t.Fatal("buffered message was not published after reconnect")
+		}
+	})
+
+	t.Run("buffer_exceeded", func(t *testing.T) {
+		disconnect()
+
+		// fills the buffer
+		futures, err := pub.PublishAsync(topic, message.NewMessage(watermill.NewUUID(), make([]byte, 128*1024)))
+		require.NoError(t, err)
+
+		err = pub.Publish(topic, message.NewMessage(watermill.NewUUID(), []byte("payload")))
+		assert.ErrorIs(t, err, jetstream.ErrReconnectBufferExceeded)
+		assert.ErrorIs(t, err, nats.ErrReconnectBufExceeded)
+
+		proxy.Accept()
+
+		select {
+		case <-futures[0].Ok():
+		case err := <-futures[0].Err():
+			t.Fatalf("buffered message was not published: %v", err)
+		case <-time.After(time.Second * 5):
+			t.Fatal("buffered message was not published after reconnect")
+		}
+	})
+
+	info, err := newJetstream(t).StreamInfo(topic)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, info.State.Msgs)
+}
@@ -0,0 +1,78 @@
+package jetstream
+
+import (
+	"context"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotSubscribed is returned by ConsumerInfo when the subscriber has no active subscriptions.
+	ErrNotSubscribed = errors.New("subscriber has no active subscriptions")
+
+	// ErrMultipleConsumers is returned by ConsumerInfo when subscriptions use more than one consumer,
+	// for example when subscribing to multiple topics or with SubscribersCount ephemeral consumers.
+	ErrMultipleConsumers = errors.New("subscriptions use more than one consumer")
+)
+
+// ConsumerInfo returns info of the JetStream consumer of active subscriptions, including NumPending
+// (messages not delivered yet) and NumAckPending (messages delivered but not acked),
+// so it can be used to measure the consumer lag.
+//
+// ErrNotSubscribed is returned when Subscribe was not called, or all subscriptions are closed,
+// and ErrMultipleConsumers when subscriptions don't share a single consumer, a DurableName
+// can be used to share it.
+func (s *StreamingSubscriber) ConsumerInfo(ctx context.Context) (*nats.ConsumerInfo, error) {
+	s.subsLock.RLock()
+	var natsSubs []*nats.Subscription
+	for _, sub := range s.allSubscriptions() {
+		if natsSub := sub.current(); natsSub.IsValid() {
+			natsSubs = append(natsSubs, natsSub)
+		}
+	}
+	s.subsLock.RUnlock()
+
+	if len(natsSubs) == 0 {
+		return nil, ErrNotSubscribed
+	}
+
+	var consumer *nats.ConsumerInfo
+	for _, natsSub := range natsSubs {
+		info, err := subscriptionConsumerInfo(ctx, natsSub)
+		if err != nil {
+			return nil, err
+		}
+
+		if consumer != nil && (consumer.Stream != info.Stream || consumer.Name != info.Name) {
+			return nil, errors.Wrapf(ErrMultipleConsumers, "%s and %s", consumer.Name, info.Name)
+		}
+		consumer = info
+	}
+
+	return consumer, nil
+}
+
+// subscriptionConsumerInfo returns info of the consumer of sub, bounded by ctx.
+//
+// nats.Subscription.ConsumerInfo doesn't accept ctx, so the request may still be running
+// after ctx is done, until the JetStream request timeout.
+func subscriptionConsumerInfo(ctx context.Context, sub *nats.Subscription) (*nats.ConsumerInfo, error) {
+	type result struct {
+		info *nats.ConsumerInfo
+		err  error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		info, err := sub.ConsumerInfo()
+		done <- result{info, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.info, errors.Wrap(r.err, "cannot get consumer info")
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "cannot get consumer info")
+	}
+}
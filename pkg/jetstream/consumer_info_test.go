@@ -0,0 +1,86 @@
+package jetstream_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+)
+
+func TestConsumerInfo(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:      "durable",
+		QueueGroup:       "group",
+		SubscribersCount: 2,
+	})
+
+	_, err := sub.ConsumerInfo(context.Background())
+	assert.ErrorIs(t, err, jetstream.ErrNotSubscribed)
+
+	publishMessages(t, pub, topic, 3)
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	// the message is not acked until the info is checked
+	msg := <-messages
+
+	assert.Eventually(t, func() bool {
+		info, err := sub.ConsumerInfo(context.Background())
+		if err != nil {
+			return false
+		}
+
+		return info.Name == "durable" && info.NumAckPending >= 1 && info.NumPending+uint64(info.NumAckPending) == 3
+	}, time.Second*5, time.Millisecond*50)
+
+	msg.Ack()
+	receiveMessages(t, messages, 2)
+
+	assert.Eventually(t, func() bool {
+		info, err := sub.ConsumerInfo(context.Background())
+		if err != nil {
+			return false
+		}
+
+		return info.NumPending == 0 && info.NumAckPending == 0
+	}, time.Second*5, time.Millisecond*50)
+}
+
+func TestConsumerInfo_multiple_consumers(t *testing.T) {
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{})
+
+	for i := 0; i < 2; i++ {
+		_, err := sub.Subscribe(context.Background(), newStream(t))
+		require.NoError(t, err)
+	}
+
+	_, err := sub.ConsumerInfo(context.Background())
+	assert.ErrorIs(t, err, jetstream.ErrMultipleConsumers)
+}
+
+func TestConsumerInfo_closed_subscription(t *testing.T) {
+	topic := newStream(t)
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	messages, err := sub.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	_, err = sub.ConsumerInfo(context.Background())
+	require.NoError(t, err)
+
+	cancel()
+	for range messages {
+	}
+
+	_, err = sub.ConsumerInfo(context.Background())
+	assert.ErrorIs(t, err, jetstream.ErrNotSubscribed)
+}
@@ -0,0 +1,148 @@
+package jetstream
+
+import (
+	"container/list"
+	"encoding/base64"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// DeduplicationStore records UUIDs of processed messages for StreamingSubscriberConfig.DeduplicationStore,
+// so messages with the same UUID received again (for example published twice by a retrying producer)
+// are acked without processing.
+type DeduplicationStore interface {
+	// Contains returns true when a message with uuid was processed within the deduplication window.
+	Contains(uuid string) (bool, error)
+
+	// Add records that a message with uuid was processed.
+	Add(uuid string) error
+}
+
+// MemoryDeduplicationStore is a DeduplicationStore keeping UUIDs in memory, so it deduplicates messages
+// received by a single process only. The least recently added UUIDs are evicted when it's full.
+type MemoryDeduplicationStore struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	lock    sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryDeduplicationEntry struct {
+	uuid    string
+	addedAt time.Time
+}
+
+// NewMemoryDeduplicationStore returns a MemoryDeduplicationStore keeping at most size UUIDs for ttl.
+func NewMemoryDeduplicationStore(size int, ttl time.Duration) (*MemoryDeduplicationStore, error) {
+	if size <= 0 {
+		return nil, errors.New("deduplication store size must be greater than 0")
+	}
+	if ttl <= 0 {
+		return nil, errors.New("deduplication store ttl must be greater than 0")
+	}
+
+	return &MemoryDeduplicationStore{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}, nil
+}
+
+func (s *MemoryDeduplicationStore) Contains(uuid string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.removeExpired()
+
+	_, ok := s.entries[uuid]
+	return ok, nil
+}
+
+func (s *MemoryDeduplicationStore) Add(uuid string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.removeExpired()
+
+	if e, ok := s.entries[uuid]; ok {
+		e.Value.(*memoryDeduplicationEntry).addedAt = s.now()
+		s.order.MoveToFront(e)
+		return nil
+	}
+
+	s.entries[uuid] = s.order.PushFront(&memoryDeduplicationEntry{uuid: uuid, addedAt: s.now()})
+
+	if s.order.Len() > s.size {
+		s.remove(s.order.Back())
+	}
+
+	return nil
+}
+
+// removeExpired removes entries older than ttl, they are at the back of the order.
+func (s *MemoryDeduplicationStore) removeExpired() {
+	expiredBefore := s.now().Add(-s.ttl)
+
+	for e := s.order.Back(); e != nil; e = s.order.Back() {
+		if e.Value.(*memoryDeduplicationEntry).addedAt.After(expiredBefore) {
+			return
+		}
+		s.remove(e)
+	}
+}
+
+func (s *MemoryDeduplicationStore) remove(e *list.Element) {
+	s.order.Remove(e)
+	delete(s.entries, e.Value.(*memoryDeduplicationEntry).uuid)
+}
+
+// KVDeduplicationStore is a DeduplicationStore keeping UUIDs in a NATS key-value bucket, so messages
+// are deduplicated across all subscribers using the bucket.
+//
+// The deduplication window is the TTL of the bucket (see nats.KeyValueConfig.TTL), without it UUIDs
+// are kept until they are removed from the bucket by its History and MaxBytes limits.
+type KVDeduplicationStore struct {
+	kv nats.KeyValue
+}
+
+// NewKVDeduplicationStore returns a KVDeduplicationStore keeping UUIDs in kv.
+func NewKVDeduplicationStore(kv nats.KeyValue) (*KVDeduplicationStore, error) {
+	if kv == nil {
+		return nil, errors.New("missing key-value bucket")
+	}
+
+	return &KVDeduplicationStore{kv: kv}, nil
+}
+
+func (s *KVDeduplicationStore) Contains(uuid string) (bool, error) {
+	_, err := s.kv.Get(deduplicationKey(uuid))
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "cannot get deduplication key of %s", uuid)
+	}
+
+	return true, nil
+}
+
+func (s *KVDeduplicationStore) Add(uuid string) error {
+	if _, err := s.kv.Put(deduplicationKey(uuid), nil); err != nil {
+		return errors.Wrapf(err, "cannot put deduplication key of %s", uuid)
+	}
+
+	return nil
+}
+
+// deduplicationKey encodes uuid as a valid key, as UUIDs set by producers can contain any characters.
+func deduplicationKey(uuid string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(uuid))
+}
@@ -0,0 +1,169 @@
+package jetstream_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+)
+
+func TestMemoryDeduplicationStore(t *testing.T) {
+	store, err := jetstream.NewMemoryDeduplicationStore(2, time.Millisecond*200)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Add("first"))
+	require.NoError(t, store.Add("second"))
+	require.NoError(t, store.Add("third"))
+
+	contains, err := store.Contains("first")
+	require.NoError(t, err)
+	assert.False(t, contains, "the least recently added UUID should be evicted")
+
+	for _, uuid := range []string{"second", "third"} {
+		contains, err := store.Contains(uuid)
+		require.NoError(t, err)
+		assert.True(t, contains, uuid)
+	}
+
+	require.Eventually(t, func() bool {
+		contains, err := store.Contains("third")
+		require.NoError(t, err)
+		return !contains
+	}, time.Second*5, time.Millisecond*10, "UUID should expire after ttl")
+}
+
+func TestNewMemoryDeduplicationStore_invalid(t *testing.T) {
+	_, err := jetstream.NewMemoryDeduplicationStore(0, time.Minute)
+	assert.Error(t, err)
+
+	_, err = jetstream.NewMemoryDeduplicationStore(10, 0)
+	assert.Error(t, err)
+
+	_, err = jetstream.NewKVDeduplicationStore(nil)
+	assert.Error(t, err)
+}
+
+func newKVDeduplicationStore(t *testing.T) *jetstream.KVDeduplicationStore {
+	js := newJetstream(t)
+
+	bucket := "dedup_" + watermill.NewShortUUID()
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{Bucket: bucket, TTL: time.Minute})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = js.DeleteKeyValue(bucket)
+	})
+
+	store, err := jetstream.NewKVDeduplicationStore(kv)
+	require.NoError(t, err)
+
+	return store
+}
+
+func TestKVDeduplicationStore(t *testing.T) {
+	store := newKVDeduplicationStore(t)
+
+	// UUIDs set by producers are not always valid keys
+	uuid := "order/1 created"
+
+	contains, err := store.Contains(uuid)
+	require.NoError(t, err)
+	assert.False(t, contains)
+
+	require.NoError(t, store.Add(uuid))
+
+	contains, err = store.Contains(uuid)
+	require.NoError(t, err)
+	assert.True(t, contains)
+}
+
+func TestDeduplicationStore(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Store func(t *testing.T) jetstream.DeduplicationStore
+	}{
+		{
+			Name: "memory",
+			Store: func(t *testing.T) jetstream.DeduplicationStore {
+				store, err := jetstream.NewMemoryDeduplicationStore(100, time.Minute)
+				require.NoError(t, err)
+				return store
+			},
+		},
+		{
+			Name: "kv",
+			Store: func(t *testing.T) jetstream.DeduplicationStore {
+				return newKVDeduplicationStore(t)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			topic := newStream(t)
+			pub := newPublisher(t)
+
+			sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+				DurableName:        "durable",
+				DeduplicationStore: tc.Store(t),
+			})
+			messages, err := sub.Subscribe(context.Background(), topic)
+			require.NoError(t, err)
+
+			first := message.NewMessage(watermill.NewUUID(), []byte("first"))
+			require.NoError(t, pub.Publish(topic, first))
+			assert.Equal(t, first.UUID, receiveMessages(t, messages, 1)[0].UUID)
+
+			duplicate := message.NewMessage(first.UUID, []byte("duplicate"))
+			next := message.NewMessage(watermill.NewUUID(), []byte("next"))
+			require.NoError(t, pub.Publish(topic, duplicate, next))
+
+			assert.Equal(t, next.UUID, receiveMessages(t, messages, 1)[0].UUID, "duplicate should not be delivered")
+
+			require.Eventually(t, func() bool {
+				info, err := newJetstream(t).ConsumerInfo(topic, "durable")
+				require.NoError(t, err)
+				return info.NumAckPending == 0 && info.AckFloor.Consumer == 3
+			}, time.Second*5, time.Millisecond*10, "duplicate should be acked")
+		})
+	}
+}
+
+func TestDeduplicationStore_messages_without_uuid(t *testing.T) {
+	topic := newStream(t)
+
+	store, err := jetstream.NewMemoryDeduplicationStore(100, time.Minute)
+	require.NoError(t, err)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:        "durable",
+		Unmarshaler:        jetstream.NATSMarshaler{},
+		DeduplicationStore: store,
+	})
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	// messages published without _watermill_message_uuid and Nats-Msg-Id headers are unmarshaled without UUID
+	js := newJetstream(t)
+	for _, payload := range []string{"first", "second"} {
+		_, err := js.Publish(topic, []byte(payload))
+		require.NoError(t, err)
+	}
+
+	for _, payload := range []string{"first", "second"} {
+		msg := receiveMessages(t, messages, 1)[0]
+		assert.Empty(t, msg.UUID)
+		assert.Equal(t, payload, string(msg.Payload), "message without UUID should not be deduplicated")
+	}
+
+	contains, err := store.Contains("")
+	require.NoError(t, err)
+	assert.False(t, contains, "empty UUID should not be recorded")
+}
@@ -0,0 +1,84 @@
+package jetstream
+
+import (
+	"context"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// Kinds of errors returned by constructors, Subscribe and SubscribeInitialize, they can be checked with errors.Is
+// while the underlying error is still available, for example nats.ErrNoServers:
+//
+//	if errors.Is(err, jetstream.ErrConnect) {
+//		// retry later
+//	}
+var (
+	// ErrConnect is wrapped by errors of connecting to NATS, or of requests failing because
+	// the connection is closed or the server didn't respond. Retrying may succeed.
+	ErrConnect = errors.New("cannot connect to NATS")
+
+	// ErrCreateConsumer is wrapped by errors of creating or binding JetStream consumers,
+	// for example when the stream of the topic doesn't exist or the server rejects the consumer config.
+	ErrCreateConsumer = errors.New("cannot create consumer")
+
+	// ErrInvalidConfig is wrapped by errors of config validation, including consumer options not matching
+	// the existing durable consumer or stream. Retrying with the same config doesn't succeed.
+	ErrInvalidConfig = errors.New("invalid config")
+)
+
+// kindError wraps err with one of ErrConnect, ErrCreateConsumer and ErrInvalidConfig.
+// The message of err is kept, the kind is only matched with errors.Is.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e kindError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// withKind wraps err with kind, unless it's nil or already has a kind.
+func withKind(kind error, err error) error {
+	if err == nil || hasKind(err) {
+		return err
+	}
+
+	return kindError{kind: kind, err: err}
+}
+
+func hasKind(err error) bool {
+	return errors.Is(err, ErrConnect) || errors.Is(err, ErrCreateConsumer) || errors.Is(err, ErrInvalidConfig)
+}
+
+// consumerError wraps err of creating or binding a consumer with ErrConnect when it's caused by the connection,
+// otherwise with ErrCreateConsumer.
+func consumerError(err error) error {
+	if isConnectionError(err) {
+		return withKind(ErrConnect, err)
+	}
+
+	return withKind(ErrCreateConsumer, err)
+}
+
+func isConnectionError(err error) bool {
+	for _, connErr := range []error{
+		nats.ErrConnectionClosed,
+		nats.ErrConnectionDraining,
+		nats.ErrConnectionReconnecting,
+		nats.ErrNoServers,
+		nats.ErrTimeout,
+		nats.ErrNoResponders,
+		context.DeadlineExceeded,
+	} {
+		if errors.Is(err, connErr) {
+			return true
+		}
+	}
+
+	return false
+}
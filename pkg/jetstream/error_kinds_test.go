@@ -0,0 +1,58 @@
+package jetstream_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+)
+
+func TestErrorKinds_constructors(t *testing.T) {
+	_, err := jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+		URL:                 getNatsURL(),
+		Unmarshaler:         jetstream.GobMarshaler{},
+		OutputChannelBuffer: -1,
+	}, watermill.NewStdLogger(true, false))
+	assert.ErrorIs(t, err, jetstream.ErrInvalidConfig)
+	assert.NotErrorIs(t, err, jetstream.ErrConnect)
+
+	_, err = jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:       "nats://127.0.0.1:1",
+		Marshaler: jetstream.GobMarshaler{},
+	}, watermill.NewStdLogger(true, false))
+	assert.ErrorIs(t, err, jetstream.ErrConnect)
+	assert.ErrorIs(t, err, nats.ErrNoServers, "the cause should be kept")
+	assert.NotErrorIs(t, err, jetstream.ErrInvalidConfig)
+
+	_, err = jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+		URL:         "nats://127.0.0.1:1",
+		Unmarshaler: jetstream.GobMarshaler{},
+	}, watermill.NewStdLogger(true, false))
+	assert.ErrorIs(t, err, jetstream.ErrConnect)
+}
+
+func TestErrorKinds_subscribe(t *testing.T) {
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{})
+
+	_, err := sub.Subscribe(context.Background(), "topic_"+watermill.NewShortUUID())
+	require.Error(t, err, "subscribing should fail when no stream stores the topic")
+	assert.ErrorIs(t, err, jetstream.ErrCreateConsumer)
+	assert.NotErrorIs(t, err, jetstream.ErrConnect)
+	assert.NotErrorIs(t, err, jetstream.ErrInvalidConfig)
+
+	topic := newStream(t)
+	sub = newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:   "durable",
+		FilterSubject: "other." + topic,
+	})
+
+	_, err = sub.Subscribe(context.Background(), topic)
+	assert.ErrorIs(t, err, jetstream.ErrInvalidConfig)
+	assert.ErrorIs(t, sub.SubscribeInitialize(topic), jetstream.ErrInvalidConfig)
+}
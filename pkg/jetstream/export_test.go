@@ -0,0 +1,33 @@
+package jetstream
+
+import (
+	nats "github.com/nats-io/nats.go"
+)
+
+// SetJetStream replaces the JetStream context used by s, so tests can inject failures.
+func SetJetStream(s *StreamingSubscriber, js nats.JetStreamContext) {
+	s.js = js
+}
+
+// NKeyOption returns the option authenticating with the NKey seed, so it can be tested without a server requiring NKeys.
+func NKeyOption(seed []byte) (nats.Option, error) {
+	return nkeyOption(seed)
+}
+
+// Subscriptions returns NATS subscriptions made by s for topic.
+func Subscriptions(s *StreamingSubscriber, topic string) []*nats.Subscription {
+	s.subsLock.RLock()
+	defer s.subsLock.RUnlock()
+
+	var subs []*nats.Subscription
+	for _, sub := range s.subs[topic] {
+		subs = append(subs, sub.current())
+	}
+
+	return subs
+}
+
+// ConnSubscribers returns the number of subscribers receiving callbacks of conn.
+func ConnSubscribers(conn *nats.Conn) int {
+	return len(connSubscribers(conn))
+}
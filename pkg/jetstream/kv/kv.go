@@ -0,0 +1,55 @@
+// Package kv bridges JetStream KeyValue buckets with Watermill, topics are bucket names.
+//
+// Subscriber watches a bucket and emits a message for each update of a key, with the key, revision and
+// operation in KeyKey, RevisionKey and OperationKey metadata, and the value as the payload.
+// Publisher performs the operation from OperationKey metadata on the key from KeyKey metadata,
+// so messages received by Subscriber can be published to another bucket.
+//
+// Deletes are represented as messages with OperationDelete (or OperationPurge when the key history
+// was purged) and an empty payload, they can be skipped with SubscriberConfig.IgnoreDeletes.
+// Publishing a message with OperationDelete or OperationPurge deletes the key, the payload is ignored.
+//
+// Values are stored as raw payloads, so UUIDs and other metadata of published messages are not kept.
+package kv
+
+import (
+	nats "github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// Metadata keys of messages emitted by Subscriber and published by Publisher.
+const (
+	// KeyKey is the metadata key with the key in the bucket.
+	KeyKey = "_nats_kv_key"
+
+	// RevisionKey is the metadata key with the revision of the update, set by Subscriber.
+	RevisionKey = "_nats_kv_revision"
+
+	// OperationKey is the metadata key with the operation of the update, one of Operation values.
+	OperationKey = "_nats_kv_operation"
+)
+
+// Operations of key updates in OperationKey metadata.
+const (
+	// OperationPut is a set value of the key, it is the default operation of Publisher.
+	OperationPut = "put"
+
+	// OperationDelete is a deleted key, the history of the key is kept.
+	OperationDelete = "delete"
+
+	// OperationPurge is a deleted key with all its history.
+	OperationPurge = "purge"
+)
+
+func operation(op nats.KeyValueOp) (string, error) {
+	switch op {
+	case nats.KeyValuePut:
+		return OperationPut, nil
+	case nats.KeyValueDelete:
+		return OperationDelete, nil
+	case nats.KeyValuePurge:
+		return OperationPurge, nil
+	default:
+		return "", errors.Errorf("unknown KeyValue operation %s", op)
+	}
+}
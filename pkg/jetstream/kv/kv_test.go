@@ -0,0 +1,195 @@
+package kv_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream/kv"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream/natstest"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(natstest.RunTests(m, "WATERMILL_TEST_NATS_URL"))
+}
+
+func getNatsURL() string {
+	natsURL := os.Getenv("WATERMILL_TEST_NATS_URL")
+	if natsURL == "" {
+		natsURL = nats.DefaultURL
+	}
+
+	return natsURL
+}
+
+func newBucket(t *testing.T) (nats.JetStreamContext, nats.KeyValue) {
+	js, err := jetstream.NewJetstreamConnection(&jetstream.NatsConnConfig{URL: getNatsURL()})
+	require.NoError(t, err)
+
+	bucket, err := js.CreateKeyValue(&nats.KeyValueConfig{Bucket: "bucket_" + watermill.NewShortUUID()})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = js.DeleteKeyValue(bucket.Bucket())
+	})
+
+	return js, bucket
+}
+
+func newKeyMessage(key string, operation string, payload string) *message.Message {
+	msg := message.NewMessage(watermill.NewUUID(), []byte(payload))
+	msg.Metadata.Set(kv.KeyKey, key)
+	if operation != "" {
+		msg.Metadata.Set(kv.OperationKey, operation)
+	}
+
+	return msg
+}
+
+func receive(t *testing.T, messages <-chan *message.Message) *message.Message {
+	select {
+	case msg, ok := <-messages:
+		require.True(t, ok, "output channel closed")
+		return msg
+	case <-time.After(time.Second * 5):
+		t.Fatal("message not received")
+		return nil
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	js, bucket := newBucket(t)
+	logger := watermill.NewStdLogger(true, false)
+
+	_, err := bucket.Put("existing", []byte("current"))
+	require.NoError(t, err)
+
+	sub, err := kv.NewSubscriber(js, kv.SubscriberConfig{}, logger)
+	require.NoError(t, err)
+	defer func() {
+		_ = sub.Close()
+	}()
+
+	pub, err := kv.NewPublisher(js, logger)
+	require.NoError(t, err)
+
+	messages, err := sub.Subscribe(context.Background(), bucket.Bucket())
+	require.NoError(t, err)
+
+	require.NoError(t, pub.Publish(
+		bucket.Bucket(),
+		newKeyMessage("key", "", "value"),
+		newKeyMessage("key", kv.OperationDelete, ""),
+		newKeyMessage("existing", kv.OperationPurge, ""),
+	))
+
+	expected := []struct {
+		Key       string
+		Revision  string
+		Operation string
+		Payload   string
+	}{
+		{"existing", "1", kv.OperationPut, "current"},
+		{"key", "2", kv.OperationPut, "value"},
+		{"key", "3", kv.OperationDelete, ""},
+		{"existing", "4", kv.OperationPurge, ""},
+	}
+
+	for _, e := range expected {
+		msg := receive(t, messages)
+		assert.Equal(t, e.Key, msg.Metadata.Get(kv.KeyKey))
+		assert.Equal(t, e.Revision, msg.Metadata.Get(kv.RevisionKey))
+		assert.Equal(t, e.Operation, msg.Metadata.Get(kv.OperationKey))
+		assert.Equal(t, e.Payload, string(msg.Payload))
+		msg.Ack()
+	}
+
+	_, err = bucket.Get("key")
+	assert.ErrorIs(t, err, nats.ErrKeyNotFound)
+}
+
+func TestSubscriber_nack(t *testing.T) {
+	js, bucket := newBucket(t)
+
+	_, err := bucket.Put("key", []byte("value"))
+	require.NoError(t, err)
+
+	sub, err := kv.NewSubscriber(js, kv.SubscriberConfig{}, nil)
+	require.NoError(t, err)
+	defer func() {
+		_ = sub.Close()
+	}()
+
+	messages, err := sub.Subscribe(context.Background(), bucket.Bucket())
+	require.NoError(t, err)
+
+	msg := receive(t, messages)
+	msg.Nack()
+
+	redelivered := receive(t, messages)
+	assert.Equal(t, msg.UUID, redelivered.UUID)
+	assert.Equal(t, "value", string(redelivered.Payload))
+	redelivered.Ack()
+}
+
+func TestSubscriber_config(t *testing.T) {
+	js, bucket := newBucket(t)
+
+	_, err := bucket.Put("orders.existing", []byte("current"))
+	require.NoError(t, err)
+
+	sub, err := kv.NewSubscriber(js, kv.SubscriberConfig{
+		Keys:          "orders.*",
+		UpdatesOnly:   true,
+		IgnoreDeletes: true,
+	}, nil)
+	require.NoError(t, err)
+	defer func() {
+		_ = sub.Close()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	messages, err := sub.Subscribe(ctx, bucket.Bucket())
+	require.NoError(t, err)
+
+	require.NoError(t, bucket.Delete("orders.existing"))
+	_, err = bucket.Put("users.new", []byte("ignored"))
+	require.NoError(t, err)
+	_, err = bucket.Put("orders.new", []byte("value"))
+	require.NoError(t, err)
+
+	msg := receive(t, messages)
+	assert.Equal(t, "orders.new", msg.Metadata.Get(kv.KeyKey))
+	msg.Ack()
+
+	cancel()
+
+	select {
+	case _, ok := <-messages:
+		assert.False(t, ok, "output channel should be closed")
+	case <-time.After(time.Second * 5):
+		t.Fatal("output channel not closed")
+	}
+}
+
+func TestPublisher_missing_key(t *testing.T) {
+	js, bucket := newBucket(t)
+
+	pub, err := kv.NewPublisher(js, nil)
+	require.NoError(t, err)
+
+	err = pub.Publish(bucket.Bucket(), message.NewMessage(watermill.NewUUID(), nil))
+	assert.Error(t, err)
+
+	err = pub.Publish(bucket.Bucket(), newKeyMessage("key", "unknown", ""))
+	assert.Error(t, err)
+}
@@ -0,0 +1,103 @@
+package kv
+
+import (
+	"sync"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// Publisher puts and deletes keys of KeyValue buckets, the topic is the bucket name.
+type Publisher struct {
+	js     nats.JetStreamContext
+	logger watermill.LoggerAdapter
+
+	buckets     map[string]nats.KeyValue
+	bucketsLock sync.Mutex
+}
+
+// NewPublisher creates a new Publisher using js, buckets have to exist before publishing.
+//
+// The connection of js is owned by the caller, it is not closed when the publisher is closed.
+func NewPublisher(js nats.JetStreamContext, logger watermill.LoggerAdapter) (*Publisher, error) {
+	if js == nil {
+		return nil, errors.New("missing JetStream context")
+	}
+	if logger == nil {
+		logger = watermill.NopLogger{}
+	}
+
+	return &Publisher{
+		js:      js,
+		logger:  logger,
+		buckets: map[string]nats.KeyValue{},
+	}, nil
+}
+
+// Publish performs the operation from OperationKey metadata (OperationPut by default) on the key
+// from KeyKey metadata of messages. Put values are message payloads.
+//
+// When one of messages can't be published - function is interrupted.
+func (p *Publisher) Publish(bucket string, messages ...*message.Message) error {
+	kv, err := p.bucket(bucket)
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range messages {
+		key := msg.Metadata.Get(KeyKey)
+		if key == "" {
+			return errors.Errorf("message %s has no %s metadata", msg.UUID, KeyKey)
+		}
+
+		logFields := watermill.LogFields{
+			"message_uuid": msg.UUID,
+			"bucket":       bucket,
+			"key":          key,
+		}
+
+		switch op := msg.Metadata.Get(OperationKey); op {
+		case "", OperationPut:
+			p.logger.Trace("Putting key", logFields)
+			_, err = kv.Put(key, msg.Payload)
+		case OperationDelete:
+			p.logger.Trace("Deleting key", logFields)
+			err = kv.Delete(key)
+		case OperationPurge:
+			p.logger.Trace("Purging key", logFields)
+			err = kv.Purge(key)
+		default:
+			return errors.Errorf("message %s has unknown operation %s", msg.UUID, op)
+		}
+
+		if err != nil {
+			return errors.Wrapf(err, "cannot update key %s of message %s", key, msg.UUID)
+		}
+	}
+
+	return nil
+}
+
+func (p *Publisher) bucket(name string) (nats.KeyValue, error) {
+	p.bucketsLock.Lock()
+	defer p.bucketsLock.Unlock()
+
+	if kv, ok := p.buckets[name]; ok {
+		return kv, nil
+	}
+
+	kv, err := p.js.KeyValue(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot get bucket %s", name)
+	}
+	p.buckets[name] = kv
+
+	return kv, nil
+}
+
+func (p *Publisher) Close() error {
+	return nil
+}
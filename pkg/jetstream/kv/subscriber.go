@@ -0,0 +1,192 @@
+package kv
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+type SubscriberConfig struct {
+	// Keys is the pattern of watched keys, with the same wildcards as NATS subjects. All keys are watched when empty.
+	Keys string
+
+	// UpdatesOnly skips current values of keys, only updates made after subscribing are emitted.
+	UpdatesOnly bool
+
+	// IgnoreDeletes skips deleted and purged keys.
+	IgnoreDeletes bool
+}
+
+func (c SubscriberConfig) watchOptions() []nats.WatchOpt {
+	var options []nats.WatchOpt
+
+	if c.UpdatesOnly {
+		options = append(options, nats.UpdatesOnly())
+	}
+	if c.IgnoreDeletes {
+		options = append(options, nats.IgnoreDeletes())
+	}
+
+	return options
+}
+
+// Subscriber watches KeyValue buckets, the topic is the bucket name.
+//
+// Updates are emitted one by one, the next update is emitted after the message is acked.
+// Nacked messages are emitted again. Watches are not durable, so updates made while not subscribed
+// are not emitted, except of current values of keys emitted on subscribe.
+type Subscriber struct {
+	js     nats.JetStreamContext
+	config SubscriberConfig
+	logger watermill.LoggerAdapter
+
+	closing   chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewSubscriber creates a new Subscriber using js, buckets have to exist before subscribing.
+//
+// The connection of js is owned by the caller, it is not closed when the subscriber is closed.
+func NewSubscriber(js nats.JetStreamContext, config SubscriberConfig, logger watermill.LoggerAdapter) (*Subscriber, error) {
+	if js == nil {
+		return nil, errors.New("missing JetStream context")
+	}
+	if logger == nil {
+		logger = watermill.NopLogger{}
+	}
+
+	return &Subscriber{
+		js:      js,
+		config:  config,
+		logger:  logger,
+		closing: make(chan struct{}),
+	}, nil
+}
+
+// Subscribe watches keys of bucket. The output channel is closed when ctx is done or the subscriber is closed.
+func (s *Subscriber) Subscribe(ctx context.Context, bucket string) (<-chan *message.Message, error) {
+	kv, err := s.js.KeyValue(bucket)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot get bucket %s", bucket)
+	}
+
+	keys := s.config.Keys
+	if keys == "" {
+		keys = ">"
+	}
+
+	watcher, err := kv.Watch(keys, s.config.watchOptions()...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot watch bucket %s", bucket)
+	}
+
+	output := make(chan *message.Message)
+	logFields := watermill.LogFields{"bucket": bucket, "keys": keys}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(output)
+		defer func() {
+			if err := watcher.Stop(); err != nil {
+				s.logger.Error("Cannot stop watcher", err, logFields)
+			}
+		}()
+
+		s.watch(ctx, watcher, output, logFields)
+	}()
+
+	return output, nil
+}
+
+func (s *Subscriber) watch(ctx context.Context, watcher nats.KeyWatcher, output chan *message.Message, logFields watermill.LogFields) {
+	for {
+		select {
+		case entry, ok := <-watcher.Updates():
+			if !ok {
+				return
+			}
+			if entry == nil {
+				// marks that all current values were emitted
+				s.logger.Trace("Current values of keys received", logFields)
+				continue
+			}
+
+			msg, err := s.entryToMessage(ctx, entry)
+			if err != nil {
+				s.logger.Error("Cannot convert entry to message", err, logFields)
+				continue
+			}
+
+			if !s.send(ctx, msg, output, logFields.Add(watermill.LogFields{"key": entry.Key()})) {
+				return
+			}
+		case <-s.closing:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Subscriber) entryToMessage(ctx context.Context, entry nats.KeyValueEntry) (*message.Message, error) {
+	op, err := operation(entry.Operation())
+	if err != nil {
+		return nil, err
+	}
+
+	msg := message.NewMessage(watermill.NewUUID(), entry.Value())
+	msg.Metadata.Set(KeyKey, entry.Key())
+	msg.Metadata.Set(RevisionKey, strconv.FormatUint(entry.Revision(), 10))
+	msg.Metadata.Set(OperationKey, op)
+	msg.SetContext(ctx)
+
+	return msg, nil
+}
+
+// send sends msg to output until it's acked, it returns false when ctx is done or the subscriber is closed.
+func (s *Subscriber) send(ctx context.Context, msg *message.Message, output chan *message.Message, logFields watermill.LogFields) bool {
+	for {
+		select {
+		case output <- msg:
+			s.logger.Trace("Message sent to consumer", logFields)
+		case <-s.closing:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+
+		select {
+		case <-msg.Acked():
+			s.logger.Trace("Message acked", logFields)
+			return true
+		case <-msg.Nacked():
+			s.logger.Trace("Message nacked, sending again", logFields)
+
+			// acked and nacked messages can't be reused
+			msgCopy := msg.Copy()
+			msgCopy.SetContext(ctx)
+			msg = msgCopy
+		case <-s.closing:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+func (s *Subscriber) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closing)
+	})
+	s.wg.Wait()
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package jetstream
+
+import (
+	"sync"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+
+	"github.com/ThreeDotsLabs/watermill"
+)
+
+// errLazyClosed is returned by the first use of a publisher or subscriber with LazyConnect after Close.
+var errLazyClosed = errors.New("cannot connect with LazyConnect, already closed")
+
+// lazyPublisher holds the publisher connected with LazyConnect on the first use.
+type lazyPublisher struct {
+	connect func() (*nats.Conn, error)
+
+	lock   sync.Mutex
+	pub    *StreamingPublisher
+	closed bool
+}
+
+func newLazyPublisher(config StreamingPublisherConfig, logger watermill.LoggerAdapter) *StreamingPublisher {
+	if logger == nil {
+		logger = watermill.NopLogger{}
+	}
+
+	return &StreamingPublisher{
+		config:          config.GetStreamingPublisherPublishConfig(),
+		logger:          logger,
+		topicMarshalers: newTopicMarshalers(),
+		lazy:            &lazyPublisher{connect: config.connect},
+	}
+}
+
+// connected returns the publisher with the connection, which is created on the first call with LazyConnect.
+// Concurrent first calls share a single connection.
+func (p StreamingPublisher) connected() (StreamingPublisher, error) {
+	if p.lazy == nil {
+		return p, nil
+	}
+
+	l := p.lazy
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.closed {
+		return StreamingPublisher{}, errLazyClosed
+	}
+	if l.pub != nil {
+		return *l.pub, nil
+	}
+
+	conn, err := l.connect()
+	if err != nil {
+		return StreamingPublisher{}, errors.Wrap(err, "cannot connect to NATS on first use with LazyConnect")
+	}
+
+	pub, err := NewStreamingPublisherWithNatsConn(conn, p.config, p.logger)
+	if err != nil {
+		conn.Close()
+		return StreamingPublisher{}, errors.Wrap(err, "cannot create publisher on first use with LazyConnect")
+	}
+	pub.ownsConn = true
+	// topic marshalers may be set before the first use
+	pub.topicMarshalers = p.topicMarshalers
+
+	l.pub = pub
+
+	return *pub, nil
+}
+
+// current returns the publisher with the connection, ok is false when it was not created yet with LazyConnect.
+func (p StreamingPublisher) current() (_ StreamingPublisher, ok bool) {
+	if p.lazy == nil {
+		return p, true
+	}
+
+	p.lazy.lock.Lock()
+	defer p.lazy.lock.Unlock()
+
+	if p.lazy.pub == nil {
+		return StreamingPublisher{}, false
+	}
+
+	return *p.lazy.pub, true
+}
+
+// closeLazy prevents connecting with LazyConnect after Close, it returns the publisher
+// with the connection like current.
+func (p StreamingPublisher) closeLazy() (_ StreamingPublisher, ok bool) {
+	if p.lazy == nil {
+		return p, true
+	}
+
+	p.lazy.lock.Lock()
+	defer p.lazy.lock.Unlock()
+
+	p.lazy.closed = true
+	if p.lazy.pub == nil {
+		return StreamingPublisher{}, false
+	}
+
+	return *p.lazy.pub, true
+}
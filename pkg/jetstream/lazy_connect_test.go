@@ -0,0 +1,136 @@
+package jetstream_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+)
+
+func TestLazyConnect(t *testing.T) {
+	var connections atomic.Int32
+	provider := jetstream.ConnectionProviderFunc(func() (*nats.Conn, error) {
+		connections.Add(1)
+		return nats.Connect(getNatsURL())
+	})
+
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		Marshaler:          jetstream.GobMarshaler{},
+		ConnectionProvider: provider,
+		LazyConnect:        true,
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+	defer pub.Close()
+
+	sub, err := jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+		Unmarshaler:        jetstream.GobMarshaler{},
+		ConnectionProvider: provider,
+		LazyConnect:        true,
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	assert.EqualValues(t, 0, connections.Load(), "should not connect before the first use")
+	assert.ErrorIs(t, pub.Ping(context.Background()), jetstream.ErrNotConnected)
+	assert.ErrorIs(t, sub.Ping(context.Background()), jetstream.ErrNotConnected)
+
+	topic := newStream(t)
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, connections.Load())
+
+	// concurrent first uses connect once
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, pub.Publish(topic, message.NewMessage(watermill.NewUUID(), nil)))
+		}()
+	}
+	wg.Wait()
+	assert.EqualValues(t, 2, connections.Load())
+
+	receiveMessages(t, messages, 10)
+
+	assert.NoError(t, pub.Ping(context.Background()))
+	assert.NoError(t, sub.Ping(context.Background()))
+}
+
+func TestLazyConnect_connection_error(t *testing.T) {
+	unreachableURL := "nats://127.0.0.1:1"
+
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:         unreachableURL,
+		Marshaler:   jetstream.GobMarshaler{},
+		LazyConnect: true,
+	}, nil)
+	require.NoError(t, err)
+
+	sub, err := jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+		URL:         unreachableURL,
+		Unmarshaler: jetstream.GobMarshaler{},
+		LazyConnect: true,
+	}, nil)
+	require.NoError(t, err)
+
+	err = pub.Publish("topic", message.NewMessage(watermill.NewUUID(), nil))
+	assert.ErrorContains(t, err, "LazyConnect")
+
+	_, err = sub.Subscribe(context.Background(), "topic")
+	assert.ErrorContains(t, err, "LazyConnect")
+
+	require.NoError(t, pub.Close())
+	require.NoError(t, sub.Close())
+}
+
+func TestLazyConnect_invalid_config(t *testing.T) {
+	_, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		LazyConnect: true,
+	}, nil)
+	assert.Error(t, err, "config should be validated without connecting")
+
+	_, err = jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+		Unmarshaler:  jetstream.GobMarshaler{},
+		LazyConnect:  true,
+		PingInterval: -1,
+	}, nil)
+	assert.Error(t, err, "config should be validated without connecting")
+}
+
+func TestLazyConnect_closed_before_use(t *testing.T) {
+	provider := jetstream.ConnectionProviderFunc(func() (*nats.Conn, error) {
+		t.Fatal("connection should not be created")
+		return nil, nil
+	})
+
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		Marshaler:          jetstream.GobMarshaler{},
+		ConnectionProvider: provider,
+		LazyConnect:        true,
+	}, nil)
+	require.NoError(t, err)
+
+	sub, err := jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+		Unmarshaler:        jetstream.GobMarshaler{},
+		ConnectionProvider: provider,
+		LazyConnect:        true,
+	}, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, pub.Close())
+	require.NoError(t, sub.Close())
+
+	assert.Error(t, pub.Publish("topic", message.NewMessage(watermill.NewUUID(), nil)))
+	_, err = sub.Subscribe(context.Background(), "topic")
+	assert.Error(t, err)
+}
@@ -3,10 +3,17 @@ package jetstream
 import (
 	"bytes"
 	"encoding/gob"
+	"encoding/json"
+	"slices"
+	"strings"
+	"unicode/utf8"
 
 	nats "github.com/nats-io/nats.go"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/protobuf/proto"
 
+	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/message"
 )
 
@@ -14,6 +21,13 @@ type Marshaler interface {
 	Marshal(topic string, msg *message.Message) ([]byte, error)
 }
 
+// MsgMarshaler is implemented by marshalers which carry parts of the message in NATS headers.
+//
+// When Marshaler implements MsgMarshaler, MarshalMsg is used instead of Marshal to create published messages.
+type MsgMarshaler interface {
+	MarshalMsg(topic string, msg *message.Message) (*nats.Msg, error)
+}
+
 type Unmarshaler interface {
 	Unmarshal(*nats.Msg) (*message.Message, error)
 }
@@ -23,9 +37,87 @@ type MarshalerUnmarshaler interface {
 	Unmarshaler
 }
 
+// marshalMsg marshals msg to a NATS message published to topic, with MarshalMsg when marshaler implements MsgMarshaler.
+func marshalMsg(marshaler Marshaler, topic string, msg *message.Message) (*nats.Msg, error) {
+	if msgMarshaler, ok := marshaler.(MsgMarshaler); ok {
+		return msgMarshaler.MarshalMsg(topic, msg)
+	}
+
+	data, err := marshaler.Marshal(topic, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &nats.Msg{Subject: topic, Data: data}, nil
+}
+
+// ContentTypeHdr is the NATS header with the content type of the message, set by the publisher
+// when Marshaler implements ContentTyper. It is used by MultiUnmarshaler to select the unmarshaler.
+const ContentTypeHdr = "_content_type"
+
+// Content types of marshalers provided by the package.
+const (
+	GobContentType      = "application/x-gob"
+	JSONContentType     = "application/json"
+	ProtobufContentType = "application/x-protobuf"
+)
+
+// ContentTyper is implemented by marshalers and unmarshalers with a content type.
+type ContentTyper interface {
+	ContentType() string
+}
+
+// MultiUnmarshaler unmarshals messages with the unmarshaler registered for the content type from
+// ContentTypeHdr header, so a subscriber can receive messages marshaled by different marshalers.
+//
+// Fallback is used for messages without the header or with a content type without an unmarshaler,
+// when it's nil, such messages cannot be unmarshaled.
+type MultiUnmarshaler struct {
+	// Unmarshalers are unmarshalers by content type.
+	Unmarshalers map[string]Unmarshaler
+
+	Fallback Unmarshaler
+}
+
+// NewMultiUnmarshaler creates a MultiUnmarshaler with unmarshalers registered by their ContentType.
+func NewMultiUnmarshaler(fallback Unmarshaler, unmarshalers ...Unmarshaler) (MultiUnmarshaler, error) {
+	m := MultiUnmarshaler{
+		Unmarshalers: map[string]Unmarshaler{},
+		Fallback:     fallback,
+	}
+
+	for _, u := range unmarshalers {
+		typer, ok := u.(ContentTyper)
+		if !ok {
+			return MultiUnmarshaler{}, errors.Errorf("unmarshaler %T doesn't implement ContentTyper", u)
+		}
+		m.Unmarshalers[typer.ContentType()] = u
+	}
+
+	return m, nil
+}
+
+func (m MultiUnmarshaler) Unmarshal(natsMsg *nats.Msg) (*message.Message, error) {
+	contentType := natsMsg.Header.Get(ContentTypeHdr)
+
+	if u, ok := m.Unmarshalers[contentType]; ok {
+		return u.Unmarshal(natsMsg)
+	}
+
+	if m.Fallback == nil {
+		return nil, errors.Errorf("no unmarshaler for content type %q", contentType)
+	}
+
+	return m.Fallback.Unmarshal(natsMsg)
+}
+
 // GobMarshaler is marshaller which is using Gob to marshal Watermill messages.
 type GobMarshaler struct{}
 
+func (GobMarshaler) ContentType() string {
+	return GobContentType
+}
+
 func (GobMarshaler) Marshal(topic string, msg *message.Message) ([]byte, error) {
 	// todo - use pool
 	buf := new(bytes.Buffer)
@@ -60,3 +152,325 @@ func (GobMarshaler) Unmarshal(natsMsg *nats.Msg) (*message.Message, error) {
 
 	return msg, nil
 }
+
+// JSONPayloadEncoding is the encoding of payloads in the JSON envelope of JSONMarshaler.
+type JSONPayloadEncoding int
+
+const (
+	// JSONPayloadBase64 encodes payloads as base64 strings, so binary payloads can be sent.
+	JSONPayloadBase64 JSONPayloadEncoding = iota
+
+	// JSONPayloadString encodes payloads as JSON strings, so UTF-8 payloads stay readable
+	// in logs and tools. Payloads which are not valid UTF-8 cannot be marshaled.
+	JSONPayloadString
+)
+
+// JSONMarshaler is marshaller which is using JSON to marshal Watermill messages.
+//
+// Messages are encoded as a {"uuid", "metadata", "payload"} envelope, with base64 encoded payload
+// unless PayloadEncoding is set, so they can be consumed by non-Go services.
+//
+// The same message is always encoded to identical bytes, as encoding/json writes metadata keys in sorted order.
+type JSONMarshaler struct {
+	// PayloadEncoding is the encoding of payloads, JSONPayloadBase64 by default.
+	// The envelope doesn't record it, so publishers and subscribers have to use the same encoding.
+	PayloadEncoding JSONPayloadEncoding
+}
+
+func (JSONMarshaler) ContentType() string {
+	return JSONContentType
+}
+
+type jsonMessage struct {
+	UUID     string            `json:"uuid"`
+	Metadata map[string]string `json:"metadata"`
+	Payload  []byte            `json:"payload"`
+}
+
+// jsonStringMessage is jsonMessage with the payload encoded with JSONPayloadString.
+type jsonStringMessage struct {
+	UUID     string            `json:"uuid"`
+	Metadata map[string]string `json:"metadata"`
+	Payload  string            `json:"payload"`
+}
+
+func (m JSONMarshaler) Marshal(topic string, msg *message.Message) ([]byte, error) {
+	payload, err := m.encodePayload(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(struct {
+		UUID     string            `json:"uuid"`
+		Metadata map[string]string `json:"metadata"`
+		Payload  interface{}       `json:"payload"`
+	}{
+		UUID:     msg.UUID,
+		Metadata: msg.Metadata,
+		Payload:  payload,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot encode message")
+	}
+
+	return b, nil
+}
+
+// encodePayload returns the payload of msg as a value encoded by encoding/json with PayloadEncoding.
+func (m JSONMarshaler) encodePayload(msg *message.Message) (interface{}, error) {
+	switch m.PayloadEncoding {
+	case JSONPayloadBase64:
+		return []byte(msg.Payload), nil
+	case JSONPayloadString:
+		if !utf8.Valid(msg.Payload) {
+			return nil, errors.Errorf("payload of message %s is not valid UTF-8, it cannot be encoded as string", msg.UUID)
+		}
+		return string(msg.Payload), nil
+	default:
+		return nil, errors.Errorf("unknown JSON payload encoding %d", m.PayloadEncoding)
+	}
+}
+
+func (m JSONMarshaler) Unmarshal(natsMsg *nats.Msg) (*message.Message, error) {
+	var decodedMsg jsonMessage
+
+	switch m.PayloadEncoding {
+	case JSONPayloadBase64:
+		if err := json.Unmarshal(natsMsg.Data, &decodedMsg); err != nil {
+			return nil, errors.Wrap(err, "cannot decode message")
+		}
+	case JSONPayloadString:
+		var stringMsg jsonStringMessage
+		if err := json.Unmarshal(natsMsg.Data, &stringMsg); err != nil {
+			return nil, errors.Wrap(err, "cannot decode message")
+		}
+		decodedMsg = jsonMessage{UUID: stringMsg.UUID, Metadata: stringMsg.Metadata, Payload: []byte(stringMsg.Payload)}
+	default:
+		return nil, errors.Errorf("unknown JSON payload encoding %d", m.PayloadEncoding)
+	}
+
+	// creating clean message, to avoid invalid internal state with ack
+	msg := message.NewMessage(decodedMsg.UUID, decodedMsg.Payload)
+	if decodedMsg.Metadata != nil {
+		msg.Metadata = decodedMsg.Metadata
+	}
+
+	return msg, nil
+}
+
+// DeterministicJSONMarshaler is JSONMarshaler, which always encodes the same message to identical bytes,
+// so marshaled messages can be hashed or signed.
+//
+// Metadata keys are written in sorted order explicitly, so the output doesn't rely on encoding/json
+// sorting map keys. Messages are unmarshaled by JSONMarshaler with the same PayloadEncoding.
+type DeterministicJSONMarshaler struct {
+	JSONMarshaler
+}
+
+func (m DeterministicJSONMarshaler) Marshal(topic string, msg *message.Message) ([]byte, error) {
+	payload, err := m.encodePayload(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(`{"uuid":`)
+	if err := writeJSON(buf, msg.UUID); err != nil {
+		return nil, err
+	}
+
+	buf.WriteString(`,"metadata":`)
+	if msg.Metadata == nil {
+		buf.WriteString("null")
+	} else {
+		keys := make([]string, 0, len(msg.Metadata))
+		for k := range msg.Metadata {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSON(buf, k); err != nil {
+				return nil, err
+			}
+			buf.WriteByte(':')
+			if err := writeJSON(buf, msg.Metadata[k]); err != nil {
+				return nil, err
+			}
+		}
+		buf.WriteByte('}')
+	}
+
+	buf.WriteString(`,"payload":`)
+	if err := writeJSON(buf, payload); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// writeJSON writes v encoded by encoding/json to buf.
+func writeJSON(buf *bytes.Buffer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "cannot encode message")
+	}
+	buf.Write(b)
+
+	return nil
+}
+
+// ProtobufMarshaler is marshaller which is using Protocol Buffers to marshal Watermill messages.
+//
+// Messages are encoded as MessageEnvelope, defined in marshaler.proto. Unknown fields of the envelope
+// are ignored, so consumers keep working when fields are added by newer producers. Arbitrary bytes
+// may still be decoded as an envelope, MultiUnmarshaler with ContentTypeHdr can be used to tell them apart.
+type ProtobufMarshaler struct{}
+
+func (ProtobufMarshaler) ContentType() string {
+	return ProtobufContentType
+}
+
+func (ProtobufMarshaler) Marshal(topic string, msg *message.Message) ([]byte, error) {
+	b, err := proto.Marshal(&MessageEnvelope{
+		Uuid:     msg.UUID,
+		Metadata: msg.Metadata,
+		Payload:  msg.Payload,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot encode message")
+	}
+
+	return b, nil
+}
+
+func (ProtobufMarshaler) Unmarshal(natsMsg *nats.Msg) (*message.Message, error) {
+	var envelope MessageEnvelope
+	if err := proto.Unmarshal(natsMsg.Data, &envelope); err != nil {
+		return nil, errors.Wrap(err, "cannot decode message, it is not a protobuf message envelope")
+	}
+
+	// creating clean message, to avoid invalid internal state with ack
+	msg := message.NewMessage(envelope.Uuid, envelope.Payload)
+	for k, v := range envelope.Metadata {
+		msg.Metadata.Set(k, v)
+	}
+
+	return msg, nil
+}
+
+// WatermillUUIDHdr is the NATS header used by NATSMarshaler to carry the Watermill message UUID.
+const WatermillUUIDHdr = "_watermill_message_uuid"
+
+// NATSMarshaler is marshaller which is using NATS headers to marshal Watermill messages.
+//
+// Metadata is mapped onto nats.Header and the payload is sent as-is,
+// so messages are readable by other NATS tooling (for example `nats sub`).
+// The message UUID is carried in the WatermillUUIDHdr header.
+//
+// Metadata and UUID are sent only by MarshalMsg, Marshal returns just the payload.
+//
+// On unmarshal, JetStream headers (Nats-*) and headers of the global propagator are not mapped onto metadata,
+// so they are not published again when the message is forwarded.
+type NATSMarshaler struct{}
+
+func (NATSMarshaler) Marshal(topic string, msg *message.Message) ([]byte, error) {
+	return msg.Payload, nil
+}
+
+func (NATSMarshaler) MarshalMsg(topic string, msg *message.Message) (*nats.Msg, error) {
+	natsMsg := nats.NewMsg(topic)
+	natsMsg.Data = msg.Payload
+
+	for k, v := range msg.Metadata {
+		natsMsg.Header.Set(k, v)
+	}
+	natsMsg.Header.Set(WatermillUUIDHdr, msg.UUID)
+
+	return natsMsg, nil
+}
+
+func (NATSMarshaler) Unmarshal(natsMsg *nats.Msg) (*message.Message, error) {
+	// Header is nil when message was sent without headers, Get handles it gracefully
+	msg := message.NewMessage(natsMsg.Header.Get(WatermillUUIDHdr), natsMsg.Data)
+
+	headersToMetadata(natsMsg.Header, msg.Metadata)
+
+	return msg, nil
+}
+
+// headersToMetadata sets metadata from header, skipping headers which would change the outcome of publishing
+// the message again, for example to a dead letter topic. Multiple values of a header are joined with ", ".
+func headersToMetadata(header nats.Header, metadata message.Metadata) {
+	for k, values := range header {
+		if k == WatermillUUIDHdr || k == ContentTypeHdr || isJetStreamHeader(k) || isPropagatorHeader(k) {
+			continue
+		}
+
+		metadata.Set(k, strings.Join(values, ", "))
+	}
+}
+
+// isJetStreamHeader returns true for headers interpreted by JetStream, like nats.MsgIdHdr or nats.ExpectedStreamHdr.
+func isJetStreamHeader(key string) bool {
+	return strings.HasPrefix(strings.ToLower(key), "nats-")
+}
+
+// isPropagatorHeader returns true for headers of the global propagator (see otel.SetTextMapPropagator),
+// which are injected again by the publisher.
+func isPropagatorHeader(key string) bool {
+	for _, field := range otel.GetTextMapPropagator().Fields() {
+		if strings.EqualFold(key, field) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RawMarshaler passes the payload through as-is, so messages published by non-Watermill producers
+// can be consumed, and messages published with it are plain NATS messages.
+//
+// NATS headers are mapped onto metadata and vice versa. Unlike NATSMarshaler, the UUID is not sent.
+// On unmarshal it's read from the WatermillUUIDHdr or nats.MsgIdHdr header,
+// and generated when the message has neither of them. JetStream headers (Nats-*) and headers of the global propagator
+// are not mapped onto metadata, like with NATSMarshaler.
+//
+// Metadata is sent only by MarshalMsg, Marshal returns just the payload.
+type RawMarshaler struct{}
+
+func (RawMarshaler) Marshal(topic string, msg *message.Message) ([]byte, error) {
+	return msg.Payload, nil
+}
+
+func (RawMarshaler) MarshalMsg(topic string, msg *message.Message) (*nats.Msg, error) {
+	natsMsg := nats.NewMsg(topic)
+	natsMsg.Data = msg.Payload
+
+	for k, v := range msg.Metadata {
+		natsMsg.Header.Set(k, v)
+	}
+
+	return natsMsg, nil
+}
+
+func (RawMarshaler) Unmarshal(natsMsg *nats.Msg) (*message.Message, error) {
+	uuid := natsMsg.Header.Get(WatermillUUIDHdr)
+	if uuid == "" {
+		uuid = natsMsg.Header.Get(nats.MsgIdHdr)
+	}
+	if uuid == "" {
+		uuid = watermill.NewUUID()
+	}
+
+	msg := message.NewMessage(uuid, natsMsg.Data)
+
+	headersToMetadata(natsMsg.Header, msg.Metadata)
+
+	return msg, nil
+}
@@ -1,15 +1,21 @@
 package jetstream_test
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"github.com/nats-io/nats.go"
 	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/message"
 
 	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
@@ -23,8 +29,9 @@ func TestGobMarshaler(t *testing.T) {
 
 	b, err := marshaler.Marshal("topic", msg)
 	require.NoError(t, err)
+	natsMsg := &nats.Msg{Data: b}
 
-	unmarshaledMsg, err := marshaler.Unmarshal(&nats.Msg{Data: b})
+	unmarshaledMsg, err := marshaler.Unmarshal(natsMsg)
 	require.NoError(t, err)
 
 	assert.True(t, msg.Equals(unmarshaledMsg))
@@ -54,8 +61,9 @@ func TestGobMarshaler_multiple_messages_async(t *testing.T) {
 
 			b, err := marshaler.Marshal("topic", msg)
 			require.NoError(t, err)
+			natsMsg := &nats.Msg{Data: b}
 
-			unmarshaledMsg, err := marshaler.Unmarshal(&nats.Msg{Data: b})
+			unmarshaledMsg, err := marshaler.Unmarshal(natsMsg)
 
 			require.NoError(t, err)
 
@@ -65,3 +73,489 @@ func TestGobMarshaler_multiple_messages_async(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestJSONMarshaler(t *testing.T) {
+	msg := message.NewMessage("1", []byte("zag"))
+	msg.Metadata.Set("foo", "bar")
+
+	marshaler := jetstream.JSONMarshaler{}
+
+	b, err := marshaler.Marshal("topic", msg)
+	require.NoError(t, err)
+	natsMsg := &nats.Msg{Data: b}
+	require.True(t, json.Valid(natsMsg.Data))
+
+	unmarshaledMsg, err := marshaler.Unmarshal(natsMsg)
+	require.NoError(t, err)
+
+	assert.True(t, msg.Equals(unmarshaledMsg))
+
+	unmarshaledMsg.Ack()
+
+	select {
+	case <-unmarshaledMsg.Acked():
+		// ok
+	default:
+		t.Fatal("ack is not working")
+	}
+}
+
+func TestJSONMarshaler_envelope(t *testing.T) {
+	msg := message.NewMessage("1", []byte("zag"))
+	msg.Metadata.Set("foo", "bar")
+
+	b, err := jetstream.JSONMarshaler{}.Marshal("topic", msg)
+	require.NoError(t, err)
+	natsMsg := &nats.Msg{Data: b}
+
+	var envelope struct {
+		UUID     string            `json:"uuid"`
+		Metadata map[string]string `json:"metadata"`
+		Payload  string            `json:"payload"`
+	}
+	require.NoError(t, json.Unmarshal(natsMsg.Data, &envelope))
+
+	assert.Equal(t, "1", envelope.UUID)
+	assert.Equal(t, map[string]string{"foo": "bar"}, envelope.Metadata)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("zag")), envelope.Payload)
+}
+
+func TestJSONMarshaler_invalid_data(t *testing.T) {
+	_, err := jetstream.JSONMarshaler{}.Unmarshal(&nats.Msg{Data: []byte("not json")})
+	require.Error(t, err)
+}
+
+func TestJSONMarshaler_payload_encoding(t *testing.T) {
+	testCases := []struct {
+		Name            string
+		PayloadEncoding jetstream.JSONPayloadEncoding
+		ExpectedPayload string
+	}{
+		{
+			Name:            "base64",
+			PayloadEncoding: jetstream.JSONPayloadBase64,
+			ExpectedPayload: base64.StdEncoding.EncodeToString([]byte(`{"id":"żółw"}`)),
+		},
+		{
+			Name:            "string",
+			PayloadEncoding: jetstream.JSONPayloadString,
+			ExpectedPayload: `{"id":"żółw"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			msg := message.NewMessage("1", []byte(`{"id":"żółw"}`))
+			msg.Metadata.Set("foo", "bar")
+
+			for _, marshaler := range []jetstream.MarshalerUnmarshaler{
+				jetstream.JSONMarshaler{PayloadEncoding: tc.PayloadEncoding},
+				jetstream.DeterministicJSONMarshaler{JSONMarshaler: jetstream.JSONMarshaler{PayloadEncoding: tc.PayloadEncoding}},
+			} {
+				b, err := marshaler.Marshal("topic", msg)
+				require.NoError(t, err)
+				natsMsg := &nats.Msg{Data: b}
+
+				var envelope struct {
+					Payload string `json:"payload"`
+				}
+				require.NoError(t, json.Unmarshal(natsMsg.Data, &envelope))
+				assert.Equal(t, tc.ExpectedPayload, envelope.Payload)
+
+				unmarshaledMsg, err := marshaler.Unmarshal(natsMsg)
+				require.NoError(t, err)
+				assert.True(t, msg.Equals(unmarshaledMsg))
+			}
+		})
+	}
+}
+
+func TestJSONMarshaler_string_payload_invalid_utf8(t *testing.T) {
+	msg := message.NewMessage("1", []byte{0xff, 0xfe})
+
+	_, err := jetstream.JSONMarshaler{PayloadEncoding: jetstream.JSONPayloadString}.Marshal("topic", msg)
+	assert.ErrorContains(t, err, "not valid UTF-8")
+
+	_, err = jetstream.DeterministicJSONMarshaler{
+		JSONMarshaler: jetstream.JSONMarshaler{PayloadEncoding: jetstream.JSONPayloadString},
+	}.Marshal("topic", msg)
+	assert.ErrorContains(t, err, "not valid UTF-8")
+
+	// binary payloads are still supported by the default encoding
+	_, err = jetstream.JSONMarshaler{}.Marshal("topic", msg)
+	assert.NoError(t, err)
+}
+
+func TestDeterministicJSONMarshaler(t *testing.T) {
+	newMsg := func() *message.Message {
+		msg := message.NewMessage("1", []byte("zag"))
+		for i := 0; i < 20; i++ {
+			msg.Metadata.Set(fmt.Sprintf("key_%d", i), fmt.Sprintf("<value %d>", i))
+		}
+		return msg
+	}
+
+	for _, marshaler := range []jetstream.MarshalerUnmarshaler{
+		jetstream.JSONMarshaler{},
+		jetstream.DeterministicJSONMarshaler{},
+	} {
+		t.Run(fmt.Sprintf("%T", marshaler), func(t *testing.T) {
+			first, err := marshaler.Marshal("topic", newMsg())
+			require.NoError(t, err)
+			require.True(t, json.Valid(first))
+
+			for i := 0; i < 100; i++ {
+				b, err := marshaler.Marshal("topic", newMsg())
+				require.NoError(t, err)
+				require.Equal(t, string(first), string(b), "marshaled message should be stable")
+			}
+
+			unmarshaledMsg, err := marshaler.Unmarshal(&nats.Msg{Data: first})
+			require.NoError(t, err)
+			assert.True(t, newMsg().Equals(unmarshaledMsg))
+		})
+	}
+
+	assert.Equal(t, jetstream.JSONContentType, jetstream.DeterministicJSONMarshaler{}.ContentType())
+}
+
+func TestDeterministicJSONMarshaler_sorted_metadata(t *testing.T) {
+	msg := message.NewMessage("1", []byte("zag"))
+	for _, key := range []string{"b", "c", "a", "<tag>"} {
+		msg.Metadata.Set(key, "value "+key)
+	}
+
+	b, err := jetstream.DeterministicJSONMarshaler{}.Marshal("topic", msg)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		`{"uuid":"1","metadata":{"\u003ctag\u003e":"value \u003ctag\u003e","a":"value a","b":"value b","c":"value c"},"payload":"emFn"}`,
+		string(b),
+	)
+
+	// the encoding is compatible with JSONMarshaler
+	jsonBytes, err := jetstream.JSONMarshaler{}.Marshal("topic", msg)
+	require.NoError(t, err)
+	assert.Equal(t, string(jsonBytes), string(b))
+
+	msg.Metadata = nil
+	b, err = jetstream.DeterministicJSONMarshaler{}.Marshal("topic", msg)
+	require.NoError(t, err)
+	assert.Equal(t, `{"uuid":"1","metadata":null,"payload":"emFn"}`, string(b))
+}
+
+func TestProtobufMarshaler(t *testing.T) {
+	msg := message.NewMessage("1", []byte("zag"))
+	msg.Metadata.Set("foo", "bar")
+	msg.Metadata.Set("empty", "")
+
+	marshaler := jetstream.ProtobufMarshaler{}
+
+	b, err := marshaler.Marshal("topic", msg)
+	require.NoError(t, err)
+	natsMsg := &nats.Msg{Data: b}
+
+	unmarshaledMsg, err := marshaler.Unmarshal(natsMsg)
+	require.NoError(t, err)
+
+	assert.True(t, msg.Equals(unmarshaledMsg))
+
+	empty, ok := unmarshaledMsg.Metadata["empty"]
+	assert.True(t, ok)
+	assert.Equal(t, "", empty)
+
+	unmarshaledMsg.Ack()
+
+	select {
+	case <-unmarshaledMsg.Acked():
+		// ok
+	default:
+		t.Fatal("ack is not working")
+	}
+}
+
+func TestProtobufMarshaler_empty_uuid(t *testing.T) {
+	marshaler := jetstream.ProtobufMarshaler{}
+
+	for _, msg := range []*message.Message{
+		message.NewMessage("", []byte("zag")),
+		message.NewMessage("", nil),
+	} {
+		b, err := marshaler.Marshal("topic", msg)
+		require.NoError(t, err)
+		natsMsg := &nats.Msg{Data: b}
+
+		unmarshaledMsg, err := marshaler.Unmarshal(natsMsg)
+		require.NoError(t, err)
+		assert.True(t, msg.Equals(unmarshaledMsg))
+	}
+}
+
+func TestProtobufMarshaler_unknown_fields(t *testing.T) {
+	// envelope with uuid "1" and field 4, which may be added by a newer version of the envelope
+	data := []byte{0x0a, 0x01, 0x31, 0x20, 0x01}
+
+	unmarshaledMsg, err := jetstream.ProtobufMarshaler{}.Unmarshal(&nats.Msg{Data: data})
+	require.NoError(t, err)
+	assert.Equal(t, "1", unmarshaledMsg.UUID)
+}
+
+func TestProtobufMarshaler_invalid_data(t *testing.T) {
+	testCases := []struct {
+		Name string
+		Data []byte
+	}{
+		{Name: "text", Data: []byte("not a protobuf message")},
+		{Name: "json", Data: []byte(`{"uuid":"1"}`)},
+		{Name: "truncated", Data: []byte{0x0a, 0x10, 0x31}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := jetstream.ProtobufMarshaler{}.Unmarshal(&nats.Msg{Data: tc.Data})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestNATSMarshaler(t *testing.T) {
+	msg := message.NewMessage("1", []byte("zag"))
+	msg.Metadata.Set("foo", "bar")
+
+	marshaler := jetstream.NATSMarshaler{}
+
+	natsMsg, err := marshaler.MarshalMsg("topic", msg)
+	require.NoError(t, err)
+
+	assert.Equal(t, "topic", natsMsg.Subject)
+	assert.Equal(t, []byte("zag"), natsMsg.Data)
+	assert.Equal(t, "bar", natsMsg.Header.Get("foo"))
+	assert.Equal(t, "1", natsMsg.Header.Get(jetstream.WatermillUUIDHdr))
+
+	unmarshaledMsg, err := marshaler.Unmarshal(natsMsg)
+	require.NoError(t, err)
+
+	assert.True(t, msg.Equals(unmarshaledMsg))
+	_, hasUUIDKey := unmarshaledMsg.Metadata[jetstream.WatermillUUIDHdr]
+	assert.False(t, hasUUIDKey)
+}
+
+func TestNATSMarshaler_no_headers(t *testing.T) {
+	unmarshaledMsg, err := jetstream.NATSMarshaler{}.Unmarshal(&nats.Msg{Subject: "topic", Data: []byte("zag")})
+	require.NoError(t, err)
+
+	assert.Equal(t, "", unmarshaledMsg.UUID)
+	assert.Empty(t, unmarshaledMsg.Metadata)
+	assert.Equal(t, message.Payload("zag"), unmarshaledMsg.Payload)
+}
+
+func TestNATSMarshaler_headers(t *testing.T) {
+	propagator := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(propagator)
+
+	for _, marshaler := range []jetstream.Unmarshaler{jetstream.NATSMarshaler{}, jetstream.RawMarshaler{}} {
+		t.Run(fmt.Sprintf("%T", marshaler), func(t *testing.T) {
+			natsMsg := nats.NewMsg("topic")
+			natsMsg.Header.Set(jetstream.WatermillUUIDHdr, "1")
+			natsMsg.Header.Set(nats.MsgIdHdr, "1")
+			natsMsg.Header.Set(nats.ExpectedStreamHdr, "stream")
+			natsMsg.Header.Set(nats.ExpectedLastSubjSeqHdr, "10")
+			natsMsg.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+			natsMsg.Header.Set("foo", "bar")
+			natsMsg.Header.Add("multi", "1")
+			natsMsg.Header.Add("multi", "2")
+
+			unmarshaledMsg, err := marshaler.Unmarshal(natsMsg)
+			require.NoError(t, err)
+
+			assert.Equal(t, "1", unmarshaledMsg.UUID)
+			assert.Equal(t, message.Metadata{"foo": "bar", "multi": "1, 2"}, unmarshaledMsg.Metadata)
+		})
+	}
+}
+
+func TestRawMarshaler(t *testing.T) {
+	msg := message.NewMessage("1", []byte{0x00, 0xff})
+	msg.Metadata.Set("foo", "bar")
+
+	marshaler := jetstream.RawMarshaler{}
+
+	natsMsg, err := marshaler.MarshalMsg("topic", msg)
+	require.NoError(t, err)
+
+	assert.Equal(t, "topic", natsMsg.Subject)
+	assert.Equal(t, []byte{0x00, 0xff}, natsMsg.Data)
+	assert.Equal(t, "bar", natsMsg.Header.Get("foo"))
+	assert.Empty(t, natsMsg.Header.Get(jetstream.WatermillUUIDHdr), "UUID should not be sent")
+
+	unmarshaledMsg, err := marshaler.Unmarshal(natsMsg)
+	require.NoError(t, err)
+
+	assert.Equal(t, message.Payload{0x00, 0xff}, unmarshaledMsg.Payload)
+	assert.Equal(t, message.Metadata{"foo": "bar"}, unmarshaledMsg.Metadata)
+	assert.NotEmpty(t, unmarshaledMsg.UUID)
+}
+
+func TestRawMarshaler_uuid(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		Header       nats.Header
+		ExpectedUUID string
+	}{
+		{
+			Name:         "watermill_uuid",
+			Header:       nats.Header{jetstream.WatermillUUIDHdr: []string{"1"}, nats.MsgIdHdr: []string{"2"}},
+			ExpectedUUID: "1",
+		},
+		{
+			Name:         "msg_id",
+			Header:       nats.Header{nats.MsgIdHdr: []string{"2"}},
+			ExpectedUUID: "2",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			unmarshaledMsg, err := jetstream.RawMarshaler{}.Unmarshal(&nats.Msg{Subject: "topic", Header: tc.Header})
+			require.NoError(t, err)
+			assert.Equal(t, tc.ExpectedUUID, unmarshaledMsg.UUID)
+		})
+	}
+
+	t.Run("generated", func(t *testing.T) {
+		natsMsg := &nats.Msg{Subject: "topic", Data: []byte("zag")}
+
+		first, err := jetstream.RawMarshaler{}.Unmarshal(natsMsg)
+		require.NoError(t, err)
+		second, err := jetstream.RawMarshaler{}.Unmarshal(natsMsg)
+		require.NoError(t, err)
+
+		assert.NotEmpty(t, first.UUID)
+		assert.NotEqual(t, first.UUID, second.UUID)
+		assert.Empty(t, first.Metadata)
+	})
+}
+
+func TestMultiUnmarshaler(t *testing.T) {
+	multi, err := jetstream.NewMultiUnmarshaler(
+		jetstream.NATSMarshaler{},
+		jetstream.GobMarshaler{},
+		jetstream.JSONMarshaler{},
+		jetstream.ProtobufMarshaler{},
+	)
+	require.NoError(t, err)
+
+	msg := message.NewMessage("1", []byte("zag"))
+	msg.Metadata.Set("foo", "bar")
+
+	marshalers := []interface {
+		jetstream.Marshaler
+		jetstream.ContentTyper
+	}{
+		jetstream.GobMarshaler{},
+		jetstream.JSONMarshaler{},
+		jetstream.ProtobufMarshaler{},
+	}
+
+	for _, marshaler := range marshalers {
+		t.Run(marshaler.ContentType(), func(t *testing.T) {
+			b, err := marshaler.Marshal("topic", msg)
+			require.NoError(t, err)
+			natsMsg := &nats.Msg{Data: b}
+			natsMsg.Header = nats.Header{}
+			natsMsg.Header.Set(jetstream.ContentTypeHdr, marshaler.ContentType())
+
+			unmarshaledMsg, err := multi.Unmarshal(natsMsg)
+			require.NoError(t, err)
+			assert.True(t, msg.Equals(unmarshaledMsg))
+		})
+	}
+
+	t.Run("fallback", func(t *testing.T) {
+		natsMsg, err := jetstream.NATSMarshaler{}.MarshalMsg("topic", msg)
+		require.NoError(t, err)
+
+		unmarshaledMsg, err := multi.Unmarshal(natsMsg)
+		require.NoError(t, err)
+		assert.True(t, msg.Equals(unmarshaledMsg))
+	})
+
+	t.Run("unknown_content_type_without_fallback", func(t *testing.T) {
+		natsMsg := nats.NewMsg("topic")
+		natsMsg.Header.Set(jetstream.ContentTypeHdr, "text/plain")
+
+		_, err := jetstream.MultiUnmarshaler{}.Unmarshal(natsMsg)
+		assert.Error(t, err)
+	})
+}
+
+func TestMultiUnmarshaler_mixed_publishers(t *testing.T) {
+	topic := newStream(t)
+
+	multi, err := jetstream.NewMultiUnmarshaler(nil, jetstream.GobMarshaler{}, jetstream.JSONMarshaler{})
+	require.NoError(t, err)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{Unmarshaler: multi})
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	var published []*message.Message
+	for _, marshaler := range []jetstream.Marshaler{jetstream.GobMarshaler{}, jetstream.JSONMarshaler{}} {
+		pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+			URL:       getNatsURL(),
+			Marshaler: marshaler,
+		}, watermill.NewStdLogger(true, false))
+		require.NoError(t, err)
+
+		published = append(published, publishMessages(t, pub, topic, 1)...)
+		require.NoError(t, pub.Close())
+	}
+
+	received := receiveMessages(t, messages, len(published))
+	assert.Equal(t, messageUUIDs(published), messageUUIDs(received))
+}
+
+func TestTopicMarshaler(t *testing.T) {
+	gobTopic := newStream(t)
+	jsonTopic := newStream(t)
+
+	pub := newPublisher(t)
+	pub.SetTopicMarshaler(jsonTopic, jetstream.JSONMarshaler{})
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{})
+	sub.SetTopicUnmarshaler(jsonTopic, jetstream.JSONMarshaler{})
+
+	for _, topic := range []string{gobTopic, jsonTopic} {
+		messages, err := sub.Subscribe(context.Background(), topic)
+		require.NoError(t, err)
+
+		published := publishMessages(t, pub, topic, 2)
+		received := receiveMessages(t, messages, len(published))
+		assert.Equal(t, messageUUIDs(published), messageUUIDs(received))
+	}
+
+	js := newJetstream(t)
+	for topic, contentType := range map[string]string{
+		gobTopic:  jetstream.GobContentType,
+		jsonTopic: jetstream.JSONContentType,
+	} {
+		raw, err := js.GetLastMsg(topic, topic)
+		require.NoError(t, err)
+		assert.Equal(t, contentType, raw.Header.Get(jetstream.ContentTypeHdr))
+	}
+}
+
+func TestTopicMarshaler_removed(t *testing.T) {
+	topic := newStream(t)
+
+	pub := newPublisher(t)
+	pub.SetTopicMarshaler(topic, jetstream.JSONMarshaler{})
+	pub.SetTopicMarshaler(topic, nil)
+
+	publishMessages(t, pub, topic, 1)
+
+	raw, err := newJetstream(t).GetLastMsg(topic, topic)
+	require.NoError(t, err)
+	assert.Equal(t, jetstream.GobContentType, raw.Header.Get(jetstream.ContentTypeHdr))
+}
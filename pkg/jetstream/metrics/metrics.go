@@ -0,0 +1,82 @@
+// Package metrics provides Prometheus metrics of JetStream publishers and subscribers.
+//
+// Metrics are registered once with NewMetrics and collected by decorated publishers and subscribers:
+//
+//	m, err := metrics.NewMetrics(prometheus.DefaultRegisterer)
+//	if err != nil {
+//		panic(err)
+//	}
+//
+//	sub, err := jetstream.NewStreamingSubscriber(config, logger)
+//	if err != nil {
+//		panic(err)
+//	}
+//	// decoratedSub should be used and closed instead of sub
+//	decoratedSub := m.DecorateSubscriber(sub, config.QueueGroup)
+//
+//	pub, err := jetstream.NewNatsStreamingPublisher(pubConfig, logger)
+//	if err != nil {
+//		panic(err)
+//	}
+//	decoratedPub := m.DecoratePublisher(pub)
+//
+// Redeliveries are counted only when StreamingSubscriberConfig.DeliveryMetadata is enabled,
+// as they are detected with jetstream.NumDeliveredKey metadata.
+package metrics
+
+import (
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "watermill_jetstream"
+
+// Metrics are Prometheus collectors shared by decorated publishers and subscribers.
+type Metrics struct {
+	published    *prometheus.CounterVec
+	received     *prometheus.CounterVec
+	redelivered  *prometheus.CounterVec
+	ackFailures  *prometheus.CounterVec
+	ackLatencies *prometheus.HistogramVec
+}
+
+// NewMetrics creates collectors and registers them with registerer.
+func NewMetrics(registerer prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		published: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_published_total",
+			Help:      "Number of published messages, result is success or error.",
+		}, []string{"topic", "result"}),
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_received_total",
+			Help:      "Number of messages received by subscribers, including redeliveries.",
+		}, []string{"topic", "queue_group"}),
+		redelivered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_redelivered_total",
+			Help:      "Number of received messages which were delivered before.",
+		}, []string{"topic", "queue_group"}),
+		ackFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "ack_failures_total",
+			Help:      "Number of acks and naks which were not sent to NATS, topic is the NATS subject.",
+		}, []string{"topic", "queue_group"}),
+		ackLatencies: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "ack_latency_seconds",
+			Help:      "Time since the message was passed to the handler until it was acked or nacked.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"topic", "queue_group", "outcome"}),
+	}
+
+	collectors := []prometheus.Collector{m.published, m.received, m.redelivered, m.ackFailures, m.ackLatencies}
+	for _, c := range collectors {
+		if err := registerer.Register(c); err != nil {
+			return nil, errors.Wrap(err, "cannot register metrics")
+		}
+	}
+
+	return m, nil
+}
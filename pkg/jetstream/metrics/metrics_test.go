@@ -0,0 +1,137 @@
+package metrics_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream/metrics"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream/natstest"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(natstest.RunTests(m, "WATERMILL_TEST_NATS_URL"))
+}
+
+func getNatsURL() string {
+	natsURL := os.Getenv("WATERMILL_TEST_NATS_URL")
+	if natsURL == "" {
+		natsURL = nats.DefaultURL
+	}
+
+	return natsURL
+}
+
+func TestMetrics(t *testing.T) {
+	js, err := jetstream.NewJetstreamConnection(&jetstream.NatsConnConfig{URL: getNatsURL()})
+	require.NoError(t, err)
+
+	topic := "topic_" + watermill.NewShortUUID()
+	require.NoError(t, jetstream.EnsureStream(js, jetstream.StreamConfig{Name: topic}))
+	defer func() {
+		_ = js.DeleteStream(topic)
+	}()
+
+	registry := prometheus.NewRegistry()
+	m, err := metrics.NewMetrics(registry)
+	require.NoError(t, err)
+
+	logger := watermill.NewStdLogger(true, false)
+
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:       getNatsURL(),
+		Marshaler: jetstream.GobMarshaler{},
+	}, logger)
+	require.NoError(t, err)
+	decoratedPub := m.DecoratePublisher(pub)
+	defer func() {
+		_ = decoratedPub.Close()
+	}()
+
+	subConfig := jetstream.StreamingSubscriberConfig{
+		URL:              getNatsURL(),
+		QueueGroup:       "group",
+		Unmarshaler:      jetstream.GobMarshaler{},
+		DeliveryMetadata: true,
+		AckWaitTimeout:   time.Second,
+		NakDelay:         time.Millisecond * 10,
+	}
+	sub, err := jetstream.NewStreamingSubscriber(subConfig, logger)
+	require.NoError(t, err)
+	decoratedSub := m.DecorateSubscriber(sub, subConfig.QueueGroup)
+
+	messages, err := decoratedSub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	require.NoError(t, decoratedPub.Publish(topic, message.NewMessage(watermill.NewUUID(), nil)))
+	assert.Error(t, decoratedPub.Publish("missing_"+topic, message.NewMessage(watermill.NewUUID(), nil)))
+
+	receive := func() *message.Message {
+		select {
+		case msg := <-messages:
+			return msg
+		case <-time.After(time.Second * 5):
+			t.Fatal("message not received")
+			return nil
+		}
+	}
+	receive().Nack()
+	receive().Ack()
+
+	require.NoError(t, decoratedSub.Close())
+
+	assert.Equal(t, 1.0, metricValue(t, registry, "watermill_jetstream_messages_published_total", map[string]string{
+		"topic": topic, "result": "success",
+	}))
+	assert.Equal(t, 1.0, metricValue(t, registry, "watermill_jetstream_messages_published_total", map[string]string{
+		"topic": "missing_" + topic, "result": "error",
+	}))
+
+	subscriberLabels := map[string]string{"topic": topic, "queue_group": "group"}
+	assert.Equal(t, 2.0, metricValue(t, registry, "watermill_jetstream_messages_received_total", subscriberLabels))
+	assert.Equal(t, 1.0, metricValue(t, registry, "watermill_jetstream_messages_redelivered_total", subscriberLabels))
+
+	for _, outcome := range []string{"ack", "nack"} {
+		assert.Equal(t, 1.0, metricValue(t, registry, "watermill_jetstream_ack_latency_seconds", map[string]string{
+			"topic": topic, "queue_group": "group", "outcome": outcome,
+		}), "one %s should be observed", outcome)
+	}
+}
+
+// metricValue returns the value of the counter, or the sample count of the histogram, with labels.
+func metricValue(t *testing.T, registry *prometheus.Registry, name string, labels map[string]string) float64 {
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+
+	metrics:
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if labels[label.GetName()] != label.GetValue() {
+					continue metrics
+				}
+			}
+
+			if metric.GetHistogram() != nil {
+				return float64(metric.GetHistogram().GetSampleCount())
+			}
+			return metric.GetCounter().GetValue()
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// Publisher is a publisher decorated with metrics.
+type Publisher struct {
+	pub     message.Publisher
+	metrics *Metrics
+}
+
+// DecoratePublisher returns pub counting published messages.
+func (m *Metrics) DecoratePublisher(pub message.Publisher) *Publisher {
+	return &Publisher{pub: pub, metrics: m}
+}
+
+// Publish publishes messages with the decorated publisher.
+//
+// When publishing fails, all messages are counted as failed, as the decorated publisher
+// doesn't report which of them were published.
+func (p *Publisher) Publish(topic string, messages ...*message.Message) error {
+	err := p.pub.Publish(topic, messages...)
+
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	p.metrics.published.WithLabelValues(topic, result).Add(float64(len(messages)))
+
+	return err
+}
+
+func (p *Publisher) Close() error {
+	return p.pub.Close()
+}
@@ -0,0 +1,161 @@
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+)
+
+// Subscriber is a StreamingSubscriber decorated with metrics.
+type Subscriber struct {
+	sub        *jetstream.StreamingSubscriber
+	queueGroup string
+	metrics    *Metrics
+
+	ackErrors chan jetstream.AckError
+
+	closing   chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// DecorateSubscriber returns sub counting received messages, redeliveries and ack failures,
+// and measuring the ack latency. queueGroup is used as the queue_group label.
+//
+// Ack failures are read from sub.AckErrors, so they should be received from Subscriber.AckErrors instead.
+func (m *Metrics) DecorateSubscriber(sub *jetstream.StreamingSubscriber, queueGroup string) *Subscriber {
+	s := &Subscriber{
+		sub:        sub,
+		queueGroup: queueGroup,
+		metrics:    m,
+		ackErrors:  make(chan jetstream.AckError, jetstream.AckErrorsBufferSize),
+		closing:    make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.forwardAckErrors()
+
+	return s
+}
+
+// Subscribe subscribes with the decorated subscriber.
+func (s *Subscriber) Subscribe(ctx context.Context, topic string) (<-chan *message.Message, error) {
+	messages, err := s.sub.Subscribe(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	output := make(chan *message.Message)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(output)
+
+		for msg := range messages {
+			s.received(topic, msg)
+
+			select {
+			case output <- msg:
+			case <-s.closing:
+				return
+			case <-ctx.Done():
+				return
+			}
+
+			s.wg.Add(1)
+			go s.measureAckLatency(topic, msg, time.Now())
+		}
+	}()
+
+	return output, nil
+}
+
+func (s *Subscriber) SubscribeInitialize(topic string) error {
+	return s.sub.SubscribeInitialize(topic)
+}
+
+func (s *Subscriber) received(topic string, msg *message.Message) {
+	s.metrics.received.WithLabelValues(topic, s.queueGroup).Inc()
+
+	numDelivered, err := strconv.Atoi(msg.Metadata.Get(jetstream.NumDeliveredKey))
+	if err == nil && numDelivered > 1 {
+		s.metrics.redelivered.WithLabelValues(topic, s.queueGroup).Inc()
+	}
+}
+
+func (s *Subscriber) measureAckLatency(topic string, msg *message.Message, handledAt time.Time) {
+	defer s.wg.Done()
+
+	select {
+	case <-msg.Acked():
+	case <-msg.Nacked():
+	case <-s.closing:
+	}
+
+	var outcome string
+	select {
+	case <-msg.Acked():
+		outcome = "ack"
+	case <-msg.Nacked():
+		outcome = "nack"
+	default:
+		// closed before the message was acked
+		return
+	}
+
+	s.metrics.ackLatencies.WithLabelValues(topic, s.queueGroup, outcome).Observe(time.Since(handledAt).Seconds())
+}
+
+func (s *Subscriber) forwardAckErrors() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case ackErr := <-s.sub.AckErrors():
+			s.metrics.ackFailures.WithLabelValues(ackErr.Topic, s.queueGroup).Inc()
+			s.sendAckError(ackErr)
+		case <-s.closing:
+			return
+		}
+	}
+}
+
+// sendAckError sends ackErr to AckErrors, dropping the oldest error when the buffer is full,
+// the same as StreamingSubscriber does.
+func (s *Subscriber) sendAckError(ackErr jetstream.AckError) {
+	for {
+		select {
+		case s.ackErrors <- ackErr:
+			return
+		default:
+		}
+
+		select {
+		case <-s.ackErrors:
+		default:
+		}
+	}
+}
+
+// AckErrors returns errors of sending acknowledgements, forwarded from StreamingSubscriber.AckErrors.
+func (s *Subscriber) AckErrors() <-chan jetstream.AckError {
+	return s.ackErrors
+}
+
+// Close closes the decorated subscriber.
+func (s *Subscriber) Close() error {
+	err := s.sub.Close()
+
+	s.closeOnce.Do(func() {
+		close(s.closing)
+	})
+	s.wg.Wait()
+
+	return err
+}
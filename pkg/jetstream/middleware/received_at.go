@@ -0,0 +1,38 @@
+// Package middleware provides Watermill router middlewares for messages received by jetstream.StreamingSubscriber.
+//
+// Middlewares are added to the router or to a handler:
+//
+//	router.AddMiddleware(middleware.ReceivedAt)
+package middleware
+
+import (
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+)
+
+// ReceivedAtKey is the metadata key with the time when the message was received by the server
+// and stored in the stream, formatted as time.RFC3339Nano.
+const ReceivedAtKey = "_nats_received_at"
+
+// ReceivedAt sets ReceivedAtKey metadata of JetStream messages to the server timestamp, so the latency
+// between publishing and handling can be measured.
+//
+// Messages without JetStream metadata, for example received with core NATS or not received
+// by jetstream.StreamingSubscriber, are passed to the handler unchanged.
+//
+// The same timestamp is set in jetstream.TimestampKey with StreamingSubscriberConfig.DeliveryMetadata,
+// together with the rest of delivery metadata.
+func ReceivedAt(h message.HandlerFunc) message.HandlerFunc {
+	return func(msg *message.Message) ([]*message.Message, error) {
+		if natsMsg, ok := jetstream.NatsMsgFromMessage(msg); ok {
+			if meta, err := natsMsg.Metadata(); err == nil {
+				msg.Metadata.Set(ReceivedAtKey, meta.Timestamp.Format(time.RFC3339Nano))
+			}
+		}
+
+		return h(msg)
+	}
+}
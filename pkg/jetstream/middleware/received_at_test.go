@@ -0,0 +1,102 @@
+package middleware_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream/middleware"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream/natstest"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(natstest.RunTests(m, "WATERMILL_TEST_NATS_URL"))
+}
+
+func getNatsURL() string {
+	natsURL := os.Getenv("WATERMILL_TEST_NATS_URL")
+	if natsURL == "" {
+		natsURL = nats.DefaultURL
+	}
+
+	return natsURL
+}
+
+func passMessage(msg *message.Message) ([]*message.Message, error) {
+	return nil, nil
+}
+
+func TestReceivedAt(t *testing.T) {
+	js, err := jetstream.NewJetstreamConnection(&jetstream.NatsConnConfig{URL: getNatsURL()})
+	require.NoError(t, err)
+
+	topic := "topic_" + watermill.NewShortUUID()
+	require.NoError(t, jetstream.EnsureStream(js, jetstream.StreamConfig{Name: topic}))
+	defer func() {
+		_ = jetstream.DeleteStream(js, topic)
+	}()
+
+	logger := watermill.NewStdLogger(true, false)
+
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:       getNatsURL(),
+		Marshaler: jetstream.GobMarshaler{},
+	}, logger)
+	require.NoError(t, err)
+	defer func() {
+		_ = pub.Close()
+	}()
+
+	sub, err := jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+		URL:         getNatsURL(),
+		Unmarshaler: jetstream.GobMarshaler{},
+	}, logger)
+	require.NoError(t, err)
+	defer func() {
+		_ = sub.Close()
+	}()
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	publishedAt := time.Now()
+	require.NoError(t, pub.Publish(topic, message.NewMessage(watermill.NewUUID(), nil)))
+
+	var msg *message.Message
+	select {
+	case msg = <-messages:
+	case <-time.After(time.Second * 5):
+		t.Fatal("message not received")
+	}
+	defer msg.Ack()
+
+	_, err = middleware.ReceivedAt(passMessage)(msg)
+	require.NoError(t, err)
+
+	receivedAt, err := time.Parse(time.RFC3339Nano, msg.Metadata.Get(middleware.ReceivedAtKey))
+	require.NoError(t, err)
+	assert.WithinDuration(t, publishedAt, receivedAt, time.Second)
+}
+
+func TestReceivedAt_without_jetstream_metadata(t *testing.T) {
+	msg := message.NewMessage(watermill.NewUUID(), nil)
+
+	handled := false
+	_, err := middleware.ReceivedAt(func(msg *message.Message) ([]*message.Message, error) {
+		handled = true
+		return nil, nil
+	})(msg)
+	require.NoError(t, err)
+
+	assert.True(t, handled)
+	assert.Empty(t, msg.Metadata.Get(middleware.ReceivedAtKey))
+}
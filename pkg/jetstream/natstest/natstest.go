@@ -0,0 +1,109 @@
+// Package natstest runs an embedded NATS server with JetStream enabled, so tests and examples
+// don't need an external server:
+//
+//	url := natstest.Run(t)
+//	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{URL: url, ...}, logger)
+package natstest
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/pkg/errors"
+)
+
+// readyTimeout is how long Start waits for the server to accept connections.
+const readyTimeout = time.Second * 10
+
+// Server is an embedded NATS server listening on a random free port of localhost.
+type Server struct {
+	server   *server.Server
+	storeDir string
+}
+
+// Start starts the embedded server with JetStream stored in a temporary directory.
+// The server should be stopped with Shutdown, which removes the directory.
+func Start() (*Server, error) {
+	storeDir, err := os.MkdirTemp("", "natstest-")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create JetStream store directory")
+	}
+
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  storeDir,
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		_ = os.RemoveAll(storeDir)
+		return nil, errors.Wrap(err, "cannot create NATS server")
+	}
+
+	go srv.Start()
+
+	if !srv.ReadyForConnections(readyTimeout) {
+		srv.Shutdown()
+		_ = os.RemoveAll(storeDir)
+		return nil, errors.Errorf("NATS server is not ready for connections after %s", readyTimeout)
+	}
+
+	return &Server{server: srv, storeDir: storeDir}, nil
+}
+
+// URL returns the client URL of the server, for example nats://127.0.0.1:41234.
+func (s *Server) URL() string {
+	return s.server.ClientURL()
+}
+
+// Shutdown stops the server and removes its JetStream store.
+func (s *Server) Shutdown() {
+	s.server.Shutdown()
+	s.server.WaitForShutdown()
+	_ = os.RemoveAll(s.storeDir)
+}
+
+// Run starts the embedded server for tb and returns its URL, the server is stopped when tb finishes.
+func Run(tb testing.TB) string {
+	tb.Helper()
+
+	srv, err := Start()
+	if err != nil {
+		tb.Fatalf("cannot start embedded NATS server: %s", err)
+	}
+	tb.Cleanup(srv.Shutdown)
+
+	return srv.URL()
+}
+
+// RunTests runs tests of m with the embedded server, unless the URL of an external server is set
+// in the urlEnv environment variable. The URL of the embedded server is set in urlEnv, so tests read it
+// like the URL of the external one:
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(natstest.RunTests(m, "WATERMILL_TEST_NATS_URL"))
+//	}
+func RunTests(m *testing.M, urlEnv string) int {
+	if os.Getenv(urlEnv) != "" {
+		return m.Run()
+	}
+
+	srv, err := Start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot start embedded NATS server: %s\n", err)
+		return 1
+	}
+	defer srv.Shutdown()
+
+	if err := os.Setenv(urlEnv, srv.URL()); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot set %s: %s\n", urlEnv, err)
+		return 1
+	}
+
+	return m.Run()
+}
@@ -0,0 +1,64 @@
+package natstest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream/natstest"
+)
+
+func TestRun(t *testing.T) {
+	url := natstest.Run(t)
+
+	js, err := jetstream.NewJetstreamConnection(&jetstream.NatsConnConfig{URL: url})
+	require.NoError(t, err)
+	require.NoError(t, jetstream.EnsureStream(js, jetstream.StreamConfig{Name: "topic"}))
+
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:       url,
+		Marshaler: jetstream.GobMarshaler{},
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+	defer pub.Close()
+
+	sub, err := jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+		URL:         url,
+		Unmarshaler: jetstream.GobMarshaler{},
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	messages, err := sub.Subscribe(context.Background(), "topic")
+	require.NoError(t, err)
+
+	published := message.NewMessage(watermill.NewUUID(), []byte("payload"))
+	require.NoError(t, pub.Publish("topic", published))
+
+	select {
+	case msg := <-messages:
+		assert.Equal(t, published.UUID, msg.UUID)
+		msg.Ack()
+	case <-time.After(time.Second * 5):
+		t.Fatal("message not received")
+	}
+}
+
+func TestStart(t *testing.T) {
+	first, err := natstest.Start()
+	require.NoError(t, err)
+	defer first.Shutdown()
+
+	second, err := natstest.Start()
+	require.NoError(t, err)
+	second.Shutdown()
+
+	assert.NotEqual(t, first.URL(), second.URL(), "servers should listen on random ports")
+}
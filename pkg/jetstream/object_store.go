@@ -0,0 +1,98 @@
+package jetstream
+
+import (
+	"context"
+	"sync"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+	"github.com/pkg/errors"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+const (
+	// ObjectBucketKey is the metadata key with the Object Store bucket of the payload stored by the publisher,
+	// when the message was larger than MaxInlineSize.
+	ObjectBucketKey = "_nats_object_bucket"
+
+	// ObjectNameKey is the metadata key with the name of the object with the payload stored by the publisher.
+	ObjectNameKey = "_nats_object_name"
+)
+
+// objectStores caches Object Store buckets of received messages, so they are not looked up for each message.
+type objectStores struct {
+	js nats.JetStreamContext
+
+	lock   sync.Mutex
+	stores map[string]nats.ObjectStore
+}
+
+func newObjectStores(js nats.JetStreamContext) *objectStores {
+	return &objectStores{js: js, stores: map[string]nats.ObjectStore{}}
+}
+
+func (o *objectStores) get(bucket string) (nats.ObjectStore, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if store, ok := o.stores[bucket]; ok {
+		return store, nil
+	}
+
+	store, err := o.js.ObjectStore(bucket)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot get object store %s", bucket)
+	}
+	o.stores[bucket] = store
+
+	return store, nil
+}
+
+// storePayload stores the payload of msg in store of bucket and returns the reference message
+// with an empty payload and the object in metadata.
+//
+// The object name is unique for each publish, so payloads of messages sharing a UUID, like retried messages
+// or messages without UUID, don't overwrite each other.
+func storePayload(store nats.ObjectStore, bucket string, msg *message.Message) (*message.Message, error) {
+	name := nuid.Next()
+	if msg.UUID != "" {
+		name = msg.UUID + "_" + name
+	}
+
+	if _, err := store.PutBytes(name, msg.Payload, nats.Context(msg.Context())); err != nil {
+		return nil, errors.Wrapf(err, "cannot store payload of message %s", msg.UUID)
+	}
+
+	ref := message.NewMessage(msg.UUID, nil)
+	for k, v := range msg.Metadata {
+		ref.Metadata.Set(k, v)
+	}
+	ref.Metadata.Set(ObjectBucketKey, bucket)
+	ref.Metadata.Set(ObjectNameKey, name)
+	ref.SetContext(msg.Context())
+
+	return ref, nil
+}
+
+// fetchPayload sets the payload of msg stored by the publisher, it does nothing when the payload is inline.
+func (o *objectStores) fetchPayload(ctx context.Context, msg *message.Message) error {
+	bucket := msg.Metadata.Get(ObjectBucketKey)
+	if bucket == "" {
+		return nil
+	}
+	name := msg.Metadata.Get(ObjectNameKey)
+
+	store, err := o.get(bucket)
+	if err != nil {
+		return err
+	}
+
+	payload, err := store.GetBytes(name, nats.Context(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "cannot get object %s from %s", name, bucket)
+	}
+	msg.Payload = payload
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package jetstream_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+)
+
+func TestObjectStore(t *testing.T) {
+	topic := newStream(t)
+	js := newJetstream(t)
+
+	bucket := "objects_" + watermill.NewShortUUID()
+	_, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: bucket})
+	require.NoError(t, err)
+	defer func() {
+		_ = js.DeleteObjectStore(bucket)
+	}()
+
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:               getNatsURL(),
+		Marshaler:         jetstream.GobMarshaler{},
+		ObjectStoreBucket: bucket,
+		MaxInlineSize:     1024,
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+	defer func() {
+		_ = pub.Close()
+	}()
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{})
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	// larger than the default max payload of the server (1MB)
+	large := message.NewMessage(watermill.NewUUID(), bytes.Repeat([]byte("x"), 2*1024*1024))
+	large.Metadata.Set("key", "value")
+	small := message.NewMessage(watermill.NewUUID(), []byte("payload"))
+
+	require.NoError(t, pub.Publish(topic, large))
+
+	stored, err := js.GetLastMsg(topic, topic)
+	require.NoError(t, err)
+	assert.Less(t, len(stored.Data), 1024, "reference message should be published instead of the payload")
+
+	require.NoError(t, pub.Publish(topic, small))
+
+	received := receiveMessages(t, messages, 2)
+
+	assert.Equal(t, large.UUID, received[0].UUID)
+	assert.Equal(t, large.Payload, received[0].Payload)
+	assert.Equal(t, "value", received[0].Metadata.Get("key"))
+	assert.Equal(t, bucket, received[0].Metadata.Get(jetstream.ObjectBucketKey))
+	assert.True(t, strings.HasPrefix(received[0].Metadata.Get(jetstream.ObjectNameKey), large.UUID+"_"))
+
+	assert.Equal(t, small.UUID, received[1].UUID)
+	assert.Equal(t, small.Payload, received[1].Payload)
+	assert.Empty(t, received[1].Metadata.Get(jetstream.ObjectBucketKey))
+}
+
+func TestObjectStore_same_uuid(t *testing.T) {
+	topic := newStream(t)
+	js := newJetstream(t)
+
+	bucket := "objects_" + watermill.NewShortUUID()
+	_, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: bucket})
+	require.NoError(t, err)
+	defer func() {
+		_ = js.DeleteObjectStore(bucket)
+	}()
+
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:               getNatsURL(),
+		Marshaler:         jetstream.GobMarshaler{},
+		ObjectStoreBucket: bucket,
+		MaxInlineSize:     1024,
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+	defer func() {
+		_ = pub.Close()
+	}()
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{})
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	// messages with the same UUID, for example a retried message, don't overwrite each other's payload
+	uuid := watermill.NewUUID()
+	first := message.NewMessage(uuid, bytes.Repeat([]byte("a"), 2048))
+	second := message.NewMessage(uuid, bytes.Repeat([]byte("b"), 2048))
+	require.NoError(t, pub.Publish(topic, first, second))
+
+	received := receiveMessages(t, messages, 2)
+	assert.Equal(t, first.Payload, received[0].Payload)
+	assert.Equal(t, second.Payload, received[1].Payload)
+}
+
+func TestObjectStore_requires_bucket(t *testing.T) {
+	_, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:           getNatsURL(),
+		Marshaler:     jetstream.GobMarshaler{},
+		MaxInlineSize: 1024,
+	}, watermill.NewStdLogger(true, false))
+	assert.Error(t, err)
+
+	_, err = jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:               getNatsURL(),
+		Marshaler:         jetstream.GobMarshaler{},
+		ObjectStoreBucket: "missing_" + watermill.NewShortUUID(),
+		MaxInlineSize:     1024,
+	}, watermill.NewStdLogger(true, false))
+	assert.Error(t, err, "bucket should exist")
+}
@@ -0,0 +1,259 @@
+package jetstream
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// PriorityMetadataKey is the metadata key of the message priority used by PriorityPublisher.
+const PriorityMetadataKey = "_priority"
+
+// Priorities of messages published with PriorityPublisher.
+const (
+	PriorityHigh = "high"
+	PriorityLow  = "low"
+)
+
+// PriorityTopic returns the topic of priority lane of topic, for example topic.high.
+// The stream of topic should store subjects of both lanes, for example with topic.> subject.
+func PriorityTopic(topic, priority string) string {
+	return topic + "." + priority
+}
+
+// PriorityPublisher is a publisher routing messages to priority lanes of topics, see PrioritySubscriber.
+type PriorityPublisher struct {
+	pub message.Publisher
+}
+
+// NewPriorityPublisher returns pub publishing messages to PriorityTopic of their PriorityMetadataKey metadata.
+// Messages without the metadata are published with PriorityLow.
+func NewPriorityPublisher(pub message.Publisher) (*PriorityPublisher, error) {
+	if pub == nil {
+		return nil, errors.New("missing publisher")
+	}
+
+	return &PriorityPublisher{pub: pub}, nil
+}
+
+// Publish publishes messages to priority lanes of topic, in the order of messages.
+//
+// When one of messages has an unknown priority, none of them are published.
+func (p *PriorityPublisher) Publish(topic string, messages ...*message.Message) error {
+	priorities := make([]string, len(messages))
+	for i, msg := range messages {
+		priority, err := messagePriority(msg)
+		if err != nil {
+			return err
+		}
+		priorities[i] = priority
+	}
+
+	for i, msg := range messages {
+		if err := p.pub.Publish(PriorityTopic(topic, priorities[i]), msg); err != nil {
+			return errors.Wrapf(err, "cannot publish message %s", msg.UUID)
+		}
+	}
+
+	return nil
+}
+
+func (p *PriorityPublisher) Close() error {
+	return p.pub.Close()
+}
+
+func messagePriority(msg *message.Message) (string, error) {
+	switch priority := msg.Metadata.Get(PriorityMetadataKey); priority {
+	case "":
+		return PriorityLow, nil
+	case PriorityHigh, PriorityLow:
+		return priority, nil
+	default:
+		return "", errors.Errorf("message %s has unknown priority %s", msg.UUID, priority)
+	}
+}
+
+// PrioritySubscriber is a subscriber merging priority lanes of topics published with PriorityPublisher.
+type PrioritySubscriber struct {
+	high          message.Subscriber
+	low           message.Subscriber
+	fairnessRatio int
+
+	// subscribers are closed on Close, a subscriber used for both lanes is closed once
+	subscribers []message.Subscriber
+
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+// NewPrioritySubscriber returns sub subscribing to both priority lanes of topics.
+//
+// High priority messages are always delivered first when messages of both lanes are waiting, so low priority
+// messages are starved as long as high priority messages keep coming. With fairnessRatio greater than zero,
+// a waiting low priority message is delivered after each fairnessRatio high priority messages.
+//
+// Lanes are different subjects, so they can't share a durable consumer. StreamingSubscriber with DurableName
+// or QueueGroup is rejected with ErrInvalidConfig, NewPriorityLanesSubscriber has to be used with a subscriber
+// of a different DurableName for each lane instead.
+func NewPrioritySubscriber(sub message.Subscriber, fairnessRatio int) (*PrioritySubscriber, error) {
+	if sub == nil {
+		return nil, errors.New("missing subscriber")
+	}
+	if streaming, ok := sub.(*StreamingSubscriber); ok && streaming.config.durableName() != "" {
+		return nil, withKind(ErrInvalidConfig, errors.Errorf(
+			"durable consumer %s cannot be used for both priority lanes: "+
+				"use NewPriorityLanesSubscriber with a different DurableName for each lane",
+			streaming.config.durableName(),
+		))
+	}
+
+	return newPrioritySubscriber(sub, sub, fairnessRatio, []message.Subscriber{sub})
+}
+
+// NewPriorityLanesSubscriber returns PrioritySubscriber subscribing to the high priority lane with high
+// and to the low priority lane with low, so each lane can have its own durable consumer.
+// See NewPrioritySubscriber for fairnessRatio.
+func NewPriorityLanesSubscriber(high, low message.Subscriber, fairnessRatio int) (*PrioritySubscriber, error) {
+	if high == nil || low == nil {
+		return nil, errors.New("missing subscriber")
+	}
+
+	return newPrioritySubscriber(high, low, fairnessRatio, []message.Subscriber{high, low})
+}
+
+func newPrioritySubscriber(high, low message.Subscriber, fairnessRatio int, subscribers []message.Subscriber) (*PrioritySubscriber, error) {
+	if fairnessRatio < 0 {
+		return nil, errors.New("fairnessRatio cannot be negative")
+	}
+
+	return &PrioritySubscriber{
+		high:          high,
+		low:           low,
+		fairnessRatio: fairnessRatio,
+		subscribers:   subscribers,
+		closing:       make(chan struct{}),
+	}, nil
+}
+
+// Subscribe subscribes to priority lanes of topic and returns the merged output channel,
+// which is closed when outputs of both lanes are closed.
+//
+// Messages are passed through, so acks are sent to the lane they were received from. The priority
+// decides only between messages waiting in both lanes, each lane still waits for the ack of the
+// delivered message before the next one, unless it's processed concurrently (see HandlerConcurrency).
+func (s *PrioritySubscriber) Subscribe(ctx context.Context, topic string) (<-chan *message.Message, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	high, err := s.high.Subscribe(ctx, PriorityTopic(topic, PriorityHigh))
+	if err != nil {
+		cancel()
+		return nil, errors.Wrap(err, "cannot subscribe to high priority topic")
+	}
+
+	low, err := s.low.Subscribe(ctx, PriorityTopic(topic, PriorityLow))
+	if err != nil {
+		// closes the high priority subscription
+		cancel()
+		return nil, errors.Wrap(err, "cannot subscribe to low priority topic")
+	}
+
+	output := make(chan *message.Message)
+	go func() {
+		defer cancel()
+		s.merge(ctx, high, low, output)
+	}()
+
+	return output, nil
+}
+
+// merge forwards messages of high and low to output until both of them are closed.
+func (s *PrioritySubscriber) merge(ctx context.Context, high, low <-chan *message.Message, output chan<- *message.Message) {
+	defer close(output)
+
+	// highStreak is the number of high priority messages delivered after the last low priority one
+	highStreak := 0
+
+	for high != nil || low != nil {
+		if s.fairnessRatio > 0 && highStreak >= s.fairnessRatio {
+			select {
+			case msg, ok := <-low:
+				if !ok {
+					low = nil
+					continue
+				}
+				highStreak = 0
+				if !s.forward(ctx, msg, output) {
+					return
+				}
+				continue
+			default:
+			}
+		}
+
+		select {
+		case msg, ok := <-high:
+			if !ok {
+				high = nil
+				continue
+			}
+			highStreak++
+			if !s.forward(ctx, msg, output) {
+				return
+			}
+			continue
+		default:
+		}
+
+		select {
+		case msg, ok := <-high:
+			if !ok {
+				high = nil
+				continue
+			}
+			highStreak++
+			if !s.forward(ctx, msg, output) {
+				return
+			}
+		case msg, ok := <-low:
+			if !ok {
+				low = nil
+				continue
+			}
+			highStreak = 0
+			if !s.forward(ctx, msg, output) {
+				return
+			}
+		}
+	}
+}
+
+// forward sends msg to output, it returns false when ctx is done or the subscriber is closed before.
+// The message is not acked then, so it's redelivered.
+func (s *PrioritySubscriber) forward(ctx context.Context, msg *message.Message, output chan<- *message.Message) bool {
+	select {
+	case output <- msg:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-s.closing:
+		return false
+	}
+}
+
+func (s *PrioritySubscriber) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closing)
+	})
+
+	var closeErr error
+	for _, sub := range s.subscribers {
+		if err := sub.Close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+
+	return closeErr
+}
@@ -0,0 +1,196 @@
+package jetstream_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+)
+
+// channelSubscriber returns prepared channels by topic, so messages of both lanes are waiting
+// before the merged channel is read.
+type channelSubscriber struct {
+	outputs map[string]chan *message.Message
+}
+
+func (s channelSubscriber) Subscribe(_ context.Context, topic string) (<-chan *message.Message, error) {
+	return s.outputs[topic], nil
+}
+
+func (s channelSubscriber) Close() error {
+	for _, output := range s.outputs {
+		close(output)
+	}
+	return nil
+}
+
+func newPriorityMessages(sub channelSubscriber, topic, priority string, count int) {
+	output := make(chan *message.Message, count)
+	for i := 0; i < count; i++ {
+		msg := message.NewMessage(watermill.NewUUID(), nil)
+		msg.Metadata.Set(jetstream.PriorityMetadataKey, priority)
+		output <- msg
+	}
+	sub.outputs[jetstream.PriorityTopic(topic, priority)] = output
+}
+
+func receivePriorities(t *testing.T, sub *jetstream.PrioritySubscriber, topic string, count int) []string {
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	var priorities []string
+	for _, msg := range receiveMessages(t, messages, count) {
+		priorities = append(priorities, msg.Metadata.Get(jetstream.PriorityMetadataKey))
+	}
+
+	return priorities
+}
+
+func TestPrioritySubscriber(t *testing.T) {
+	testCases := []struct {
+		Name               string
+		FairnessRatio      int
+		ExpectedPriorities []string
+	}{
+		{
+			Name:          "strict",
+			FairnessRatio: 0,
+			ExpectedPriorities: []string{
+				jetstream.PriorityHigh, jetstream.PriorityHigh, jetstream.PriorityHigh, jetstream.PriorityHigh,
+				jetstream.PriorityLow, jetstream.PriorityLow,
+			},
+		},
+		{
+			Name:          "fairness_ratio",
+			FairnessRatio: 2,
+			ExpectedPriorities: []string{
+				jetstream.PriorityHigh, jetstream.PriorityHigh, jetstream.PriorityLow,
+				jetstream.PriorityHigh, jetstream.PriorityHigh, jetstream.PriorityLow,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			lanes := channelSubscriber{outputs: map[string]chan *message.Message{}}
+			newPriorityMessages(lanes, "topic", jetstream.PriorityHigh, 4)
+			newPriorityMessages(lanes, "topic", jetstream.PriorityLow, 2)
+
+			sub, err := jetstream.NewPrioritySubscriber(lanes, tc.FairnessRatio)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.ExpectedPriorities, receivePriorities(t, sub, "topic", 6))
+			require.NoError(t, sub.Close())
+		})
+	}
+}
+
+func TestPriorityPublisher(t *testing.T) {
+	topic := "topic_" + watermill.NewShortUUID()
+	js := newJetstream(t)
+	require.NoError(t, jetstream.EnsureStream(js, jetstream.StreamConfig{Name: topic, Subjects: []string{topic + ".>"}}))
+	t.Cleanup(func() {
+		_ = jetstream.DeleteStream(js, topic, jetstream.IgnoreStreamNotFound())
+	})
+
+	pub, err := jetstream.NewPriorityPublisher(newPublisher(t))
+	require.NoError(t, err)
+
+	high := message.NewMessage(watermill.NewUUID(), nil)
+	high.Metadata.Set(jetstream.PriorityMetadataKey, jetstream.PriorityHigh)
+	low := message.NewMessage(watermill.NewUUID(), nil)
+
+	unknown := message.NewMessage(watermill.NewUUID(), nil)
+	unknown.Metadata.Set(jetstream.PriorityMetadataKey, "urgent")
+	assert.ErrorContains(t, pub.Publish(topic, high, unknown), "unknown priority urgent")
+
+	require.NoError(t, pub.Publish(topic, high, low))
+
+	sub, err := jetstream.NewPrioritySubscriber(newSubscriber(t, jetstream.StreamingSubscriberConfig{}), 0)
+	require.NoError(t, err)
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	received := map[string]struct{}{}
+	for _, msg := range receiveMessages(t, messages, 2) {
+		received[msg.UUID] = struct{}{}
+	}
+	assert.Equal(t, map[string]struct{}{high.UUID: {}, low.UUID: {}}, received, "messages without priority should be published as low")
+
+	info, err := js.StreamInfo(topic, &nats.StreamInfoRequest{SubjectsFilter: ">"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]uint64{
+		jetstream.PriorityTopic(topic, jetstream.PriorityHigh): 1,
+		jetstream.PriorityTopic(topic, jetstream.PriorityLow):  1,
+	}, info.State.Subjects, "no message should be published when one of them has unknown priority")
+}
+
+func TestPrioritySubscriber_durable(t *testing.T) {
+	topic := "topic_" + watermill.NewShortUUID()
+	js := newJetstream(t)
+	require.NoError(t, jetstream.EnsureStream(js, jetstream.StreamConfig{Name: topic, Subjects: []string{topic + ".>"}}))
+	t.Cleanup(func() {
+		_ = jetstream.DeleteStream(js, topic, jetstream.IgnoreStreamNotFound())
+	})
+
+	// lanes can't share the durable consumer
+	_, err := jetstream.NewPrioritySubscriber(newSubscriber(t, jetstream.StreamingSubscriberConfig{DurableName: "durable"}), 0)
+	assert.ErrorIs(t, err, jetstream.ErrInvalidConfig)
+
+	pub, err := jetstream.NewPriorityPublisher(newPublisher(t))
+	require.NoError(t, err)
+
+	high := message.NewMessage(watermill.NewUUID(), nil)
+	high.Metadata.Set(jetstream.PriorityMetadataKey, jetstream.PriorityHigh)
+	low := message.NewMessage(watermill.NewUUID(), nil)
+	require.NoError(t, pub.Publish(topic, high, low))
+
+	sub, err := jetstream.NewPriorityLanesSubscriber(
+		newSubscriber(t, jetstream.StreamingSubscriberConfig{DurableName: "durable_high"}),
+		newSubscriber(t, jetstream.StreamingSubscriberConfig{DurableName: "durable_low"}),
+		0,
+	)
+	require.NoError(t, err)
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	received := map[string]struct{}{}
+	for _, msg := range receiveMessages(t, messages, 2) {
+		received[msg.UUID] = struct{}{}
+		msg.Ack()
+	}
+	assert.Equal(t, map[string]struct{}{high.UUID: {}, low.UUID: {}}, received)
+
+	for _, durable := range []string{"durable_high", "durable_low"} {
+		assert.Eventually(t, func() bool {
+			info, err := js.ConsumerInfo(topic, durable)
+			return err == nil && info.NumAckPending == 0 && info.Delivered.Consumer == 1
+		}, time.Second*5, time.Millisecond*50, "each lane should have its own consumer")
+	}
+
+	require.NoError(t, sub.Close())
+}
+
+func TestNewPrioritySubscriber_invalid(t *testing.T) {
+	_, err := jetstream.NewPrioritySubscriber(nil, 0)
+	assert.Error(t, err)
+
+	_, err = jetstream.NewPrioritySubscriber(channelSubscriber{}, -1)
+	assert.Error(t, err)
+
+	_, err = jetstream.NewPriorityLanesSubscriber(channelSubscriber{}, nil, 0)
+	assert.Error(t, err)
+
+	_, err = jetstream.NewPriorityPublisher(nil)
+	assert.Error(t, err)
+}
@@ -1,8 +1,16 @@
 package jetstream
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
 	nats "github.com/nats-io/nats.go"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/message"
@@ -12,36 +20,382 @@ type StreamingPublisherConfig struct {
 	// URL is the NATS URL.
 	URL string
 
+	// URLs are URLs of multiple servers of a NATS cluster used together with URL, so the client
+	// can reconnect to another server when one goes down.
+	URLs []string
+
 	// NatsOptions are custom options for a connection.
 	NatsOptions []nats.Option
 
+	// CredentialsFile is the path to the NATS credentials file (JWT and NKey seed) used for authentication.
+	CredentialsFile string
+
+	// Token is the authentication token, it is mapped to nats.Token. It cannot be used with Username and Password.
+	Token string
+
+	// Username and Password are used for the user/password authentication, they are mapped to nats.UserInfo.
+	// Like Token, they are not logged.
+	Username string
+	Password string
+
+	// NKeySeed is the NKey user seed (starting with "SU") used for authentication, the public key is derived
+	// from it and the server nonce is signed with it. NKeySeedFile can be used instead to read the seed from a file.
+	// They cannot be used with CredentialsFile, Token and Username.
+	NKeySeed     string
+	NKeySeedFile string
+
+	// TLSConfig is the TLS configuration of the connection, TLSFiles can be used to build it from PEM files.
+	TLSConfig *tls.Config
+
+	// OnDisconnect is called when the connection to NATS is lost, err is nil when it was closed explicitly.
+	OnDisconnect func(conn *nats.Conn, err error)
+
+	// OnReconnect is called when the connection to NATS is re-established.
+	OnReconnect func(conn *nats.Conn)
+
+	// OnClosed is called when the connection is closed and no further reconnects will be attempted.
+	OnClosed func(conn *nats.Conn)
+
+	// ClientName is the name of the connection shown in NATS monitoring, it is mapped to nats.Name.
+	// When empty, watermill-jetstream-<hostname> is used, unless a name is set with NatsOptions.
+	ClientName string
+
+	// PingInterval is the interval of pings sent to the server to detect broken connections,
+	// the nats.go default (2 minutes) is used when zero. It is mapped to nats.PingInterval.
+	PingInterval time.Duration
+
+	// MaxPingsOutstanding is the number of pings without a response after which the connection
+	// is considered broken, the nats.go default (2) is used when zero. It is mapped to nats.MaxPingsOutstanding.
+	MaxPingsOutstanding int
+
+	// NoEcho prevents messages published with core NATS on the connection from being delivered
+	// to subscriptions of the same connection, it is mapped to nats.NoEcho. Messages delivered
+	// by JetStream consumers are sent by the server, so they are still received.
+	//
+	// It cannot be used with ConnectionProvider, as the provider may share the connection
+	// with components relying on echo.
+	NoEcho bool
+
+	// ReconnectBufferSize is the size in bytes of the buffer of messages published while the connection
+	// is reconnecting, it is mapped to nats.ReconnectBufSize. Buffered messages are flushed when the connection
+	// is re-established, Publish still waits for their acks until the JetStream request timeout.
+	// Messages are buffered until the size is reached, publishing then fails with ErrReconnectBufferExceeded.
+	//
+	// When zero, the nats.go default (8MB) is used, a negative value disables buffering, so publishing
+	// fails right away while reconnecting.
+	ReconnectBufferSize int
+
+	// LazyConnect defers connecting to NATS until the first Publish, PublishAsync, PublishBatch or JetStream call,
+	// so the constructor only validates the config, for example to avoid connecting on cold starts when no messages
+	// are published. Connection errors are returned by the first use, which is retried by the next one.
+	LazyConnect bool
+
+	// ConnectionProvider creates the connection instead of connecting to URL with NatsOptions,
+	// so connections can be configured in one place or mocked in tests.
+	// When set, URL, NatsOptions and other connection options of the config are ignored.
+	// The created connection is closed on Close.
+	ConnectionProvider ConnectionProvider
+
 	// Marshaler is marshaler used to marshal messages to stan format.
+	// When it implements ContentTyper, the content type is set in ContentTypeHdr header.
+	// It can be overridden per topic with StreamingPublisher.SetTopicMarshaler.
 	Marshaler Marshaler
+
+	// MaxPendingAsync is the maximum number of messages published with PublishAsync waiting for an ack.
+	// When reached, PublishAsync blocks for a while until acks are received and fails if they don't arrive.
+	// When zero, the nats.go default (4000) is used.
+	MaxPendingAsync int
+
+	// Deduplication sets Nats-Msg-Id header of published messages, so JetStream discards messages
+	// published again within the duplicates window of the stream, for example when publishing is retried.
+	Deduplication bool
+
+	// DeduplicationKey is the metadata key of the value used as Nats-Msg-Id when Deduplication is enabled.
+	// When empty, the message UUID is used. Messages without the metadata are not published.
+	DeduplicationKey string
+
+	// StampUUIDAsMsgID sets Nats-Msg-Id header of published messages to the message UUID, so messages
+	// can be correlated with messages stored in JetStream, for example in the nats CLI. Subscribers use the header
+	// as the UUID of messages without the UUID set by the unmarshaler.
+	//
+	// JetStream deduplicates messages by the header anyway, so a message published again with the same UUID
+	// within the duplicates window of the stream is discarded, like with Deduplication.
+	// It cannot be used with DeduplicationKey.
+	StampUUIDAsMsgID bool
+
+	// SubjectToStream returns the name of the stream expected to store messages published to topic,
+	// it is mapped to nats.ExpectStream. JetStream rejects messages when the subject is stored by another stream,
+	// which guards against publishing to the wrong stream when subjects of streams overlap.
+	// Messages are not published when it returns an empty name.
+	SubjectToStream func(topic string) string
+
+	// TopicResolver returns the topic of each published message, so subjects can be built from message metadata,
+	// for example events.<tenant>.<type>. When set, it overrides the topic passed to Publish, PublishAsync
+	// and PublishBatch, the resolved topic is used also by SubjectToStream and SetTopicMarshaler.
+	//
+	// The resolved topic has to be a concrete subject, messages are not published when it's empty
+	// or contains wildcards.
+	TopicResolver func(msg *message.Message) (string, error)
+
+	// Tracer enables OpenTelemetry tracing, when set, a producer span is started for each published message,
+	// with the message context as the parent. The span context is injected into NATS headers with the global
+	// propagator (see otel.SetTextMapPropagator), so the receive span of the subscriber is linked to it.
+	Tracer trace.Tracer
+
+	// ObjectStoreBucket is the Object Store bucket where payloads of messages larger than MaxInlineSize are stored,
+	// the bucket has to exist before the publisher is created.
+	ObjectStoreBucket string
+
+	// MaxInlineSize is the maximum size of the marshaled message published with the payload inline.
+	// Payloads of larger messages are stored in ObjectStoreBucket, and a reference message with an empty payload
+	// and ObjectBucketKey, ObjectNameKey metadata is published instead, subscribers fetch the payload transparently.
+	// When zero, payloads are always published inline.
+	//
+	// Stored objects are not deleted after delivery, as the message can be delivered again or received
+	// by other consumers. They are cleaned up by the TTL of the bucket (see nats.ObjectStoreConfig.TTL),
+	// which should be longer than the MaxAge of the stream.
+	MaxInlineSize int
+
+	// BlockOnFull makes Publish retry when the stream is full, which happens when MaxMsgs or MaxBytes limit
+	// of a stream with nats.DiscardNew policy is reached. Publish blocks until space frees up, at most
+	// for BlockOnFullTimeout or until the message context is done. PublishAsync and PublishBatch don't retry.
+	BlockOnFull bool
+
+	// PublishRetryBackoff is the delay before the first retry with BlockOnFull, 100 milliseconds by default.
+	// It is doubled after each retry, up to 5 seconds.
+	PublishRetryBackoff time.Duration
+
+	// BlockOnFullTimeout is the maximum time Publish retries with BlockOnFull, 30 seconds by default.
+	BlockOnFullTimeout time.Duration
+
+	// CloseTimeout is the maximum time Close waits for buffered messages to be flushed
+	// and for acks of messages published with PublishAsync. When zero, 30 seconds are used.
+	CloseTimeout time.Duration
 }
 
 type StreamingPublisherPublishConfig struct {
 	// Marshaler is marshaler used to marshal messages to stan format.
+	// When it implements ContentTyper, the content type is set in ContentTypeHdr header.
+	// It can be overridden per topic with StreamingPublisher.SetTopicMarshaler.
 	Marshaler Marshaler
+
+	// MaxPendingAsync is the maximum number of messages published with PublishAsync waiting for an ack.
+	// When reached, PublishAsync blocks for a while until acks are received and fails if they don't arrive.
+	// When zero, the nats.go default (4000) is used.
+	MaxPendingAsync int
+
+	// Deduplication sets Nats-Msg-Id header of published messages, so JetStream discards messages
+	// published again within the duplicates window of the stream, for example when publishing is retried.
+	Deduplication bool
+
+	// DeduplicationKey is the metadata key of the value used as Nats-Msg-Id when Deduplication is enabled.
+	// When empty, the message UUID is used. Messages without the metadata are not published.
+	DeduplicationKey string
+
+	// StampUUIDAsMsgID sets Nats-Msg-Id header of published messages to the message UUID, so messages
+	// can be correlated with messages stored in JetStream, for example in the nats CLI. Subscribers use the header
+	// as the UUID of messages without the UUID set by the unmarshaler.
+	//
+	// JetStream deduplicates messages by the header anyway, so a message published again with the same UUID
+	// within the duplicates window of the stream is discarded, like with Deduplication.
+	// It cannot be used with DeduplicationKey.
+	StampUUIDAsMsgID bool
+
+	// SubjectToStream returns the name of the stream expected to store messages published to topic,
+	// it is mapped to nats.ExpectStream. JetStream rejects messages when the subject is stored by another stream,
+	// which guards against publishing to the wrong stream when subjects of streams overlap.
+	// Messages are not published when it returns an empty name.
+	SubjectToStream func(topic string) string
+
+	// TopicResolver returns the topic of each published message, so subjects can be built from message metadata,
+	// for example events.<tenant>.<type>. When set, it overrides the topic passed to Publish, PublishAsync
+	// and PublishBatch, the resolved topic is used also by SubjectToStream and SetTopicMarshaler.
+	//
+	// The resolved topic has to be a concrete subject, messages are not published when it's empty
+	// or contains wildcards.
+	TopicResolver func(msg *message.Message) (string, error)
+
+	// Tracer enables OpenTelemetry tracing, when set, a producer span is started for each published message,
+	// with the message context as the parent. The span context is injected into NATS headers with the global
+	// propagator (see otel.SetTextMapPropagator), so the receive span of the subscriber is linked to it.
+	Tracer trace.Tracer
+
+	// ObjectStoreBucket is the Object Store bucket where payloads of messages larger than MaxInlineSize are stored,
+	// the bucket has to exist before the publisher is created.
+	ObjectStoreBucket string
+
+	// MaxInlineSize is the maximum size of the marshaled message published with the payload inline.
+	// Payloads of larger messages are stored in ObjectStoreBucket, and a reference message with an empty payload
+	// and ObjectBucketKey, ObjectNameKey metadata is published instead, subscribers fetch the payload transparently.
+	// When zero, payloads are always published inline.
+	//
+	// Stored objects are not deleted after delivery, as the message can be delivered again or received
+	// by other consumers. They are cleaned up by the TTL of the bucket (see nats.ObjectStoreConfig.TTL),
+	// which should be longer than the MaxAge of the stream.
+	MaxInlineSize int
+
+	// BlockOnFull makes Publish retry when the stream is full, which happens when MaxMsgs or MaxBytes limit
+	// of a stream with nats.DiscardNew policy is reached. Publish blocks until space frees up, at most
+	// for BlockOnFullTimeout or until the message context is done. PublishAsync and PublishBatch don't retry.
+	BlockOnFull bool
+
+	// PublishRetryBackoff is the delay before the first retry with BlockOnFull, 100 milliseconds by default.
+	// It is doubled after each retry, up to 5 seconds.
+	PublishRetryBackoff time.Duration
+
+	// BlockOnFullTimeout is the maximum time Publish retries with BlockOnFull, 30 seconds by default.
+	BlockOnFullTimeout time.Duration
+
+	// CloseTimeout is the maximum time Close waits for buffered messages to be flushed
+	// and for acks of messages published with PublishAsync. When zero, 30 seconds are used.
+	CloseTimeout time.Duration
 }
 
 func (c StreamingPublisherConfig) Validate() error {
 	if c.Marshaler == nil {
 		return errors.New("StreamingPublisherConfig.Marshaler is missing")
 	}
+	if c.MaxPendingAsync < 0 {
+		return errors.New("StreamingPublisherConfig.MaxPendingAsync cannot be negative")
+	}
+	if c.CloseTimeout < 0 {
+		return errors.New("StreamingPublisherConfig.CloseTimeout cannot be negative")
+	}
+	if c.PublishRetryBackoff < 0 {
+		return errors.New("StreamingPublisherConfig.PublishRetryBackoff cannot be negative")
+	}
+	if c.BlockOnFullTimeout < 0 {
+		return errors.New("StreamingPublisherConfig.BlockOnFullTimeout cannot be negative")
+	}
+	if c.MaxInlineSize < 0 {
+		return errors.New("StreamingPublisherConfig.MaxInlineSize cannot be negative")
+	}
+	if c.MaxInlineSize > 0 && c.ObjectStoreBucket == "" {
+		return errors.New("StreamingPublisherConfig.MaxInlineSize requires ObjectStoreBucket")
+	}
+	if c.PingInterval < 0 {
+		return errors.New("StreamingPublisherConfig.PingInterval cannot be negative")
+	}
+	if c.MaxPingsOutstanding < 0 {
+		return errors.New("StreamingPublisherConfig.MaxPingsOutstanding cannot be negative")
+	}
+	if c.NoEcho && c.ConnectionProvider != nil {
+		return errors.New("StreamingPublisherConfig.NoEcho cannot be used with ConnectionProvider")
+	}
+	if c.StampUUIDAsMsgID && c.DeduplicationKey != "" {
+		return errors.New(
+			"StreamingPublisherConfig.StampUUIDAsMsgID cannot be used with StreamingPublisherConfig.DeduplicationKey, " +
+				"both of them set Nats-Msg-Id header",
+		)
+	}
+	if err := c.auth().validate(c.ConnectionProvider != nil); err != nil {
+		return errors.Wrap(err, "invalid StreamingPublisherConfig auth")
+	}
 
 	return nil
 }
 
+func (c StreamingPublisherConfig) auth() connectionAuth {
+	return connectionAuth{
+		credentialsFile: c.CredentialsFile,
+		token:           c.Token,
+		username:        c.Username,
+		password:        c.Password,
+		nkeySeed:        c.NKeySeed,
+		nkeySeedFile:    c.NKeySeedFile,
+	}
+}
+
+func (c StreamingPublisherConfig) natsOptions() ([]nats.Option, error) {
+	// the default name is overridden by the name set with NatsOptions
+	options := append([]nats.Option{nats.Name(defaultClientName())}, c.NatsOptions...)
+
+	if c.ClientName != "" {
+		options = append(options, nats.Name(c.ClientName))
+	}
+
+	auth, err := c.auth().options()
+	if err != nil {
+		return nil, err
+	}
+	options = append(options, auth...)
+
+	if c.TLSConfig != nil {
+		options = append(options, nats.Secure(c.TLSConfig))
+	}
+
+	options = append(options, handlerOptions(c.OnDisconnect, c.OnReconnect, c.OnClosed)...)
+	options = append(options, pingOptions(c.PingInterval, c.MaxPingsOutstanding)...)
+
+	if c.NoEcho {
+		options = append(options, nats.NoEcho())
+	}
+
+	if c.ReconnectBufferSize != 0 {
+		options = append(options, nats.ReconnectBufSize(c.ReconnectBufferSize))
+	}
+
+	return options, nil
+}
+
+func (c StreamingPublisherConfig) connect() (*nats.Conn, error) {
+	if c.ConnectionProvider != nil {
+		return connectWithProvider(c.ConnectionProvider)
+	}
+
+	options, err := c.natsOptions()
+	if err != nil {
+		return nil, withKind(ErrInvalidConfig, err)
+	}
+
+	url, err := serverURLs(c.URL, c.URLs)
+	if err != nil {
+		return nil, withKind(ErrInvalidConfig, errors.Wrap(err, "invalid StreamingPublisherConfig.URLs"))
+	}
+
+	conn, err := nats.Connect(url, options...)
+	if err != nil {
+		return nil, withKind(ErrConnect, errors.Wrap(err, "cannot connect to nats"))
+	}
+
+	return conn, nil
+}
+
 func (c StreamingPublisherConfig) GetStreamingPublisherPublishConfig() StreamingPublisherPublishConfig {
 	return StreamingPublisherPublishConfig{
-		Marshaler: c.Marshaler,
+		Marshaler:           c.Marshaler,
+		MaxPendingAsync:     c.MaxPendingAsync,
+		Deduplication:       c.Deduplication,
+		DeduplicationKey:    c.DeduplicationKey,
+		StampUUIDAsMsgID:    c.StampUUIDAsMsgID,
+		SubjectToStream:     c.SubjectToStream,
+		TopicResolver:       c.TopicResolver,
+		Tracer:              c.Tracer,
+		BlockOnFull:         c.BlockOnFull,
+		PublishRetryBackoff: c.PublishRetryBackoff,
+		BlockOnFullTimeout:  c.BlockOnFullTimeout,
+		ObjectStoreBucket:   c.ObjectStoreBucket,
+		MaxInlineSize:       c.MaxInlineSize,
+		CloseTimeout:        c.CloseTimeout,
 	}
 }
 
 type StreamingPublisher struct {
-	conn   nats.Conn
+	conn *nats.Conn
+	// ownsConn is true when conn was created by the publisher, so it's closed on Close.
+	ownsConn bool
+
+	js     nats.JetStreamContext
 	config StreamingPublisherPublishConfig
 	logger watermill.LoggerAdapter
+
+	// objects stores payloads larger than MaxInlineSize, it's nil when ObjectStoreBucket is not set.
+	objects nats.ObjectStore
+
+	topicMarshalers *topicMarshalers
+
+	// lazy connects the publisher on the first use with LazyConnect, it's nil when connected by the constructor.
+	lazy *lazyPublisher
 }
 
 // NewNatsStreamingPublisher creates a new StreamingPublisher.
@@ -52,50 +406,123 @@ type StreamingPublisher struct {
 //			nats.NatsURL("nats://your-nats-hostname:4222"),
 //		}
 //		// ...
+//
+// When logger is nil, watermill.NopLogger is used.
 func NewNatsStreamingPublisher(config StreamingPublisherConfig, logger watermill.LoggerAdapter) (*StreamingPublisher, error) {
 	if err := config.Validate(); err != nil {
+		return nil, withKind(ErrInvalidConfig, err)
+	}
+
+	if config.LazyConnect {
+		return newLazyPublisher(config, logger), nil
+	}
+
+	conn, err := config.connect()
+	if err != nil {
 		return nil, err
 	}
 
-	conn, err := nats.Connect(config.URL, config.NatsOptions...)
+	pub, err := NewStreamingPublisherWithNatsConn(conn, config.GetStreamingPublisherPublishConfig(), logger)
 	if err != nil {
-		return nil, errors.Wrap(err, "cannot connect to nats")
+		conn.Close()
+		return nil, err
 	}
+	pub.ownsConn = true
 
-	return NewNatsStreamingPublisherWithNatsConn(*conn, config.GetStreamingPublisherPublishConfig(), logger)
+	return pub, nil
 }
 
-func NewNatsStreamingPublisherWithNatsConn(conn nats.Conn, config StreamingPublisherPublishConfig, logger watermill.LoggerAdapter) (*StreamingPublisher, error) {
+// NewStreamingPublisherWithNatsConn creates a new StreamingPublisher using an existing NATS connection,
+// so it can be shared with other publishers and subscribers.
+//
+// The connection is owned by the caller, it is not closed when the publisher is closed.
+// When logger is nil, watermill.NopLogger is used.
+func NewStreamingPublisherWithNatsConn(conn *nats.Conn, config StreamingPublisherPublishConfig, logger watermill.LoggerAdapter) (*StreamingPublisher, error) {
 	if logger == nil {
 		logger = watermill.NopLogger{}
 	}
 
+	var jsOptions []nats.JSOpt
+	if config.MaxPendingAsync > 0 {
+		jsOptions = append(jsOptions, nats.PublishAsyncMaxPending(config.MaxPendingAsync))
+	}
+
+	js, err := conn.JetStream(jsOptions...)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get JetStream context")
+	}
+
+	if config.MaxInlineSize > 0 && config.ObjectStoreBucket == "" {
+		return nil, withKind(ErrInvalidConfig, errors.New("StreamingPublisherConfig.MaxInlineSize requires ObjectStoreBucket"))
+	}
+
+	var objects nats.ObjectStore
+	if config.ObjectStoreBucket != "" {
+		objects, err = js.ObjectStore(config.ObjectStoreBucket)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot get object store %s", config.ObjectStoreBucket)
+		}
+	}
+
 	return &StreamingPublisher{
-		conn:   conn,
-		config: config,
-		logger: logger,
+		conn:            conn,
+		js:              js,
+		config:          config,
+		logger:          logger,
+		objects:         objects,
+		topicMarshalers: newTopicMarshalers(),
 	}, nil
 }
 
-// Publish publishes message to NATS.
+// NewNatsStreamingPublisherWithNatsConn creates a new StreamingPublisher using an existing NATS connection.
 //
-// Publish will not return until an ack has been received from NATS Streaming.
+// Deprecated: use NewStreamingPublisherWithNatsConn.
+func NewNatsStreamingPublisherWithNatsConn(conn *nats.Conn, config StreamingPublisherPublishConfig, logger watermill.LoggerAdapter) (*StreamingPublisher, error) {
+	return NewStreamingPublisherWithNatsConn(conn, config, logger)
+}
+
+// Publish publishes message to JetStream.
+//
+// Publish will not return until an ack has been received from JetStream.
 // When one of messages delivery fails - function is interrupted.
+// Publishing to a topic without a stream fails with nats.ErrNoStreamResponse, see EnsureStream.
 func (p StreamingPublisher) Publish(topic string, messages ...*message.Message) error {
+	return p.PublishWithContext(context.Background(), topic, messages...)
+}
+
+// PublishWithContext publishes messages to JetStream the same as Publish, waiting for the acks
+// until ctx is done. When ctx has no deadline, each ack is awaited up to 5 seconds (the default of nats.MaxWait).
+//
+// The publish span is started from ctx when it carries a span, otherwise from the message context.
+func (p StreamingPublisher) PublishWithContext(ctx context.Context, topic string, messages ...*message.Message) error {
+	p, err := p.connected()
+	if err != nil {
+		return err
+	}
+
 	for _, msg := range messages {
+		msgTopic, err := p.resolveTopic(topic, msg)
+		if err != nil {
+			return err
+		}
+
 		messageFields := watermill.LogFields{
 			"message_uuid": msg.UUID,
-			"topic_name":   topic,
+			"topic_name":   msgTopic,
 		}
 
 		p.logger.Trace("Publishing message", messageFields)
 
-		b, err := p.config.Marshaler.Marshal(topic, msg)
+		natsMsg, err := p.marshal(msgTopic, msg)
 		if err != nil {
 			return err
 		}
 
-		if err := p.conn.Publish(topic, b); err != nil {
+		span := startPublishSpan(publishSpanParent(ctx, msg), p.config.Tracer, msgTopic, msg.UUID, natsMsg)
+		err = publishError(p.publishMsg(ctx, msg, natsMsg, messageFields))
+		endSpan(span, err)
+
+		if err != nil {
 			return errors.Wrap(err, "sending message failed")
 		}
 	}
@@ -103,11 +530,584 @@ func (p StreamingPublisher) Publish(topic string, messages ...*message.Message)
 	return nil
 }
 
+const (
+	defaultPublishRetryBackoff = time.Millisecond * 100
+	maxPublishRetryBackoff     = time.Second * 5
+	defaultBlockOnFullTimeout  = time.Second * 30
+	// defaultPublishTimeout is the default of nats.MaxWait, used when ctx has no deadline
+	defaultPublishTimeout = time.Second * 5
+)
+
+// publishMsg publishes natsMsg, with BlockOnFull it's retried while the stream is full.
+// Retries are stopped when either ctx or the context of msg is done.
+func (p StreamingPublisher) publishMsg(ctx context.Context, msg *message.Message, natsMsg *nats.Msg, logFields watermill.LogFields) error {
+	err := p.publishWithContext(ctx, natsMsg)
+	if err == nil || !p.config.BlockOnFull || !isStreamFull(err) {
+		return err
+	}
+
+	timeout := p.config.BlockOnFullTimeout
+	if timeout == 0 {
+		timeout = defaultBlockOnFullTimeout
+	}
+	retryCtx, cancel := context.WithTimeout(msg.Context(), timeout)
+	defer cancel()
+
+	backoff := p.config.PublishRetryBackoff
+	if backoff == 0 {
+		backoff = defaultPublishRetryBackoff
+	}
+
+	for {
+		p.logger.Debug("Stream is full, retrying publish", logFields.Add(watermill.LogFields{"backoff": backoff}))
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-retryCtx.Done():
+			timer.Stop()
+			return errors.Wrap(err, "stream is still full, publish retries stopped")
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Wrap(err, "stream is still full, publish retries stopped")
+		}
+
+		err = p.publishWithContext(ctx, natsMsg)
+		if err == nil || !isStreamFull(err) {
+			return err
+		}
+
+		backoff *= 2
+		if backoff > maxPublishRetryBackoff {
+			backoff = maxPublishRetryBackoff
+		}
+	}
+}
+
+// publishWithContext publishes natsMsg waiting for the ack until ctx is done,
+// ctx without deadline is bounded by defaultPublishTimeout.
+func (p StreamingPublisher) publishWithContext(ctx context.Context, natsMsg *nats.Msg) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultPublishTimeout)
+		defer cancel()
+	}
+
+	_, err := p.js.PublishMsg(natsMsg, nats.Context(ctx))
+	return err
+}
+
+// isStreamFull returns true when err is returned by JetStream rejecting a message by limits of the stream.
+func isStreamFull(err error) bool {
+	var jsErr nats.JetStreamError
+	if !errors.As(err, &jsErr) || jsErr.APIError() == nil {
+		return false
+	}
+
+	// JSStreamStoreFailedF error of the server, with the store error as the description
+	apiErr := jsErr.APIError()
+	if apiErr.ErrorCode != 10077 {
+		return false
+	}
+
+	switch apiErr.Description {
+	case "maximum messages exceeded", "maximum bytes exceeded", "maximum messages per subject exceeded":
+		return true
+	default:
+		return false
+	}
+}
+
+// PublishAsync publishes messages to JetStream without waiting for acks.
+//
+// Returned futures are resolved when the message is acked by JetStream or publishing fails.
+// The number of messages waiting for an ack is limited by MaxPendingAsync.
+// When one of messages can't be published - function is interrupted, futures of already published
+// messages are returned with the error.
+func (p StreamingPublisher) PublishAsync(topic string, messages ...*message.Message) ([]nats.PubAckFuture, error) {
+	p, err := p.connected()
+	if err != nil {
+		return nil, err
+	}
+
+	futures := make([]nats.PubAckFuture, 0, len(messages))
+
+	for _, msg := range messages {
+		msgTopic, err := p.resolveTopic(topic, msg)
+		if err != nil {
+			return futures, err
+		}
+
+		messageFields := watermill.LogFields{
+			"message_uuid": msg.UUID,
+			"topic_name":   msgTopic,
+		}
+
+		p.logger.Trace("Publishing message asynchronously", messageFields)
+
+		natsMsg, err := p.marshal(msgTopic, msg)
+		if err != nil {
+			return futures, err
+		}
+
+		// acks are awaited by the caller, so the span ends when the message is sent
+		span := startPublishSpan(msg.Context(), p.config.Tracer, msgTopic, msg.UUID, natsMsg)
+		future, err := p.js.PublishMsgAsync(natsMsg)
+		endSpan(span, err)
+
+		if err != nil {
+			return futures, errors.Wrap(publishError(err), "sending message failed")
+		}
+		futures = append(futures, future)
+	}
+
+	return futures, nil
+}
+
+// PublishBatch publishes messages asynchronously and waits until all of them are acked by JetStream.
+//
+// Unlike Publish, it doesn't stop on the first failure. When some of messages were not published,
+// *PublishBatchError identifying them is returned. The number of messages waiting for an ack
+// is limited by MaxPendingAsync.
+func (p StreamingPublisher) PublishBatch(topic string, messages []*message.Message) error {
+	p, err := p.connected()
+	if err != nil {
+		return err
+	}
+
+	type pendingMessage struct {
+		uuid   string
+		future nats.PubAckFuture
+		span   trace.Span
+	}
+
+	batchErr := &PublishBatchError{Total: len(messages)}
+	pending := make([]pendingMessage, 0, len(messages))
+
+	for _, msg := range messages {
+		msgTopic, err := p.resolveTopic(topic, msg)
+		if err != nil {
+			batchErr.Failed = append(batchErr.Failed, FailedMessage{UUID: msg.UUID, Err: err})
+			continue
+		}
+
+		p.logger.Trace("Publishing message in batch", watermill.LogFields{
+			"message_uuid": msg.UUID,
+			"topic_name":   msgTopic,
+		})
+
+		natsMsg, err := p.marshal(msgTopic, msg)
+		if err != nil {
+			batchErr.Failed = append(batchErr.Failed, FailedMessage{UUID: msg.UUID, Err: err})
+			continue
+		}
+
+		span := startPublishSpan(msg.Context(), p.config.Tracer, msgTopic, msg.UUID, natsMsg)
+		future, err := p.js.PublishMsgAsync(natsMsg)
+		if err != nil {
+			endSpan(span, err)
+			batchErr.Failed = append(batchErr.Failed, FailedMessage{UUID: msg.UUID, Err: errors.Wrap(publishError(err), "sending message failed")})
+			continue
+		}
+		pending = append(pending, pendingMessage{uuid: msg.UUID, future: future, span: span})
+	}
+
+	for _, m := range pending {
+		select {
+		case <-m.future.Ok():
+			endSpan(m.span, nil)
+		case err := <-m.future.Err():
+			endSpan(m.span, err)
+			batchErr.Failed = append(batchErr.Failed, FailedMessage{UUID: m.uuid, Err: errors.Wrap(publishError(err), "sending message failed")})
+		}
+	}
+
+	if len(batchErr.Failed) > 0 {
+		return batchErr
+	}
+
+	return nil
+}
+
+// FailedMessage is a message which was not published by PublishBatch.
+type FailedMessage struct {
+	UUID string
+	Err  error
+}
+
+// PublishBatchError is returned by PublishBatch when some of messages were not published.
+type PublishBatchError struct {
+	// Total is the number of messages passed to PublishBatch.
+	Total int
+
+	// Failed are messages which were not published.
+	Failed []FailedMessage
+}
+
+func (e *PublishBatchError) Error() string {
+	failed := make([]string, 0, len(e.Failed))
+	for _, m := range e.Failed {
+		failed = append(failed, fmt.Sprintf("%s: %s", m.UUID, m.Err))
+	}
+
+	return fmt.Sprintf("cannot publish %d of %d messages: %s", len(e.Failed), e.Total, strings.Join(failed, "; "))
+}
+
+// MsgTTLKey is the metadata key with the TTL of the published message, for example "1h".
+// The message expires independently of the stream MaxAge, the stream has to allow it with StreamConfig.AllowMsgTTL.
+//
+// The value is a duration parsed with time.ParseDuration, JetStream requires at least one second.
+const MsgTTLKey = "_nats_msg_ttl"
+
+func msgTTL(msg *message.Message) (time.Duration, error) {
+	value := msg.Metadata.Get(MsgTTLKey)
+	if value == "" {
+		return 0, nil
+	}
+
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid %s metadata of message %s", MsgTTLKey, msg.UUID)
+	}
+	if ttl < time.Second {
+		return 0, errors.Errorf("%s metadata of message %s must be at least 1s, got %s", MsgTTLKey, msg.UUID, value)
+	}
+
+	return ttl, nil
+}
+
+// ExpectedLastSubjectSequenceKey is the metadata key with the stream sequence of the last message
+// of the subject the message is published to, it is mapped to nats.ExpectLastSequencePerSubject.
+// When the subject has a newer message, JetStream rejects the message and ErrSequenceMismatch is returned,
+// so it can be used for compare-and-set updates. "0" expects the subject to have no messages.
+const ExpectedLastSubjectSequenceKey = "_nats_expected_last_subject_seq"
+
+// ErrSequenceMismatch is returned by Publish and in PublishBatchError when the last sequence of the subject
+// is not the one set with ExpectedLastSubjectSequenceKey.
+var ErrSequenceMismatch = errors.New("last subject sequence doesn't match the expected sequence")
+
+func expectedLastSubjectSequence(msg *message.Message) (string, error) {
+	value := msg.Metadata.Get(ExpectedLastSubjectSequenceKey)
+	if value == "" {
+		return "", nil
+	}
+
+	if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+		return "", errors.Wrapf(err, "invalid %s metadata of message %s", ExpectedLastSubjectSequenceKey, msg.UUID)
+	}
+
+	return value, nil
+}
+
+// ErrReconnectBufferExceeded is returned by Publish, PublishAsync and in PublishBatchError when the message doesn't fit into the buffer
+// of messages published while reconnecting (see StreamingPublisherConfig.ReconnectBufferSize).
+var ErrReconnectBufferExceeded = errors.New("reconnect buffer exceeded")
+
+// publishError returns an error matching ErrSequenceMismatch when err is returned by JetStream rejecting a message
+// with ExpectedLastSubjectSequenceKey and ErrReconnectBufferExceeded when the message couldn't be buffered
+// while reconnecting, the original error can still be unwrapped. Other errors are returned unchanged.
+func publishError(err error) error {
+	if errors.Is(err, nats.ErrReconnectBufExceeded) {
+		return sentinelError{sentinel: ErrReconnectBufferExceeded, err: err}
+	}
+
+	var jsErr nats.JetStreamError
+	if !errors.As(err, &jsErr) || jsErr.APIError() == nil {
+		return err
+	}
+
+	switch jsErr.APIError().ErrorCode {
+	case nats.JSErrCodeStreamWrongLastSequence, nats.JSErrCodeStreamWrongLastSequenceConstant:
+		return sentinelError{sentinel: ErrSequenceMismatch, err: err}
+	default:
+		return err
+	}
+}
+
+// sentinelError is err classified as sentinel, errors.Is matches both of them and errors.As finds types of err.
+type sentinelError struct {
+	sentinel error
+	err      error
+}
+
+func (e sentinelError) Error() string {
+	return e.sentinel.Error() + ": " + e.err.Error()
+}
+
+func (e sentinelError) Is(target error) bool {
+	return target == e.sentinel
+}
+
+func (e sentinelError) Unwrap() error {
+	return e.err
+}
+
+// ErrPayloadTooLarge is wrapped by PayloadTooLargeError, so it can be checked with errors.Is.
+var ErrPayloadTooLarge = errors.New("payload too large")
+
+// PayloadTooLargeError is returned when the marshaled message is larger than the max payload of the NATS server.
+// Large payloads can be stored in the Object Store instead, see StreamingPublisherConfig.MaxInlineSize.
+type PayloadTooLargeError struct {
+	// MessageUUID is the UUID of the Watermill message.
+	MessageUUID string
+
+	// Size is the size of the marshaled message, with headers.
+	Size int64
+
+	// MaxPayload is the max payload of the NATS server.
+	MaxPayload int64
+}
+
+func (e PayloadTooLargeError) Error() string {
+	return fmt.Sprintf("message %s is %d bytes, max payload of the NATS server is %d bytes", e.MessageUUID, e.Size, e.MaxPayload)
+}
+
+func (e PayloadTooLargeError) Unwrap() error {
+	return ErrPayloadTooLarge
+}
+
+// checkPayloadSize returns PayloadTooLargeError when natsMsg is larger than the max payload of the server,
+// nats.go would reject it with nats.ErrMaxPayload, which doesn't tell the sizes.
+func (p StreamingPublisher) checkPayloadSize(msgUUID string, natsMsg *nats.Msg) error {
+	// the subject is not counted to the payload
+	size := int64(natsMsg.Size() - len(natsMsg.Subject) - len(natsMsg.Reply))
+
+	if maxPayload := p.conn.MaxPayload(); size > maxPayload {
+		return PayloadTooLargeError{MessageUUID: msgUUID, Size: size, MaxPayload: maxPayload}
+	}
+
+	return nil
+}
+
+// resolveTopic returns the topic of msg returned by TopicResolver, or topic when it's not set.
+func (p StreamingPublisher) resolveTopic(topic string, msg *message.Message) (string, error) {
+	if p.config.TopicResolver == nil {
+		return topic, nil
+	}
+
+	resolved, err := p.config.TopicResolver(msg)
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot resolve topic of message %s", msg.UUID)
+	}
+	if err := validateConcreteSubject(resolved); err != nil {
+		return "", errors.Wrapf(err, "TopicResolver returned invalid topic for message %s", msg.UUID)
+	}
+
+	return resolved, nil
+}
+
+// validateConcreteSubject checks that subject can be published to, it can't be empty or contain wildcards.
+func validateConcreteSubject(subject string) error {
+	if subject == "" {
+		return errors.New("empty subject")
+	}
+	if strings.ContainsAny(subject, " \t\r\n") {
+		return errors.Errorf("subject %q contains whitespace", subject)
+	}
+
+	for _, token := range strings.Split(subject, ".") {
+		switch token {
+		case "":
+			return errors.Errorf("subject %q has an empty token", subject)
+		case "*", ">":
+			return errors.Errorf("subject %q contains wildcard %s", subject, token)
+		}
+	}
+
+	return nil
+}
+
+func (p StreamingPublisher) marshal(topic string, msg *message.Message) (*nats.Msg, error) {
+	ttl, err := msgTTL(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	expectedSequence, err := expectedLastSubjectSequence(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	marshaler := p.topicMarshalers.get(topic, p.config.Marshaler)
+
+	natsMsg, err := marshalMsg(marshaler, topic, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.config.MaxInlineSize > 0 && len(natsMsg.Data) > p.config.MaxInlineSize {
+		ref, err := storePayload(p.objects, p.config.ObjectStoreBucket, msg)
+		if err != nil {
+			return nil, err
+		}
+
+		natsMsg, err = marshalMsg(marshaler, topic, ref)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if typer, ok := marshaler.(ContentTyper); ok {
+		if natsMsg.Header == nil {
+			natsMsg.Header = nats.Header{}
+		}
+		natsMsg.Header.Set(ContentTypeHdr, typer.ContentType())
+	}
+
+	if ttl > 0 {
+		if natsMsg.Header == nil {
+			natsMsg.Header = nats.Header{}
+		}
+		natsMsg.Header.Set(nats.MsgTTLHdr, ttl.String())
+	}
+
+	if p.config.SubjectToStream != nil {
+		stream := p.config.SubjectToStream(topic)
+		if stream == "" {
+			return nil, errors.Errorf("SubjectToStream returned no stream for topic %s", topic)
+		}
+
+		if natsMsg.Header == nil {
+			natsMsg.Header = nats.Header{}
+		}
+		natsMsg.Header.Set(nats.ExpectedStreamHdr, stream)
+	}
+
+	if expectedSequence != "" {
+		if natsMsg.Header == nil {
+			natsMsg.Header = nats.Header{}
+		}
+		natsMsg.Header.Set(nats.ExpectedLastSubjSeqHdr, expectedSequence)
+	}
+
+	if p.config.Deduplication {
+		msgID := msg.UUID
+		if p.config.DeduplicationKey != "" {
+			msgID = msg.Metadata.Get(p.config.DeduplicationKey)
+		}
+		if msgID == "" {
+			return nil, errors.Errorf("message %s has no deduplication metadata %s", msg.UUID, p.config.DeduplicationKey)
+		}
+
+		if natsMsg.Header == nil {
+			natsMsg.Header = nats.Header{}
+		}
+		natsMsg.Header.Set(nats.MsgIdHdr, msgID)
+	}
+
+	if p.config.StampUUIDAsMsgID && msg.UUID != "" {
+		if natsMsg.Header == nil {
+			natsMsg.Header = nats.Header{}
+		}
+		natsMsg.Header.Set(nats.MsgIdHdr, msg.UUID)
+	}
+
+	if err := p.checkPayloadSize(msg.UUID, natsMsg); err != nil {
+		return nil, err
+	}
+
+	return natsMsg, nil
+}
+
+// PublishAsyncComplete blocks until all messages published with PublishAsync are acked or failed.
+func (p StreamingPublisher) PublishAsyncComplete() {
+	p, ok := p.current()
+	if !ok {
+		// nothing was published yet
+		return
+	}
+
+	<-p.js.PublishAsyncComplete()
+}
+
+// Ping checks that the NATS connection is alive, it can be used as a readiness probe.
+//
+// ErrNotConnected is returned when the connection is not connected, also when it was not created yet
+// with LazyConnect, and ErrPingTimeout when the server didn't respond before ctx was done.
+func (p StreamingPublisher) Ping(ctx context.Context) error {
+	p, ok := p.current()
+	if !ok {
+		return errors.Wrap(ErrNotConnected, "connection is not created yet with LazyConnect")
+	}
+
+	return ping(ctx, p.conn)
+}
+
+// JetStream returns the JetStreamContext used by the publisher, so management operations like getting
+// stream info can be run without another connection.
+//
+// The context shares the connection of the publisher, so the connection must not be closed with it,
+// when the connection was created by the publisher it is closed by Close. ErrNotConnected is returned
+// when the connection is already closed.
+func (p StreamingPublisher) JetStream() (nats.JetStreamContext, error) {
+	p, err := p.connected()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.conn.IsClosed() {
+		return nil, errors.Wrap(ErrNotConnected, "connection is closed")
+	}
+
+	return p.js, nil
+}
+
+const defaultCloseTimeout = time.Second * 30
+
+// Close flushes buffered messages and waits for acks of messages published with PublishAsync,
+// at most for CloseTimeout, and closes the connection if it's owned by the publisher.
+//
+// When messages were not confirmed before the timeout, an error with their number is returned,
+// the connection is closed anyway.
 func (p StreamingPublisher) Close() error {
 	p.logger.Trace("Closing publisher", nil)
 	defer p.logger.Trace("StreamingPublisher closed", nil)
 
-	p.conn.Close()
+	p, ok := p.closeLazy()
+	if !ok {
+		// not connected with LazyConnect
+		return nil
+	}
+
+	if p.conn.IsClosed() {
+		return nil
+	}
+
+	err := p.flush()
+
+	// shared connection is closed by its owner
+	if p.ownsConn {
+		p.conn.Close()
+	}
+
+	return err
+}
+
+func (p StreamingPublisher) flush() error {
+	timeout := p.config.CloseTimeout
+	if timeout == 0 {
+		timeout = defaultCloseTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	var errs []string
+
+	if err := p.conn.FlushTimeout(timeout); err != nil {
+		errs = append(errs, fmt.Sprintf("cannot flush connection: %s", err))
+	}
+
+	ackTimer := time.NewTimer(time.Until(deadline))
+	defer ackTimer.Stop()
+
+	select {
+	case <-p.js.PublishAsyncComplete():
+	case <-ackTimer.C:
+		errs = append(errs, fmt.Sprintf("%d async publishes were not confirmed", p.js.PublishAsyncPending()))
+	}
+
+	if len(errs) > 0 {
+		return errors.Errorf("cannot flush publisher within %s: %s", timeout, strings.Join(errs, "; "))
+	}
 
 	return nil
 }
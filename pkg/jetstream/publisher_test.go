@@ -0,0 +1,702 @@
+package jetstream_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+)
+
+func TestPublish_without_stream(t *testing.T) {
+	pub := newPublisher(t)
+
+	err := pub.Publish("topic_"+watermill.NewShortUUID(), message.NewMessage(watermill.NewUUID(), nil))
+	assert.ErrorIs(t, err, nats.ErrNoStreamResponse, "publishing should fail when no stream stores the topic")
+}
+
+func TestNewNatsStreamingPublisher_nil_logger(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		NewPublisher func(t *testing.T) (*jetstream.StreamingPublisher, error)
+	}{
+		{
+			Name: "connect",
+			NewPublisher: func(t *testing.T) (*jetstream.StreamingPublisher, error) {
+				return jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+					URL:       getNatsURL(),
+					Marshaler: jetstream.GobMarshaler{},
+				}, nil)
+			},
+		},
+		{
+			Name: "lazy_connect",
+			NewPublisher: func(t *testing.T) (*jetstream.StreamingPublisher, error) {
+				return jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+					URL:         getNatsURL(),
+					Marshaler:   jetstream.GobMarshaler{},
+					LazyConnect: true,
+				}, nil)
+			},
+		},
+		{
+			Name: "nats_conn",
+			NewPublisher: func(t *testing.T) (*jetstream.StreamingPublisher, error) {
+				conn, err := nats.Connect(getNatsURL())
+				require.NoError(t, err)
+				t.Cleanup(conn.Close)
+
+				return jetstream.NewStreamingPublisherWithNatsConn(conn, jetstream.StreamingPublisherPublishConfig{
+					Marshaler: jetstream.GobMarshaler{},
+				}, nil)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			topic := newStream(t)
+
+			pub, err := tc.NewPublisher(t)
+			require.NoError(t, err)
+
+			require.NoError(t, pub.Publish(topic, message.NewMessage(watermill.NewUUID(), nil)))
+			// failures are logged as well
+			assert.Error(t, pub.Publish("topic_"+watermill.NewShortUUID(), message.NewMessage(watermill.NewUUID(), nil)))
+
+			require.NoError(t, pub.Close())
+		})
+	}
+}
+
+func TestPublishWithContext(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	msg := message.NewMessage(watermill.NewUUID(), nil)
+	require.NoError(t, pub.PublishWithContext(ctx, topic, msg))
+
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := pub.PublishWithContext(cancelledCtx, topic, message.NewMessage(watermill.NewUUID(), nil))
+	assert.ErrorIs(t, err, context.Canceled)
+
+	info, err := newJetstream(t).StreamInfo(topic)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, info.State.Msgs, "message with cancelled context should not be published")
+}
+
+func TestPublishAsync(t *testing.T) {
+	topic := newStream(t)
+
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:             getNatsURL(),
+		Marshaler:       jetstream.GobMarshaler{},
+		MaxPendingAsync: 10,
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+	defer func() {
+		_ = pub.Close()
+	}()
+
+	var messages []*message.Message
+	for i := 0; i < 100; i++ {
+		messages = append(messages, message.NewMessage(watermill.NewUUID(), []byte("payload")))
+	}
+
+	futures, err := pub.PublishAsync(topic, messages...)
+	require.NoError(t, err)
+	require.Len(t, futures, len(messages))
+
+	pub.PublishAsyncComplete()
+
+	for i, future := range futures {
+		select {
+		case ack := <-future.Ok():
+			assert.Equal(t, topic, ack.Stream)
+			assert.EqualValues(t, i+1, ack.Sequence)
+		case err := <-future.Err():
+			t.Fatalf("message %d not published: %s", i, err)
+		case <-time.After(time.Second * 5):
+			t.Fatalf("message %d not acked", i)
+		}
+	}
+}
+
+func TestPublish_deduplication(t *testing.T) {
+	testCases := []struct {
+		Name             string
+		DeduplicationKey string
+		Messages         func() []*message.Message
+		ExpectedMsgs     uint64
+	}{
+		{
+			Name: "uuid",
+			Messages: func() []*message.Message {
+				msg := message.NewMessage(watermill.NewUUID(), []byte("payload"))
+				return []*message.Message{msg, msg, message.NewMessage(watermill.NewUUID(), []byte("payload"))}
+			},
+			ExpectedMsgs: 2,
+		},
+		{
+			Name:             "metadata_key",
+			DeduplicationKey: "event_id",
+			Messages: func() []*message.Message {
+				var messages []*message.Message
+				for i := 0; i < 3; i++ {
+					msg := message.NewMessage(watermill.NewUUID(), []byte("payload"))
+					msg.Metadata.Set("event_id", "1")
+					messages = append(messages, msg)
+				}
+				return messages
+			},
+			ExpectedMsgs: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			topic := newStream(t)
+
+			pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+				URL:              getNatsURL(),
+				Marshaler:        jetstream.GobMarshaler{},
+				Deduplication:    true,
+				DeduplicationKey: tc.DeduplicationKey,
+			}, watermill.NewStdLogger(true, false))
+			require.NoError(t, err)
+			defer func() {
+				_ = pub.Close()
+			}()
+
+			require.NoError(t, pub.Publish(topic, tc.Messages()...))
+
+			info, err := newJetstream(t).StreamInfo(topic)
+			require.NoError(t, err)
+			assert.Equal(t, tc.ExpectedMsgs, info.State.Msgs)
+		})
+	}
+}
+
+func TestPublish_deduplication_missing_metadata(t *testing.T) {
+	topic := newStream(t)
+
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:              getNatsURL(),
+		Marshaler:        jetstream.GobMarshaler{},
+		Deduplication:    true,
+		DeduplicationKey: "event_id",
+	}, nil)
+	require.NoError(t, err)
+	defer func() {
+		_ = pub.Close()
+	}()
+
+	assert.Error(t, pub.Publish(topic, message.NewMessage(watermill.NewUUID(), nil)))
+}
+
+func TestPublish_stamp_uuid_as_msg_id(t *testing.T) {
+	topic := newStream(t)
+
+	// RawMarshaler doesn't carry the UUID, so it's received only in the header
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:              getNatsURL(),
+		Marshaler:        jetstream.RawMarshaler{},
+		StampUUIDAsMsgID: true,
+	}, nil)
+	require.NoError(t, err)
+	defer func() {
+		_ = pub.Close()
+	}()
+
+	published := message.NewMessage(watermill.NewUUID(), []byte("payload"))
+	require.NoError(t, pub.Publish(topic, published))
+
+	stored, err := newJetstream(t).GetLastMsg(topic, topic)
+	require.NoError(t, err)
+	assert.Equal(t, published.UUID, stored.Header.Get(nats.MsgIdHdr))
+
+	// NATSMarshaler returns messages without UUID when WatermillUUIDHdr header is missing
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{Unmarshaler: jetstream.NATSMarshaler{}})
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	assert.Equal(t, published.UUID, receiveMessages(t, messages, 1)[0].UUID, "UUID should be read from Nats-Msg-Id header")
+}
+
+func TestPublish_stamp_uuid_as_msg_id_with_deduplication_key(t *testing.T) {
+	_, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:              getNatsURL(),
+		Marshaler:        jetstream.GobMarshaler{},
+		Deduplication:    true,
+		DeduplicationKey: "event_id",
+		StampUUIDAsMsgID: true,
+	}, nil)
+	assert.ErrorIs(t, err, jetstream.ErrInvalidConfig)
+}
+
+func TestPublish_msg_ttl(t *testing.T) {
+	js := newJetstream(t)
+
+	topic := "topic_" + watermill.NewShortUUID()
+	require.NoError(t, jetstream.EnsureStream(js, jetstream.StreamConfig{Name: topic, AllowMsgTTL: true}))
+	defer func() {
+		_ = js.DeleteStream(topic)
+	}()
+
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:       getNatsURL(),
+		Marshaler: jetstream.NATSMarshaler{},
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+	defer func() {
+		_ = pub.Close()
+	}()
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{Unmarshaler: jetstream.NATSMarshaler{}})
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte("payload"))
+	msg.Metadata.Set(jetstream.MsgTTLKey, "1s")
+	require.NoError(t, pub.Publish(topic, msg))
+
+	stored, err := js.GetLastMsg(topic, topic)
+	require.NoError(t, err)
+	assert.Equal(t, "1s", stored.Header.Get(nats.MsgTTLHdr))
+
+	received := receiveMessages(t, messages, 1)
+	assert.Equal(t, msg.UUID, received[0].UUID)
+	_, hasTTLHeader := received[0].Metadata[nats.MsgTTLHdr]
+	assert.False(t, hasTTLHeader, "TTL header should not be added to metadata")
+
+	assert.Eventually(t, func() bool {
+		info, err := js.StreamInfo(topic)
+		return err == nil && info.State.Msgs == 0
+	}, time.Second*10, time.Millisecond*100, "message should expire")
+}
+
+func TestPublish_invalid_msg_ttl(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	for _, ttl := range []string{"tomorrow", "10", "500ms", "-1h"} {
+		t.Run(ttl, func(t *testing.T) {
+			msg := message.NewMessage(watermill.NewUUID(), nil)
+			msg.Metadata.Set(jetstream.MsgTTLKey, ttl)
+
+			assert.Error(t, pub.Publish(topic, msg))
+		})
+	}
+}
+
+func TestPublish_expected_last_subject_sequence(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	newMsg := func(expectedSequence string) *message.Message {
+		msg := message.NewMessage(watermill.NewUUID(), nil)
+		msg.Metadata.Set(jetstream.ExpectedLastSubjectSequenceKey, expectedSequence)
+		return msg
+	}
+
+	// the subject has no messages yet
+	require.NoError(t, pub.Publish(topic, newMsg("0")))
+	require.NoError(t, pub.Publish(topic, newMsg("1")))
+
+	err := pub.Publish(topic, newMsg("1"))
+	assert.ErrorIs(t, err, jetstream.ErrSequenceMismatch)
+	var jsErr nats.JetStreamError
+	require.ErrorAs(t, err, &jsErr, "the error of JetStream should be kept")
+	assert.Equal(t, nats.JSErrCodeStreamWrongLastSequence, jsErr.APIError().ErrorCode)
+
+	err = pub.PublishBatch(topic, []*message.Message{newMsg("2"), newMsg("2")})
+	var batchErr *jetstream.PublishBatchError
+	require.ErrorAs(t, err, &batchErr)
+	require.Len(t, batchErr.Failed, 1)
+	assert.ErrorIs(t, batchErr.Failed[0].Err, jetstream.ErrSequenceMismatch)
+
+	info, err := newJetstream(t).StreamInfo(topic)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, info.State.Msgs)
+}
+
+func TestPublish_invalid_expected_last_subject_sequence(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	for _, sequence := range []string{"first", "-1", "1.5"} {
+		t.Run(sequence, func(t *testing.T) {
+			msg := message.NewMessage(watermill.NewUUID(), nil)
+			msg.Metadata.Set(jetstream.ExpectedLastSubjectSequenceKey, sequence)
+
+			err := pub.Publish(topic, msg)
+			assert.Error(t, err)
+			assert.NotErrorIs(t, err, jetstream.ErrSequenceMismatch)
+		})
+	}
+}
+
+func TestPublish_subject_to_stream(t *testing.T) {
+	js := newJetstream(t)
+
+	stream := "stream_" + watermill.NewShortUUID()
+	subject := "orders." + watermill.NewShortUUID()
+	_, err := js.AddStream(&nats.StreamConfig{Name: stream, Subjects: []string{subject}})
+	require.NoError(t, err)
+	defer func() {
+		_ = js.DeleteStream(stream)
+	}()
+	otherStream := newStream(t)
+
+	testCases := []struct {
+		Name        string
+		Stream      string
+		ExpectedErr bool
+	}{
+		{
+			Name:   "matching_stream",
+			Stream: stream,
+		},
+		{
+			Name:        "other_stream",
+			Stream:      otherStream,
+			ExpectedErr: true,
+		},
+		{
+			Name:        "no_stream",
+			ExpectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+				URL:       getNatsURL(),
+				Marshaler: jetstream.GobMarshaler{},
+				SubjectToStream: func(topic string) string {
+					assert.Equal(t, subject, topic)
+					return tc.Stream
+				},
+			}, watermill.NewStdLogger(true, false))
+			require.NoError(t, err)
+			defer pub.Close()
+
+			err = pub.Publish(subject, message.NewMessage(watermill.NewUUID(), nil))
+			if tc.ExpectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	info, err := js.StreamInfo(stream)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, info.State.Msgs)
+}
+
+func TestPublish_topic_resolver(t *testing.T) {
+	js := newJetstream(t)
+
+	prefix := "events_" + watermill.NewShortUUID()
+	require.NoError(t, jetstream.EnsureStream(js, jetstream.StreamConfig{Name: prefix, Subjects: []string{prefix + ".>"}}))
+	t.Cleanup(func() {
+		_ = jetstream.DeleteStream(js, prefix, jetstream.IgnoreStreamNotFound())
+	})
+
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:       getNatsURL(),
+		Marshaler: jetstream.GobMarshaler{},
+		TopicResolver: func(msg *message.Message) (string, error) {
+			tenant := msg.Metadata.Get("tenant")
+			if tenant == "" {
+				return "", errors.New("missing tenant")
+			}
+			return prefix + "." + tenant + ".created", nil
+		},
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+	defer pub.Close()
+
+	newTenantMessage := func(tenant string) *message.Message {
+		msg := message.NewMessage(watermill.NewUUID(), nil)
+		msg.Metadata.Set("tenant", tenant)
+		return msg
+	}
+
+	// the topic argument is overridden by the resolver
+	require.NoError(t, pub.Publish("ignored", newTenantMessage("first"), newTenantMessage("second")))
+	require.NoError(t, pub.PublishBatch("ignored", []*message.Message{newTenantMessage("first")}))
+
+	assert.ErrorContains(t, pub.Publish("ignored", newTenantMessage("")), "missing tenant")
+	assert.ErrorContains(t, pub.Publish("ignored", newTenantMessage("*")), "wildcard")
+	assert.ErrorContains(t, pub.Publish("ignored", newTenantMessage("a b")), "whitespace")
+
+	info, err := js.StreamInfo(prefix, &nats.StreamInfoRequest{SubjectsFilter: ">"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]uint64{
+		prefix + ".first.created":  2,
+		prefix + ".second.created": 1,
+	}, info.State.Subjects)
+}
+
+func TestPublish_payload_too_large(t *testing.T) {
+	topic := newStream(t)
+
+	conn, err := nats.Connect(getNatsURL())
+	require.NoError(t, err)
+	maxPayload := conn.MaxPayload()
+	conn.Close()
+
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:       getNatsURL(),
+		Marshaler: jetstream.NATSMarshaler{},
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+	defer pub.Close()
+
+	uuid := watermill.NewUUID()
+	// NATSMarshaler sends the payload as-is, with the UUID in headers
+	headers, err := jetstream.NATSMarshaler{}.MarshalMsg(topic, message.NewMessage(uuid, nil))
+	require.NoError(t, err)
+	headersSize := int64(headers.Size() - len(topic))
+
+	tooLarge := message.NewMessage(uuid, make([]byte, maxPayload-headersSize+1))
+	err = pub.Publish(topic, tooLarge)
+	require.ErrorIs(t, err, jetstream.ErrPayloadTooLarge)
+
+	var tooLargeErr jetstream.PayloadTooLargeError
+	require.ErrorAs(t, err, &tooLargeErr)
+	assert.Equal(t, uuid, tooLargeErr.MessageUUID)
+	assert.Equal(t, maxPayload+1, tooLargeErr.Size)
+	assert.Equal(t, maxPayload, tooLargeErr.MaxPayload)
+
+	largest := message.NewMessage(uuid, make([]byte, maxPayload-headersSize))
+	assert.NoError(t, pub.Publish(topic, largest))
+}
+
+func TestPublish_block_on_full(t *testing.T) {
+	js := newJetstream(t)
+
+	topic := "topic_" + watermill.NewShortUUID()
+	_, err := js.AddStream(&nats.StreamConfig{
+		Name:     topic,
+		Subjects: []string{topic},
+		MaxMsgs:  1,
+		Discard:  nats.DiscardNew,
+	})
+	require.NoError(t, err)
+	defer func() {
+		_ = js.DeleteStream(topic)
+	}()
+
+	newFullStreamPublisher := func(config jetstream.StreamingPublisherConfig) *jetstream.StreamingPublisher {
+		config.URL = getNatsURL()
+		config.Marshaler = jetstream.GobMarshaler{}
+
+		pub, err := jetstream.NewNatsStreamingPublisher(config, watermill.NewStdLogger(true, false))
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = pub.Close()
+		})
+
+		return pub
+	}
+
+	publishMessages(t, newPublisher(t), topic, 1)
+
+	t.Run("without_block_on_full", func(t *testing.T) {
+		pub := newFullStreamPublisher(jetstream.StreamingPublisherConfig{})
+		assert.Error(t, pub.Publish(topic, message.NewMessage(watermill.NewUUID(), nil)))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		pub := newFullStreamPublisher(jetstream.StreamingPublisherConfig{
+			BlockOnFull:         true,
+			PublishRetryBackoff: time.Millisecond * 10,
+			BlockOnFullTimeout:  time.Millisecond * 200,
+		})
+
+		start := time.Now()
+		assert.Error(t, pub.Publish(topic, message.NewMessage(watermill.NewUUID(), nil)))
+		assert.GreaterOrEqual(t, int64(time.Since(start)), int64(time.Millisecond*200))
+	})
+
+	t.Run("message_context", func(t *testing.T) {
+		pub := newFullStreamPublisher(jetstream.StreamingPublisherConfig{
+			BlockOnFull:         true,
+			PublishRetryBackoff: time.Millisecond * 10,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+
+		msg := message.NewMessage(watermill.NewUUID(), nil)
+		msg.SetContext(ctx)
+		assert.Error(t, pub.Publish(topic, msg))
+	})
+
+	t.Run("publish_context", func(t *testing.T) {
+		pub := newFullStreamPublisher(jetstream.StreamingPublisherConfig{
+			BlockOnFull:         true,
+			PublishRetryBackoff: time.Millisecond * 10,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+
+		start := time.Now()
+		assert.Error(t, pub.PublishWithContext(ctx, topic, message.NewMessage(watermill.NewUUID(), nil)))
+		assert.Less(t, int64(time.Since(start)), int64(time.Second*5), "retries should stop when ctx is done")
+	})
+
+	t.Run("space_freed", func(t *testing.T) {
+		pub := newFullStreamPublisher(jetstream.StreamingPublisherConfig{
+			BlockOnFull:         true,
+			PublishRetryBackoff: time.Millisecond * 10,
+		})
+
+		published := make(chan error, 1)
+		go func() {
+			published <- pub.Publish(topic, message.NewMessage(watermill.NewUUID(), nil))
+		}()
+
+		select {
+		case err := <-published:
+			t.Fatalf("Publish should block while the stream is full, returned %v", err)
+		case <-time.After(time.Millisecond * 200):
+		}
+
+		require.NoError(t, jetstream.PurgeStream(js, topic))
+
+		select {
+		case err := <-published:
+			require.NoError(t, err)
+		case <-time.After(time.Second * 10):
+			t.Fatal("Publish should succeed after space is freed")
+		}
+	})
+}
+
+func TestPublishBatch(t *testing.T) {
+	topic := newStream(t)
+
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:              getNatsURL(),
+		Marshaler:        jetstream.GobMarshaler{},
+		MaxPendingAsync:  10,
+		Deduplication:    true,
+		DeduplicationKey: "event_id",
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+	defer func() {
+		_ = pub.Close()
+	}()
+
+	var messages []*message.Message
+	for i := 0; i < 100; i++ {
+		msg := message.NewMessage(watermill.NewUUID(), []byte("payload"))
+		msg.Metadata.Set("event_id", watermill.NewUUID())
+		messages = append(messages, msg)
+	}
+	require.NoError(t, pub.PublishBatch(topic, messages))
+
+	// the message without deduplication metadata can't be published
+	invalid := message.NewMessage(watermill.NewUUID(), []byte("payload"))
+	err = pub.PublishBatch(topic, append(messages[:1:1], invalid))
+
+	var batchErr *jetstream.PublishBatchError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Equal(t, 2, batchErr.Total)
+	require.Len(t, batchErr.Failed, 1)
+	assert.Equal(t, invalid.UUID, batchErr.Failed[0].UUID)
+	assert.Contains(t, err.Error(), invalid.UUID)
+
+	info, err := newJetstream(t).StreamInfo(topic)
+	require.NoError(t, err)
+	assert.EqualValues(t, 100, info.State.Msgs)
+}
+
+func TestClose_waits_for_async_publishes(t *testing.T) {
+	topic := newStream(t)
+
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:       getNatsURL(),
+		Marshaler: jetstream.GobMarshaler{},
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+
+	var messages []*message.Message
+	for i := 0; i < 100; i++ {
+		messages = append(messages, message.NewMessage(watermill.NewUUID(), []byte("payload")))
+	}
+
+	_, err = pub.PublishAsync(topic, messages...)
+	require.NoError(t, err)
+	require.NoError(t, pub.Close())
+
+	info, err := newJetstream(t).StreamInfo(topic)
+	require.NoError(t, err)
+	assert.EqualValues(t, len(messages), info.State.Msgs)
+}
+
+func TestClose_timeout(t *testing.T) {
+	conn, err := nats.Connect(getNatsURL())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// the subject is not stored by a stream, and the subscriber never responds, so publishes are not acked
+	subject := "unconfirmed_" + watermill.NewShortUUID()
+	_, err = conn.SubscribeSync(subject)
+	require.NoError(t, err)
+	require.NoError(t, conn.Flush())
+
+	pub, err := jetstream.NewStreamingPublisherWithNatsConn(conn, jetstream.StreamingPublisherPublishConfig{
+		Marshaler:    jetstream.GobMarshaler{},
+		CloseTimeout: time.Millisecond * 100,
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+
+	_, err = pub.PublishAsync(subject, message.NewMessage(watermill.NewUUID(), nil), message.NewMessage(watermill.NewUUID(), nil))
+	require.NoError(t, err)
+
+	err = pub.Close()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "2 async publishes were not confirmed")
+	assert.True(t, conn.IsConnected(), "shared connection should not be closed by the publisher")
+}
+
+func TestNewStreamingPublisherWithNatsConn(t *testing.T) {
+	topic := newStream(t)
+
+	conn, err := nats.Connect(getNatsURL())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var publishers []*jetstream.StreamingPublisher
+	for i := 0; i < 2; i++ {
+		pub, err := jetstream.NewStreamingPublisherWithNatsConn(conn, jetstream.StreamingPublisherPublishConfig{
+			Marshaler: jetstream.GobMarshaler{},
+		}, watermill.NewStdLogger(true, false))
+		require.NoError(t, err)
+		publishers = append(publishers, pub)
+	}
+
+	require.NoError(t, publishers[0].Close())
+	assert.True(t, conn.IsConnected(), "shared connection should not be closed by the publisher")
+
+	require.NoError(t, publishers[1].Publish(topic, message.NewMessage(watermill.NewUUID(), nil)))
+}
@@ -1,40 +1,115 @@
 package jetstream_test
 
 import (
+	"context"
+	"encoding/json"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream/natstest"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/ThreeDotsLabs/watermill/pubsub/tests"
 	"github.com/nats-io/nats.go"
 	"github.com/stretchr/testify/require"
 )
 
-func newPubSub(t *testing.T, clientID string, queueName string) (message.Publisher, message.Subscriber) {
-	logger := watermill.NewStdLogger(true, true)
+func TestMain(m *testing.M) {
+	os.Exit(natstest.RunTests(m, "WATERMILL_TEST_NATS_URL"))
+}
 
+func getNatsURL() string {
 	natsURL := os.Getenv("WATERMILL_TEST_NATS_URL")
 	if natsURL == "" {
 		natsURL = nats.DefaultURL
 	}
 
+	return natsURL
+}
+
+// streamProvisioner creates a stream for each topic the first time it's used, as JetStream requires it.
+type streamProvisioner struct {
+	js      nats.JetStreamContext
+	streams sync.Map
+}
+
+func (p *streamProvisioner) ensure(topic string) error {
+	if _, ok := p.streams.Load(topic); ok {
+		return nil
+	}
+
+	if err := jetstream.EnsureStream(p.js, jetstream.StreamConfig{Name: topic}); err != nil {
+		return err
+	}
+
+	p.streams.Store(topic, struct{}{})
+	return nil
+}
+
+type provisioningPublisher struct {
+	message.Publisher
+	streams *streamProvisioner
+}
+
+func (p provisioningPublisher) Publish(topic string, messages ...*message.Message) error {
+	if err := p.streams.ensure(topic); err != nil {
+		return err
+	}
+
+	return p.Publisher.Publish(topic, messages...)
+}
+
+type provisioningSubscriber struct {
+	*jetstream.StreamingSubscriber
+	streams *streamProvisioner
+}
+
+func (s provisioningSubscriber) Subscribe(ctx context.Context, topic string) (<-chan *message.Message, error) {
+	if err := s.streams.ensure(topic); err != nil {
+		return nil, err
+	}
+
+	return s.StreamingSubscriber.Subscribe(ctx, topic)
+}
+
+func (s provisioningSubscriber) SubscribeInitialize(topic string) error {
+	if err := s.streams.ensure(topic); err != nil {
+		return err
+	}
+
+	return s.StreamingSubscriber.SubscribeInitialize(topic)
+}
+
+func newPubSub(t *testing.T, clientID string, queueName string) (message.Publisher, message.Subscriber) {
+	logger := watermill.NewStdLogger(true, true)
+
+	natsURL := getNatsURL()
+
 	options := []nats.Option{}
 
+	conn, err := jetstream.NewNatsConnection(&jetstream.NatsConnConfig{URL: natsURL})
+	require.NoError(t, err)
+	t.Cleanup(conn.Close)
+
+	js, err := conn.JetStream()
+	require.NoError(t, err)
+	streams := &streamProvisioner{js: js}
+
 	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
-		URL:   natsURL,
+		URL:         natsURL,
 		Marshaler:   jetstream.GobMarshaler{},
 		NatsOptions: options,
 	}, logger)
 	require.NoError(t, err)
 
 	sub, err := jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
-		ClusterID:        natsURL,
+		URL:              natsURL,
 		ClientID:         clientID + "_sub",
 		QueueGroup:       queueName,
-		DurableName:      "durable-name",
+		DurableName:      queueName,
 		SubscribersCount: 10,
 		AckWaitTimeout:   time.Second, // AckTiemout < 5 required for continueAfterErrors
 		Unmarshaler:      jetstream.GobMarshaler{},
@@ -42,7 +117,7 @@ func newPubSub(t *testing.T, clientID string, queueName string) (message.Publish
 	}, logger)
 	require.NoError(t, err)
 
-	return pub, sub
+	return provisioningPublisher{pub, streams}, provisioningSubscriber{sub, streams}
 }
 
 func createPubSub(t *testing.T) (message.Publisher, message.Subscriber) {
@@ -66,3 +141,63 @@ func TestPublishSubscribe(t *testing.T) {
 		createPubSubWithDurable,
 	)
 }
+
+func TestPublish_JSONMarshaler(t *testing.T) {
+	topic := newStream(t)
+
+	nc, err := nats.Connect(getNatsURL())
+	require.NoError(t, err)
+	defer nc.Close()
+
+	natsSub, err := nc.SubscribeSync(topic)
+	require.NoError(t, err)
+	require.NoError(t, nc.Flush())
+
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:       getNatsURL(),
+		Marshaler: jetstream.JSONMarshaler{},
+	}, nil)
+	require.NoError(t, err)
+	defer pub.Close()
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte("payload"))
+	msg.Metadata.Set("foo", "bar")
+	require.NoError(t, pub.Publish(topic, msg))
+
+	natsMsg, err := natsSub.NextMsg(time.Second * 5)
+	require.NoError(t, err)
+	require.True(t, json.Valid(natsMsg.Data))
+
+	received, err := jetstream.JSONMarshaler{}.Unmarshal(natsMsg)
+	require.NoError(t, err)
+	require.True(t, msg.Equals(received))
+}
+
+func TestPublish_NATSMarshaler(t *testing.T) {
+	topic := newStream(t)
+
+	nc, err := nats.Connect(getNatsURL())
+	require.NoError(t, err)
+	defer nc.Close()
+
+	natsSub, err := nc.SubscribeSync(topic)
+	require.NoError(t, err)
+	require.NoError(t, nc.Flush())
+
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:       getNatsURL(),
+		Marshaler: jetstream.NATSMarshaler{},
+	}, nil)
+	require.NoError(t, err)
+	defer pub.Close()
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte("payload"))
+	msg.Metadata.Set("foo", "bar")
+	require.NoError(t, pub.Publish(topic, msg))
+
+	natsMsg, err := natsSub.NextMsg(time.Second * 5)
+	require.NoError(t, err)
+	require.Equal(t, "bar", natsMsg.Header.Get("foo"))
+	require.Equal(t, msg.UUID, natsMsg.Header.Get(jetstream.WatermillUUIDHdr))
+	require.Equal(t, []byte("payload"), natsMsg.Data)
+}
@@ -0,0 +1,50 @@
+package jetstream
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// ReplyToKey is the metadata key with the subject where the response to the message is expected,
+// for example an inbox created with nats.NewInbox and subscribed by the requester.
+//
+// The reply subject of messages delivered by JetStream is used for acks, so the reply subject
+// of the request has to be carried in metadata. It's never set by the publisher, the requester
+// has to set it before publishing the request and subscribe to the subject first:
+//
+//	inbox := nats.NewInbox()
+//	responses, err := conn.SubscribeSync(inbox)
+//	// ...
+//	request.Metadata.Set(jetstream.ReplyToKey, inbox)
+//	err = publisher.Publish(topic, request)
+//
+// The marshaler used by the requester has to carry metadata, so that Reply can read it.
+const ReplyToKey = "_nats_reply_to"
+
+// ErrNoReplySubject is returned by Reply when the message has no ReplyToKey metadata,
+// for example when it was published without a requester waiting for the response.
+var ErrNoReplySubject = errors.New("message has no reply subject")
+
+// Reply publishes response to the reply subject of msg set by the requester (see ReplyToKey) with core NATS,
+// so the requester doesn't need a stream storing the reply subject.
+//
+// The response is marshaled with Unmarshaler, which has to implement Marshaler.
+func (s *StreamingSubscriber) Reply(msg *message.Message, response *message.Message) error {
+	replyTo := msg.Metadata.Get(ReplyToKey)
+	if replyTo == "" {
+		return errors.Wrapf(ErrNoReplySubject, "cannot reply to message %s", msg.UUID)
+	}
+
+	marshaler, ok := s.config.Unmarshaler.(Marshaler)
+	if !ok {
+		return errors.New("StreamingSubscriberConfig.Unmarshaler doesn't implement Marshaler, it is required to reply")
+	}
+
+	natsMsg, err := marshalMsg(marshaler, replyTo, response)
+	if err != nil {
+		return errors.Wrap(err, "cannot marshal response")
+	}
+
+	return errors.Wrap(s.conn.PublishMsg(natsMsg), "cannot publish response")
+}
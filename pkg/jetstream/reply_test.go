@@ -0,0 +1,58 @@
+package jetstream_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+)
+
+func TestReply(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	conn, err := nats.Connect(getNatsURL())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	inbox := nats.NewInbox()
+	responses, err := conn.SubscribeSync(inbox)
+	require.NoError(t, err)
+	// the response is published by another connection, so the subscription has to be registered by the server
+	require.NoError(t, conn.Flush())
+
+	request := message.NewMessage(watermill.NewUUID(), []byte("request"))
+	request.Metadata.Set(jetstream.ReplyToKey, inbox)
+	require.NoError(t, pub.Publish(topic, request))
+
+	received := receiveMessages(t, messages, 1)[0]
+	response := message.NewMessage(watermill.NewUUID(), []byte("response"))
+	require.NoError(t, sub.Reply(received, response))
+
+	natsResponse, err := responses.NextMsg(time.Second * 5)
+	require.NoError(t, err)
+
+	unmarshaled, err := jetstream.GobMarshaler{}.Unmarshal(natsResponse)
+	require.NoError(t, err)
+	assert.Equal(t, response.UUID, unmarshaled.UUID)
+	assert.Equal(t, response.Payload, unmarshaled.Payload)
+}
+
+func TestReply_without_reply_subject(t *testing.T) {
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{})
+
+	err := sub.Reply(message.NewMessage(watermill.NewUUID(), nil), message.NewMessage(watermill.NewUUID(), nil))
+	assert.ErrorIs(t, err, jetstream.ErrNoReplySubject)
+}
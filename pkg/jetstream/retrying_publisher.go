@@ -0,0 +1,151 @@
+package jetstream
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// defaultRetryMaxAttempts is the default of RetryingPublisherConfig.MaxAttempts.
+const defaultRetryMaxAttempts = 3
+
+// RetryingPublisherConfig is the config of NewRetryingPublisher, zero values are replaced with defaults.
+type RetryingPublisherConfig struct {
+	// MaxAttempts is the maximum number of attempts to publish a message, including the first one, 3 by default.
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry, 100 milliseconds by default.
+	// It is doubled after each retry, up to MaxBackoff.
+	Backoff time.Duration
+
+	// MaxBackoff is the maximum delay between retries, 5 seconds by default.
+	MaxBackoff time.Duration
+
+	// IsRetryable returns true when publishing failed with err may succeed when retried,
+	// IsTransientPublishError by default.
+	IsRetryable func(err error) bool
+}
+
+func (c *RetryingPublisherConfig) setDefaults() {
+	if c.MaxAttempts == 0 {
+		c.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if c.Backoff == 0 {
+		c.Backoff = defaultPublishRetryBackoff
+	}
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = maxPublishRetryBackoff
+	}
+	if c.IsRetryable == nil {
+		c.IsRetryable = IsTransientPublishError
+	}
+}
+
+func (c RetryingPublisherConfig) Validate() error {
+	if c.MaxAttempts < 0 {
+		return errors.New("RetryingPublisherConfig.MaxAttempts cannot be negative")
+	}
+	if c.Backoff < 0 {
+		return errors.New("RetryingPublisherConfig.Backoff cannot be negative")
+	}
+	if c.MaxBackoff < 0 {
+		return errors.New("RetryingPublisherConfig.MaxBackoff cannot be negative")
+	}
+	if c.MaxBackoff > 0 && c.Backoff > c.MaxBackoff {
+		return errors.New("RetryingPublisherConfig.Backoff cannot be greater than RetryingPublisherConfig.MaxBackoff")
+	}
+
+	return nil
+}
+
+// IsTransientPublishError returns true when err is returned when JetStream didn't respond to the publish,
+// for example while the stream leader is elected, or when the message couldn't be buffered while reconnecting.
+func IsTransientPublishError(err error) bool {
+	for _, transientErr := range []error{
+		nats.ErrNoResponders,
+		nats.ErrTimeout,
+		context.DeadlineExceeded,
+		ErrReconnectBufferExceeded,
+	} {
+		if errors.Is(err, transientErr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RetryingPublisher is a publisher decorated with retries of publishing failing with transient errors.
+type RetryingPublisher struct {
+	pub    message.Publisher
+	config RetryingPublisherConfig
+}
+
+// NewRetryingPublisher returns pub retrying to publish messages failing with errors accepted
+// by RetryingPublisherConfig.IsRetryable.
+func NewRetryingPublisher(pub message.Publisher, config RetryingPublisherConfig) (*RetryingPublisher, error) {
+	if pub == nil {
+		return nil, errors.New("missing publisher")
+	}
+
+	config.setDefaults()
+	if err := config.Validate(); err != nil {
+		return nil, withKind(ErrInvalidConfig, err)
+	}
+
+	return &RetryingPublisher{pub: pub, config: config}, nil
+}
+
+// Publish publishes messages with the decorated publisher one by one, so only the failed message is retried,
+// not the messages published before it. A message which was published but not acked in time is published again,
+// so it is duplicated, unless the publisher sets Nats-Msg-Id (see StreamingPublisherConfig.Deduplication).
+//
+// Retries are stopped when the context of the message is done.
+// When a message can't be published, the following messages are not published.
+func (p *RetryingPublisher) Publish(topic string, messages ...*message.Message) error {
+	for _, msg := range messages {
+		if err := p.publish(topic, msg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *RetryingPublisher) publish(topic string, msg *message.Message) error {
+	backoff := p.config.Backoff
+
+	for attempt := 1; ; attempt++ {
+		err := p.pub.Publish(topic, msg)
+		if err == nil {
+			return nil
+		}
+		if !p.config.IsRetryable(err) {
+			return err
+		}
+		if attempt >= p.config.MaxAttempts {
+			return errors.Wrapf(err, "cannot publish message %s after %d attempts", msg.UUID, attempt)
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-msg.Context().Done():
+			timer.Stop()
+			return errors.Wrapf(err, "publish retries of message %s stopped", msg.UUID)
+		}
+
+		backoff *= 2
+		if backoff > p.config.MaxBackoff {
+			backoff = p.config.MaxBackoff
+		}
+	}
+}
+
+func (p *RetryingPublisher) Close() error {
+	return p.pub.Close()
+}
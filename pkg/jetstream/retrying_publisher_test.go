@@ -0,0 +1,155 @@
+package jetstream_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+)
+
+// flakyPublisher fails to publish with errs before publishing messages.
+type flakyPublisher struct {
+	errs      []error
+	attempts  int
+	published []string
+}
+
+func (p *flakyPublisher) Publish(_ string, messages ...*message.Message) error {
+	p.attempts++
+
+	if len(p.errs) > 0 {
+		err := p.errs[0]
+		p.errs = p.errs[1:]
+		return err
+	}
+
+	for _, msg := range messages {
+		p.published = append(p.published, msg.UUID)
+	}
+	return nil
+}
+
+func (p *flakyPublisher) Close() error {
+	return nil
+}
+
+func TestRetryingPublisher(t *testing.T) {
+	errPermanent := errors.New("permanent")
+
+	testCases := []struct {
+		Name              string
+		Errs              []error
+		ExpectedAttempts  int
+		ExpectedPublished bool
+		ExpectedErr       error
+	}{
+		{
+			Name:              "no_errors",
+			ExpectedAttempts:  1,
+			ExpectedPublished: true,
+		},
+		{
+			Name:              "transient_errors",
+			Errs:              []error{nats.ErrNoResponders, errors.Wrap(nats.ErrTimeout, "sending message failed")},
+			ExpectedAttempts:  3,
+			ExpectedPublished: true,
+		},
+		{
+			Name:             "max_attempts",
+			Errs:             []error{nats.ErrNoResponders, nats.ErrNoResponders, nats.ErrNoResponders},
+			ExpectedAttempts: 3,
+			ExpectedErr:      nats.ErrNoResponders,
+		},
+		{
+			Name:             "not_retryable",
+			Errs:             []error{errPermanent},
+			ExpectedAttempts: 1,
+			ExpectedErr:      errPermanent,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			flaky := &flakyPublisher{errs: tc.Errs}
+
+			pub, err := jetstream.NewRetryingPublisher(flaky, jetstream.RetryingPublisherConfig{
+				MaxAttempts: 3,
+				Backoff:     time.Millisecond,
+			})
+			require.NoError(t, err)
+
+			msg := message.NewMessage(watermill.NewUUID(), nil)
+			err = pub.Publish("topic", msg)
+			if tc.ExpectedErr != nil {
+				assert.ErrorIs(t, err, tc.ExpectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, tc.ExpectedAttempts, flaky.attempts)
+			if tc.ExpectedPublished {
+				assert.Equal(t, []string{msg.UUID}, flaky.published)
+			} else {
+				assert.Empty(t, flaky.published)
+			}
+		})
+	}
+}
+
+func TestRetryingPublisher_retries_only_failed_message(t *testing.T) {
+	flaky := &flakyPublisher{}
+
+	pub, err := jetstream.NewRetryingPublisher(flaky, jetstream.RetryingPublisherConfig{Backoff: time.Millisecond})
+	require.NoError(t, err)
+
+	first := message.NewMessage(watermill.NewUUID(), nil)
+	second := message.NewMessage(watermill.NewUUID(), nil)
+	require.NoError(t, pub.Publish("topic", first))
+
+	flaky.errs = []error{nats.ErrTimeout}
+	require.NoError(t, pub.Publish("topic", second))
+
+	assert.Equal(t, []string{first.UUID, second.UUID}, flaky.published)
+}
+
+func TestRetryingPublisher_context_cancelled(t *testing.T) {
+	flaky := &flakyPublisher{errs: []error{nats.ErrNoResponders, nats.ErrNoResponders}}
+
+	pub, err := jetstream.NewRetryingPublisher(flaky, jetstream.RetryingPublisherConfig{
+		Backoff:    time.Hour,
+		MaxBackoff: time.Hour,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	msg := message.NewMessage(watermill.NewUUID(), nil)
+	msg.SetContext(ctx)
+
+	assert.ErrorIs(t, pub.Publish("topic", msg), nats.ErrNoResponders)
+	assert.Equal(t, 1, flaky.attempts, "retries should stop when the message context is done")
+}
+
+func TestNewRetryingPublisher_invalid(t *testing.T) {
+	_, err := jetstream.NewRetryingPublisher(nil, jetstream.RetryingPublisherConfig{})
+	assert.Error(t, err)
+
+	_, err = jetstream.NewRetryingPublisher(&flakyPublisher{}, jetstream.RetryingPublisherConfig{MaxAttempts: -1})
+	assert.ErrorIs(t, err, jetstream.ErrInvalidConfig)
+
+	_, err = jetstream.NewRetryingPublisher(&flakyPublisher{}, jetstream.RetryingPublisherConfig{
+		Backoff:    time.Second,
+		MaxBackoff: time.Millisecond,
+	})
+	assert.ErrorIs(t, err, jetstream.ErrInvalidConfig)
+}
@@ -0,0 +1,99 @@
+package jetstream
+
+import (
+	"fmt"
+	"sync"
+
+	nats "github.com/nats-io/nats.go"
+
+	"github.com/ThreeDotsLabs/watermill"
+)
+
+// SequenceGapsBufferSize is the size of the buffer of StreamingSubscriber.SequenceGaps channel.
+// When the buffer is full, the oldest gap is dropped.
+const SequenceGapsBufferSize = 100
+
+// SequenceGap is a gap in consumer sequences of messages delivered to a subscription, detected with GapDetection.
+// Messages between Expected and Actual were not delivered to the subscription.
+type SequenceGap struct {
+	// Topic is the topic passed to Subscribe.
+	Topic string
+
+	// Expected is the consumer sequence following the previously delivered message.
+	Expected uint64
+
+	// Actual is the consumer sequence of the delivered message.
+	Actual uint64
+}
+
+func (g SequenceGap) String() string {
+	return fmt.Sprintf("sequence gap in %s: expected consumer sequence %d, got %d", g.Topic, g.Expected, g.Actual)
+}
+
+// gapDetector tracks the last consumer sequence delivered to a subscription.
+type gapDetector struct {
+	lock sync.Mutex
+	last uint64
+}
+
+// next records sequence and returns the expected sequence when it doesn't follow the last one.
+func (d *gapDetector) next(sequence uint64) (expected uint64, gap bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	last := d.last
+	d.last = sequence
+
+	if last == 0 || sequence <= last {
+		// first message, or the consumer was re-created and its sequence starts again
+		return 0, false
+	}
+
+	return last + 1, sequence != last+1
+}
+
+// detectGap logs and sends to SequenceGaps a gap between m and the previous message delivered to the subscription.
+// It does nothing when detector is nil, which is when GapDetection is disabled.
+func (s *StreamingSubscriber) detectGap(detector *gapDetector, topic string, m *nats.Msg, logFields watermill.LogFields) {
+	if detector == nil {
+		return
+	}
+
+	meta, err := m.Metadata()
+	if err != nil {
+		s.logger.Error("Cannot get message metadata", err, logFields)
+		return
+	}
+
+	expected, ok := detector.next(meta.Sequence.Consumer)
+	if !ok {
+		return
+	}
+
+	gap := SequenceGap{Topic: topic, Expected: expected, Actual: meta.Sequence.Consumer}
+	s.logger.Info("Consumer sequence gap detected", logFields.Add(watermill.LogFields{
+		"expected_sequence": gap.Expected,
+		"actual_sequence":   gap.Actual,
+	}))
+
+	for {
+		select {
+		case s.sequenceGaps <- gap:
+			return
+		default:
+		}
+
+		select {
+		case <-s.sequenceGaps:
+		default:
+		}
+	}
+}
+
+// SequenceGaps returns gaps in consumer sequences detected with GapDetection.
+//
+// The channel is buffered with SequenceGapsBufferSize, when gaps are not received fast enough
+// the oldest ones are dropped. The channel is not closed on Close.
+func (s *StreamingSubscriber) SequenceGaps() <-chan SequenceGap {
+	return s.sequenceGaps
+}
@@ -0,0 +1,303 @@
+package jetstream
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// StreamConfig describes a JetStream stream provisioned by EnsureStream.
+//
+// Only fields declared here are managed, all other settings of an existing stream are left untouched.
+type StreamConfig struct {
+	// Name is the name of the stream.
+	Name string
+
+	// Subjects are the subjects stored in the stream.
+	// When empty, stream Name is used as the only subject, unless Mirror or Sources are set.
+	Subjects []string
+
+	// Retention is the retention policy of the stream, nats.LimitsPolicy by default.
+	Retention nats.RetentionPolicy
+
+	// MaxAge is the maximum age of messages kept in the stream.
+	// When zero, messages don't expire.
+	MaxAge time.Duration
+
+	// MaxBytes is the maximum size of the stream in bytes.
+	// When zero, the size is unlimited.
+	MaxBytes int64
+
+	// MaxMsgs is the maximum number of messages kept in the stream.
+	// When zero, the number of messages is unlimited.
+	MaxMsgs int64
+
+	// Replicas is the number of stream replicas in a clustered JetStream, 1 by default.
+	Replicas int
+
+	// AllowMsgTTL allows per-message TTLs set with MsgTTLKey metadata, requires NATS server 2.11 or newer.
+	AllowMsgTTL bool
+
+	// Mirror makes the stream a mirror of another stream, which can be in another domain or account,
+	// for example to replicate messages to another region.
+	//
+	// Mirrored streams store only messages of the origin stream, so they can't have Subjects or Sources.
+	// The mirror of an existing stream can't be changed by the NATS server.
+	Mirror *nats.StreamSource
+
+	// Sources are streams whose messages are copied to the stream, in addition to messages of its Subjects.
+	Sources []*nats.StreamSource
+}
+
+func (c StreamConfig) Validate() error {
+	if c.Name == "" {
+		return errors.New("StreamConfig.Name is missing")
+	}
+	if c.MaxAge < 0 {
+		return errors.New("StreamConfig.MaxAge cannot be negative")
+	}
+	if c.MaxBytes < 0 {
+		return errors.New("StreamConfig.MaxBytes cannot be negative")
+	}
+	if c.MaxMsgs < 0 {
+		return errors.New("StreamConfig.MaxMsgs cannot be negative")
+	}
+	if c.Replicas < 0 {
+		return errors.New("StreamConfig.Replicas cannot be negative")
+	}
+
+	if c.Mirror != nil {
+		if c.Mirror.Name == "" {
+			return errors.New("StreamConfig.Mirror.Name is missing")
+		}
+		if len(c.Subjects) > 0 {
+			return errors.New("StreamConfig.Subjects cannot be used with StreamConfig.Mirror")
+		}
+		if len(c.Sources) > 0 {
+			return errors.New("StreamConfig.Sources cannot be used with StreamConfig.Mirror")
+		}
+	}
+	for _, source := range c.Sources {
+		if source == nil || source.Name == "" {
+			return errors.New("StreamConfig.Sources entries must have Name")
+		}
+	}
+
+	return nil
+}
+
+// apply sets fields managed by StreamConfig on natsConfig,
+// using the same representation of defaults as the NATS server.
+func (c StreamConfig) apply(natsConfig *nats.StreamConfig) {
+	natsConfig.Name = c.Name
+
+	natsConfig.Subjects = c.Subjects
+	if len(natsConfig.Subjects) == 0 && c.Mirror == nil && len(c.Sources) == 0 {
+		natsConfig.Subjects = []string{c.Name}
+	}
+
+	natsConfig.Retention = c.Retention
+	natsConfig.MaxAge = c.MaxAge
+
+	natsConfig.MaxBytes = c.MaxBytes
+	if natsConfig.MaxBytes == 0 {
+		natsConfig.MaxBytes = -1
+	}
+
+	natsConfig.MaxMsgs = c.MaxMsgs
+	if natsConfig.MaxMsgs == 0 {
+		natsConfig.MaxMsgs = -1
+	}
+
+	natsConfig.Replicas = c.Replicas
+	if natsConfig.Replicas == 0 {
+		natsConfig.Replicas = 1
+	}
+
+	natsConfig.AllowMsgTTL = c.AllowMsgTTL
+	natsConfig.Mirror = c.Mirror
+	natsConfig.Sources = c.Sources
+}
+
+func (c StreamConfig) matches(natsConfig nats.StreamConfig) bool {
+	var expected nats.StreamConfig
+	c.apply(&expected)
+
+	return expected.Retention == natsConfig.Retention &&
+		expected.MaxAge == natsConfig.MaxAge &&
+		expected.MaxBytes == natsConfig.MaxBytes &&
+		expected.MaxMsgs == natsConfig.MaxMsgs &&
+		expected.Replicas == natsConfig.Replicas &&
+		expected.AllowMsgTTL == natsConfig.AllowMsgTTL &&
+		sameSubjects(expected.Subjects, natsConfig.Subjects) &&
+		sameSources([]*nats.StreamSource{expected.Mirror}, []*nats.StreamSource{natsConfig.Mirror}) &&
+		sameSources(expected.Sources, natsConfig.Sources)
+}
+
+// sameSources compares stream sources by their origin, filter and start sequence, in any order.
+func sameSources(a, b []*nats.StreamSource) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	keys := func(sources []*nats.StreamSource) []string {
+		k := make([]string, 0, len(sources))
+		for _, source := range sources {
+			k = append(k, sourceKey(source))
+		}
+		return k
+	}
+
+	return sameSubjects(keys(a), keys(b))
+}
+
+func sourceKey(source *nats.StreamSource) string {
+	if source == nil {
+		return ""
+	}
+
+	// nats.go converts Domain to the API prefix of External when creating the stream
+	apiPrefix := ""
+	if source.External != nil {
+		apiPrefix = source.External.APIPrefix
+	}
+	if source.Domain != "" {
+		apiPrefix = fmt.Sprintf("$JS.%s.API", source.Domain)
+	}
+
+	return fmt.Sprintf("%s|%s|%d|%s", source.Name, source.FilterSubject, source.OptStartSeq, apiPrefix)
+}
+
+func sameSubjects(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// subjectIsSubset returns true when all subjects matching subject also match pattern,
+// both can contain wildcards.
+func subjectIsSubset(subject, pattern string) bool {
+	subjectTokens := strings.Split(subject, ".")
+	patternTokens := strings.Split(pattern, ".")
+
+	for i, p := range patternTokens {
+		if p == ">" {
+			return len(subjectTokens) > i
+		}
+		if i >= len(subjectTokens) || subjectTokens[i] == ">" {
+			return false
+		}
+		if p != "*" && p != subjectTokens[i] {
+			return false
+		}
+	}
+
+	return len(subjectTokens) == len(patternTokens)
+}
+
+// EnsureStream creates the stream described by config if it doesn't exist,
+// or updates it when its configuration differs from config.
+func EnsureStream(js nats.JetStreamContext, config StreamConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	info, err := js.StreamInfo(config.Name)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		var natsConfig nats.StreamConfig
+		config.apply(&natsConfig)
+
+		if _, err := js.AddStream(&natsConfig); err != nil {
+			return errors.Wrapf(err, "cannot create stream %s", config.Name)
+		}
+
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "cannot get info of stream %s", config.Name)
+	}
+
+	if config.matches(info.Config) {
+		return nil
+	}
+
+	natsConfig := info.Config
+	config.apply(&natsConfig)
+
+	if _, err := js.UpdateStream(&natsConfig); err != nil {
+		return errors.Wrapf(err, "cannot update stream %s", config.Name)
+	}
+
+	return nil
+}
+
+type streamOptions struct {
+	ignoreNotFound bool
+}
+
+// StreamOption configures DeleteStream and PurgeStream.
+type StreamOption func(*streamOptions)
+
+// IgnoreStreamNotFound makes DeleteStream and PurgeStream return nil when the stream doesn't exist,
+// so they can be used for idempotent teardown.
+func IgnoreStreamNotFound() StreamOption {
+	return func(o *streamOptions) {
+		o.ignoreNotFound = true
+	}
+}
+
+func applyStreamOptions(options []StreamOption) streamOptions {
+	var o streamOptions
+	for _, option := range options {
+		option(&o)
+	}
+
+	return o
+}
+
+// DeleteStream deletes the stream with all its messages and consumers.
+//
+// When the stream doesn't exist, an error wrapping nats.ErrStreamNotFound is returned,
+// unless IgnoreStreamNotFound is passed.
+func DeleteStream(js nats.JetStreamContext, name string, options ...StreamOption) error {
+	o := applyStreamOptions(options)
+
+	err := js.DeleteStream(name)
+	if errors.Is(err, nats.ErrStreamNotFound) && o.ignoreNotFound {
+		return nil
+	}
+
+	return errors.Wrapf(err, "cannot delete stream %s", name)
+}
+
+// PurgeStream deletes all messages of the stream, the stream and its consumers are kept.
+//
+// When the stream doesn't exist, an error wrapping nats.ErrStreamNotFound is returned,
+// unless IgnoreStreamNotFound is passed.
+func PurgeStream(js nats.JetStreamContext, name string, options ...StreamOption) error {
+	o := applyStreamOptions(options)
+
+	err := js.PurgeStream(name)
+	if errors.Is(err, nats.ErrStreamNotFound) && o.ignoreNotFound {
+		return nil
+	}
+
+	return errors.Wrapf(err, "cannot purge stream %s", name)
+}
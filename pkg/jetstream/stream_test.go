@@ -0,0 +1,193 @@
+package jetstream_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+)
+
+func newJetstream(t *testing.T) nats.JetStreamContext {
+	nc, err := jetstream.NewNatsConnection(&jetstream.NatsConnConfig{URL: getNatsURL()})
+	require.NoError(t, err)
+
+	t.Cleanup(nc.Close)
+
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+
+	return js
+}
+
+func TestEnsureStream(t *testing.T) {
+	js := newJetstream(t)
+
+	config := jetstream.StreamConfig{
+		Name:     "stream_" + watermill.NewShortUUID(),
+		MaxAge:   time.Hour,
+		MaxMsgs:  100,
+		Replicas: 1,
+	}
+	defer func() {
+		_ = js.DeleteStream(config.Name)
+	}()
+
+	require.NoError(t, jetstream.EnsureStream(js, config))
+
+	info, err := js.StreamInfo(config.Name)
+	require.NoError(t, err)
+	assert.Equal(t, []string{config.Name}, info.Config.Subjects)
+	assert.Equal(t, time.Hour, info.Config.MaxAge)
+	assert.EqualValues(t, 100, info.Config.MaxMsgs)
+	assert.EqualValues(t, -1, info.Config.MaxBytes)
+
+	// no changes, stream is left as is
+	require.NoError(t, jetstream.EnsureStream(js, config))
+
+	config.MaxMsgs = 200
+	config.Subjects = []string{config.Name + ".>"}
+	require.NoError(t, jetstream.EnsureStream(js, config))
+
+	info, err = js.StreamInfo(config.Name)
+	require.NoError(t, err)
+	assert.Equal(t, []string{config.Name + ".>"}, info.Config.Subjects)
+	assert.EqualValues(t, 200, info.Config.MaxMsgs)
+}
+
+func TestEnsureStream_mirror_and_sources(t *testing.T) {
+	js := newJetstream(t)
+	pub := newPublisher(t)
+
+	origins := []string{newStream(t), newStream(t)}
+
+	mirror := jetstream.StreamConfig{
+		Name:   "mirror_" + watermill.NewShortUUID(),
+		Mirror: &nats.StreamSource{Name: origins[0]},
+	}
+	aggregate := jetstream.StreamConfig{
+		Name:    "aggregate_" + watermill.NewShortUUID(),
+		Sources: []*nats.StreamSource{{Name: origins[0]}, {Name: origins[1]}},
+	}
+	defer func() {
+		_ = js.DeleteStream(mirror.Name)
+		_ = js.DeleteStream(aggregate.Name)
+	}()
+
+	for _, config := range []jetstream.StreamConfig{mirror, aggregate} {
+		require.NoError(t, jetstream.EnsureStream(js, config))
+		// no changes, stream is left as is
+		require.NoError(t, jetstream.EnsureStream(js, config))
+	}
+
+	info, err := js.StreamInfo(mirror.Name)
+	require.NoError(t, err)
+	assert.Empty(t, info.Config.Subjects, "mirror should have no subjects")
+
+	for _, origin := range origins {
+		publishMessages(t, pub, origin, 1)
+	}
+
+	assert.Eventually(t, func() bool {
+		mirrorInfo, err := js.StreamInfo(mirror.Name)
+		require.NoError(t, err)
+		aggregateInfo, err := js.StreamInfo(aggregate.Name)
+		require.NoError(t, err)
+
+		return mirrorInfo.State.Msgs == 1 && aggregateInfo.State.Msgs == 2
+	}, time.Second*5, time.Millisecond*50)
+}
+
+func TestStreamConfig_Validate(t *testing.T) {
+	testCases := []struct {
+		Name        string
+		Config      jetstream.StreamConfig
+		ExpectedErr bool
+	}{
+		{Name: "valid", Config: jetstream.StreamConfig{Name: "stream"}},
+		{Name: "missing_name", Config: jetstream.StreamConfig{}, ExpectedErr: true},
+		{Name: "negative_max_age", Config: jetstream.StreamConfig{Name: "stream", MaxAge: -1}, ExpectedErr: true},
+		{Name: "negative_max_bytes", Config: jetstream.StreamConfig{Name: "stream", MaxBytes: -1}, ExpectedErr: true},
+		{Name: "negative_max_msgs", Config: jetstream.StreamConfig{Name: "stream", MaxMsgs: -1}, ExpectedErr: true},
+		{Name: "negative_replicas", Config: jetstream.StreamConfig{Name: "stream", Replicas: -1}, ExpectedErr: true},
+		{Name: "mirror", Config: jetstream.StreamConfig{Name: "stream", Mirror: &nats.StreamSource{Name: "origin"}}},
+		{
+			Name:        "mirror_without_name",
+			Config:      jetstream.StreamConfig{Name: "stream", Mirror: &nats.StreamSource{}},
+			ExpectedErr: true,
+		},
+		{
+			Name: "mirror_with_subjects",
+			Config: jetstream.StreamConfig{
+				Name:     "stream",
+				Subjects: []string{"stream"},
+				Mirror:   &nats.StreamSource{Name: "origin"},
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "mirror_with_sources",
+			Config: jetstream.StreamConfig{
+				Name:    "stream",
+				Mirror:  &nats.StreamSource{Name: "origin"},
+				Sources: []*nats.StreamSource{{Name: "other"}},
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name:   "sources",
+			Config: jetstream.StreamConfig{Name: "stream", Sources: []*nats.StreamSource{{Name: "origin"}}},
+		},
+		{
+			Name:        "source_without_name",
+			Config:      jetstream.StreamConfig{Name: "stream", Sources: []*nats.StreamSource{{}}},
+			ExpectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := tc.Config.Validate()
+			if tc.ExpectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestPurgeStream(t *testing.T) {
+	js := newJetstream(t)
+	topic := newStream(t)
+
+	publishMessages(t, newPublisher(t), topic, 3)
+
+	require.NoError(t, jetstream.PurgeStream(js, topic))
+
+	info, err := js.StreamInfo(topic)
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, info.State.Msgs)
+
+	missing := "missing_" + watermill.NewShortUUID()
+	assert.ErrorIs(t, jetstream.PurgeStream(js, missing), nats.ErrStreamNotFound)
+	assert.NoError(t, jetstream.PurgeStream(js, missing, jetstream.IgnoreStreamNotFound()))
+}
+
+func TestDeleteStream(t *testing.T) {
+	js := newJetstream(t)
+	topic := newStream(t)
+
+	require.NoError(t, jetstream.DeleteStream(js, topic))
+
+	_, err := js.StreamInfo(topic)
+	assert.ErrorIs(t, err, nats.ErrStreamNotFound)
+
+	assert.ErrorIs(t, jetstream.DeleteStream(js, topic), nats.ErrStreamNotFound)
+	assert.NoError(t, jetstream.DeleteStream(js, topic, jetstream.IgnoreStreamNotFound()))
+}
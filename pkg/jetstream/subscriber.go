@@ -2,19 +2,146 @@ package jetstream
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	internalSync "github.com/ThreeDotsLabs/watermill/pubsub/sync"
+	"unicode"
 
 	nats "github.com/nats-io/nats.go"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/message"
 )
 
+const (
+	// DeadLetterReasonKey is the metadata key with the reason why the message was sent to DeadLetterTopic.
+	DeadLetterReasonKey = "_dead_letter_reason"
+
+	// DeliveryCountKey is the metadata key with the number of delivery attempts of a dead lettered message.
+	DeliveryCountKey = "_delivery_count"
+
+	// SubjectKey is the metadata key with the subject to which the message was published.
+	// It allows to route messages received from a wildcard topic, like "orders.>".
+	SubjectKey = "_nats_subject"
+
+	// StreamSequenceKey is the metadata key with the sequence of the message in the stream (see DeliveryMetadata).
+	StreamSequenceKey = "_nats_stream_sequence"
+
+	// ConsumerSequenceKey is the metadata key with the delivery sequence of the consumer (see DeliveryMetadata).
+	ConsumerSequenceKey = "_nats_consumer_sequence"
+
+	// NumDeliveredKey is the metadata key with the number of deliveries of the message,
+	// it's greater than 1 for redelivered messages (see DeliveryMetadata).
+	NumDeliveredKey = "_nats_num_delivered"
+
+	// TimestampKey is the metadata key with the time when the message was stored in the stream,
+	// formatted as time.RFC3339Nano (see DeliveryMetadata).
+	TimestampKey = "_nats_timestamp"
+)
+
+// ConsumerType determines how messages are delivered to the StreamingSubscriber.
+type ConsumerType int
+
+const (
+	// PushConsumer subscribes with JetStream Subscribe or QueueSubscribe and the messages are pushed by NATS.
+	PushConsumer ConsumerType = iota
+
+	// PullConsumer uses a durable JetStream pull consumer, messages are fetched in batches of FetchBatchSize.
+	PullConsumer
+)
+
+// AckPolicy determines how messages received by the consumer are acknowledged.
+type AckPolicy int
+
+const (
+	// AckExplicit requires each message to be acked, messages which are not acked are redelivered.
+	// It is the most reliable mode.
+	AckExplicit AckPolicy = iota
+
+	// AckAll acknowledges the message together with all messages delivered before it.
+	// It reduces the acknowledgement overhead, but a nack of a message delivered before an acked one
+	// doesn't cause redelivery, so it should be used only when messages are processed in order.
+	AckAll
+
+	// AckNone doesn't acknowledge messages, they are considered delivered when sent by the server.
+	// Messages are lost when processing fails or the subscriber is closed, and they are never redelivered.
+	AckNone
+)
+
+// AckMode determines how acks of messages are sent to the server.
+type AckMode int
+
+const (
+	// AckAsync sends acks without waiting for the server, an ack lost on the way is not detected
+	// and the message is redelivered after AckWaitTimeout.
+	AckAsync AckMode = iota
+
+	// AckSync sends acks with nats.Msg.AckSync and waits until the server confirms them.
+	// Each ack costs a round trip to the server, so the processing of a message takes longer
+	// and messages are processed more slowly, unless SubscribersCount or HandlerConcurrency is increased.
+	AckSync
+)
+
+// UnmarshalErrorPolicy determines what happens with messages which cannot be unmarshaled.
+type UnmarshalErrorPolicy int
+
+const (
+	// UnmarshalErrorIgnore logs the error and leaves the message unacked, so it's redelivered after AckWaitTimeout
+	// until MaxDeliver is reached. A malformed message is redelivered forever when MaxDeliver is not set.
+	UnmarshalErrorIgnore UnmarshalErrorPolicy = iota
+
+	// UnmarshalErrorAck acks the message, so it's lost.
+	UnmarshalErrorAck
+
+	// UnmarshalErrorTerm terminates the message, so it's not redelivered and JetStream
+	// publishes a $JS.EVENT.ADVISORY.CONSUMER.MSG_TERMINATED advisory.
+	UnmarshalErrorTerm
+
+	// UnmarshalErrorNack nacks the message, so it's redelivered immediately, or after NakDelay when it is set.
+	UnmarshalErrorNack
+
+	// UnmarshalErrorDeadLetter publishes the message to DeadLetterTopic and terminates it.
+	// As the message cannot be unmarshaled, it's published with the original data and headers,
+	// with DeadLetterReasonKey and DeliveryCountKey headers added.
+	UnmarshalErrorDeadLetter
+)
+
+// DeliverPolicy determines from which message of the stream a new consumer starts delivering.
+type DeliverPolicy int
+
+const (
+	// DeliverAll delivers all messages available in the stream.
+	DeliverAll DeliverPolicy = iota
+
+	// DeliverLast delivers the last message of the stream and all messages published later.
+	DeliverLast
+
+	// DeliverNew delivers only messages published after the consumer was created.
+	DeliverNew
+
+	// DeliverByStartSequence delivers messages starting from the stream sequence OptStartSeq.
+	DeliverByStartSequence
+
+	// DeliverByStartTime delivers messages stored in the stream since OptStartTime.
+	DeliverByStartTime
+)
+
 type StreamingSubscriberConfig struct {
+	// URL is the NATS URL, nats.DefaultURL is used when both URL and URLs are empty.
+	URL string
+
+	// URLs are URLs of multiple servers of a NATS cluster used together with URL, so the client
+	// can reconnect to another server when one goes down.
+	URLs []string
+
 	// ClusterID is the NATS Streaming cluster ID.
 	ClusterID string
 
@@ -49,30 +176,359 @@ type StreamingSubscriberConfig struct {
 	//
 	// Doing this causes the NATS Streaming server to track
 	// the last acknowledged message for that ClientID + DurableName.
+	//
+	// The durable consumer filters the subscribed topic, so topics of the same stream
+	// need different durable names, otherwise Subscribe returns ErrInvalidConfig.
 	DurableName string
 
 	// SubscribersCount determines wow much concurrent subscribers should be started.
 	SubscribersCount int
 
+	// HandlerConcurrency is the number of messages of a single subscription processed concurrently,
+	// so handlers can run in parallel without more subscriptions (queue group members, or ephemeral consumers).
+	// Received messages are dispatched to a pool of HandlerConcurrency workers before the output channel,
+	// each of SubscribersCount subscriptions has its own pool. When zero, messages are processed one by one.
+	//
+	// Messages are then not processed in the order they are delivered, so it cannot be used with Ordered.
+	HandlerConcurrency int
+
+	// OutputChannelBuffer is the buffer size of the channel returned by Subscribe, so received messages
+	// can wait for the consumer without blocking delivery. When zero, the channel is unbuffered.
+	//
+	// Each subscription still waits for the Ack or Nack of a buffered message before processing the next one,
+	// so the buffer is filled only with SubscribersCount or HandlerConcurrency greater than 1.
+	// Messages left in the buffer on Close or when ctx is done are not acked and they are redelivered.
+	OutputChannelBuffer int
+
+	// StartPaused makes the subscriber process no messages until Resume is called, so subscribers can be
+	// registered before dependencies of handlers are ready. Subscribe creates consumers and returns
+	// the output channel as usual, so invalid configs are still reported by Subscribe.
+	//
+	// Pull consumers don't fetch messages while paused. Push consumers are delivered messages by JetStream
+	// regardless, up to MaxAckPending of them are buffered by nats.go and they are redelivered
+	// when they are not acked within AckWaitTimeout, so PullConsumer is preferred when pausing for long.
+	StartPaused bool
+
 	// CloseTimeout determines how long subscriber will wait for Ack/Nack on close.
 	// When no Ack/Nack is received after CloseTimeout, subscriber will be closed.
 	CloseTimeout time.Duration
 
+	// DrainOnClose makes Close drain subscriptions instead of unsubscribing them.
+	// Messages which were already delivered are processed and acked before closing the connection,
+	// Close waits for them up to CloseTimeout.
+	DrainOnClose bool
+
+	// CloseProgress is called every CloseProgressInterval while Close waits for messages being processed,
+	// with the number of remaining messages. It's not called anymore when all messages are processed.
+	// The progress is logged on Info level also when it's not set.
+	CloseProgress func(remaining int)
+
+	// CloseProgressInterval is how often the progress of Close is reported, 5 seconds by default.
+	CloseProgressInterval time.Duration
+
 	// How long subscriber should wait for Ack/Nack. When no Ack/Nack was received, message will be redelivered.
 	// It is mapped to stan.AckWait option.
 	AckWaitTimeout time.Duration
 
+	// AckWaitJitter randomizes the ack wait of each message within [AckWaitTimeout, AckWaitTimeout+AckWaitJitter],
+	// so redeliveries of messages which timed out at once, for example during a partial outage, are spread.
+	// Timed out messages are nacked by the subscriber, the consumer ack wait is set to AckWaitTimeout+AckWaitJitter,
+	// so messages are redelivered by JetStream only when the subscriber is gone.
+	//
+	// It cannot be used with BackOff and AckProgressInterval.
+	AckWaitJitter time.Duration
+
 	// NatsOptions are custom []nats.Option passed to the connection.
 	// It is also used to provide connection parameters, for example:
 	// 		nats.NatsURL("nats://localhost:4222")
 	NatsOptions []nats.Option
 
+	// CredentialsFile is the path to the NATS credentials file (JWT and NKey seed) used for authentication.
+	CredentialsFile string
+
+	// Token is the authentication token, it is mapped to nats.Token. It cannot be used with Username and Password.
+	Token string
+
+	// Username and Password are used for the user/password authentication, they are mapped to nats.UserInfo.
+	// Like Token, they are not logged.
+	Username string
+	Password string
+
+	// NKeySeed is the NKey user seed (starting with "SU") used for authentication, the public key is derived
+	// from it and the server nonce is signed with it. NKeySeedFile can be used instead to read the seed from a file.
+	// They cannot be used with CredentialsFile, Token and Username.
+	NKeySeed     string
+	NKeySeedFile string
+
+	// TLSConfig is the TLS configuration of the connection, TLSFiles can be used to build it from PEM files.
+	TLSConfig *tls.Config
+
+	// OnDisconnect is called when the connection to NATS is lost, err is nil when it was closed explicitly.
+	OnDisconnect func(conn *nats.Conn, err error)
+
+	// OnReconnect is called when the connection to NATS is re-established.
+	OnReconnect func(conn *nats.Conn)
+
+	// OnClosed is called when the connection is closed and no further reconnects will be attempted.
+	OnClosed func(conn *nats.Conn)
+
+	// ClientName is the name of the connection shown in NATS monitoring, it is mapped to nats.Name.
+	// When empty, watermill-jetstream-<hostname> is used, unless a name is set with NatsOptions.
+	ClientName string
+
+	// PingInterval is the interval of pings sent to the server to detect broken connections,
+	// the nats.go default (2 minutes) is used when zero. It is mapped to nats.PingInterval.
+	PingInterval time.Duration
+
+	// MaxPingsOutstanding is the number of pings without a response after which the connection
+	// is considered broken, the nats.go default (2) is used when zero. It is mapped to nats.MaxPingsOutstanding.
+	MaxPingsOutstanding int
+
+	// NoEcho prevents messages published with core NATS on the connection from being delivered
+	// to subscriptions of the same connection, it is mapped to nats.NoEcho. Messages delivered
+	// by JetStream consumers are sent by the server, so they are still received.
+	//
+	// It cannot be used with ConnectionProvider, as the provider may share the connection
+	// with components relying on echo.
+	NoEcho bool
+
+	// LazyConnect defers connecting to NATS until the first Subscribe, SubscribeInitialize or JetStream call,
+	// so the constructor only validates the config, for example to avoid connecting on cold starts when nothing
+	// is subscribed. Connection errors are returned by the first use, which is retried by the next one.
+	LazyConnect bool
+
+	// ConnectionProvider creates the connection instead of connecting to URL with NatsOptions,
+	// so connections can be configured in one place or mocked in tests.
+	// When set, URL, NatsOptions and other connection options of the config are ignored.
+	// The created connection is closed on Close.
+	ConnectionProvider ConnectionProvider
+
 	// Unmarshaler is an unmarshaler used to unmarshaling messages from NATS format to Watermill format.
+	// It can be overridden per topic with StreamingSubscriber.SetTopicUnmarshaler.
 	Unmarshaler Unmarshaler
+
+	// ConsumerType determines if messages are pushed by NATS or fetched by the subscriber, PushConsumer by default.
+	//
+	// PullConsumer requires DurableName to be set.
+	ConsumerType ConsumerType
+
+	// AckPolicy determines how messages are acknowledged, AckExplicit by default (see AckPolicy for trade-offs).
+	//
+	// With AckNone, messages are sent to the output channel without waiting for Ack or Nack, so it cannot be used
+	// with MaxDeliver, NakDelay, AckProgressInterval, AckWaitJitter, AckMode, DeadLetterTopic and OnUnmarshalError.
+	AckPolicy AckPolicy
+
+	// AckMode determines if acks are confirmed by the server, AckAsync by default (see AckMode for trade-offs).
+	//
+	// With AckSync, acks which were not confirmed are sent to AckErrors and the outcome of the message
+	// is "ack_failed", it is then redelivered after AckWaitTimeout.
+	AckMode AckMode
+
+	// DeliverPolicy determines from which message of the stream the consumer starts delivering, DeliverAll by default.
+	// It is applied when the consumer is created, existing durable consumers continue where they stopped.
+	DeliverPolicy DeliverPolicy
+
+	// OptStartSeq is the stream sequence of the first delivered message, required by DeliverByStartSequence.
+	OptStartSeq uint64
+
+	// OptStartTime is the time since which messages are delivered, required by DeliverByStartTime.
+	OptStartTime time.Time
+
+	// FilterSubject limits messages delivered by the consumer to the subject, by default the subscribed topic.
+	// It allows to subscribe a subset of subjects stored in the stream of the topic, it has to be
+	// a subset of the stream subjects.
+	FilterSubject string
+
+	// FilterSubjects are multiple subjects used instead of FilterSubject, requires NATS server 2.10 or newer.
+	FilterSubjects []string
+
+	// FetchBatchSize is the maximum number of messages fetched at once by PullConsumer, 1 by default.
+	FetchBatchSize int
+
+	// FetchTimeout is how long a single fetch of PullConsumer waits for messages, 5 seconds by default.
+	FetchTimeout time.Duration
+
+	// BatchAck acks messages fetched together by PullConsumer with a single ack of the last one when all of them
+	// are acked, which saves an ack round-trip per message. It requires AckPolicy AckAll.
+	//
+	// It relies on messages of a batch being delivered in the stream order, so the ack of the last one acknowledges
+	// all of them, and on the consumer being used by a single subscription, as the ack acknowledges messages
+	// fetched by other subscriptions as well. It cannot be used with SubscribersCount greater than 1,
+	// and a durable consumer shouldn't be shared with other subscribers.
+	//
+	// When a message of the batch is nacked (or not acked in time), messages before it are acked
+	// and it is nacked with all messages after it, so they are redelivered before new messages.
+	// The next batch is fetched after the previous one is acked. It cannot be used with MaxRedeliverThenAck,
+	// UnmarshalErrorAck and DeduplicationStore, which ack messages outside of the batch, or with NakDelay
+	// and BackOff, which delay redeliveries of nacked messages.
+	BatchAck bool
+
+	// MaxDeliver is the maximum number of delivery attempts of a message, unlimited by default.
+	//
+	// When a message is nacked on its last delivery attempt, it is terminated, so it won't be redelivered.
+	MaxDeliver int
+
+	// MaxRedeliverThenAck is the number of redeliveries of a message after which it's given up on:
+	// when it's delivered again, it is acked without processing and logged, so it won't be redelivered.
+	// It's simpler than DeadLetterTopic for best-effort processing, unlimited by default.
+	//
+	// With MaxDeliver, it must be lower than MaxDeliver - 1, otherwise messages are not delivered
+	// to be acked anymore.
+	MaxRedeliverThenAck int
+
+	// DeduplicationStore records UUIDs of processed messages, so a message with the UUID of an already
+	// processed one is acked without processing and isn't sent to the output channel.
+	// The UUID is recorded when the message is acked, NewMemoryDeduplicationStore and NewKVDeduplicationStore
+	// can be used. Messages without UUID (neither set by the unmarshaler nor in Nats-Msg-Id) are not deduplicated.
+	//
+	// When the store returns an error, the message is processed, so it's not lost. It cannot be used
+	// with AckNone and BatchAck.
+	DeduplicationStore DeduplicationStore
+
+	// BackOff is the list of delays between redeliveries of a message, it is overriding AckWaitTimeout.
+	// When a message is redelivered more times than BackOff entries, the last delay is used.
+	//
+	// The number of BackOff entries cannot exceed MaxDeliver.
+	BackOff []time.Duration
+
+	// MaxAckPending is the maximum number of messages delivered but not acked yet,
+	// when it is reached, JetStream stops delivering messages until some of them are acked.
+	// When zero, the JetStream default is used.
+	//
+	// The limit is set on the consumer, so with QueueGroup or PullConsumer it is shared by all
+	// SubscribersCount subscribers (and other subscribers of the consumer), not applied per subscriber.
+	MaxAckPending int
+
+	// FlowControl enables flow control of the push consumer, so the server doesn't deliver messages faster
+	// than the subscriber receives them, for example over high-latency connections. It requires IdleHeartbeat.
+	// It cannot be used with QueueGroup or PullConsumer.
+	//
+	// It is set when the consumer is created, so it's not changed for existing durable consumers.
+	FlowControl bool
+
+	// IdleHeartbeat is the interval of heartbeats sent by the server to the push consumer when there are
+	// no messages to deliver. Missed heartbeats are reported with nats.ErrConsumerNotActive
+	// to the connection error handler, so stalled consumers can be detected.
+	// It cannot be used with QueueGroup or PullConsumer.
+	//
+	// It is set when the consumer is created, so it's not changed for existing durable consumers.
+	IdleHeartbeat time.Duration
+
+	// RateLimitBitsPerSec limits the rate of delivery of the push consumer, it is mapped to the RateLimit
+	// of the consumer. The limit is in bits per second of delivered messages (with headers), not in messages,
+	// so the message rate depends on the size of messages. When zero, the delivery is not limited.
+	// It cannot be used with PullConsumer, which fetches messages at its own pace.
+	//
+	// It is set when the consumer is created, so it's not changed for existing durable consumers.
+	RateLimitBitsPerSec uint64
+
+	// InactiveThreshold is how long the server keeps an ephemeral consumer without subscriptions,
+	// so consumers of crashed subscribers are deleted by the server. When zero, the server default is used.
+	//
+	// Durable consumers are never deleted by the subscriber, so it cannot be used with DurableName,
+	// QueueGroup (which is the durable name by default) or PullConsumer.
+	InactiveThreshold time.Duration
+
+	// ConsumerReplicas is the number of replicas of the consumer state in a clustered JetStream,
+	// when zero, the consumer has as many replicas as the stream. The count is checked by the server,
+	// it can't be larger than the cluster size.
+	//
+	// It is set when the consumer is created, so it's not changed for existing durable consumers.
+	ConsumerReplicas int
+
+	// ConsumerMemoryStorage keeps the consumer state in memory instead of the stream storage, which lowers
+	// the latency of acks, but the state is lost when the server restarts. It suits ephemeral consumers.
+	//
+	// It is set when the consumer is created, so it's not changed for existing durable consumers.
+	ConsumerMemoryStorage bool
+
+	// ConsumerDescription and ConsumerMetadata are the description and metadata of the consumer shown
+	// by nats consumer info, for example to identify the service owning the consumer.
+	// Keys and values of ConsumerMetadata cannot be empty.
+	//
+	// They are set when the consumer is created, so they're not changed for existing durable consumers.
+	ConsumerDescription string
+	ConsumerMetadata    map[string]string
+
+	// SampleFrequency is the percentage of acks sampled by the server, for example "100%" or "10".
+	// Sampled acks are published as advisories to $JS.EVENT.METRIC.CONSUMER.ACK.<stream>.<consumer>,
+	// with the delivery latency of the message. When empty, acks are not sampled.
+	//
+	// It is set when the consumer is created, so it's not changed for existing durable consumers.
+	SampleFrequency string
+
+	// NakDelay is the delay of redelivery of nacked messages, requested with NakWithDelay.
+	// When zero, nacked messages are redelivered after AckWaitTimeout (or BackOff) expires.
+	NakDelay time.Duration
+
+	// AckProgressInterval is the interval of in progress acks sent while the message is neither acked nor nacked,
+	// so handlers running longer than AckWaitTimeout don't cause redelivery. It must be shorter than AckWaitTimeout.
+	//
+	// When set, the subscriber waits for Ack/Nack until it's closed, instead of giving up after AckWaitTimeout.
+	// Handlers can instead extend the deadline only when they make progress, with ExtendAckDeadline.
+	AckProgressInterval time.Duration
+
+	// DeadLetterTopic is the topic where messages nacked on their last delivery attempt (see MaxDeliver)
+	// are published before being terminated.
+	//
+	// When the message can't be published, for example when the topic has no stream, publishing is retried
+	// with backoff until the subscriber is closed, so the message is not lost. Messages of the subscription
+	// are not processed in the meantime, unless HandlerConcurrency is set.
+	//
+	// Dead lettered messages keep the original payload and metadata, with DeadLetterReasonKey and
+	// DeliveryCountKey metadata added. It requires MaxDeliver or UnmarshalErrorDeadLetter,
+	// and Unmarshaler implementing Marshaler.
+	DeadLetterTopic string
+
+	// OnUnmarshalError determines what happens with messages which cannot be unmarshaled,
+	// UnmarshalErrorIgnore by default (see UnmarshalErrorPolicy).
+	//
+	// UnmarshalErrorDeadLetter requires DeadLetterTopic. It cannot be used with AckNone and Ordered.
+	OnUnmarshalError UnmarshalErrorPolicy
+
+	// DeliveryMetadata adds JetStream delivery metadata to metadata of received messages:
+	// StreamSequenceKey, ConsumerSequenceKey, NumDeliveredKey and TimestampKey.
+	// It can be used to detect redelivered and duplicated messages.
+	DeliveryMetadata bool
+
+	// GapDetection tracks the consumer sequence of messages delivered to each subscription and reports
+	// messages which were skipped (for example deleted from the stream before delivery) with SequenceGaps
+	// and an Info log with the expected and actual sequence.
+	//
+	// The consumer has to deliver all messages to a single subscription, so it cannot be used with QueueGroup,
+	// Ordered, or PullConsumer with SubscribersCount greater than 1. A durable PullConsumer shouldn't
+	// be shared with other subscribers either.
+	GapDetection bool
+
+	// Ordered subscribes with an ordered push consumer (see nats.OrderedConsumer), which delivers messages
+	// strictly in the stream order and is re-created by nats.go when a gap is detected.
+	// SubscribersCount is forced to 1.
+	//
+	// Ordered consumers are ephemeral and don't use acks, so nacked messages are not redelivered.
+	// It cannot be used with QueueGroup, DurableName, PullConsumer, AckPolicy, AckMode, MaxDeliver, BackOff,
+	// MaxAckPending, FlowControl, IdleHeartbeat, RateLimitBitsPerSec, NakDelay, AckProgressInterval, AckWaitJitter,
+	// OnUnmarshalError, ConsumerReplicas, ConsumerMemoryStorage, ConsumerDescription, ConsumerMetadata,
+	// SampleFrequency and DeduplicationStore.
+	Ordered bool
+
+	// Tracer enables OpenTelemetry tracing, when set, a consumer span is started for each received message
+	// and set in the message context. The span is linked to the producer span extracted from NATS headers
+	// with the global propagator (see otel.SetTextMapPropagator), OutcomeAttributeKey records if the message
+	// was acked or nacked.
+	Tracer trace.Tracer
+
+	// LogFieldsExtractor returns log fields of the received message, for example a correlation ID from metadata.
+	// They are added to all logs of processing the message, after the message is unmarshaled.
+	LogFieldsExtractor func(*message.Message) watermill.LogFields
+
+	// Clock is used for AckWaitTimeout and CloseTimeout, so they can be triggered with a fake clock in tests.
+	// When nil, RealClock is used.
+	Clock Clock
 }
 
 type StreamingSubscriberSubscriptionConfig struct {
 	// Unmarshaler is an unmarshaler used to unmarshaling messages from NATS format to Watermill format.
+	// It can be overridden per topic with StreamingSubscriber.SetTopicUnmarshaler.
 	Unmarshaler Unmarshaler
 	// QueueGroup is the NATS Streaming queue group.
 	//
@@ -98,297 +554,2630 @@ type StreamingSubscriberSubscriptionConfig struct {
 	//
 	// Doing this causes the NATS Streaming server to track
 	// the last acknowledged message for that ClientID + DurableName.
+	//
+	// The durable consumer filters the subscribed topic, so topics of the same stream
+	// need different durable names, otherwise Subscribe returns ErrInvalidConfig.
 	DurableName string
 
 	// SubscribersCount determines wow much concurrent subscribers should be started.
 	SubscribersCount int
 
+	// HandlerConcurrency is the number of messages of a single subscription processed concurrently,
+	// so handlers can run in parallel without more subscriptions (queue group members, or ephemeral consumers).
+	// Received messages are dispatched to a pool of HandlerConcurrency workers before the output channel,
+	// each of SubscribersCount subscriptions has its own pool. When zero, messages are processed one by one.
+	//
+	// Messages are then not processed in the order they are delivered, so it cannot be used with Ordered.
+	HandlerConcurrency int
+
+	// OutputChannelBuffer is the buffer size of the channel returned by Subscribe, so received messages
+	// can wait for the consumer without blocking delivery. When zero, the channel is unbuffered.
+	//
+	// Each subscription still waits for the Ack or Nack of a buffered message before processing the next one,
+	// so the buffer is filled only with SubscribersCount or HandlerConcurrency greater than 1.
+	// Messages left in the buffer on Close or when ctx is done are not acked and they are redelivered.
+	OutputChannelBuffer int
+
 	// How long subscriber should wait for Ack/Nack. When no Ack/Nack was received, message will be redelivered.
 	// It is mapped to stan.AckWait option.
 	AckWaitTimeout time.Duration
 
+	// AckWaitJitter randomizes the ack wait of each message within [AckWaitTimeout, AckWaitTimeout+AckWaitJitter],
+	// so redeliveries of messages which timed out at once, for example during a partial outage, are spread.
+	// Timed out messages are nacked by the subscriber, the consumer ack wait is set to AckWaitTimeout+AckWaitJitter,
+	// so messages are redelivered by JetStream only when the subscriber is gone.
+	//
+	// It cannot be used with BackOff and AckProgressInterval.
+	AckWaitJitter time.Duration
+
+	// StartPaused makes the subscriber process no messages until Resume is called, so subscribers can be
+	// registered before dependencies of handlers are ready. Subscribe creates consumers and returns
+	// the output channel as usual, so invalid configs are still reported by Subscribe.
+	//
+	// Pull consumers don't fetch messages while paused. Push consumers are delivered messages by JetStream
+	// regardless, up to MaxAckPending of them are buffered by nats.go and they are redelivered
+	// when they are not acked within AckWaitTimeout, so PullConsumer is preferred when pausing for long.
+	StartPaused bool
+
 	// CloseTimeout determines how long subscriber will wait for Ack/Nack on close.
 	// When no Ack/Nack is received after CloseTimeout, subscriber will be closed.
 	CloseTimeout time.Duration
-}
 
-func (c *StreamingSubscriberConfig) GetStreamingSubscriberSubscriptionConfig() StreamingSubscriberSubscriptionConfig {
-	return StreamingSubscriberSubscriptionConfig{
-		Unmarshaler:      c.Unmarshaler,
-		QueueGroup:       c.QueueGroup,
-		DurableName:      c.DurableName,
-		SubscribersCount: c.SubscribersCount,
-		AckWaitTimeout:   c.AckWaitTimeout,
-		CloseTimeout:     c.CloseTimeout,
-	}
-}
+	// DrainOnClose makes Close drain subscriptions instead of unsubscribing them.
+	// Messages which were already delivered are processed and acked before closing the connection,
+	// Close waits for them up to CloseTimeout.
+	DrainOnClose bool
 
-func (c *StreamingSubscriberSubscriptionConfig) setDefaults() {
-	if c.SubscribersCount <= 0 {
-		c.SubscribersCount = 1
-	}
-	if c.CloseTimeout <= 0 {
-		c.CloseTimeout = time.Second * 30
-	}
-	if c.AckWaitTimeout <= 0 {
-		c.AckWaitTimeout = time.Second * 30
-	}
-}
+	// CloseProgress is called every CloseProgressInterval while Close waits for messages being processed,
+	// with the number of remaining messages. It's not called anymore when all messages are processed.
+	// The progress is logged on Info level also when it's not set.
+	CloseProgress func(remaining int)
 
-func (c *StreamingSubscriberSubscriptionConfig) Validate() error {
-	if c.Unmarshaler == nil {
-		return errors.New("StreamingSubscriberConfig.Unmarshaler is missing")
-	}
+	// CloseProgressInterval is how often the progress of Close is reported, 5 seconds by default.
+	CloseProgressInterval time.Duration
 
-	if c.QueueGroup == "" && c.SubscribersCount > 1 {
-		return errors.New(
-			"to set StreamingSubscriberConfig.SubscribersCount " +
-				"you need to also set StreamingSubscriberConfig.QueueGroup, " +
-				"in other case you will receive duplicated messages",
-		)
-	}
+	// ConsumerType determines if messages are pushed by NATS or fetched by the subscriber, PushConsumer by default.
+	//
+	// PullConsumer requires DurableName to be set.
+	ConsumerType ConsumerType
 
-	return nil
-}
+	// AckPolicy determines how messages are acknowledged, AckExplicit by default (see AckPolicy for trade-offs).
+	//
+	// With AckNone, messages are sent to the output channel without waiting for Ack or Nack, so it cannot be used
+	// with MaxDeliver, NakDelay, AckProgressInterval, AckWaitJitter, AckMode, DeadLetterTopic and OnUnmarshalError.
+	AckPolicy AckPolicy
 
-type StreamingSubscriber struct {
-	conn   nats.Conn
-	logger watermill.LoggerAdapter
+	// AckMode determines if acks are confirmed by the server, AckAsync by default (see AckMode for trade-offs).
+	//
+	// With AckSync, acks which were not confirmed are sent to AckErrors and the outcome of the message
+	// is "ack_failed", it is then redelivered after AckWaitTimeout.
+	AckMode AckMode
 
-	config StreamingSubscriberSubscriptionConfig
+	// DeliverPolicy determines from which message of the stream the consumer starts delivering, DeliverAll by default.
+	// It is applied when the consumer is created, existing durable consumers continue where they stopped.
+	DeliverPolicy DeliverPolicy
 
-	subs     []nats.Subscription
-	subsLock sync.RWMutex
+	// OptStartSeq is the stream sequence of the first delivered message, required by DeliverByStartSequence.
+	OptStartSeq uint64
 
-	closed  bool
-	closing chan struct{}
+	// OptStartTime is the time since which messages are delivered, required by DeliverByStartTime.
+	OptStartTime time.Time
 
-	outputsWg            sync.WaitGroup
-	processingMessagesWg sync.WaitGroup
-}
+	// FilterSubject limits messages delivered by the consumer to the subject, by default the subscribed topic.
+	// It allows to subscribe a subset of subjects stored in the stream of the topic, it has to be
+	// a subset of the stream subjects.
+	FilterSubject string
 
-// NewStreamingSubscriber creates a new StreamingSubscriber.
-//
-// When using custom NATS hostname, you should pass it by options StreamingSubscriberConfig.NatsOptions:
-//		// ...
-//		NatsOptions: []nats.Option{
-//			nats.NatsURL("nats://your-nats-hostname:4222"),
-//		}
-//		// ...
-func NewStreamingSubscriber(config StreamingSubscriberConfig, logger watermill.LoggerAdapter) (*StreamingSubscriber, error) {
-	conn, err := nats.Connect(config.ClusterID, config.NatsOptions...)
-	if err != nil {
-		return nil, errors.Wrap(err, "cannot connect to NATS")
-	}
-	return NewStreamingSubscriberWithNatsConn(*conn, config.GetStreamingSubscriberSubscriptionConfig(), logger)
-}
+	// FilterSubjects are multiple subjects used instead of FilterSubject, requires NATS server 2.10 or newer.
+	FilterSubjects []string
 
-func NewStreamingSubscriberWithNatsConn(conn nats.Conn, config StreamingSubscriberSubscriptionConfig, logger watermill.LoggerAdapter) (*StreamingSubscriber, error) {
-	config.setDefaults()
+	// FetchBatchSize is the maximum number of messages fetched at once by PullConsumer, 1 by default.
+	FetchBatchSize int
 
-	if err := config.Validate(); err != nil {
-		return nil, err
-	}
+	// FetchTimeout is how long a single fetch of PullConsumer waits for messages, 5 seconds by default.
+	FetchTimeout time.Duration
 
-	if logger == nil {
-		logger = watermill.NopLogger{}
-	}
+	// BatchAck acks messages fetched together by PullConsumer with a single ack of the last one when all of them
+	// are acked, which saves an ack round-trip per message. It requires AckPolicy AckAll.
+	//
+	// It relies on messages of a batch being delivered in the stream order, so the ack of the last one acknowledges
+	// all of them, and on the consumer being used by a single subscription, as the ack acknowledges messages
+	// fetched by other subscriptions as well. It cannot be used with SubscribersCount greater than 1,
+	// and a durable consumer shouldn't be shared with other subscribers.
+	//
+	// When a message of the batch is nacked (or not acked in time), messages before it are acked
+	// and it is nacked with all messages after it, so they are redelivered before new messages.
+	// The next batch is fetched after the previous one is acked. It cannot be used with MaxRedeliverThenAck,
+	// UnmarshalErrorAck and DeduplicationStore, which ack messages outside of the batch, or with NakDelay
+	// and BackOff, which delay redeliveries of nacked messages.
+	BatchAck bool
+
+	// MaxDeliver is the maximum number of delivery attempts of a message, unlimited by default.
+	//
+	// When a message is nacked on its last delivery attempt, it is terminated, so it won't be redelivered.
+	MaxDeliver int
 
-	return &StreamingSubscriber{
-		conn:    conn,
-		logger:  logger,
-		config:  config,
-		closing: make(chan struct{}),
-	}, nil
-}
+	// MaxRedeliverThenAck is the number of redeliveries of a message after which it's given up on:
+	// when it's delivered again, it is acked without processing and logged, so it won't be redelivered.
+	// It's simpler than DeadLetterTopic for best-effort processing, unlimited by default.
+	//
+	// With MaxDeliver, it must be lower than MaxDeliver - 1, otherwise messages are not delivered
+	// to be acked anymore.
+	MaxRedeliverThenAck int
+
+	// DeduplicationStore records UUIDs of processed messages, so a message with the UUID of an already
+	// processed one is acked without processing and isn't sent to the output channel.
+	// The UUID is recorded when the message is acked, NewMemoryDeduplicationStore and NewKVDeduplicationStore
+	// can be used. Messages without UUID (neither set by the unmarshaler nor in Nats-Msg-Id) are not deduplicated.
+	//
+	// When the store returns an error, the message is processed, so it's not lost. It cannot be used
+	// with AckNone and BatchAck.
+	DeduplicationStore DeduplicationStore
 
-// Subscribe subscribes messages from NATS Streaming.
-//
-// Subscribe will spawn SubscribersCount goroutines making subscribe.
-func (s *StreamingSubscriber) Subscribe(ctx context.Context, topic string) (<-chan *message.Message, error) {
-	output := make(chan *message.Message)
+	// BackOff is the list of delays between redeliveries of a message, it is overriding AckWaitTimeout.
+	// When a message is redelivered more times than BackOff entries, the last delay is used.
+	//
+	// The number of BackOff entries cannot exceed MaxDeliver.
+	BackOff []time.Duration
 
-	for i := 0; i < s.config.SubscribersCount; i++ {
-		s.outputsWg.Add(1)
-		subscriberLogFields := watermill.LogFields{
-			"subscriber_num": i,
-			"topic":          topic,
-		}
+	// MaxAckPending is the maximum number of messages delivered but not acked yet,
+	// when it is reached, JetStream stops delivering messages until some of them are acked.
+	// When zero, the JetStream default is used.
+	//
+	// The limit is set on the consumer, so with QueueGroup or PullConsumer it is shared by all
+	// SubscribersCount subscribers (and other subscribers of the consumer), not applied per subscriber.
+	MaxAckPending int
 
-		s.logger.Debug("Starting subscriber", subscriberLogFields)
+	// FlowControl enables flow control of the push consumer, so the server doesn't deliver messages faster
+	// than the subscriber receives them, for example over high-latency connections. It requires IdleHeartbeat.
+	// It cannot be used with QueueGroup or PullConsumer.
+	//
+	// It is set when the consumer is created, so it's not changed for existing durable consumers.
+	FlowControl bool
 
-		processMessagesWg := &sync.WaitGroup{}
+	// IdleHeartbeat is the interval of heartbeats sent by the server to the push consumer when there are
+	// no messages to deliver. Missed heartbeats are reported with nats.ErrConsumerNotActive
+	// to the connection error handler, so stalled consumers can be detected.
+	// It cannot be used with QueueGroup or PullConsumer.
+	//
+	// It is set when the consumer is created, so it's not changed for existing durable consumers.
+	IdleHeartbeat time.Duration
 
-		sub, err := s.subscribe(ctx, output, topic, subscriberLogFields, processMessagesWg)
-		if err != nil {
-			return nil, errors.Wrap(err, "cannot subscribe")
-		}
+	// RateLimitBitsPerSec limits the rate of delivery of the push consumer, it is mapped to the RateLimit
+	// of the consumer. The limit is in bits per second of delivered messages (with headers), not in messages,
+	// so the message rate depends on the size of messages. When zero, the delivery is not limited.
+	// It cannot be used with PullConsumer, which fetches messages at its own pace.
+	//
+	// It is set when the consumer is created, so it's not changed for existing durable consumers.
+	RateLimitBitsPerSec uint64
 
-		go func(subscriber nats.Subscription, subscriberLogFields watermill.LogFields) {
-			select {
-			case <-s.closing:
-				// unblock
-			case <-ctx.Done():
-				// unblock
-			}
-			// if err := sub.Close(); err != nil {
-			// 	s.logger.Error("Cannot close subscriber", err, subscriberLogFields)
-			// }
+	// InactiveThreshold is how long the server keeps an ephemeral consumer without subscriptions,
+	// so consumers of crashed subscribers are deleted by the server. When zero, the server default is used.
+	//
+	// Durable consumers are never deleted by the subscriber, so it cannot be used with DurableName,
+	// QueueGroup (which is the durable name by default) or PullConsumer.
+	InactiveThreshold time.Duration
 
-			processMessagesWg.Wait()
-			s.outputsWg.Done()
-		}(*sub, subscriberLogFields)
+	// ConsumerReplicas is the number of replicas of the consumer state in a clustered JetStream,
+	// when zero, the consumer has as many replicas as the stream. The count is checked by the server,
+	// it can't be larger than the cluster size.
+	//
+	// It is set when the consumer is created, so it's not changed for existing durable consumers.
+	ConsumerReplicas int
 
-		s.subsLock.Lock()
-		s.subs = append(s.subs, *sub)
-		s.subsLock.Unlock()
-	}
+	// ConsumerMemoryStorage keeps the consumer state in memory instead of the stream storage, which lowers
+	// the latency of acks, but the state is lost when the server restarts. It suits ephemeral consumers.
+	//
+	// It is set when the consumer is created, so it's not changed for existing durable consumers.
+	ConsumerMemoryStorage bool
 
-	go func() {
-		s.outputsWg.Wait()
-		close(output)
-	}()
+	// ConsumerDescription and ConsumerMetadata are the description and metadata of the consumer shown
+	// by nats consumer info, for example to identify the service owning the consumer.
+	// Keys and values of ConsumerMetadata cannot be empty.
+	//
+	// They are set when the consumer is created, so they're not changed for existing durable consumers.
+	ConsumerDescription string
+	ConsumerMetadata    map[string]string
+
+	// SampleFrequency is the percentage of acks sampled by the server, for example "100%" or "10".
+	// Sampled acks are published as advisories to $JS.EVENT.METRIC.CONSUMER.ACK.<stream>.<consumer>,
+	// with the delivery latency of the message. When empty, acks are not sampled.
+	//
+	// It is set when the consumer is created, so it's not changed for existing durable consumers.
+	SampleFrequency string
+
+	// NakDelay is the delay of redelivery of nacked messages, requested with NakWithDelay.
+	// When zero, nacked messages are redelivered after AckWaitTimeout (or BackOff) expires.
+	NakDelay time.Duration
+
+	// AckProgressInterval is the interval of in progress acks sent while the message is neither acked nor nacked,
+	// so handlers running longer than AckWaitTimeout don't cause redelivery. It must be shorter than AckWaitTimeout.
+	//
+	// When set, the subscriber waits for Ack/Nack until it's closed, instead of giving up after AckWaitTimeout.
+	// Handlers can instead extend the deadline only when they make progress, with ExtendAckDeadline.
+	AckProgressInterval time.Duration
+
+	// DeadLetterTopic is the topic where messages nacked on their last delivery attempt (see MaxDeliver)
+	// are published before being terminated.
+	//
+	// When the message can't be published, for example when the topic has no stream, publishing is retried
+	// with backoff until the subscriber is closed, so the message is not lost. Messages of the subscription
+	// are not processed in the meantime, unless HandlerConcurrency is set.
+	//
+	// Dead lettered messages keep the original payload and metadata, with DeadLetterReasonKey and
+	// DeliveryCountKey metadata added. It requires MaxDeliver or UnmarshalErrorDeadLetter,
+	// and Unmarshaler implementing Marshaler.
+	DeadLetterTopic string
+
+	// OnUnmarshalError determines what happens with messages which cannot be unmarshaled,
+	// UnmarshalErrorIgnore by default (see UnmarshalErrorPolicy).
+	//
+	// UnmarshalErrorDeadLetter requires DeadLetterTopic. It cannot be used with AckNone and Ordered.
+	OnUnmarshalError UnmarshalErrorPolicy
 
-	return output, nil
+	// DeliveryMetadata adds JetStream delivery metadata to metadata of received messages:
+	// StreamSequenceKey, ConsumerSequenceKey, NumDeliveredKey and TimestampKey.
+	// It can be used to detect redelivered and duplicated messages.
+	DeliveryMetadata bool
+
+	// GapDetection tracks the consumer sequence of messages delivered to each subscription and reports
+	// messages which were skipped (for example deleted from the stream before delivery) with SequenceGaps
+	// and an Info log with the expected and actual sequence.
+	//
+	// The consumer has to deliver all messages to a single subscription, so it cannot be used with QueueGroup,
+	// Ordered, or PullConsumer with SubscribersCount greater than 1. A durable PullConsumer shouldn't
+	// be shared with other subscribers either.
+	GapDetection bool
+
+	// Ordered subscribes with an ordered push consumer (see nats.OrderedConsumer), which delivers messages
+	// strictly in the stream order and is re-created by nats.go when a gap is detected.
+	// SubscribersCount is forced to 1.
+	//
+	// Ordered consumers are ephemeral and don't use acks, so nacked messages are not redelivered.
+	// It cannot be used with QueueGroup, DurableName, PullConsumer, AckPolicy, AckMode, MaxDeliver, BackOff,
+	// MaxAckPending, FlowControl, IdleHeartbeat, RateLimitBitsPerSec, NakDelay, AckProgressInterval, AckWaitJitter,
+	// OnUnmarshalError, ConsumerReplicas, ConsumerMemoryStorage, ConsumerDescription, ConsumerMetadata,
+	// SampleFrequency and DeduplicationStore.
+	Ordered bool
+
+	// Tracer enables OpenTelemetry tracing, when set, a consumer span is started for each received message
+	// and set in the message context. The span is linked to the producer span extracted from NATS headers
+	// with the global propagator (see otel.SetTextMapPropagator), OutcomeAttributeKey records if the message
+	// was acked or nacked.
+	Tracer trace.Tracer
+
+	// LogFieldsExtractor returns log fields of the received message, for example a correlation ID from metadata.
+	// They are added to all logs of processing the message, after the message is unmarshaled.
+	LogFieldsExtractor func(*message.Message) watermill.LogFields
+
+	// Clock is used for AckWaitTimeout and CloseTimeout, so they can be triggered with a fake clock in tests.
+	// When nil, RealClock is used.
+	Clock Clock
 }
 
-func (s *StreamingSubscriber) SubscribeInitialize(topic string) (err error) {
-	_, err = s.subscribe(
-		context.Background(),
-		make(chan *message.Message),
-		topic,
-		nil,
-		&sync.WaitGroup{},
-	)
-	if err != nil {
-		return errors.Wrap(err, "cannot initialize subscribe")
+// validateConnection checks options of the connection created by the subscriber.
+func (c *StreamingSubscriberConfig) validateConnection() error {
+	if c.PingInterval < 0 {
+		return errors.New("StreamingSubscriberConfig.PingInterval cannot be negative")
+	}
+	if c.MaxPingsOutstanding < 0 {
+		return errors.New("StreamingSubscriberConfig.MaxPingsOutstanding cannot be negative")
+	}
+	if c.NoEcho && c.ConnectionProvider != nil {
+		return errors.New("StreamingSubscriberConfig.NoEcho cannot be used with ConnectionProvider")
+	}
+	if err := c.auth().validate(c.ConnectionProvider != nil); err != nil {
+		return errors.Wrap(err, "invalid StreamingSubscriberConfig auth")
 	}
 
-	// return errors.Wrap(sub.Close(), "cannot close after subscribe initialize")
 	return nil
 }
 
-func (s *StreamingSubscriber) subscribe(
-	ctx context.Context,
-	output chan *message.Message,
-	topic string,
-	subscriberLogFields watermill.LogFields,
-	processMessagesWg *sync.WaitGroup,
-) (*nats.Subscription, error) {
-	if s.config.QueueGroup != "" {
-		return s.conn.QueueSubscribe(
-			topic,
-			s.config.QueueGroup,
-			func(m *nats.Msg) {
-				if s.isClosed() {
-					return
-				}
+func (c *StreamingSubscriberConfig) auth() connectionAuth {
+	return connectionAuth{
+		credentialsFile: c.CredentialsFile,
+		token:           c.Token,
+		username:        c.Username,
+		password:        c.Password,
+		nkeySeed:        c.NKeySeed,
+		nkeySeedFile:    c.NKeySeedFile,
+	}
+}
 
-				processMessagesWg.Add(1)
-				defer processMessagesWg.Done()
+func (c *StreamingSubscriberConfig) natsOptions() ([]nats.Option, error) {
+	// the default name is overridden by the name set with NatsOptions
+	options := append([]nats.Option{nats.Name(defaultClientName())}, c.NatsOptions...)
 
-				s.processMessage(ctx, m, output, subscriberLogFields)
-			},
-		)
+	if c.ClientName != "" {
+		options = append(options, nats.Name(c.ClientName))
 	}
 
-	return s.conn.Subscribe(
-		topic,
-		func(m *nats.Msg) {
-			processMessagesWg.Add(1)
-			defer processMessagesWg.Done()
+	auth, err := c.auth().options()
+	if err != nil {
+		return nil, err
+	}
+	options = append(options, auth...)
 
-			s.processMessage(ctx, m, output, subscriberLogFields)
+	if c.TLSConfig != nil {
+		options = append(options, nats.Secure(c.TLSConfig))
+	}
+
+	options = append(options, handlerOptions(c.OnDisconnect, c.OnReconnect, c.OnClosed)...)
+	options = append(options, pingOptions(c.PingInterval, c.MaxPingsOutstanding)...)
+
+	if c.NoEcho {
+		options = append(options, nats.NoEcho())
+	}
+
+	return options, nil
+}
+
+func (c *StreamingSubscriberConfig) connect() (*nats.Conn, error) {
+	if err := c.validateConnection(); err != nil {
+		return nil, withKind(ErrInvalidConfig, err)
+	}
+
+	if c.ConnectionProvider != nil {
+		return connectWithProvider(c.ConnectionProvider)
+	}
+
+	options, err := c.natsOptions()
+	if err != nil {
+		return nil, withKind(ErrInvalidConfig, err)
+	}
+
+	url, err := serverURLs(c.URL, c.URLs)
+	if err != nil {
+		return nil, withKind(ErrInvalidConfig, errors.Wrap(err, "invalid StreamingSubscriberConfig.URLs"))
+	}
+	if url == "" {
+		url = nats.DefaultURL
+	}
+
+	conn, err := nats.Connect(url, options...)
+	if err != nil {
+		return nil, withKind(ErrConnect, errors.Wrap(err, "cannot connect to NATS"))
+	}
+
+	return conn, nil
+}
+
+func (c *StreamingSubscriberConfig) GetStreamingSubscriberSubscriptionConfig() StreamingSubscriberSubscriptionConfig {
+	return StreamingSubscriberSubscriptionConfig{
+		Unmarshaler:           c.Unmarshaler,
+		QueueGroup:            c.QueueGroup,
+		DurableName:           c.DurableName,
+		SubscribersCount:      c.SubscribersCount,
+		HandlerConcurrency:    c.HandlerConcurrency,
+		OutputChannelBuffer:   c.OutputChannelBuffer,
+		AckWaitTimeout:        c.AckWaitTimeout,
+		AckWaitJitter:         c.AckWaitJitter,
+		CloseTimeout:          c.CloseTimeout,
+		DrainOnClose:          c.DrainOnClose,
+		CloseProgress:         c.CloseProgress,
+		CloseProgressInterval: c.CloseProgressInterval,
+		ConsumerType:          c.ConsumerType,
+		AckPolicy:             c.AckPolicy,
+		AckMode:               c.AckMode,
+		DeliverPolicy:         c.DeliverPolicy,
+		OptStartSeq:           c.OptStartSeq,
+		OptStartTime:          c.OptStartTime,
+		FilterSubject:         c.FilterSubject,
+		FilterSubjects:        c.FilterSubjects,
+		FetchBatchSize:        c.FetchBatchSize,
+		FetchTimeout:          c.FetchTimeout,
+		BatchAck:              c.BatchAck,
+		MaxDeliver:            c.MaxDeliver,
+		MaxRedeliverThenAck:   c.MaxRedeliverThenAck,
+		DeduplicationStore:    c.DeduplicationStore,
+		BackOff:               c.BackOff,
+		MaxAckPending:         c.MaxAckPending,
+		FlowControl:           c.FlowControl,
+		IdleHeartbeat:         c.IdleHeartbeat,
+		RateLimitBitsPerSec:   c.RateLimitBitsPerSec,
+		InactiveThreshold:     c.InactiveThreshold,
+		ConsumerReplicas:      c.ConsumerReplicas,
+		ConsumerMemoryStorage: c.ConsumerMemoryStorage,
+		ConsumerDescription:   c.ConsumerDescription,
+		ConsumerMetadata:      c.ConsumerMetadata,
+		SampleFrequency:       c.SampleFrequency,
+		NakDelay:              c.NakDelay,
+		AckProgressInterval:   c.AckProgressInterval,
+		DeadLetterTopic:       c.DeadLetterTopic,
+		OnUnmarshalError:      c.OnUnmarshalError,
+		DeliveryMetadata:      c.DeliveryMetadata,
+		GapDetection:          c.GapDetection,
+		Ordered:               c.Ordered,
+		Tracer:                c.Tracer,
+		LogFieldsExtractor:    c.LogFieldsExtractor,
+		Clock:                 c.Clock,
+		StartPaused:           c.StartPaused,
+	}
+}
+
+func (c *StreamingSubscriberSubscriptionConfig) setDefaults() {
+	if c.SubscribersCount <= 0 {
+		c.SubscribersCount = 1
+	}
+	if c.HandlerConcurrency <= 0 {
+		c.HandlerConcurrency = 1
+	}
+	if c.CloseTimeout <= 0 {
+		c.CloseTimeout = time.Second * 30
+	}
+	if c.CloseProgressInterval <= 0 {
+		c.CloseProgressInterval = time.Second * 5
+	}
+	if c.AckWaitTimeout <= 0 {
+		c.AckWaitTimeout = time.Second * 30
+	}
+	if c.FetchBatchSize <= 0 {
+		c.FetchBatchSize = 1
+	}
+	if c.FetchTimeout <= 0 {
+		c.FetchTimeout = time.Second * 5
+	}
+	if c.Clock == nil {
+		c.Clock = RealClock{}
+	}
+	if c.Ordered {
+		// ordered consumer delivers messages to a single subscription
+		c.SubscribersCount = 1
+	}
+}
+
+func (c *StreamingSubscriberSubscriptionConfig) Validate() error {
+	if c.Unmarshaler == nil {
+		return errors.New("StreamingSubscriberConfig.Unmarshaler is missing")
+	}
+
+	if c.ConsumerType != PushConsumer && c.ConsumerType != PullConsumer {
+		return errors.Errorf("unknown StreamingSubscriberConfig.ConsumerType: %d", c.ConsumerType)
+	}
+
+	if err := validateConsumerName("DurableName", c.DurableName); err != nil {
+		return err
+	}
+	// QueueGroup is the durable name of the consumer when DurableName is empty
+	if err := validateConsumerName("QueueGroup", c.QueueGroup); err != nil {
+		return err
+	}
+
+	if c.OutputChannelBuffer < 0 {
+		return errors.New("StreamingSubscriberConfig.OutputChannelBuffer cannot be negative")
+	}
+	if c.AckMode != AckAsync && c.AckMode != AckSync {
+		return errors.Errorf("unknown StreamingSubscriberConfig.AckMode: %d", c.AckMode)
+	}
+	if err := c.validateAckPolicy(); err != nil {
+		return err
+	}
+
+	if err := c.validateDeliverPolicy(); err != nil {
+		return err
+	}
+
+	if c.FilterSubject != "" && len(c.FilterSubjects) > 0 {
+		return errors.New("StreamingSubscriberConfig.FilterSubject and StreamingSubscriberConfig.FilterSubjects cannot be used together")
+	}
+
+	if c.MaxDeliver < 0 {
+		return errors.New("StreamingSubscriberConfig.MaxDeliver cannot be negative")
+	}
+
+	if c.MaxRedeliverThenAck < 0 {
+		return errors.New("StreamingSubscriberConfig.MaxRedeliverThenAck cannot be negative")
+	}
+	if c.MaxRedeliverThenAck > 0 && c.MaxDeliver > 0 && c.MaxRedeliverThenAck >= c.MaxDeliver-1 {
+		return errors.Errorf(
+			"StreamingSubscriberConfig.MaxRedeliverThenAck (%d) must be lower than StreamingSubscriberConfig.MaxDeliver - 1 (%d), "+
+				"messages are not delivered to be acked otherwise",
+			c.MaxRedeliverThenAck,
+			c.MaxDeliver-1,
+		)
+	}
+
+	if c.MaxDeliver > 0 && len(c.BackOff) > c.MaxDeliver {
+		return errors.Errorf(
+			"StreamingSubscriberConfig.BackOff has %d entries, it cannot exceed StreamingSubscriberConfig.MaxDeliver (%d)",
+			len(c.BackOff),
+			c.MaxDeliver,
+		)
+	}
+
+	for _, d := range c.BackOff {
+		if d <= 0 {
+			return errors.New("StreamingSubscriberConfig.BackOff entries must be positive")
+		}
+	}
+
+	if c.MaxAckPending < 0 {
+		return errors.New("StreamingSubscriberConfig.MaxAckPending cannot be negative")
+	}
+
+	if c.IdleHeartbeat < 0 {
+		return errors.New("StreamingSubscriberConfig.IdleHeartbeat cannot be negative")
+	}
+	if c.FlowControl && c.IdleHeartbeat == 0 {
+		return errors.New("StreamingSubscriberConfig.FlowControl requires StreamingSubscriberConfig.IdleHeartbeat")
+	}
+	if (c.FlowControl || c.IdleHeartbeat > 0) && c.QueueGroup != "" {
+		return errors.New(
+			"StreamingSubscriberConfig.FlowControl and StreamingSubscriberConfig.IdleHeartbeat " +
+				"are not supported by JetStream for QueueGroup subscriptions",
+		)
+	}
+
+	if c.InactiveThreshold < 0 {
+		return errors.New("StreamingSubscriberConfig.InactiveThreshold cannot be negative")
+	}
+	if c.InactiveThreshold > 0 && c.durableName() != "" {
+		return errors.Errorf(
+			"StreamingSubscriberConfig.InactiveThreshold can be used only with ephemeral consumers, "+
+				"but the consumer is durable with name %q (set by DurableName or QueueGroup)",
+			c.durableName(),
+		)
+	}
+
+	if c.ConsumerReplicas < 0 {
+		return errors.New("StreamingSubscriberConfig.ConsumerReplicas cannot be negative")
+	}
+	for key, value := range c.ConsumerMetadata {
+		if key == "" || value == "" {
+			return errors.Errorf("StreamingSubscriberConfig.ConsumerMetadata cannot have empty key or value (%q: %q)", key, value)
+		}
+	}
+	if c.SampleFrequency != "" && !isPercentage(c.SampleFrequency) {
+		return errors.Errorf(
+			"StreamingSubscriberConfig.SampleFrequency should be a percentage from 0 to 100, for example \"100%%\", got %q",
+			c.SampleFrequency,
+		)
+	}
+
+	if c.NakDelay < 0 {
+		return errors.New("StreamingSubscriberConfig.NakDelay cannot be negative")
+	}
+
+	if c.AckWaitJitter < 0 {
+		return errors.New("StreamingSubscriberConfig.AckWaitJitter cannot be negative")
+	}
+	if c.AckWaitJitter > 0 && len(c.BackOff) > 0 {
+		return errors.New("StreamingSubscriberConfig.AckWaitJitter cannot be used with StreamingSubscriberConfig.BackOff")
+	}
+	if c.AckWaitJitter > 0 && c.AckProgressInterval > 0 {
+		return errors.New("StreamingSubscriberConfig.AckWaitJitter cannot be used with StreamingSubscriberConfig.AckProgressInterval")
+	}
+
+	if c.AckProgressInterval < 0 {
+		return errors.New("StreamingSubscriberConfig.AckProgressInterval cannot be negative")
+	}
+	if c.AckProgressInterval > 0 && c.AckProgressInterval >= c.AckWaitTimeout {
+		return errors.New("StreamingSubscriberConfig.AckProgressInterval must be shorter than StreamingSubscriberConfig.AckWaitTimeout")
+	}
+
+	if c.OnUnmarshalError < UnmarshalErrorIgnore || c.OnUnmarshalError > UnmarshalErrorDeadLetter {
+		return errors.Errorf("unknown StreamingSubscriberConfig.OnUnmarshalError: %d", c.OnUnmarshalError)
+	}
+	if c.OnUnmarshalError == UnmarshalErrorDeadLetter && c.DeadLetterTopic == "" {
+		return errors.New("StreamingSubscriberConfig.OnUnmarshalError UnmarshalErrorDeadLetter requires StreamingSubscriberConfig.DeadLetterTopic")
+	}
+
+	if c.DeadLetterTopic != "" {
+		if c.MaxDeliver == 0 && c.OnUnmarshalError != UnmarshalErrorDeadLetter {
+			return errors.New(
+				"StreamingSubscriberConfig.DeadLetterTopic requires StreamingSubscriberConfig.MaxDeliver " +
+					"or StreamingSubscriberConfig.OnUnmarshalError UnmarshalErrorDeadLetter",
+			)
+		}
+		if _, ok := c.Unmarshaler.(Marshaler); !ok {
+			return errors.New(
+				"StreamingSubscriberConfig.DeadLetterTopic requires StreamingSubscriberConfig.Unmarshaler " +
+					"to implement Marshaler, it is used to publish dead lettered messages",
+			)
+		}
+	}
+
+	if c.Ordered {
+		if err := c.validateOrdered(); err != nil {
+			return err
+		}
+	}
+
+	if c.ConsumerType == PullConsumer {
+		// subscribers are sharing the durable pull consumer, so QueueGroup is not needed
+		if c.DurableName == "" {
+			return errors.New("StreamingSubscriberConfig.DurableName is required for PullConsumer")
+		}
+		if c.FlowControl || c.IdleHeartbeat > 0 {
+			return errors.New(
+				"StreamingSubscriberConfig.FlowControl and StreamingSubscriberConfig.IdleHeartbeat " +
+					"can be used only with PushConsumer",
+			)
+		}
+		if c.RateLimitBitsPerSec > 0 {
+			return errors.New("StreamingSubscriberConfig.RateLimitBitsPerSec can be used only with PushConsumer")
+		}
+		if c.GapDetection && c.SubscribersCount > 1 {
+			return errors.New(
+				"StreamingSubscriberConfig.GapDetection cannot be used with PullConsumer and SubscribersCount " +
+					"greater than 1, messages of the consumer are fetched by multiple subscriptions",
+			)
+		}
+
+		return c.validateBatchAck()
+	}
+
+	if c.BatchAck {
+		return errors.New("StreamingSubscriberConfig.BatchAck can be used only with PullConsumer")
+	}
+
+	if c.GapDetection && c.QueueGroup != "" {
+		return errors.New(
+			"StreamingSubscriberConfig.GapDetection cannot be used with StreamingSubscriberConfig.QueueGroup, " +
+				"messages of the consumer are delivered to multiple subscriptions",
+		)
+	}
+
+	if c.QueueGroup == "" && c.SubscribersCount > 1 {
+		return errors.Errorf(
+			"StreamingSubscriberConfig.SubscribersCount is %d, but StreamingSubscriberConfig.QueueGroup is not set: "+
+				"push subscriptions without a queue group receive duplicated messages and can't share a durable consumer, "+
+				"set QueueGroup or use PullConsumer",
+			c.SubscribersCount,
+		)
+	}
+
+	return nil
+}
+
+// validateConsumerName returns an error when name of option cannot be used as a JetStream consumer name.
+func validateConsumerName(option string, name string) error {
+	for _, r := range name {
+		if unicode.IsSpace(r) || !unicode.IsPrint(r) || strings.ContainsRune(".*>/\\", r) {
+			return errors.Errorf(
+				"StreamingSubscriberConfig.%s %q contains invalid character %q, "+
+					"consumer names cannot contain whitespace, non-printable characters, '.', '*', '>', '/' or '\\'",
+				option,
+				name,
+				r,
+			)
+		}
+	}
+
+	return nil
+}
+
+func (c *StreamingSubscriberSubscriptionConfig) validateAckPolicy() error {
+	switch c.AckPolicy {
+	case AckExplicit, AckAll:
+		return nil
+	case AckNone:
+	default:
+		return errors.Errorf("unknown StreamingSubscriberConfig.AckPolicy: %d", c.AckPolicy)
+	}
+
+	unsupported := []struct {
+		option string
+		isSet  bool
+	}{
+		{"MaxDeliver", c.MaxDeliver > 0},
+		{"MaxRedeliverThenAck", c.MaxRedeliverThenAck > 0},
+		{"NakDelay", c.NakDelay > 0},
+		{"AckProgressInterval", c.AckProgressInterval > 0},
+		{"AckWaitJitter", c.AckWaitJitter > 0},
+		{"AckMode", c.AckMode != AckAsync},
+		{"DeadLetterTopic", c.DeadLetterTopic != ""},
+		{"OnUnmarshalError", c.OnUnmarshalError != UnmarshalErrorIgnore},
+		{"SampleFrequency", c.SampleFrequency != ""},
+		{"DeduplicationStore", c.DeduplicationStore != nil},
+	}
+
+	for _, u := range unsupported {
+		if u.isSet {
+			return errors.Errorf("StreamingSubscriberConfig.%s cannot be used with AckNone", u.option)
+		}
+	}
+
+	return nil
+}
+
+func (c *StreamingSubscriberSubscriptionConfig) validateBatchAck() error {
+	if !c.BatchAck {
+		return nil
+	}
+
+	if c.AckPolicy != AckAll {
+		return errors.New("StreamingSubscriberConfig.BatchAck requires StreamingSubscriberConfig.AckPolicy AckAll")
+	}
+	if c.SubscribersCount > 1 {
+		return errors.New(
+			"StreamingSubscriberConfig.BatchAck cannot be used with SubscribersCount greater than 1, " +
+				"the batch ack would acknowledge messages fetched by other subscriptions",
+		)
+	}
+	if c.MaxRedeliverThenAck > 0 {
+		return errors.New("StreamingSubscriberConfig.BatchAck cannot be used with StreamingSubscriberConfig.MaxRedeliverThenAck")
+	}
+	if c.OnUnmarshalError == UnmarshalErrorAck {
+		return errors.New("StreamingSubscriberConfig.BatchAck cannot be used with UnmarshalErrorAck")
+	}
+	if c.DeduplicationStore != nil {
+		return errors.New("StreamingSubscriberConfig.BatchAck cannot be used with StreamingSubscriberConfig.DeduplicationStore")
+	}
+	if c.NakDelay > 0 || len(c.BackOff) > 0 {
+		return errors.New(
+			"StreamingSubscriberConfig.BatchAck cannot be used with StreamingSubscriberConfig.NakDelay " +
+				"and StreamingSubscriberConfig.BackOff, acks of later messages would acknowledge delayed messages",
+		)
+	}
+
+	return nil
+}
+
+func (c *StreamingSubscriberSubscriptionConfig) validateDeliverPolicy() error {
+	switch c.DeliverPolicy {
+	case DeliverAll, DeliverLast, DeliverNew:
+		if c.OptStartSeq != 0 || !c.OptStartTime.IsZero() {
+			return errors.New(
+				"StreamingSubscriberConfig.OptStartSeq and StreamingSubscriberConfig.OptStartTime " +
+					"can be used only with DeliverByStartSequence and DeliverByStartTime",
+			)
+		}
+	case DeliverByStartSequence:
+		if c.OptStartSeq == 0 {
+			return errors.New("StreamingSubscriberConfig.OptStartSeq is required for DeliverByStartSequence")
+		}
+		if !c.OptStartTime.IsZero() {
+			return errors.New("StreamingSubscriberConfig.OptStartTime cannot be used with DeliverByStartSequence")
+		}
+	case DeliverByStartTime:
+		if c.OptStartTime.IsZero() {
+			return errors.New("StreamingSubscriberConfig.OptStartTime is required for DeliverByStartTime")
+		}
+		if c.OptStartSeq != 0 {
+			return errors.New("StreamingSubscriberConfig.OptStartSeq cannot be used with DeliverByStartTime")
+		}
+	default:
+		return errors.Errorf("unknown StreamingSubscriberConfig.DeliverPolicy: %d", c.DeliverPolicy)
+	}
+
+	return nil
+}
+
+// validateOrdered checks that options not supported by ordered consumers are not set.
+func (c *StreamingSubscriberSubscriptionConfig) validateOrdered() error {
+	unsupported := []struct {
+		option string
+		isSet  bool
+	}{
+		{"QueueGroup", c.QueueGroup != ""},
+		{"DurableName", c.DurableName != ""},
+		{"ConsumerType", c.ConsumerType == PullConsumer},
+		{"MaxDeliver", c.MaxDeliver > 0},
+		{"MaxRedeliverThenAck", c.MaxRedeliverThenAck > 0},
+		{"BackOff", len(c.BackOff) > 0},
+		{"MaxAckPending", c.MaxAckPending > 0},
+		{"FilterSubjects", len(c.FilterSubjects) > 0},
+		{"AckPolicy", c.AckPolicy != AckExplicit},
+		{"AckMode", c.AckMode != AckAsync},
+		{"NakDelay", c.NakDelay > 0},
+		{"AckProgressInterval", c.AckProgressInterval > 0},
+		{"AckWaitJitter", c.AckWaitJitter > 0},
+		{"HandlerConcurrency", c.HandlerConcurrency > 1},
+		{"GapDetection", c.GapDetection},
+		{"OnUnmarshalError", c.OnUnmarshalError != UnmarshalErrorIgnore},
+		// ordered consumer has flow control and heartbeats enabled by nats.go
+		{"FlowControl", c.FlowControl},
+		{"IdleHeartbeat", c.IdleHeartbeat > 0},
+		{"RateLimitBitsPerSec", c.RateLimitBitsPerSec > 0},
+		// ordered consumer is forced to a single replica in memory by nats.go
+		{"ConsumerReplicas", c.ConsumerReplicas > 0},
+		{"ConsumerMemoryStorage", c.ConsumerMemoryStorage},
+		{"ConsumerDescription", c.ConsumerDescription != ""},
+		{"ConsumerMetadata", len(c.ConsumerMetadata) > 0},
+		{"SampleFrequency", c.SampleFrequency != ""},
+		{"DeduplicationStore", c.DeduplicationStore != nil},
+	}
+
+	for _, u := range unsupported {
+		if u.isSet {
+			return errors.Errorf("StreamingSubscriberConfig.%s cannot be used with StreamingSubscriberConfig.Ordered", u.option)
+		}
+	}
+
+	return nil
+}
+
+// isPercentage returns true when s is an integer percentage from 0 to 100, with an optional % suffix,
+// as accepted by the server for SampleFrequency.
+func isPercentage(s string) bool {
+	percentage, err := strconv.Atoi(strings.TrimSuffix(s, "%"))
+	if err != nil {
+		return false
+	}
+
+	return percentage >= 0 && percentage <= 100
+}
+
+// ackWait returns the ack wait of a message, randomized with AckWaitJitter.
+func (c *StreamingSubscriberSubscriptionConfig) ackWait() time.Duration {
+	if c.AckWaitJitter <= 0 {
+		return c.AckWaitTimeout
+	}
+
+	return c.AckWaitTimeout + time.Duration(rand.Int63n(int64(c.AckWaitJitter)+1))
+}
+
+func (c *StreamingSubscriberSubscriptionConfig) natsAckPolicy() nats.AckPolicy {
+	switch c.AckPolicy {
+	case AckAll:
+		return nats.AckAllPolicy
+	case AckNone:
+		return nats.AckNonePolicy
+	default:
+		return nats.AckExplicitPolicy
+	}
+}
+
+func (c *StreamingSubscriberSubscriptionConfig) natsDeliverPolicy() nats.DeliverPolicy {
+	switch c.DeliverPolicy {
+	case DeliverLast:
+		return nats.DeliverLastPolicy
+	case DeliverNew:
+		return nats.DeliverNewPolicy
+	case DeliverByStartSequence:
+		return nats.DeliverByStartSequencePolicy
+	case DeliverByStartTime:
+		return nats.DeliverByStartTimePolicy
+	default:
+		return nats.DeliverAllPolicy
+	}
+}
+
+// deliverPolicyOption returns the option setting DeliverPolicy of consumers created by nats.go (see Ordered).
+func (c *StreamingSubscriberSubscriptionConfig) deliverPolicyOption() nats.SubOpt {
+	switch c.DeliverPolicy {
+	case DeliverLast:
+		return nats.DeliverLast()
+	case DeliverNew:
+		return nats.DeliverNew()
+	case DeliverByStartSequence:
+		return nats.StartSequence(c.OptStartSeq)
+	case DeliverByStartTime:
+		return nats.StartTime(c.OptStartTime)
+	default:
+		return nats.DeliverAll()
+	}
+}
+
+// durableName returns name of the durable consumer, or empty string when the consumer is ephemeral.
+func (c *StreamingSubscriberSubscriptionConfig) durableName() string {
+	if c.DurableName == "" && c.ConsumerType == PushConsumer {
+		// the same as nats.go does for queue subscriptions
+		return c.QueueGroup
+	}
+
+	return c.DurableName
+}
+
+// consumerConfig returns configuration of the JetStream consumer created for the subscription.
+func (c *StreamingSubscriberSubscriptionConfig) consumerConfig(topic string) *nats.ConsumerConfig {
+	config := &nats.ConsumerConfig{
+		Durable:           c.durableName(),
+		FilterSubject:     topic,
+		AckPolicy:         c.natsAckPolicy(),
+		AckWait:           c.AckWaitTimeout + c.AckWaitJitter,
+		InactiveThreshold: c.InactiveThreshold,
+		Replicas:          c.ConsumerReplicas,
+		MemoryStorage:     c.ConsumerMemoryStorage,
+		Description:       c.ConsumerDescription,
+		Metadata:          c.ConsumerMetadata,
+		SampleFrequency:   c.SampleFrequency,
+		MaxDeliver:        c.MaxDeliver,
+		BackOff:           c.BackOff,
+		MaxAckPending:     c.MaxAckPending,
+		DeliverPolicy:     c.natsDeliverPolicy(),
+		OptStartSeq:       c.OptStartSeq,
+	}
+
+	if !c.OptStartTime.IsZero() {
+		startTime := c.OptStartTime
+		config.OptStartTime = &startTime
+	}
+
+	if c.FilterSubject != "" {
+		config.FilterSubject = c.FilterSubject
+	}
+	if len(c.FilterSubjects) > 0 {
+		config.FilterSubject = ""
+		config.FilterSubjects = c.FilterSubjects
+	}
+
+	if c.ConsumerType == PushConsumer {
+		config.DeliverSubject = nats.NewInbox()
+		config.DeliverGroup = c.QueueGroup
+		config.FlowControl = c.FlowControl
+		config.Heartbeat = c.IdleHeartbeat
+		config.RateLimit = c.RateLimitBitsPerSec
+	}
+
+	return config
+}
+
+type StreamingSubscriber struct {
+	conn *nats.Conn
+	// ownsConn is true when conn was created by the subscriber, so it's closed on Close.
+	ownsConn bool
+	// lazyConnect creates conn on the first use with LazyConnect, conn is nil until then.
+	lazyConnect func() (*nats.Conn, error)
+	connLock    sync.Mutex
+
+	js     nats.JetStreamContext
+	logger watermill.LoggerAdapter
+
+	deadLetterPublisher *StreamingPublisher
+	objectStores        *objectStores
+	topicUnmarshalers   *topicUnmarshalers
+
+	config StreamingSubscriberSubscriptionConfig
+
+	// subs are subscriptions made by Subscribe, by topic
+	subs     map[string][]*subscription
+	subsLock sync.RWMutex
+
+	// lastErrors are asynchronous errors of subscriptions, by topic
+	lastErrors     map[string]error
+	lastErrorsLock sync.Mutex
+
+	closed  bool
+	closing chan struct{}
+
+	// resumed is closed by Resume, it's created closed without StartPaused
+	resumed    chan struct{}
+	resumeOnce sync.Once
+
+	ackErrors    chan AckError
+	sequenceGaps chan SequenceGap
+
+	outputsWg sync.WaitGroup
+	// processingMessages is the number of messages being processed, reported by Close
+	processingMessages atomic.Int64
+
+	discarded atomic.Uint64
+	counters  subscriberCounters
+}
+
+// NewStreamingSubscriber creates a new StreamingSubscriber.
+//
+// When using custom NATS hostname, you should pass it by options StreamingSubscriberConfig.NatsOptions:
+//		// ...
+//		NatsOptions: []nats.Option{
+//			nats.NatsURL("nats://your-nats-hostname:4222"),
+//		}
+//		// ...
+func NewStreamingSubscriber(config StreamingSubscriberConfig, logger watermill.LoggerAdapter) (*StreamingSubscriber, error) {
+	if config.LazyConnect {
+		if err := config.validateConnection(); err != nil {
+			return nil, withKind(ErrInvalidConfig, err)
+		}
+
+		sub, err := newStreamingSubscriber(config.GetStreamingSubscriberSubscriptionConfig(), logger)
+		if err != nil {
+			return nil, err
+		}
+		sub.lazyConnect = config.connect
+
+		return sub, nil
+	}
+
+	conn, err := config.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := NewStreamingSubscriberWithNatsConn(conn, config.GetStreamingSubscriberSubscriptionConfig(), logger)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	sub.ownsConn = true
+
+	return sub, nil
+}
+
+// NewStreamingSubscriberWithNatsConn creates a new StreamingSubscriber using an existing NATS connection,
+// so it can be shared with other publishers and subscribers.
+//
+// The connection is owned by the caller, Close closes subscriptions of the subscriber but not the connection.
+func NewStreamingSubscriberWithNatsConn(conn *nats.Conn, config StreamingSubscriberSubscriptionConfig, logger watermill.LoggerAdapter) (*StreamingSubscriber, error) {
+	s, err := newStreamingSubscriber(config, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.setConn(conn); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// newStreamingSubscriber creates the subscriber without a connection, which is set with setConn.
+func newStreamingSubscriber(config StreamingSubscriberSubscriptionConfig, logger watermill.LoggerAdapter) (*StreamingSubscriber, error) {
+	config.setDefaults()
+
+	if err := config.Validate(); err != nil {
+		return nil, withKind(ErrInvalidConfig, err)
+	}
+
+	if logger == nil {
+		logger = watermill.NopLogger{}
+	}
+
+	resumed := make(chan struct{})
+	if !config.StartPaused {
+		close(resumed)
+	}
+
+	return &StreamingSubscriber{
+		logger:            logger,
+		config:            config,
+		topicUnmarshalers: newTopicUnmarshalers(),
+		subs:              map[string][]*subscription{},
+		lastErrors:        map[string]error{},
+		closing:           make(chan struct{}),
+		resumed:           resumed,
+		ackErrors:         make(chan AckError, AckErrorsBufferSize),
+		sequenceGaps:      make(chan SequenceGap, SequenceGapsBufferSize),
+	}, nil
+}
+
+// setConn sets up the subscriber to use conn.
+func (s *StreamingSubscriber) setConn(conn *nats.Conn) error {
+	js, err := conn.JetStream()
+	if err != nil {
+		return errors.Wrap(err, "cannot get JetStream context")
+	}
+
+	var deadLetterPublisher *StreamingPublisher
+	if s.config.DeadLetterTopic != "" {
+		deadLetterPublisher, err = NewStreamingPublisherWithNatsConn(
+			conn,
+			StreamingPublisherPublishConfig{Marshaler: s.config.Unmarshaler.(Marshaler), Tracer: s.config.Tracer},
+			s.logger,
+		)
+		if err != nil {
+			return errors.Wrap(err, "cannot create dead letter publisher")
+		}
+	}
+
+	s.conn = conn
+	s.js = js
+	s.deadLetterPublisher = deadLetterPublisher
+	s.objectStores = newObjectStores(js)
+
+	addConnSubscriber(conn, s)
+
+	return nil
+}
+
+// connect creates the connection on the first use with LazyConnect, concurrent first uses share
+// a single connection. It does nothing when the subscriber is already connected.
+func (s *StreamingSubscriber) connect() error {
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+
+	if s.conn != nil {
+		return nil
+	}
+	if s.isClosed() {
+		return errLazyClosed
+	}
+
+	conn, err := s.lazyConnect()
+	if err != nil {
+		return errors.Wrap(err, "cannot connect to NATS on first use with LazyConnect")
+	}
+
+	if err := s.setConn(conn); err != nil {
+		conn.Close()
+		return errors.Wrap(err, "cannot create subscriber on first use with LazyConnect")
+	}
+	s.ownsConn = true
+
+	return nil
+}
+
+// connection returns the connection of the subscriber, it's nil until the first use with LazyConnect.
+func (s *StreamingSubscriber) connection() *nats.Conn {
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+
+	return s.conn
+}
+
+// subscription is a single subscription made by Subscribe.
+// The underlying NATS subscription is replaced when it's re-established after reconnect.
+type subscription struct {
+	ctx        context.Context
+	subscribe  func() (*nats.Subscription, error)
+	processing *processingGroup
+	logFields  watermill.LogFields
+
+	// cancel cancels ctx of all subscriptions made by the Subscribe call, which closes its output channel
+	cancel context.CancelFunc
+	// outputClosed is closed after the output channel of the Subscribe call is closed
+	outputClosed <-chan struct{}
+
+	lock sync.RWMutex
+	sub  *nats.Subscription
+}
+
+func (s *subscription) current() *nats.Subscription {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.sub
+}
+
+// isLost returns true when the subscription or its consumer no longer exists,
+// for example when an ephemeral consumer was removed during the NATS server restart.
+func (s *subscription) isLost() (bool, error) {
+	sub := s.current()
+	if !sub.IsValid() {
+		return true, nil
+	}
+
+	_, err := sub.ConsumerInfo()
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "cannot get consumer info")
+	}
+
+	return false, nil
+}
+
+// reestablish replaces the subscription with a new one.
+// The lock is held meanwhile, so fetchMessages waits for the new subscription.
+func (s *subscription) reestablish() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// the subscription is lost, so the error is expected
+	_ = s.sub.Unsubscribe()
+
+	sub, err := s.subscribe()
+	if err != nil {
+		return err
+	}
+	s.sub = sub
+
+	return nil
+}
+
+// processingGroup waits for messages processed by a subscription.
+//
+// Unlike sync.WaitGroup, it can be closed, so message callbacks called while the subscription
+// is being closed don't call Add concurrently with Wait.
+type processingGroup struct {
+	lock   sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+
+	// workers limits messages processed concurrently with HandlerConcurrency,
+	// it's nil when messages are processed by the caller of run.
+	workers chan struct{}
+}
+
+func newProcessingGroup(concurrency int) *processingGroup {
+	g := &processingGroup{}
+	if concurrency > 1 {
+		g.workers = make(chan struct{}, concurrency)
+	}
+
+	return g
+}
+
+// run calls process, or dispatches it to a worker when the group has workers. It blocks until a worker
+// is free, so messages are not delivered faster than they are processed.
+//
+// process is not called when the group is closed, or stop or ctx is done while waiting for a worker.
+func (g *processingGroup) run(ctx context.Context, stop <-chan struct{}, process func()) {
+	if g.workers == nil {
+		if !g.add() {
+			return
+		}
+		defer g.done()
+
+		process()
+		return
+	}
+
+	select {
+	case g.workers <- struct{}{}:
+	case <-stop:
+		return
+	case <-ctx.Done():
+		return
+	}
+
+	if !g.add() {
+		<-g.workers
+		return
+	}
+
+	go func() {
+		defer func() {
+			<-g.workers
+			g.done()
+		}()
+
+		process()
+	}()
+}
+
+// add returns false when the group is closed, the message should not be processed then.
+func (g *processingGroup) add() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.closed {
+		return false
+	}
+	g.wg.Add(1)
+
+	return true
+}
+
+func (g *processingGroup) done() {
+	g.wg.Done()
+}
+
+// wait waits until processing of added messages is done, without closing the group.
+// Messages must not be added when nothing is processed anymore, as it happens after the subscription is drained.
+func (g *processingGroup) wait() {
+	g.wg.Wait()
+}
+
+// closeAndWait closes the group and waits until processing of added messages is done.
+func (g *processingGroup) closeAndWait() {
+	g.lock.Lock()
+	g.closed = true
+	g.lock.Unlock()
+
+	g.wg.Wait()
+}
+
+// Subscribe subscribes messages from JetStream.
+//
+// The stream containing topic must exist before subscribing, it can be created with EnsureStream.
+//
+// Subscribe will spawn SubscribersCount goroutines making subscribe.
+//
+// When ctx is done, subscriptions are closed (ephemeral consumers are deleted) and the output channel
+// is closed after messages being processed are done, independently of Close.
+// Otherwise it's closed by Close before it returns, unless CloseTimeout passes first.
+// Subscriptions of a single topic can be drained and closed with Unsubscribe as well.
+//
+// Returned errors wrap ErrConnect, ErrCreateConsumer or ErrInvalidConfig, so retryable errors can be told apart.
+func (s *StreamingSubscriber) Subscribe(ctx context.Context, topic string) (<-chan *message.Message, error) {
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+
+	output, _, err := s.subscribeTopic(ctx, topic)
+	return output, err
+}
+
+// SubscribeMulti subscribes messages of each of topics, the same as Subscribe does, and returns
+// the output channel of each topic. Subscriptions share the connection of the subscriber.
+//
+// When subscribing to any of topics fails, subscriptions made before are closed and their output channels
+// are closed before it returns.
+func (s *StreamingSubscriber) SubscribeMulti(ctx context.Context, topics []string) (map[string]<-chan *message.Message, error) {
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+
+	for i, topic := range topics {
+		if slices.Contains(topics[:i], topic) {
+			return nil, errors.Errorf("topic %s is duplicated", topic)
+		}
+	}
+
+	outputs := make(map[string]<-chan *message.Message, len(topics))
+	subs := make(map[string][]*subscription, len(topics))
+
+	for _, topic := range topics {
+		output, topicSubs, err := s.subscribeTopic(ctx, topic)
+		if err != nil {
+			for subscribedTopic, subscribed := range subs {
+				s.closeSubscribed(subscribedTopic, subscribed)
+			}
+			return nil, errors.Wrapf(err, "cannot subscribe to topic %s", topic)
+		}
+
+		outputs[topic] = output
+		subs[topic] = topicSubs
+	}
+
+	return outputs, nil
+}
+
+// closeSubscribed closes subs of topic, cancelling the context of their subscribeTopic calls,
+// and waits until their output channels are closed.
+func (s *StreamingSubscriber) closeSubscribed(topic string, subs []*subscription) {
+	for _, sub := range subs {
+		sub.cancel()
+	}
+	for _, sub := range subs {
+		<-sub.outputClosed
+	}
+
+	s.removeSubscriptions(topic, subs)
+}
+
+// subscribeTopic makes subscriptions of topic, it returns them with their output channel.
+func (s *StreamingSubscriber) subscribeTopic(ctx context.Context, topic string) (chan *message.Message, []*subscription, error) {
+	output := make(chan *message.Message, s.config.OutputChannelBuffer)
+	outputClosed := make(chan struct{})
+	subscribersWg := &sync.WaitGroup{}
+
+	// cancelled when a subscription fails, so subscriptions made before are closed
+	ctx, cancel := context.WithCancel(ctx)
+	var subs []*subscription
+
+	for i := 0; i < s.config.SubscribersCount; i++ {
+		subscriberLogFields := watermill.LogFields{
+			"subscriber_num": i,
+			"topic":          topic,
+		}
+
+		s.logger.Debug("Starting subscriber", subscriberLogFields)
+
+		processing := newProcessingGroup(s.config.HandlerConcurrency)
+
+		var gaps *gapDetector
+		if s.config.GapDetection {
+			gaps = &gapDetector{}
+		}
+
+		sub := &subscription{
+			ctx: ctx,
+			subscribe: func() (*nats.Subscription, error) {
+				return s.subscribe(ctx, output, topic, subscriberLogFields, processing, gaps)
+			},
+			processing:   processing,
+			logFields:    subscriberLogFields,
+			cancel:       cancel,
+			outputClosed: outputClosed,
+		}
+
+		natsSub, err := sub.subscribe()
+		if err != nil {
+			s.rollbackSubscribe(cancel, topic, subs, subscribersWg)
+			return nil, nil, errors.Wrap(consumerError(err), "cannot subscribe")
+		}
+		sub.sub = natsSub
+
+		subscribersWg.Add(1)
+
+		if s.config.ConsumerType == PullConsumer {
+			processing.add()
+			go func() {
+				defer processing.done()
+				s.fetchMessages(ctx, topic, sub, processing, gaps, output, subscriberLogFields)
+			}()
+		}
+
+		go func() {
+			select {
+			case <-s.closing:
+				// unblock, subscription is closed by Close
+			case <-ctx.Done():
+				// subscriptions drained by Unsubscribe are already closed
+				if natsSub := sub.current(); natsSub.IsValid() {
+					if err := s.closeSubscription(natsSub); err != nil {
+						s.logger.Error("Cannot close subscription", err, subscriberLogFields)
+					}
+				}
+			}
+			processing.closeAndWait()
+			subscribersWg.Done()
+		}()
+
+		s.subsLock.Lock()
+		s.subs[topic] = append(s.subs[topic], sub)
+		s.subsLock.Unlock()
+		subs = append(subs, sub)
+	}
+
+	s.outputsWg.Add(1)
+	go func() {
+		subscribersWg.Wait()
+		cancel()
+		close(output)
+		close(outputClosed)
+		s.outputsWg.Done()
+	}()
+
+	return output, subs, nil
+}
+
+// rollbackSubscribe closes subs made by Subscribe before one of its subscriptions failed,
+// so no subscription is left when Subscribe returns an error.
+func (s *StreamingSubscriber) rollbackSubscribe(
+	cancel context.CancelFunc,
+	topic string,
+	subs []*subscription,
+	subscribersWg *sync.WaitGroup,
+) {
+	// subscriptions are closed the same as when ctx passed to Subscribe is done
+	cancel()
+	subscribersWg.Wait()
+
+	s.removeSubscriptions(topic, subs)
+}
+
+// removeSubscriptions removes subs from subscriptions of topic.
+func (s *StreamingSubscriber) removeSubscriptions(topic string, subs []*subscription) {
+	s.subsLock.Lock()
+	defer s.subsLock.Unlock()
+
+	remaining := slices.DeleteFunc(s.subs[topic], func(sub *subscription) bool {
+		return slices.Contains(subs, sub)
+	})
+	if len(remaining) == 0 {
+		delete(s.subs, topic)
+		return
+	}
+	s.subs[topic] = remaining
+}
+
+// allSubscriptions returns subscriptions of all topics, subsLock must be held by the caller.
+func (s *StreamingSubscriber) allSubscriptions() []*subscription {
+	var all []*subscription
+	for _, subs := range s.subs {
+		all = append(all, subs...)
+	}
+
+	return all
+}
+
+// Resume starts processing messages of a subscriber created with StartPaused.
+// It does nothing when the subscriber is not paused.
+func (s *StreamingSubscriber) Resume() {
+	s.resumeOnce.Do(func() {
+		if s.config.StartPaused {
+			close(s.resumed)
+			s.logger.Info("Subscriber resumed", nil)
+		}
+	})
+}
+
+// waitResumed blocks until the subscriber is resumed, it returns false when it's closed or ctx is done before.
+func (s *StreamingSubscriber) waitResumed(ctx context.Context) bool {
+	select {
+	case <-s.resumed:
+		return true
+	default:
+	}
+
+	select {
+	case <-s.resumed:
+		return true
+	case <-s.closing:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Unsubscribe drains and closes subscriptions of topic made by Subscribe, subscriptions of other topics
+// are not affected.
+//
+// Messages which were already delivered are still processed and can be acked, the wait is bounded
+// by CloseTimeout. Ephemeral consumers are deleted, output channels returned by Subscribe for topic
+// are closed when Unsubscribe returns.
+//
+// ErrNotSubscribed is returned when there are no subscriptions of topic, or the subscriber is closed.
+func (s *StreamingSubscriber) Unsubscribe(topic string) error {
+	s.subsLock.Lock()
+	subs, ok := s.subs[topic]
+	if s.closed || !ok {
+		s.subsLock.Unlock()
+		return errors.Wrapf(ErrNotSubscribed, "cannot unsubscribe from topic %s", topic)
+	}
+	// removed before draining, so the subscriptions are not re-established after reconnect
+	delete(s.subs, topic)
+	s.subsLock.Unlock()
+
+	deadline, stopDeadline := newDeadline(s.config.Clock, s.config.CloseTimeout)
+	defer stopDeadline()
+
+	s.drain(subs, deadline)
+
+	s.closeSubscribed(topic, subs)
+	s.forgetLastError(topic)
+
+	s.logger.Debug("Unsubscribed", watermill.LogFields{"topic": topic})
+
+	return nil
+}
+
+// SubscribeInitialize creates the durable consumer of topic without consuming messages,
+// so consumers can be provisioned before subscribers are started.
+//
+// Ephemeral and ordered consumers are created on Subscribe and deleted when unsubscribed,
+// so only the stream of topic is checked for them.
+func (s *StreamingSubscriber) SubscribeInitialize(topic string) error {
+	if err := s.connect(); err != nil {
+		return err
+	}
+
+	if s.config.Ordered || s.config.durableName() == "" {
+		if _, err := s.js.StreamNameBySubject(topic); err != nil {
+			return errors.Wrapf(consumerError(err), "cannot initialize subscribe, cannot find stream of topic %s", topic)
+		}
+
+		return nil
+	}
+
+	if _, err := s.ensureConsumer(topic); err != nil {
+		return errors.Wrap(consumerError(err), "cannot initialize subscribe")
+	}
+
+	return nil
+}
+
+// ensureConsumer creates the consumer of the subscription, or returns the existing durable consumer.
+//
+// Subscriptions are bound to consumers created here, so unsubscribing doesn't delete durable consumers
+// as it happens with consumers created by nats.go (see nats.Subscription.Unsubscribe).
+func (s *StreamingSubscriber) ensureConsumer(topic string) (*nats.ConsumerInfo, error) {
+	stream, err := s.js.StreamNameBySubject(topic)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot find stream of topic %s", topic)
+	}
+
+	config := s.config.consumerConfig(topic)
+
+	if s.config.FilterSubject != "" || len(s.config.FilterSubjects) > 0 {
+		if err := s.checkFilterSubjects(stream, config); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Durable != "" {
+		info, err := s.js.ConsumerInfo(stream, config.Durable)
+		if err == nil {
+			return info, s.checkExistingConsumer(info, config)
+		}
+		if !errors.Is(err, nats.ErrConsumerNotFound) {
+			return nil, errors.Wrapf(err, "cannot get info of consumer %s", config.Durable)
+		}
+	}
+
+	info, err := s.js.AddConsumer(stream, config)
+	if errors.Is(err, nats.ErrConsumerNameAlreadyInUse) {
+		// created concurrently by another subscriber
+		info, err := s.js.ConsumerInfo(stream, config.Durable)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot get info of consumer %s", config.Durable)
+		}
+
+		return info, s.checkExistingConsumer(info, config)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot create consumer of stream %s", stream)
+	}
+
+	return info, nil
+}
+
+// checkExistingConsumer returns an error when the existing durable consumer can't be used by the subscription
+// with config, because it was created for other filter subjects or another queue group.
+func (s *StreamingSubscriber) checkExistingConsumer(info *nats.ConsumerInfo, config *nats.ConsumerConfig) error {
+	if err := checkConsumerFilterSubjects(info, config); err != nil {
+		return err
+	}
+
+	return s.checkDeliverGroup(info)
+}
+
+// checkConsumerFilterSubjects returns an error when the existing durable consumer filters other subjects than config,
+// for example when it was created for another topic of the same stream with the same DurableName.
+func checkConsumerFilterSubjects(info *nats.ConsumerInfo, config *nats.ConsumerConfig) error {
+	existing := consumerFilterSubjects(info.Config)
+	expected := consumerFilterSubjects(*config)
+
+	if slices.Equal(existing, expected) {
+		return nil
+	}
+
+	return withKind(ErrInvalidConfig, errors.Errorf(
+		"durable consumer %s of stream %s was created for subjects %v, but the subscription filters %v: "+
+			"use a different DurableName for each topic of the stream",
+		info.Name,
+		info.Stream,
+		existing,
+		expected,
+	))
+}
+
+// consumerFilterSubjects returns sorted subjects filtered by the consumer with config.
+func consumerFilterSubjects(config nats.ConsumerConfig) []string {
+	subjects := append([]string{}, config.FilterSubjects...)
+	if config.FilterSubject != "" {
+		subjects = append(subjects, config.FilterSubject)
+	}
+	slices.Sort(subjects)
+
+	return subjects
+}
+
+// checkDeliverGroup returns an error when the existing durable push consumer was created for another queue group,
+// it can't be shared by the subscriptions of both groups.
+func (s *StreamingSubscriber) checkDeliverGroup(info *nats.ConsumerInfo) error {
+	if s.config.ConsumerType != PushConsumer || info.Config.DeliverSubject == "" {
+		return nil
+	}
+
+	if info.Config.DeliverGroup != s.config.QueueGroup {
+		return withKind(ErrInvalidConfig, errors.Errorf(
+			"durable consumer %s of stream %s was created for queue group %q, "+
+				"but StreamingSubscriberConfig.QueueGroup is %q: use a different DurableName for each queue group",
+			info.Name,
+			info.Stream,
+			info.Config.DeliverGroup,
+			s.config.QueueGroup,
+		))
+	}
+
+	return nil
+}
+
+// checkFilterSubjects returns an error when filter subjects of config are not a subset of stream subjects.
+func (s *StreamingSubscriber) checkFilterSubjects(stream string, config *nats.ConsumerConfig) error {
+	info, err := s.js.StreamInfo(stream)
+	if err != nil {
+		return errors.Wrapf(err, "cannot get info of stream %s", stream)
+	}
+
+	filterSubjects := config.FilterSubjects
+	if config.FilterSubject != "" {
+		filterSubjects = []string{config.FilterSubject}
+	}
+
+	for _, filterSubject := range filterSubjects {
+		if !matchesAnySubject(filterSubject, info.Config.Subjects) {
+			return withKind(ErrInvalidConfig, errors.Errorf(
+				"filter subject %s is not a subset of subjects of stream %s: %v",
+				filterSubject, stream, info.Config.Subjects,
+			))
+		}
+	}
+
+	return nil
+}
+
+func matchesAnySubject(filterSubject string, subjects []string) bool {
+	for _, subject := range subjects {
+		if subjectIsSubset(filterSubject, subject) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *StreamingSubscriber) subscribe(
+	ctx context.Context,
+	output chan *message.Message,
+	topic string,
+	subscriberLogFields watermill.LogFields,
+	processing *processingGroup,
+	gaps *gapDetector,
+) (*nats.Subscription, error) {
+	if s.config.Ordered {
+		subject := topic
+		if s.config.FilterSubject != "" {
+			subject = s.config.FilterSubject
+		}
+
+		// ordered consumer is created and re-created on gaps by nats.go, so it can't be bound
+		return s.js.Subscribe(
+			subject,
+			func(m *nats.Msg) {
+				processing.run(ctx, s.closing, func() {
+					s.processMessage(ctx, topic, m, output, nil, subscriberLogFields)
+				})
+			},
+			nats.OrderedConsumer(),
+			s.config.deliverPolicyOption(),
+			nats.InactiveThreshold(s.config.InactiveThreshold),
+		)
+	}
+
+	consumer, err := s.ensureConsumer(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	bind := nats.Bind(consumer.Stream, consumer.Name)
+
+	// nats.go requires the subject of bound subscription to match the consumer filter
+	subject := topic
+	if consumer.Config.FilterSubject != "" {
+		subject = consumer.Config.FilterSubject
+	}
+
+	if s.config.ConsumerType == PullConsumer {
+		// messages are fetched by fetchMessages, so creating the subscription doesn't start consuming
+		return s.js.PullSubscribe(subject, consumer.Name, bind)
+	}
+
+	// acks are sent when Watermill message is acked
+	manualAck := nats.ManualAck()
+
+	if s.config.QueueGroup != "" {
+		return s.js.QueueSubscribe(
+			subject,
+			s.config.QueueGroup,
+			func(m *nats.Msg) {
+				if s.isClosed() {
+					return
+				}
+
+				processing.run(ctx, s.closing, func() {
+					s.processMessage(ctx, topic, m, output, nil, subscriberLogFields)
+				})
+			},
+			bind,
+			manualAck,
+		)
+	}
+
+	return s.js.Subscribe(
+		subject,
+		func(m *nats.Msg) {
+			// detected before dispatching, so messages processed concurrently are tracked in the delivery order
+			s.detectGap(gaps, topic, m, subscriberLogFields)
+
+			processing.run(ctx, s.closing, func() {
+				s.processMessage(ctx, topic, m, output, nil, subscriberLogFields)
+			})
 		},
+		bind,
+		manualAck,
 	)
 }
 
+// closeSubscription unsubscribes sub. Ephemeral consumers are deleted, as they are never reused.
+func (s *StreamingSubscriber) closeSubscription(sub *nats.Subscription) error {
+	ephemeral, err := s.ephemeralConsumer(sub)
+	if err != nil {
+		return err
+	}
+
+	if err := sub.Unsubscribe(); err != nil {
+		return errors.Wrap(err, "cannot unsubscribe")
+	}
+
+	return s.deleteEphemeralConsumer(ephemeral)
+}
+
+// ephemeralConsumer returns info of the consumer of sub, or nil when the consumer is durable.
+func (s *StreamingSubscriber) ephemeralConsumer(sub *nats.Subscription) (*nats.ConsumerInfo, error) {
+	if s.config.durableName() != "" {
+		return nil, nil
+	}
+
+	info, err := sub.ConsumerInfo()
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot get consumer info")
+	}
+
+	return info, nil
+}
+
+func (s *StreamingSubscriber) deleteEphemeralConsumer(ephemeral *nats.ConsumerInfo) error {
+	if ephemeral == nil {
+		return nil
+	}
+
+	err := s.js.DeleteConsumer(ephemeral.Stream, ephemeral.Name)
+	if err != nil && !errors.Is(err, nats.ErrConsumerNotFound) {
+		return errors.Wrapf(err, "cannot delete consumer %s", ephemeral.Name)
+	}
+
+	return nil
+}
+
+// drain drains subs and waits until they are closed and their messages are processed, bounded by deadline,
+// so messages which were already delivered are still processed and acked. Ephemeral consumers of subs are deleted then.
+func (s *StreamingSubscriber) drain(subs []*subscription, deadline <-chan struct{}) {
+	var drained []<-chan nats.SubStatus
+	var ephemerals []*nats.ConsumerInfo
+
+	for _, sub := range subs {
+		natsSub := sub.current()
+		if !natsSub.IsValid() {
+			// already closed after ctx was done
+			continue
+		}
+
+		ephemeral, err := s.ephemeralConsumer(natsSub)
+		if err != nil {
+			s.logger.Error("Cannot drain subscription", err, sub.logFields)
+			continue
+		}
+
+		// the channel is closed when the subscription is closed after draining
+		closed := natsSub.StatusChanged(nats.SubscriptionClosed)
+		if err := natsSub.Drain(); err != nil {
+			s.logger.Error("Cannot drain subscription", err, sub.logFields)
+			continue
+		}
+
+		drained = append(drained, closed)
+		ephemerals = append(ephemerals, ephemeral)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for _, closed := range drained {
+			for range closed {
+			}
+		}
+		for _, sub := range subs {
+			sub.processing.wait()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.logger.Debug("Subscriptions drained", nil)
+	case <-deadline:
+		s.logger.Info("Draining subscriptions timed out", watermill.LogFields{"close_timeout": s.config.CloseTimeout})
+	}
+
+	for _, ephemeral := range ephemerals {
+		if err := s.deleteEphemeralConsumer(ephemeral); err != nil {
+			s.logger.Error("Cannot delete ephemeral consumer", err, nil)
+		}
+	}
+}
+
+// resubscribe re-establishes subscriptions which were lost while the connection was down.
+func (s *StreamingSubscriber) resubscribe() {
+	s.subsLock.RLock()
+	defer s.subsLock.RUnlock()
+
+	if s.closed {
+		return
+	}
+
+	for _, sub := range s.allSubscriptions() {
+		if sub.ctx.Err() != nil {
+			// closed after ctx was done
+			continue
+		}
+
+		isLost, err := sub.isLost()
+		if err != nil {
+			s.logger.Error("Cannot check subscription after reconnect", err, sub.logFields)
+			continue
+		}
+		if !isLost {
+			continue
+		}
+
+		if err := sub.reestablish(); err != nil {
+			s.logger.Error("Cannot re-establish subscription after reconnect", err, sub.logFields)
+			continue
+		}
+		s.logger.Info("Subscription re-established after reconnect", sub.logFields)
+	}
+}
+
+// fetchMessages fetches messages of PullConsumer subscription until the subscriber is closed or ctx is done.
+func (s *StreamingSubscriber) fetchMessages(
+	ctx context.Context,
+	topic string,
+	sub *subscription,
+	processing *processingGroup,
+	gaps *gapDetector,
+	output chan *message.Message,
+	logFields watermill.LogFields,
+) {
+	// cancels in-flight fetch on close, ctx deadline bounds the fetch as well
+	closingCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-s.closing:
+		case <-closingCtx.Done():
+		}
+		cancel()
+	}()
+
+	if !s.waitResumed(ctx) {
+		return
+	}
+
+	for {
+		select {
+		case <-s.closing:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		natsSub := sub.current()
+
+		fetchCtx, cancelFetch := context.WithTimeout(closingCtx, s.config.FetchTimeout)
+		msgs, err := natsSub.Fetch(s.config.FetchBatchSize, nats.Context(fetchCtx))
+		cancelFetch()
+
+		if errors.Is(err, nats.ErrBadSubscription) && sub.current() != natsSub {
+			// subscription was re-established after reconnect
+			continue
+		}
+		if errors.Is(err, nats.ErrConnectionClosed) || errors.Is(err, nats.ErrBadSubscription) {
+			s.logger.Debug("Pull subscription closed, stopping fetching", logFields)
+			return
+		}
+		if err != nil && !errors.Is(err, nats.ErrTimeout) && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) {
+			s.logger.Error("Cannot fetch messages", err, logFields)
+		}
+
+		var batch *ackBatch
+		if s.config.BatchAck && len(msgs) > 0 {
+			batch = newAckBatch(msgs)
+		}
+
+		for _, m := range msgs {
+			s.detectGap(gaps, topic, m, logFields)
+
+			processing.run(ctx, s.closing, func() {
+				s.processMessage(ctx, topic, m, output, batch, logFields)
+			})
+		}
+
+		if batch != nil {
+			// the ack of the next batch would acknowledge messages of this one as well
+			select {
+			case <-batch.done:
+			case <-s.closing:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
 func (s *StreamingSubscriber) processMessage(
 	ctx context.Context,
+	topic string,
 	m *nats.Msg,
 	output chan *message.Message,
+	batch *ackBatch,
 	logFields watermill.LogFields,
 ) {
-	if s.isClosed() {
+	if !s.waitResumed(ctx) || s.isClosed() {
 		return
 	}
 
-	s.processingMessagesWg.Add(1)
-	defer s.processingMessagesWg.Done()
+	s.processingMessages.Add(1)
+	defer s.processingMessages.Add(-1)
+
+	// with BatchAck, the ack is sent when results of all messages of the batch are recorded
+	var batchUUID string
+	batchAcked := false
+	if batch != nil {
+		defer func() {
+			s.recordBatchResult(batch, m, batchUUID, batchAcked, logFields)
+		}()
+	}
 
 	s.logger.Trace("Received message", logFields)
+	s.counters.countRedelivered(m)
+
+	if s.ackIfMaxRedelivered(m, logFields) {
+		return
+	}
 
-	msg, err := s.config.Unmarshaler.Unmarshal(m)
+	unmarshaler := s.topicUnmarshalers.get(topic, s.config.Unmarshaler)
+
+	msg, err := unmarshaler.Unmarshal(m)
 	if err != nil {
 		s.logger.Error("Cannot unmarshal message", err, logFields)
+		s.counters.unmarshalErrors.Add(1)
+		s.handleUnmarshalError(m, err, logFields)
+		return
+	}
+
+	if msg.UUID == "" {
+		// the unmarshaler doesn't carry the UUID, it's set in the header with StampUUIDAsMsgID or Deduplication
+		msg.UUID = m.Header.Get(nats.MsgIdHdr)
+	}
+
+	if err := s.objectStores.fetchPayload(ctx, msg); err != nil {
+		s.logger.Error("Cannot fetch payload from object store", err, logFields)
 		return
 	}
 
-	ctx, cancelCtx := context.WithCancel(ctx)
+	msg.Metadata.Set(SubjectKey, m.Subject)
+
+	if s.config.DeliveryMetadata {
+		if err := setDeliveryMetadata(msg, m); err != nil {
+			s.logger.Error("Cannot set delivery metadata", err, logFields)
+			return
+		}
+	}
+
+	// with AckNone, processing ends when the message is sent to the output, so its context is not cancelled then
+	if s.config.AckPolicy != AckNone {
+		var cancelCtx context.CancelFunc
+		ctx, cancelCtx = context.WithCancel(ctx)
+		defer cancelCtx()
+	}
+
+	ctx = context.WithValue(ctx, natsMsgContextKey{}, m)
+
+	var deadline *ackDeadline
+	if s.config.AckPolicy != AckNone && !s.config.Ordered {
+		deadline = newAckDeadline(m)
+		ctx = context.WithValue(ctx, ackDeadlineContextKey{}, deadline)
+	}
+
+	ctx, span := startReceiveSpan(ctx, s.config.Tracer, m, msg.UUID)
+	outcome := "discarded"
+	var ackErr error
+	defer func() {
+		span.SetAttributes(OutcomeAttributeKey.String(outcome))
+		endSpan(span, ackErr)
+	}()
+
 	msg.SetContext(ctx)
-	defer cancelCtx()
 
 	messageLogFields := logFields.Add(watermill.LogFields{"message_uuid": msg.UUID})
+	if s.config.LogFieldsExtractor != nil {
+		messageLogFields = messageLogFields.Add(s.config.LogFieldsExtractor(msg))
+	}
 	s.logger.Trace("Unmarshaled message", messageLogFields)
 
+	if s.isDuplicate(msg.UUID, messageLogFields) {
+		outcome = "duplicate"
+		if err := s.ack(m); err != nil {
+			ackErr = errors.Wrap(err, "cannot send ack")
+			s.ackFailed(m, msg.UUID, ackErr, messageLogFields)
+			return
+		}
+		s.logger.Debug("Duplicate message acked without processing", messageLogFields)
+		return
+	}
+
 	select {
 	case output <- msg:
+		s.counters.delivered.Add(1)
 		s.logger.Trace("Message sent to consumer", messageLogFields)
 	case <-s.closing:
-		s.logger.Trace("Closing, message discarded", messageLogFields)
+		s.discard("subscriber closing", messageLogFields)
 		return
 	case <-ctx.Done():
-		s.logger.Trace("Context cancelled, message discarded", messageLogFields)
+		s.discard("context cancelled", messageLogFields)
 		return
 	}
 
-	select {
-	case <-msg.Acked():
-		if err := m.Ack(); err != nil {
-			s.logger.Error("Cannot send ack", err, messageLogFields)
+	if s.config.AckPolicy == AckNone {
+		// the message is not acked, so there is nothing to wait for
+		outcome = "forwarded"
+		return
+	}
+
+	batchUUID = msg.UUID
+
+	var ackTimer Timer
+	var ackTimeout <-chan time.Time
+	var progress <-chan time.Time
+	if s.config.AckProgressInterval > 0 {
+		// the message is not redelivered while in progress, so there is no ack timeout
+		ticker := time.NewTicker(s.config.AckProgressInterval)
+		defer ticker.Stop()
+		progress = ticker.C
+	} else {
+		ackTimer = s.config.Clock.NewTimer(s.config.ackWait())
+		// the timer is replaced when the deadline is extended, so the current one is stopped
+		defer func() {
+			ackTimer.Stop()
+		}()
+		ackTimeout = ackTimer.C()
+	}
+	sentAt := s.config.Clock.Now()
+
+	var extended <-chan struct{}
+	if deadline != nil {
+		extended = deadline.extended
+	}
+
+	for {
+		select {
+		case <-msg.Acked():
+			outcome = "ack"
+			s.counters.acked.Add(1)
+			s.recordProcessed(msg.UUID, messageLogFields)
+			if batch != nil {
+				batchAcked = true
+				s.logger.Trace("Message Acked, waiting for the batch", messageLogFields)
+				return
+			}
+			if s.config.Ordered {
+				// ordered consumer doesn't use acks
+				s.logger.Trace("Message Acked", messageLogFields)
+				return
+			}
+			if err := s.ack(m); err != nil {
+				outcome = "ack_failed"
+				ackErr = errors.Wrap(err, "cannot send ack")
+				s.ackFailed(m, msg.UUID, ackErr, messageLogFields)
+				return
+			}
+			s.logger.Trace("Message Acked", messageLogFields)
+			return
+		case <-msg.Nacked():
+			outcome = "nack"
+			s.counters.nacked.Add(1)
+			s.logger.Trace("Message Nacked", messageLogFields)
+			if terminated := s.terminateIfLastDelivery(m, unmarshaler, msg.UUID, messageLogFields); terminated || s.config.NakDelay == 0 {
+				return
+			}
+			if err := m.NakWithDelay(s.config.NakDelay); err != nil {
+				ackErr = errors.Wrap(err, "cannot send nak")
+				s.ackFailed(m, msg.UUID, ackErr, messageLogFields)
+				return
+			}
+			s.logger.Trace("Nak with delay sent", messageLogFields)
+			return
+		case <-progress:
+			if err := m.InProgress(); err != nil {
+				s.ackFailed(m, msg.UUID, errors.Wrap(err, "cannot send in progress ack"), messageLogFields)
+				continue
+			}
+			s.logger.Trace("In progress ack sent", messageLogFields)
+		case <-extended:
+			if ackTimer != nil {
+				ackTimer.Stop()
+				ackTimer = s.config.Clock.NewTimer(s.config.ackWait())
+				ackTimeout = ackTimer.C()
+			}
+			s.logger.Trace("Ack deadline extended", messageLogFields)
+		case <-ackTimeout:
+			outcome = "ack_timeout"
+			s.logger.Trace("Ack timeouted", messageLogFields.Add(watermill.LogFields{
+				"waited": s.config.Clock.Now().Sub(sentAt),
+			}))
+			if s.config.AckWaitJitter == 0 {
+				return
+			}
+			// the consumer ack wait covers the whole jitter, so the message is redelivered now
+			if err := m.Nak(); err != nil {
+				ackErr = errors.Wrap(err, "cannot send nak")
+				s.ackFailed(m, msg.UUID, ackErr, messageLogFields)
+			}
+			return
+		case <-s.closing:
+			s.discard("subscriber closing before ack", messageLogFields)
+			return
+		case <-ctx.Done():
+			s.discard("context cancelled before ack", messageLogFields)
 			return
 		}
-		s.logger.Trace("Message Acked", messageLogFields)
-	case <-msg.Nacked():
-		s.logger.Trace("Message Nacked", messageLogFields)
-		return
-	case <-time.After(s.config.AckWaitTimeout):
-		s.logger.Trace("Ack timeouted", messageLogFields)
-		return
-	case <-s.closing:
-		s.logger.Trace("Closing, message discarded before ack", messageLogFields)
-		return
-	case <-ctx.Done():
-		s.logger.Trace("Context cancelled, message discarded before ack", messageLogFields)
+	}
+}
+
+// isDuplicate returns true when a message with uuid was already processed, according to DeduplicationStore.
+// Messages without UUID are never duplicates, as they can't be told apart.
+func (s *StreamingSubscriber) isDuplicate(uuid string, messageLogFields watermill.LogFields) bool {
+	if s.config.DeduplicationStore == nil || uuid == "" {
+		return false
+	}
+
+	duplicate, err := s.config.DeduplicationStore.Contains(uuid)
+	if err != nil {
+		s.logger.Error("Cannot check message in deduplication store, processing it", err, messageLogFields)
+		return false
+	}
+
+	return duplicate
+}
+
+// recordProcessed adds uuid of the acked message to DeduplicationStore.
+func (s *StreamingSubscriber) recordProcessed(uuid string, messageLogFields watermill.LogFields) {
+	if s.config.DeduplicationStore == nil || uuid == "" {
 		return
 	}
+
+	if err := s.config.DeduplicationStore.Add(uuid); err != nil {
+		s.logger.Error("Cannot add message to deduplication store", err, messageLogFields)
+	}
+}
+
+// ack acks m, waiting for the server confirmation with AckSync.
+func (s *StreamingSubscriber) ack(m *nats.Msg) error {
+	if s.config.AckMode == AckSync {
+		return m.AckSync()
+	}
+
+	return m.Ack()
+}
+
+// discard counts the message discarded because of closing or ctx cancellation.
+// It's logged on Info level, so messages dropped during shutdown are visible.
+func (s *StreamingSubscriber) discard(reason string, messageLogFields watermill.LogFields) {
+	s.discarded.Add(1)
+	s.logger.Info("Message discarded", messageLogFields.Add(watermill.LogFields{"discard_reason": reason}))
+}
+
+// DiscardedCount returns the number of received messages discarded because the subscriber was closing
+// or the Subscribe context was done, before they were sent to the output channel or acked.
+//
+// Discarded messages are not acked, so they are redelivered (unless AckPolicy is AckNone).
+func (s *StreamingSubscriber) DiscardedCount() uint64 {
+	return s.discarded.Load()
+}
+
+type natsMsgContextKey struct{}
+
+// NatsMsgFromMessage returns the original *nats.Msg of msg received by StreamingSubscriber.
+// It can be used to access headers and JetStream metadata which are not mapped to Watermill, or to reply.
+//
+// False is returned when msg was not received by StreamingSubscriber.
+func NatsMsgFromMessage(msg *message.Message) (*nats.Msg, bool) {
+	m, ok := msg.Context().Value(natsMsgContextKey{}).(*nats.Msg)
+	return m, ok
+}
+
+// setDeliveryMetadata adds JetStream delivery metadata of m to msg.
+func setDeliveryMetadata(msg *message.Message, m *nats.Msg) error {
+	meta, err := m.Metadata()
+	if err != nil {
+		return errors.Wrap(err, "cannot get message metadata")
+	}
+
+	msg.Metadata.Set(StreamSequenceKey, strconv.FormatUint(meta.Sequence.Stream, 10))
+	msg.Metadata.Set(ConsumerSequenceKey, strconv.FormatUint(meta.Sequence.Consumer, 10))
+	msg.Metadata.Set(NumDeliveredKey, strconv.FormatUint(meta.NumDelivered, 10))
+	msg.Metadata.Set(TimestampKey, meta.Timestamp.Format(time.RFC3339Nano))
+
+	return nil
+}
+
+// ackIfMaxRedelivered acks m without processing when it was redelivered more than MaxRedeliverThenAck times.
+func (s *StreamingSubscriber) ackIfMaxRedelivered(m *nats.Msg, logFields watermill.LogFields) bool {
+	if s.config.MaxRedeliverThenAck <= 0 {
+		return false
+	}
+
+	meta, err := m.Metadata()
+	if err != nil {
+		s.logger.Error("Cannot get message metadata", err, logFields)
+		return false
+	}
+
+	// the first delivery is not a redelivery
+	if meta.NumDelivered <= uint64(s.config.MaxRedeliverThenAck)+1 {
+		return false
+	}
+
+	logFields = logFields.Add(watermill.LogFields{"num_delivered": meta.NumDelivered})
+
+	if err := s.ack(m); err != nil {
+		s.ackFailed(m, "", errors.Wrap(err, "cannot send ack"), logFields)
+		return true
+	}
+	// Info is the highest level of Watermill logger below Error
+	s.logger.Info("Message acked without processing after reaching MaxRedeliverThenAck", logFields.Add(watermill.LogFields{
+		"max_redeliver_then_ack": s.config.MaxRedeliverThenAck,
+	}))
+
+	return true
+}
+
+// terminateIfLastDelivery terminates nacked message when it reached MaxDeliver, so it won't be redelivered.
+// When DeadLetterTopic is set, the message is published there first.
+//
+// It returns true when it was the last delivery of the message.
+func (s *StreamingSubscriber) terminateIfLastDelivery(
+	m *nats.Msg,
+	unmarshaler Unmarshaler,
+	msgUUID string,
+	logFields watermill.LogFields,
+) bool {
+	if s.config.MaxDeliver <= 0 {
+		return false
+	}
+
+	meta, err := m.Metadata()
+	if err != nil {
+		s.logger.Error("Cannot get message metadata", err, logFields)
+		return false
+	}
+
+	if meta.NumDelivered < uint64(s.config.MaxDeliver) {
+		return false
+	}
+
+	logFields = logFields.Add(watermill.LogFields{"num_delivered": meta.NumDelivered})
+
+	if s.deadLetterPublisher != nil {
+		reason := fmt.Sprintf("nacked on the last delivery attempt, MaxDeliver is %d", s.config.MaxDeliver)
+		err := s.retryDeadLetter(func() error {
+			return s.publishDeadLetter(m, unmarshaler, reason, meta.NumDelivered)
+		}, logFields)
+		if err != nil {
+			// the message is not redelivered after MaxDeliver, but it's left unacked in the stream instead of terminated
+			s.logger.Error("Cannot publish message to dead letter topic, message is not terminated", err, logFields)
+			return true
+		}
+		s.logger.Info("Message published to dead letter topic", logFields.Add(watermill.LogFields{
+			"dead_letter_topic": s.config.DeadLetterTopic,
+		}))
+	}
+
+	if err := m.Term(); err != nil {
+		s.ackFailed(m, msgUUID, errors.Wrap(err, "cannot terminate message"), logFields)
+		return true
+	}
+	s.logger.Info("Message terminated after reaching MaxDeliver", logFields)
+
+	return true
+}
+
+// retryDeadLetter calls publish until it succeeds or the subscriber is closed, with backoff doubled after each attempt.
+//
+// Messages on their last delivery are not redelivered, so terminating them when the dead letter topic is not
+// available, for example because it has no stream yet, would lose them.
+func (s *StreamingSubscriber) retryDeadLetter(publish func() error, logFields watermill.LogFields) error {
+	backoff := defaultPublishRetryBackoff
+
+	for {
+		err := publish()
+		if err == nil {
+			return nil
+		}
+
+		s.logger.Error("Cannot publish message to dead letter topic, retrying", err, logFields.Add(watermill.LogFields{
+			"backoff": backoff,
+		}))
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-s.closing:
+			timer.Stop()
+			return errors.Wrap(err, "subscriber closed, dead letter retries stopped")
+		}
+
+		backoff *= 2
+		if backoff > maxPublishRetryBackoff {
+			backoff = maxPublishRetryBackoff
+		}
+	}
+}
+
+// handleUnmarshalError applies OnUnmarshalError to m, which couldn't be unmarshaled with unmarshalErr.
+func (s *StreamingSubscriber) handleUnmarshalError(m *nats.Msg, unmarshalErr error, logFields watermill.LogFields) {
+	// the message has no UUID, as it couldn't be unmarshaled
+	const msgUUID = ""
+
+	switch s.config.OnUnmarshalError {
+	case UnmarshalErrorAck:
+		if err := s.ack(m); err != nil {
+			s.ackFailed(m, msgUUID, errors.Wrap(err, "cannot send ack"), logFields)
+			return
+		}
+		s.logger.Info("Message which cannot be unmarshaled acked", logFields)
+	case UnmarshalErrorNack:
+		if err := m.NakWithDelay(s.config.NakDelay); err != nil {
+			s.ackFailed(m, msgUUID, errors.Wrap(err, "cannot send nak"), logFields)
+			return
+		}
+		s.logger.Trace("Message which cannot be unmarshaled nacked", logFields)
+	case UnmarshalErrorDeadLetter:
+		meta, err := m.Metadata()
+		if err != nil {
+			s.logger.Error("Cannot get message metadata", err, logFields)
+			return
+		}
+
+		err = s.retryDeadLetter(func() error {
+			return s.publishRawDeadLetter(m, unmarshalErr, meta.NumDelivered)
+		}, logFields)
+		if err != nil {
+			// the message is left unacked instead of terminated, so it's redelivered after AckWaitTimeout
+			s.logger.Error("Cannot publish message to dead letter topic, message is not terminated", err, logFields)
+			return
+		}
+		s.logger.Info("Message published to dead letter topic", logFields.Add(watermill.LogFields{
+			"dead_letter_topic": s.config.DeadLetterTopic,
+		}))
+		fallthrough
+	case UnmarshalErrorTerm:
+		if err := m.Term(); err != nil {
+			s.ackFailed(m, msgUUID, errors.Wrap(err, "cannot terminate message"), logFields)
+			return
+		}
+		s.logger.Info("Message which cannot be unmarshaled terminated", logFields)
+	}
+}
+
+// ackFailed logs err and sends it to AckErrors, dropping the oldest error when the buffer is full.
+func (s *StreamingSubscriber) ackFailed(m *nats.Msg, msgUUID string, err error, logFields watermill.LogFields) {
+	s.logger.Error("Acknowledgement failed", err, logFields)
+
+	ackErr := AckError{MessageUUID: msgUUID, Topic: m.Subject, Err: err}
+	for {
+		select {
+		case s.ackErrors <- ackErr:
+			return
+		default:
+		}
+
+		select {
+		case <-s.ackErrors:
+		default:
+		}
+	}
+}
+
+// AckErrors returns errors of sending acknowledgements of received messages to NATS.
+//
+// The channel is buffered with AckErrorsBufferSize, when errors are not received fast enough
+// the oldest ones are dropped. The channel is not closed on Close.
+func (s *StreamingSubscriber) AckErrors() <-chan AckError {
+	return s.ackErrors
+}
+
+func (s *StreamingSubscriber) publishDeadLetter(m *nats.Msg, unmarshaler Unmarshaler, reason string, deliveryCount uint64) error {
+	// unmarshaling again, so metadata changed by the handler is not published
+	msg, err := unmarshaler.Unmarshal(m)
+	if err != nil {
+		return errors.Wrap(err, "cannot unmarshal message")
+	}
+
+	msg.Metadata.Set(DeadLetterReasonKey, reason)
+	msg.Metadata.Set(DeliveryCountKey, strconv.FormatUint(deliveryCount, 10))
+
+	return s.deadLetterPublisher.Publish(s.config.DeadLetterTopic, msg)
+}
+
+// publishRawDeadLetter publishes data and headers of m, which couldn't be unmarshaled, to DeadLetterTopic.
+func (s *StreamingSubscriber) publishRawDeadLetter(m *nats.Msg, unmarshalErr error, deliveryCount uint64) error {
+	header := nats.Header{}
+	for k, v := range m.Header {
+		// expectations and message ID of the original publish would be checked against the dead letter topic
+		if isJetStreamHeader(k) {
+			continue
+		}
+		header[k] = v
+	}
+	header.Set(DeadLetterReasonKey, "cannot unmarshal message: "+unmarshalErr.Error())
+	header.Set(DeliveryCountKey, strconv.FormatUint(deliveryCount, 10))
+
+	_, err := s.js.PublishMsg(&nats.Msg{
+		Subject: s.config.DeadLetterTopic,
+		Header:  header,
+		Data:    m.Data,
+	})
+	return err
+}
+
+// Ping checks that the NATS connection is alive, it can be used as a readiness probe.
+//
+// ErrNotConnected is returned when the connection is not connected, also when it was not created yet
+// with LazyConnect, and ErrPingTimeout when the server didn't respond before ctx was done.
+func (s *StreamingSubscriber) Ping(ctx context.Context) error {
+	conn := s.connection()
+	if conn == nil {
+		return errors.Wrap(ErrNotConnected, "connection is not created yet with LazyConnect")
+	}
+
+	return ping(ctx, conn)
+}
+
+// JetStream returns the JetStreamContext used by the subscriber, so management operations like updating
+// consumers can be run without another connection.
+//
+// The context shares the connection of the subscriber, so the connection must not be closed with it,
+// when the connection was created by the subscriber it is closed by Close. ErrNotConnected is returned
+// when the connection is already closed.
+func (s *StreamingSubscriber) JetStream() (nats.JetStreamContext, error) {
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+
+	if s.conn.IsClosed() {
+		return nil, errors.Wrap(ErrNotConnected, "connection is closed")
+	}
+
+	return s.js, nil
 }
 
 func (s *StreamingSubscriber) Close() error {
 	s.subsLock.Lock()
-	defer s.subsLock.Unlock()
-
 	if s.closed {
+		s.subsLock.Unlock()
 		return nil
 	}
 	s.closed = true
+	// subscriptions are closed without holding the lock, as draining and waiting can take up to CloseTimeout
+	subs := s.allSubscriptions()
+	s.subsLock.Unlock()
 
 	s.logger.Debug("Closing subscriber", nil)
 	defer s.logger.Info("StreamingSubscriber closed", nil)
 
-	var result error
+	stopProgress := make(chan struct{})
+	defer close(stopProgress)
+	go s.reportCloseProgress(stopProgress)
+
+	// draining and waiting for outputs share the deadline, so Close takes at most CloseTimeout
+	deadline, stopDeadline := newDeadline(s.config.Clock, s.config.CloseTimeout)
+	defer stopDeadline()
+
+	if s.config.DrainOnClose {
+		s.drain(subs, deadline)
+	} else {
+		for _, sub := range subs {
+			natsSub := sub.current()
+			if !natsSub.IsValid() {
+				// already closed after ctx was done
+				continue
+			}
+
+			if err := s.closeSubscription(natsSub); err != nil {
+				s.logger.Error("Cannot close subscription", err, sub.logFields)
+			}
+		}
+	}
 
 	close(s.closing)
-	internalSync.WaitGroupTimeout(&s.outputsWg, s.config.CloseTimeout)
+	waitGroupTimeout(&s.outputsWg, deadline)
 
-	s.conn.Close()
+	// conn is nil when the subscriber was not used with LazyConnect
+	if conn := s.connection(); conn != nil {
+		removeConnSubscriber(conn, s)
+		if s.ownsConn {
+			conn.Close()
+		}
+	}
 
-	return result
+	return nil
 }
 
-func (s *StreamingSubscriber) isClosed() bool {
-	s.subsLock.RLock()
-	defer s.subsLock.RUnlock()
+// reportCloseProgress reports the number of messages being processed every CloseProgressInterval,
+// until all messages are processed or stop is closed.
+func (s *StreamingSubscriber) reportCloseProgress(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.config.CloseProgressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+
+		remaining := int(s.processingMessages.Load())
+		if remaining == 0 {
+			return
+		}
 
-	return s.closed
+		s.logger.Info("Waiting for messages being processed", watermill.LogFields{"remaining": remaining})
+		if s.config.CloseProgress != nil {
+			s.config.CloseProgress(remaining)
+		}
+	}
+}
+
+// isClosed returns true when messages should no longer be processed.
+// While draining on Close it still returns false, so delivered messages are processed.
+func (s *StreamingSubscriber) isClosed() bool {
+	select {
+	case <-s.closing:
+		return true
+	default:
+		return false
+	}
 }
@@ -0,0 +1,69 @@
+package jetstream
+
+import (
+	"sync/atomic"
+
+	"github.com/nats-io/nats.go"
+)
+
+// SubscriberStats are counters of messages received by StreamingSubscriber, returned by Stats.
+//
+// Counters are monotonic, they are never decreased and are reset only by creating a new subscriber.
+type SubscriberStats struct {
+	// Delivered is the number of messages sent to output channels.
+	Delivered uint64
+
+	// Acked is the number of delivered messages acked by the handler.
+	Acked uint64
+
+	// Nacked is the number of delivered messages nacked by the handler.
+	Nacked uint64
+
+	// Redelivered is the number of received messages which were delivered by the server before,
+	// they are counted before processing, so also when they are acked without processing
+	// (see MaxRedeliverThenAck and DeduplicationStore).
+	Redelivered uint64
+
+	// Discarded is the number of received messages discarded because the subscriber was closing
+	// or the Subscribe context was done, see DiscardedCount.
+	Discarded uint64
+
+	// UnmarshalErrors is the number of received messages which couldn't be unmarshaled.
+	UnmarshalErrors uint64
+}
+
+// subscriberCounters are counters of SubscriberStats updated by processMessage.
+type subscriberCounters struct {
+	delivered       atomic.Uint64
+	acked           atomic.Uint64
+	nacked          atomic.Uint64
+	redelivered     atomic.Uint64
+	unmarshalErrors atomic.Uint64
+}
+
+// Stats returns counters of messages received by all subscriptions of the subscriber.
+// Each counter is read atomically, but they are not read at once, so they can be slightly inconsistent
+// while messages are processed.
+func (s *StreamingSubscriber) Stats() SubscriberStats {
+	return SubscriberStats{
+		Delivered:       s.counters.delivered.Load(),
+		Acked:           s.counters.acked.Load(),
+		Nacked:          s.counters.nacked.Load(),
+		Redelivered:     s.counters.redelivered.Load(),
+		Discarded:       s.discarded.Load(),
+		UnmarshalErrors: s.counters.unmarshalErrors.Load(),
+	}
+}
+
+// countRedelivered counts m when it was delivered by the server before.
+func (c *subscriberCounters) countRedelivered(m *nats.Msg) {
+	meta, err := m.Metadata()
+	if err != nil {
+		// not a JetStream message, it can't be redelivered
+		return
+	}
+
+	if meta.NumDelivered > 1 {
+		c.redelivered.Add(1)
+	}
+}
@@ -0,0 +1,50 @@
+package jetstream_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+)
+
+func TestStreamingSubscriber_Stats(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName: "durable",
+		// nacked messages are redelivered after AckWaitTimeout without NakDelay
+		NakDelay: time.Millisecond,
+	})
+	assert.Equal(t, jetstream.SubscriberStats{}, sub.Stats())
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	publishMessages(t, pub, topic, 1)
+	receiveMessage(t, messages).Nack()
+	// the nacked message is redelivered
+	receiveMessage(t, messages).Ack()
+
+	_, err = newJetstream(t).Publish(topic, []byte("not gob"))
+	require.NoError(t, err)
+
+	publishMessages(t, pub, topic, 1)
+	receiveMessage(t, messages).Ack()
+
+	require.Eventually(t, func() bool {
+		return sub.Stats().Acked == 2
+	}, time.Second*5, time.Millisecond*10)
+
+	assert.Equal(t, jetstream.SubscriberStats{
+		Delivered:       3,
+		Acked:           2,
+		Nacked:          1,
+		Redelivered:     1,
+		UnmarshalErrors: 1,
+	}, sub.Stats())
+}
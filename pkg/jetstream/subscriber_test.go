@@ -0,0 +1,2997 @@
+package jetstream_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+)
+
+func newStream(t *testing.T) string {
+	js := newJetstream(t)
+
+	topic := "topic_" + watermill.NewShortUUID()
+	require.NoError(t, jetstream.EnsureStream(js, jetstream.StreamConfig{Name: topic}))
+
+	t.Cleanup(func() {
+		_ = jetstream.DeleteStream(js, topic, jetstream.IgnoreStreamNotFound())
+	})
+
+	return topic
+}
+
+func newPublisher(t *testing.T) *jetstream.StreamingPublisher {
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:       getNatsURL(),
+		Marshaler: jetstream.GobMarshaler{},
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = pub.Close()
+	})
+
+	return pub
+}
+
+func newSubscriber(t *testing.T, config jetstream.StreamingSubscriberConfig) *jetstream.StreamingSubscriber {
+	if config.URL == "" {
+		config.URL = getNatsURL()
+	}
+	if config.Unmarshaler == nil {
+		config.Unmarshaler = jetstream.GobMarshaler{}
+	}
+
+	sub, err := jetstream.NewStreamingSubscriber(config, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = sub.Close()
+	})
+
+	return sub
+}
+
+func publishMessages(t *testing.T, pub message.Publisher, topic string, count int) []*message.Message {
+	var messages []*message.Message
+	for i := 0; i < count; i++ {
+		msg := message.NewMessage(watermill.NewUUID(), []byte("payload"))
+		require.NoError(t, pub.Publish(topic, msg))
+		messages = append(messages, msg)
+	}
+
+	return messages
+}
+
+func receiveMessages(t *testing.T, messages <-chan *message.Message, count int) []*message.Message {
+	var received []*message.Message
+	for len(received) < count {
+		select {
+		case msg, ok := <-messages:
+			require.True(t, ok, "output channel closed")
+			msg.Ack()
+			received = append(received, msg)
+		case <-time.After(time.Second * 10):
+			t.Fatalf("received %d of %d messages", len(received), count)
+		}
+	}
+
+	return received
+}
+
+func TestPullConsumer(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:      "durable",
+		SubscribersCount: 2,
+		ConsumerType:     jetstream.PullConsumer,
+		FetchBatchSize:   5,
+		FetchTimeout:     time.Minute,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	published := publishMessages(t, pub, topic, 10)
+	received := receiveMessages(t, messages, len(published))
+
+	expectedUUIDs := map[string]struct{}{}
+	for _, msg := range published {
+		expectedUUIDs[msg.UUID] = struct{}{}
+	}
+	receivedUUIDs := map[string]struct{}{}
+	for _, msg := range received {
+		receivedUUIDs[msg.UUID] = struct{}{}
+	}
+	assert.Equal(t, expectedUUIDs, receivedUUIDs)
+
+	// in-flight fetches are waiting up to FetchTimeout, Close should cancel them
+	closeStarted := time.Now()
+	require.NoError(t, sub.Close())
+	assert.Less(t, int64(time.Since(closeStarted)), int64(time.Second*5))
+
+	_, open := <-messages
+	assert.False(t, open)
+}
+
+func TestPullConsumer_context_deadline(t *testing.T) {
+	topic := newStream(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:  "durable",
+		ConsumerType: jetstream.PullConsumer,
+		FetchTimeout: time.Minute,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+
+	messages, err := sub.Subscribe(ctx, topic)
+	require.NoError(t, err)
+
+	// the fetch waiting up to FetchTimeout should be bounded by ctx deadline
+	select {
+	case _, open := <-messages:
+		assert.False(t, open)
+	case <-time.After(time.Second * 5):
+		t.Fatal("messages channel should be closed after ctx deadline")
+	}
+}
+
+func TestPullConsumer_requires_durable_name(t *testing.T) {
+	_, err := jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+		URL:          getNatsURL(),
+		ConsumerType: jetstream.PullConsumer,
+		Unmarshaler:  jetstream.GobMarshaler{},
+	}, nil)
+	require.Error(t, err)
+}
+
+func TestSubscribeInitialize(t *testing.T) {
+	testCases := []struct {
+		Name            string
+		Config          jetstream.StreamingSubscriberConfig
+		DurableConsumer string
+	}{
+		{
+			Name:            "durable",
+			Config:          jetstream.StreamingSubscriberConfig{DurableName: "durable"},
+			DurableConsumer: "durable",
+		},
+		{
+			Name:            "queue_group",
+			Config:          jetstream.StreamingSubscriberConfig{QueueGroup: "queue_group"},
+			DurableConsumer: "queue_group",
+		},
+		{
+			Name: "pull",
+			Config: jetstream.StreamingSubscriberConfig{
+				DurableName:  "durable",
+				ConsumerType: jetstream.PullConsumer,
+			},
+			DurableConsumer: "durable",
+		},
+		{
+			Name:   "ephemeral",
+			Config: jetstream.StreamingSubscriberConfig{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			topic := newStream(t)
+			js := newJetstream(t)
+
+			sub := newSubscriber(t, tc.Config)
+			require.NoError(t, sub.SubscribeInitialize(topic))
+
+			var consumers []string
+			for consumer := range js.ConsumerNames(topic) {
+				consumers = append(consumers, consumer)
+			}
+
+			if tc.DurableConsumer == "" {
+				assert.Empty(t, consumers, "ephemeral consumer should be created on Subscribe")
+				return
+			}
+			require.Equal(t, []string{tc.DurableConsumer}, consumers)
+
+			published := publishMessages(t, newPublisher(t), topic, 2)
+			time.Sleep(time.Millisecond * 100)
+
+			info, err := js.ConsumerInfo(topic, tc.DurableConsumer)
+			require.NoError(t, err)
+			assert.EqualValues(t, len(published), info.NumPending, "messages should not be consumed")
+			assert.EqualValues(t, 0, info.Delivered.Consumer)
+			assert.False(t, info.PushBound)
+
+			messages, err := sub.Subscribe(context.Background(), topic)
+			require.NoError(t, err)
+
+			received := receiveMessages(t, messages, len(published))
+			assert.Equal(t, messageUUIDs(published), messageUUIDs(received))
+		})
+	}
+}
+
+func TestSubscribeInitialize_without_stream(t *testing.T) {
+	for _, config := range []jetstream.StreamingSubscriberConfig{{}, {DurableName: "durable"}} {
+		sub := newSubscriber(t, config)
+		assert.Error(t, sub.SubscribeInitialize("missing_"+watermill.NewShortUUID()))
+	}
+}
+
+func TestNewStreamingSubscriber_default_url(t *testing.T) {
+	if getNatsURL() != nats.DefaultURL {
+		t.Skip("NATS server is not available at nats.DefaultURL")
+	}
+
+	sub, err := jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+		Unmarshaler: jetstream.GobMarshaler{},
+	}, nil)
+	require.NoError(t, err)
+	require.NoError(t, sub.Close())
+}
+
+func TestMaxDeliver(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:    "durable",
+		AckWaitTimeout: time.Millisecond * 100,
+		MaxDeliver:     3,
+		BackOff:        []time.Duration{time.Millisecond * 100, time.Millisecond * 300},
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	info, err := newJetstream(t).ConsumerInfo(topic, "durable")
+	require.NoError(t, err)
+	assert.Equal(t, 3, info.Config.MaxDeliver)
+	assert.Equal(t, []time.Duration{time.Millisecond * 100, time.Millisecond * 300}, info.Config.BackOff)
+
+	published := publishMessages(t, pub, topic, 1)
+
+	var deliveries []time.Time
+	for i := 0; i < 3; i++ {
+		select {
+		case msg := <-messages:
+			assert.Equal(t, published[0].UUID, msg.UUID)
+			deliveries = append(deliveries, time.Now())
+			msg.Nack()
+		case <-time.After(time.Second * 5):
+			t.Fatalf("message was delivered only %d times", len(deliveries))
+		}
+	}
+
+	// the last redelivery is delayed by the last BackOff entry
+	assert.GreaterOrEqual(t, int64(deliveries[2].Sub(deliveries[1])), int64(time.Millisecond*250))
+
+	select {
+	case msg := <-messages:
+		t.Fatalf("message %s was delivered after MaxDeliver", msg.UUID)
+	case <-time.After(time.Second):
+		// ok
+	}
+
+	info, err = newJetstream(t).ConsumerInfo(topic, "durable")
+	require.NoError(t, err)
+	assert.Equal(t, 0, info.NumAckPending, "message should be terminated")
+}
+
+func TestDeliveryMetadata(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		AckWaitTimeout:   time.Millisecond * 100,
+		DeliveryMetadata: true,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	publishedAt := time.Now()
+	publishMessages(t, pub, topic, 1)
+
+	var first *message.Message
+	select {
+	case first = <-messages:
+		first.Nack()
+	case <-time.After(time.Second * 5):
+		t.Fatal("message not received")
+	}
+
+	assert.Equal(t, "1", first.Metadata.Get(jetstream.StreamSequenceKey))
+	assert.Equal(t, "1", first.Metadata.Get(jetstream.ConsumerSequenceKey))
+	assert.Equal(t, "1", first.Metadata.Get(jetstream.NumDeliveredKey))
+
+	timestamp, err := time.Parse(time.RFC3339Nano, first.Metadata.Get(jetstream.TimestampKey))
+	require.NoError(t, err)
+	assert.WithinDuration(t, publishedAt, timestamp, time.Second)
+
+	redelivered := receiveMessages(t, messages, 1)[0]
+	assert.Equal(t, first.UUID, redelivered.UUID)
+	assert.Equal(t, "1", redelivered.Metadata.Get(jetstream.StreamSequenceKey))
+	assert.Equal(t, "2", redelivered.Metadata.Get(jetstream.ConsumerSequenceKey))
+	assert.Equal(t, "2", redelivered.Metadata.Get(jetstream.NumDeliveredKey))
+}
+
+func TestSubscribe_wildcard_subject(t *testing.T) {
+	js := newJetstream(t)
+
+	stream := "orders_" + watermill.NewShortUUID()
+	require.NoError(t, jetstream.EnsureStream(js, jetstream.StreamConfig{
+		Name:     stream,
+		Subjects: []string{stream + ".>"},
+	}))
+	t.Cleanup(func() {
+		_ = js.DeleteStream(stream)
+	})
+
+	pub := newPublisher(t)
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{})
+
+	messages, err := sub.Subscribe(context.Background(), stream+".>")
+	require.NoError(t, err)
+
+	created := publishMessages(t, pub, stream+".created", 1)
+	shipped := publishMessages(t, pub, stream+".shipped", 1)
+
+	subjects := map[string]string{}
+	for _, msg := range receiveMessages(t, messages, 2) {
+		subjects[msg.UUID] = msg.Metadata.Get(jetstream.SubjectKey)
+		msg.Ack()
+	}
+
+	assert.Equal(t, map[string]string{
+		created[0].UUID: stream + ".created",
+		shipped[0].UUID: stream + ".shipped",
+	}, subjects)
+}
+
+func TestFilterSubject(t *testing.T) {
+	js := newJetstream(t)
+	pub := newPublisher(t)
+
+	newOrdersStream := func(t *testing.T) string {
+		stream := "orders_" + watermill.NewShortUUID()
+		require.NoError(t, jetstream.EnsureStream(js, jetstream.StreamConfig{
+			Name:     stream,
+			Subjects: []string{stream + ".>"},
+		}))
+		t.Cleanup(func() {
+			_ = js.DeleteStream(stream)
+		})
+
+		return stream
+	}
+
+	testCases := []struct {
+		Name   string
+		Config func(stream string) jetstream.StreamingSubscriberConfig
+	}{
+		{
+			Name: "filter_subject",
+			Config: func(stream string) jetstream.StreamingSubscriberConfig {
+				return jetstream.StreamingSubscriberConfig{FilterSubject: stream + ".created"}
+			},
+		},
+		{
+			Name: "filter_subjects",
+			Config: func(stream string) jetstream.StreamingSubscriberConfig {
+				return jetstream.StreamingSubscriberConfig{FilterSubjects: []string{stream + ".created", stream + ".paid"}}
+			},
+		},
+		{
+			Name: "filter_subjects_pull",
+			Config: func(stream string) jetstream.StreamingSubscriberConfig {
+				return jetstream.StreamingSubscriberConfig{
+					DurableName:    "filtered",
+					ConsumerType:   jetstream.PullConsumer,
+					FilterSubjects: []string{stream + ".created", stream + ".paid"},
+				}
+			},
+		},
+		{
+			Name: "filter_subject_ordered",
+			Config: func(stream string) jetstream.StreamingSubscriberConfig {
+				return jetstream.StreamingSubscriberConfig{Ordered: true, FilterSubject: stream + ".created"}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			stream := newOrdersStream(t)
+			sub := newSubscriber(t, tc.Config(stream))
+
+			messages, err := sub.Subscribe(context.Background(), stream+".>")
+			require.NoError(t, err)
+
+			publishMessages(t, pub, stream+".shipped", 1)
+			created := publishMessages(t, pub, stream+".created", 1)
+
+			received := receiveMessages(t, messages, 1)
+			assert.Equal(t, created[0].UUID, received[0].UUID)
+		})
+	}
+
+	t.Run("not_subset_of_stream_subjects", func(t *testing.T) {
+		stream := newOrdersStream(t)
+		sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{FilterSubject: "other." + stream})
+
+		_, err := sub.Subscribe(context.Background(), stream+".>")
+		assert.Error(t, err)
+	})
+}
+
+func TestNatsMsgFromMessage(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	published := publishMessages(t, pub, topic, 1)
+
+	received := receiveMessages(t, messages, 1)[0]
+	natsMsg, ok := jetstream.NatsMsgFromMessage(received)
+	require.True(t, ok)
+	assert.Equal(t, topic, natsMsg.Subject)
+
+	meta, err := natsMsg.Metadata()
+	require.NoError(t, err)
+	assert.Equal(t, topic, meta.Stream)
+
+	_, ok = jetstream.NatsMsgFromMessage(published[0])
+	assert.False(t, ok)
+}
+
+func TestDeliverPolicy(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	published := publishMessages(t, pub, topic, 3)
+	time.Sleep(time.Millisecond * 10)
+	startTime := time.Now()
+	published = append(published, publishMessages(t, pub, topic, 2)...)
+
+	testCases := []struct {
+		Name             string
+		Config           jetstream.StreamingSubscriberConfig
+		ExpectedMessages []*message.Message
+	}{
+		{
+			Name:             "all",
+			Config:           jetstream.StreamingSubscriberConfig{DeliverPolicy: jetstream.DeliverAll},
+			ExpectedMessages: published,
+		},
+		{
+			Name:             "last",
+			Config:           jetstream.StreamingSubscriberConfig{DeliverPolicy: jetstream.DeliverLast},
+			ExpectedMessages: published[4:],
+		},
+		{
+			Name: "start_sequence",
+			Config: jetstream.StreamingSubscriberConfig{
+				DeliverPolicy: jetstream.DeliverByStartSequence,
+				OptStartSeq:   2,
+			},
+			ExpectedMessages: published[1:],
+		},
+		{
+			Name: "start_time",
+			Config: jetstream.StreamingSubscriberConfig{
+				DeliverPolicy: jetstream.DeliverByStartTime,
+				OptStartTime:  startTime,
+			},
+			ExpectedMessages: published[3:],
+		},
+		{
+			Name: "start_sequence_ordered",
+			Config: jetstream.StreamingSubscriberConfig{
+				Ordered:       true,
+				DeliverPolicy: jetstream.DeliverByStartSequence,
+				OptStartSeq:   4,
+			},
+			ExpectedMessages: published[3:],
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			sub := newSubscriber(t, tc.Config)
+
+			messages, err := sub.Subscribe(context.Background(), topic)
+			require.NoError(t, err)
+
+			received := receiveMessages(t, messages, len(tc.ExpectedMessages))
+			assert.Equal(t, messageUUIDs(tc.ExpectedMessages), messageUUIDs(received))
+
+			select {
+			case msg := <-messages:
+				t.Fatalf("unexpected message %s", msg.UUID)
+			case <-time.After(time.Millisecond * 100):
+			}
+		})
+	}
+
+	t.Run("new", func(t *testing.T) {
+		sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{DeliverPolicy: jetstream.DeliverNew})
+
+		messages, err := sub.Subscribe(context.Background(), topic)
+		require.NoError(t, err)
+
+		newMessages := publishMessages(t, pub, topic, 1)
+		received := receiveMessages(t, messages, 1)
+		assert.Equal(t, newMessages[0].UUID, received[0].UUID)
+	})
+}
+
+func TestNakDelay(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		AckWaitTimeout: time.Second * 30,
+		NakDelay:       time.Millisecond * 300,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	published := publishMessages(t, pub, topic, 1)
+
+	var nackedAt time.Time
+	select {
+	case msg := <-messages:
+		nackedAt = time.Now()
+		msg.Nack()
+	case <-time.After(time.Second * 5):
+		t.Fatal("message not received")
+	}
+
+	// redelivered after NakDelay, without waiting for AckWaitTimeout
+	redelivered := receiveMessages(t, messages, 1)[0]
+	assert.Equal(t, published[0].UUID, redelivered.UUID)
+	assert.GreaterOrEqual(t, int64(time.Since(nackedAt)), int64(time.Millisecond*250))
+}
+
+func TestMaxRedeliverThenAck(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:         "durable",
+		NakDelay:            time.Millisecond * 10,
+		MaxRedeliverThenAck: 2,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	published := publishMessages(t, pub, topic, 1)
+
+	// the first delivery and 2 redeliveries are processed
+	for i := 0; i < 3; i++ {
+		select {
+		case msg := <-messages:
+			assert.Equal(t, published[0].UUID, msg.UUID)
+			msg.Nack()
+		case <-time.After(time.Second * 5):
+			t.Fatalf("delivery %d not received", i+1)
+		}
+	}
+
+	require.Eventually(t, func() bool {
+		info, err := newJetstream(t).ConsumerInfo(topic, "durable")
+		require.NoError(t, err)
+		return info.NumAckPending == 0 && info.NumPending == 0
+	}, time.Second*5, time.Millisecond*10, "message should be acked after MaxRedeliverThenAck")
+
+	select {
+	case msg := <-messages:
+		t.Fatalf("message %s should not be processed after MaxRedeliverThenAck", msg.UUID)
+	case <-time.After(time.Millisecond * 200):
+	}
+}
+
+func TestAckWaitJitter(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:    "durable",
+		AckWaitTimeout: time.Millisecond * 300,
+		AckWaitJitter:  time.Millisecond * 300,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	info, err := newJetstream(t).ConsumerInfo(topic, "durable")
+	require.NoError(t, err)
+	assert.Equal(t, time.Millisecond*600, info.Config.AckWait, "consumer ack wait should cover the jitter")
+
+	published := publishMessages(t, pub, topic, 1)
+
+	var receivedAt time.Time
+	select {
+	case <-messages:
+		// not acked, so it times out
+		receivedAt = time.Now()
+	case <-time.After(time.Second * 5):
+		t.Fatal("message not received")
+	}
+
+	redelivered := receiveMessages(t, messages, 1)[0]
+	assert.Equal(t, published[0].UUID, redelivered.UUID)
+
+	elapsed := time.Since(receivedAt)
+	assert.GreaterOrEqual(t, int64(elapsed), int64(time.Millisecond*250))
+	assert.Less(t, int64(elapsed), int64(time.Second*2))
+}
+
+func TestAckProgressInterval(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:         "durable",
+		AckWaitTimeout:      time.Millisecond * 300,
+		AckProgressInterval: time.Millisecond * 100,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	publishMessages(t, pub, topic, 1)
+
+	var msg *message.Message
+	select {
+	case msg = <-messages:
+	case <-time.After(time.Second * 5):
+		t.Fatal("message not received")
+	}
+
+	// processing takes longer than AckWaitTimeout
+	select {
+	case redelivered := <-messages:
+		t.Fatalf("message %s was redelivered while in progress", redelivered.UUID)
+	case <-time.After(time.Second):
+	}
+
+	msg.Ack()
+
+	require.Eventually(t, func() bool {
+		info, err := newJetstream(t).ConsumerInfo(topic, "durable")
+		require.NoError(t, err)
+		return info.NumAckPending == 0
+	}, time.Second*5, time.Millisecond*10, "message should be acked")
+}
+
+func TestAckErrors(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	conn, err := nats.Connect(getNatsURL())
+	require.NoError(t, err)
+
+	sub, err := jetstream.NewStreamingSubscriberWithNatsConn(conn, jetstream.StreamingSubscriberSubscriptionConfig{
+		Unmarshaler: jetstream.GobMarshaler{},
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+	defer func() {
+		_ = sub.Close()
+	}()
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	publishMessages(t, pub, topic, 1)
+
+	var msg *message.Message
+	select {
+	case msg = <-messages:
+	case <-time.After(time.Second * 5):
+		t.Fatal("message not received")
+	}
+
+	conn.Close()
+	msg.Ack()
+
+	select {
+	case ackErr := <-sub.AckErrors():
+		assert.Equal(t, msg.UUID, ackErr.MessageUUID)
+		assert.Equal(t, topic, ackErr.Topic)
+		assert.ErrorIs(t, ackErr, nats.ErrConnectionClosed)
+	case <-time.After(time.Second * 5):
+		t.Fatal("ack error not received")
+	}
+}
+
+func TestAckMode(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		AckMode       jetstream.AckMode
+		ExpectedError bool
+	}{
+		{
+			Name:          "async",
+			AckMode:       jetstream.AckAsync,
+			ExpectedError: false,
+		},
+		{
+			Name:          "sync",
+			AckMode:       jetstream.AckSync,
+			ExpectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			topic := newStream(t)
+			pub := newPublisher(t)
+
+			sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+				DurableName: "durable",
+				AckMode:     tc.AckMode,
+			})
+
+			messages, err := sub.Subscribe(context.Background(), topic)
+			require.NoError(t, err)
+
+			publishMessages(t, pub, topic, 1)
+
+			var msg *message.Message
+			select {
+			case msg = <-messages:
+			case <-time.After(time.Second * 5):
+				t.Fatal("message not received")
+			}
+
+			// the ack is not received by anyone, only AckSync notices it
+			require.NoError(t, newJetstream(t).DeleteConsumer(topic, "durable"))
+			msg.Ack()
+
+			select {
+			case ackErr := <-sub.AckErrors():
+				require.True(t, tc.ExpectedError, "unexpected ack error: %s", ackErr)
+				assert.Equal(t, msg.UUID, ackErr.MessageUUID)
+			case <-time.After(time.Second * 2):
+				require.False(t, tc.ExpectedError, "ack error not received")
+			}
+		})
+	}
+}
+
+func TestDeadLetterTopic(t *testing.T) {
+	topic := newStream(t)
+	deadLetterTopic := newStream(t)
+	pub := newPublisher(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:     "durable",
+		AckWaitTimeout:  time.Millisecond * 100,
+		MaxDeliver:      2,
+		DeadLetterTopic: deadLetterTopic,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte("poison"))
+	msg.Metadata.Set("foo", "bar")
+	require.NoError(t, pub.Publish(topic, msg))
+
+	for i := 0; i < 2; i++ {
+		select {
+		case received := <-messages:
+			received.Metadata.Set("changed_by_handler", "1")
+			received.Nack()
+		case <-time.After(time.Second * 5):
+			t.Fatalf("message was delivered only %d times", i)
+		}
+	}
+
+	deadLetterSub := newSubscriber(t, jetstream.StreamingSubscriberConfig{})
+	deadLetters, err := deadLetterSub.Subscribe(context.Background(), deadLetterTopic)
+	require.NoError(t, err)
+
+	received := receiveMessages(t, deadLetters, 1)[0]
+	assert.Equal(t, msg.UUID, received.UUID)
+	assert.Equal(t, msg.Payload, received.Payload)
+	assert.Equal(t, "bar", received.Metadata.Get("foo"))
+	assert.Equal(t, "2", received.Metadata.Get(jetstream.DeliveryCountKey))
+	assert.NotEmpty(t, received.Metadata.Get(jetstream.DeadLetterReasonKey))
+	assert.Empty(t, received.Metadata.Get("changed_by_handler"))
+
+	info, err := newJetstream(t).ConsumerInfo(topic, "durable")
+	require.NoError(t, err)
+	assert.Equal(t, 0, info.NumAckPending, "message should be terminated")
+}
+
+func TestDeadLetterTopic_no_stream(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+	js := newJetstream(t)
+
+	// the stream of the dead letter topic is created after the message is nacked
+	deadLetterTopic := "dead_letters_" + watermill.NewShortUUID()
+	t.Cleanup(func() {
+		_ = jetstream.DeleteStream(js, deadLetterTopic, jetstream.IgnoreStreamNotFound())
+	})
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:     "durable",
+		AckWaitTimeout:  time.Millisecond * 100,
+		MaxDeliver:      1,
+		DeadLetterTopic: deadLetterTopic,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	published := publishMessages(t, pub, topic, 1)
+	receiveMessage(t, messages).Nack()
+
+	// publishing to the dead letter topic fails a few times
+	time.Sleep(time.Millisecond * 500)
+
+	require.NoError(t, jetstream.EnsureStream(js, jetstream.StreamConfig{Name: deadLetterTopic}))
+
+	deadLetterSub := newSubscriber(t, jetstream.StreamingSubscriberConfig{})
+	deadLetters, err := deadLetterSub.Subscribe(context.Background(), deadLetterTopic)
+	require.NoError(t, err)
+
+	assert.Equal(t, published[0].UUID, receiveMessage(t, deadLetters).UUID)
+}
+
+func TestDeadLetterTopic_no_stream_unmarshal_error(t *testing.T) {
+	topic := newStream(t)
+	js := newJetstream(t)
+
+	// the stream of the dead letter topic is created after the message is received
+	deadLetterTopic := "dead_letters_" + watermill.NewShortUUID()
+	t.Cleanup(func() {
+		_ = jetstream.DeleteStream(js, deadLetterTopic, jetstream.IgnoreStreamNotFound())
+	})
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:      "durable",
+		OnUnmarshalError: jetstream.UnmarshalErrorDeadLetter,
+		DeadLetterTopic:  deadLetterTopic,
+	})
+
+	_, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	corrupt := nats.NewMsg(topic)
+	corrupt.Data = []byte("corrupt")
+	_, err = js.PublishMsg(corrupt)
+	require.NoError(t, err)
+
+	// publishing to the dead letter topic fails a few times
+	time.Sleep(time.Millisecond * 500)
+
+	require.NoError(t, jetstream.EnsureStream(js, jetstream.StreamConfig{Name: deadLetterTopic}))
+
+	assert.Eventually(t, func() bool {
+		deadLetter, err := js.GetLastMsg(deadLetterTopic, deadLetterTopic)
+		return err == nil && string(deadLetter.Data) == "corrupt"
+	}, time.Second*5, time.Millisecond*50)
+}
+
+func TestDeadLetterTopic_headers_marshaler(t *testing.T) {
+	topic := newStream(t)
+	deadLetterTopic := newStream(t)
+
+	// expected stream and message ID of the original message must not be checked when dead lettering
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:              getNatsURL(),
+		Marshaler:        jetstream.NATSMarshaler{},
+		SubjectToStream:  func(topic string) string { return topic },
+		StampUUIDAsMsgID: true,
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = pub.Close()
+	})
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:     "durable",
+		Unmarshaler:     jetstream.NATSMarshaler{},
+		AckWaitTimeout:  time.Millisecond * 100,
+		MaxDeliver:      1,
+		DeadLetterTopic: deadLetterTopic,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	msg := message.NewMessage(watermill.NewUUID(), []byte("poison"))
+	require.NoError(t, pub.Publish(topic, msg))
+
+	receiveMessage(t, messages).Nack()
+
+	assert.Eventually(t, func() bool {
+		info, err := newJetstream(t).StreamInfo(deadLetterTopic)
+		return err == nil && info.State.Msgs == 1
+	}, time.Second*5, time.Millisecond*50, "message should be dead lettered")
+}
+
+func TestOnUnmarshalError(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Policy jetstream.UnmarshalErrorPolicy
+		// Check is called with the consumer info after the valid message published after the corrupt one was acked.
+		Check func(t *testing.T, info *nats.ConsumerInfo) bool
+	}{
+		{
+			Name:   "ignore",
+			Policy: jetstream.UnmarshalErrorIgnore,
+			Check: func(t *testing.T, info *nats.ConsumerInfo) bool {
+				return info.NumAckPending == 1 && info.NumRedelivered == 0
+			},
+		},
+		{
+			Name:   "ack",
+			Policy: jetstream.UnmarshalErrorAck,
+			Check: func(t *testing.T, info *nats.ConsumerInfo) bool {
+				return info.NumAckPending == 0 && info.NumRedelivered == 0
+			},
+		},
+		{
+			Name:   "term",
+			Policy: jetstream.UnmarshalErrorTerm,
+			Check: func(t *testing.T, info *nats.ConsumerInfo) bool {
+				return info.NumAckPending == 0 && info.NumRedelivered == 0
+			},
+		},
+		{
+			Name:   "nack",
+			Policy: jetstream.UnmarshalErrorNack,
+			Check: func(t *testing.T, info *nats.ConsumerInfo) bool {
+				return info.NumRedelivered > 0
+			},
+		},
+		{
+			Name:   "dead_letter",
+			Policy: jetstream.UnmarshalErrorDeadLetter,
+			Check: func(t *testing.T, info *nats.ConsumerInfo) bool {
+				return info.NumAckPending == 0 && info.NumRedelivered == 0
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			topic := newStream(t)
+			deadLetterTopic := newStream(t)
+			pub := newPublisher(t)
+			js := newJetstream(t)
+
+			config := jetstream.StreamingSubscriberConfig{
+				DurableName:      "durable",
+				NakDelay:         time.Millisecond * 100,
+				OnUnmarshalError: tc.Policy,
+			}
+			if tc.Policy == jetstream.UnmarshalErrorDeadLetter {
+				config.DeadLetterTopic = deadLetterTopic
+			}
+			sub := newSubscriber(t, config)
+
+			messages, err := sub.Subscribe(context.Background(), topic)
+			require.NoError(t, err)
+
+			corrupt := nats.NewMsg(topic)
+			corrupt.Data = []byte("corrupt")
+			corrupt.Header.Set("foo", "bar")
+			_, err = js.PublishMsg(corrupt)
+			require.NoError(t, err)
+
+			// the consumer is not poisoned by the corrupt message
+			published := publishMessages(t, pub, topic, 1)
+			received := receiveMessages(t, messages, 1)
+			assert.Equal(t, published[0].UUID, received[0].UUID)
+
+			assert.Eventually(t, func() bool {
+				info, err := js.ConsumerInfo(topic, "durable")
+				return err == nil && tc.Check(t, info)
+			}, time.Second*5, time.Millisecond*50)
+
+			if tc.Policy != jetstream.UnmarshalErrorDeadLetter {
+				return
+			}
+
+			deadLetter, err := js.GetLastMsg(deadLetterTopic, deadLetterTopic)
+			require.NoError(t, err)
+			assert.Equal(t, corrupt.Data, deadLetter.Data)
+			assert.Equal(t, "bar", deadLetter.Header.Get("foo"))
+			assert.Equal(t, "1", deadLetter.Header.Get(jetstream.DeliveryCountKey))
+			assert.Contains(t, deadLetter.Header.Get(jetstream.DeadLetterReasonKey), "cannot unmarshal message")
+		})
+	}
+}
+
+func TestResubscribe_after_reconnect(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Config jetstream.StreamingSubscriberConfig
+	}{
+		{
+			Name:   "ephemeral",
+			Config: jetstream.StreamingSubscriberConfig{},
+		},
+		{
+			Name:   "durable",
+			Config: jetstream.StreamingSubscriberConfig{DurableName: "durable"},
+		},
+		{
+			Name: "durable_queue_group",
+			Config: jetstream.StreamingSubscriberConfig{
+				DurableName:      "durable",
+				QueueGroup:       "queue_group",
+				SubscribersCount: 2,
+			},
+		},
+		{
+			Name: "pull",
+			Config: jetstream.StreamingSubscriberConfig{
+				DurableName:  "durable",
+				ConsumerType: jetstream.PullConsumer,
+				FetchTimeout: time.Millisecond * 100,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			topic := newStream(t)
+			pub := newPublisher(t)
+			js := newJetstream(t)
+			proxy := newNatsProxy(t)
+
+			reconnected := make(chan struct{}, 1)
+
+			config := tc.Config
+			config.URL = proxy.URL()
+			config.NatsOptions = []nats.Option{nats.ReconnectWait(time.Millisecond * 10)}
+			config.OnReconnect = func(_ *nats.Conn) {
+				reconnected <- struct{}{}
+			}
+			sub := newSubscriber(t, config)
+
+			messages, err := sub.Subscribe(context.Background(), topic)
+			require.NoError(t, err)
+
+			first := publishMessages(t, pub, topic, 1)
+			receiveMessages(t, messages, 1)
+
+			// losing consumers while the connection is down, as with restart of the NATS server without persistence
+			for consumer := range js.ConsumerNames(topic) {
+				require.NoError(t, js.DeleteConsumer(topic, consumer))
+			}
+			proxy.DropConnections()
+
+			select {
+			case <-reconnected:
+			case <-time.After(time.Second * 5):
+				t.Fatal("subscriber didn't reconnect")
+			}
+
+			require.Eventually(t, func() bool {
+				for range js.ConsumerNames(topic) {
+					return true
+				}
+				return false
+			}, time.Second*5, time.Millisecond*10, "consumer was not re-created")
+
+			published := publishMessages(t, pub, topic, 2)
+
+			// re-created consumer starts from the beginning of the stream, so the first message is redelivered
+			received := receiveMessages(t, messages, len(first)+len(published))
+			assert.ElementsMatch(t, messageUUIDs(append(first, published...)), messageUUIDs(received))
+		})
+	}
+}
+
+func TestClose_restores_reconnect_handler_of_shared_connection(t *testing.T) {
+	reconnected := 0
+	conn, err := nats.Connect(getNatsURL(), nats.ReconnectHandler(func(_ *nats.Conn) {
+		reconnected++
+	}))
+	require.NoError(t, err)
+	t.Cleanup(conn.Close)
+
+	var subs []*jetstream.StreamingSubscriber
+	for i := 0; i < 3; i++ {
+		sub, err := jetstream.NewStreamingSubscriberWithNatsConn(conn, jetstream.StreamingSubscriberSubscriptionConfig{
+			Unmarshaler: jetstream.GobMarshaler{},
+		}, nil)
+		require.NoError(t, err)
+		subs = append(subs, sub)
+	}
+	assert.Equal(t, 3, jetstream.ConnSubscribers(conn))
+
+	conn.Opts.ReconnectedCB(conn)
+	assert.Equal(t, 1, reconnected, "the reconnect handler of the connection should be called once")
+
+	for _, sub := range subs {
+		require.NoError(t, sub.Close())
+	}
+	assert.Equal(t, 0, jetstream.ConnSubscribers(conn), "closed subscribers should not be called on reconnect")
+
+	conn.Opts.ReconnectedCB(conn)
+	assert.Equal(t, 2, reconnected)
+}
+
+func TestClose_closes_subscriptions(t *testing.T) {
+	testCases := []struct {
+		Name            string
+		Config          jetstream.StreamingSubscriberConfig
+		DurableConsumer string
+	}{
+		{
+			Name:   "ephemeral",
+			Config: jetstream.StreamingSubscriberConfig{},
+		},
+		{
+			Name:            "durable",
+			Config:          jetstream.StreamingSubscriberConfig{DurableName: "durable"},
+			DurableConsumer: "durable",
+		},
+		{
+			Name: "queue_group",
+			Config: jetstream.StreamingSubscriberConfig{
+				QueueGroup:       "queue_group",
+				SubscribersCount: 2,
+			},
+			DurableConsumer: "queue_group",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			topic := newStream(t)
+			js := newJetstream(t)
+
+			sub := newSubscriber(t, tc.Config)
+
+			_, err := sub.Subscribe(context.Background(), topic)
+			require.NoError(t, err)
+
+			require.NoError(t, sub.Close())
+
+			var consumers []string
+			for consumer := range js.ConsumerNames(topic) {
+				consumers = append(consumers, consumer)
+			}
+
+			if tc.DurableConsumer == "" {
+				assert.Empty(t, consumers, "ephemeral consumer should be deleted")
+				return
+			}
+
+			require.Equal(t, []string{tc.DurableConsumer}, consumers, "durable consumer should be kept")
+
+			info, err := js.ConsumerInfo(topic, tc.DurableConsumer)
+			require.NoError(t, err)
+			assert.False(t, info.PushBound, "consumer should have no active subscriptions")
+		})
+	}
+}
+
+func TestSubscribe_context_cancelled(t *testing.T) {
+	testCases := []struct {
+		Name            string
+		Config          jetstream.StreamingSubscriberConfig
+		DurableConsumer string
+	}{
+		{
+			Name:   "ephemeral",
+			Config: jetstream.StreamingSubscriberConfig{},
+		},
+		{
+			Name: "queue_group",
+			Config: jetstream.StreamingSubscriberConfig{
+				QueueGroup:       "queue_group",
+				SubscribersCount: 2,
+			},
+			DurableConsumer: "queue_group",
+		},
+		{
+			Name: "pull",
+			Config: jetstream.StreamingSubscriberConfig{
+				DurableName:  "durable",
+				ConsumerType: jetstream.PullConsumer,
+				FetchTimeout: time.Millisecond * 100,
+			},
+			DurableConsumer: "durable",
+		},
+		{
+			Name:   "ordered",
+			Config: jetstream.StreamingSubscriberConfig{Ordered: true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			topic := newStream(t)
+			pub := newPublisher(t)
+			js := newJetstream(t)
+
+			sub := newSubscriber(t, tc.Config)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			messages, err := sub.Subscribe(ctx, topic)
+			require.NoError(t, err)
+
+			receiveMessages(t, messages, len(publishMessages(t, pub, topic, 1)))
+
+			cancel()
+
+			select {
+			case _, open := <-messages:
+				require.False(t, open, "no messages should be received after ctx is cancelled")
+			case <-time.After(time.Second * 5):
+				t.Fatal("output channel should be closed after ctx is cancelled")
+			}
+
+			var consumers []string
+			for consumer := range js.ConsumerNames(topic) {
+				consumers = append(consumers, consumer)
+			}
+
+			if tc.DurableConsumer == "" {
+				assert.Empty(t, consumers, "ephemeral consumer should be deleted")
+			} else {
+				require.Equal(t, []string{tc.DurableConsumer}, consumers, "durable consumer should be kept")
+
+				info, err := js.ConsumerInfo(topic, tc.DurableConsumer)
+				require.NoError(t, err)
+				assert.False(t, info.PushBound, "consumer should have no active subscriptions")
+			}
+
+			// the subscriber is not closed, so it can still subscribe
+			messages, err = sub.Subscribe(context.Background(), topic)
+			require.NoError(t, err)
+
+			published := publishMessages(t, pub, topic, 1)
+			received := receiveMessages(t, messages, 1)
+			if tc.DurableConsumer == "" {
+				// new ephemeral consumer delivers the stream from the start
+				received = receiveMessages(t, messages, 1)
+			}
+			assert.Equal(t, published[0].UUID, received[0].UUID)
+		})
+	}
+}
+
+func TestDiscardedCount(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	logger := watermill.NewCaptureLogger()
+	sub, err := jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+		URL:         getNatsURL(),
+		Unmarshaler: jetstream.GobMarshaler{},
+	}, logger)
+	require.NoError(t, err)
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	published := publishMessages(t, pub, topic, 1)
+
+	select {
+	case <-messages:
+		// the message is not acked before close
+	case <-time.After(time.Second * 5):
+		t.Fatal("message not received")
+	}
+	assert.Zero(t, sub.DiscardedCount())
+
+	require.NoError(t, sub.Close())
+
+	assert.EqualValues(t, 1, sub.DiscardedCount())
+
+	var discarded []watermill.LogFields
+	for _, captured := range logger.Captured()[watermill.InfoLogLevel] {
+		if captured.Msg == "Message discarded" {
+			discarded = append(discarded, captured.Fields)
+		}
+	}
+	require.Len(t, discarded, 1)
+	assert.Equal(t, published[0].UUID, discarded[0]["message_uuid"])
+	assert.Equal(t, "subscriber closing before ack", discarded[0]["discard_reason"])
+}
+
+func TestLogFieldsExtractor(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	logger := watermill.NewCaptureLogger()
+	sub, err := jetstream.NewStreamingSubscriber(jetstream.StreamingSubscriberConfig{
+		URL:         getNatsURL(),
+		Unmarshaler: jetstream.GobMarshaler{},
+		LogFieldsExtractor: func(msg *message.Message) watermill.LogFields {
+			return watermill.LogFields{"correlation_id": msg.Metadata.Get("correlation_id")}
+		},
+	}, logger)
+	require.NoError(t, err)
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	msg := message.NewMessage(watermill.NewUUID(), nil)
+	msg.Metadata.Set("correlation_id", "correlation")
+	require.NoError(t, pub.Publish(topic, msg))
+
+	receiveMessages(t, messages, 1)
+
+	// waits until the ack is processed
+	require.NoError(t, sub.Close())
+
+	assert.True(t, logger.Has(watermill.CapturedMessage{
+		Level: watermill.TraceLogLevel,
+		Fields: watermill.LogFields{
+			"subscriber_num": 0,
+			"topic":          topic,
+			"message_uuid":   msg.UUID,
+			"correlation_id": "correlation",
+		},
+		Msg: "Message Acked",
+	}))
+}
+
+// failingJetStream fails the QueueSubscribe call with number failOn.
+type failingJetStream struct {
+	nats.JetStreamContext
+
+	calls  int
+	failOn int
+}
+
+func (f *failingJetStream) QueueSubscribe(subj, queue string, cb nats.MsgHandler, opts ...nats.SubOpt) (*nats.Subscription, error) {
+	f.calls++
+	if f.calls == f.failOn {
+		return nil, errors.New("injected failure")
+	}
+
+	return f.JetStreamContext.QueueSubscribe(subj, queue, cb, opts...)
+}
+
+func TestSubscribe_rollback(t *testing.T) {
+	topic := newStream(t)
+	js := newJetstream(t)
+
+	conn, err := nats.Connect(getNatsURL())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	subJs, err := conn.JetStream()
+	require.NoError(t, err)
+
+	sub, err := jetstream.NewStreamingSubscriberWithNatsConn(conn, jetstream.StreamingSubscriberSubscriptionConfig{
+		Unmarshaler:      jetstream.GobMarshaler{},
+		QueueGroup:       "group",
+		SubscribersCount: 3,
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+	defer func() {
+		_ = sub.Close()
+	}()
+
+	failing := &failingJetStream{JetStreamContext: subJs, failOn: 3}
+	jetstream.SetJetStream(sub, failing)
+
+	_, err = sub.Subscribe(context.Background(), topic)
+	require.Error(t, err)
+	assert.Equal(t, 3, failing.calls)
+
+	// subscriptions made before the failure are closed
+	_, err = sub.ConsumerInfo(context.Background())
+	assert.ErrorIs(t, err, jetstream.ErrNotSubscribed)
+
+	assert.Eventually(t, func() bool {
+		info, err := js.ConsumerInfo(topic, "group")
+		return err == nil && !info.PushBound
+	}, time.Second*5, time.Millisecond*10, "consumer should have no active subscriptions")
+
+	// the subscriber can subscribe again
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	published := publishMessages(t, newPublisher(t), topic, 1)
+	received := receiveMessages(t, messages, 1)
+	assert.Equal(t, published[0].UUID, received[0].UUID)
+}
+
+func TestDrainOnClose(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:  "durable",
+		DrainOnClose: true,
+		CloseTimeout: time.Second * 5,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	publishMessages(t, pub, topic, 1)
+
+	var msg *message.Message
+	select {
+	case msg = <-messages:
+	case <-time.After(time.Second * 5):
+		t.Fatal("message not received")
+	}
+
+	closed := make(chan error)
+	go func() {
+		closed <- sub.Close()
+	}()
+
+	select {
+	case <-closed:
+		t.Fatal("Close should wait for the message being processed")
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	// subscriptions should not be locked while Close waits for the message
+	consumerInfoDone := make(chan struct{})
+	go func() {
+		_, _ = sub.ConsumerInfo(context.Background())
+		close(consumerInfoDone)
+	}()
+	select {
+	case <-consumerInfoDone:
+	case <-time.After(time.Second):
+		t.Fatal("ConsumerInfo should not be blocked by Close")
+	}
+
+	msg.Ack()
+	require.NoError(t, <-closed)
+
+	info, err := newJetstream(t).ConsumerInfo(topic, "durable")
+	require.NoError(t, err)
+	assert.Equal(t, 0, info.NumAckPending, "message should be acked")
+	assert.False(t, info.PushBound)
+}
+
+func TestDrainOnClose_handler_concurrency(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:        "durable",
+		DrainOnClose:       true,
+		CloseTimeout:       time.Second * 5,
+		HandlerConcurrency: 3,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	publishMessages(t, pub, topic, 3)
+
+	var received []*message.Message
+	for i := 0; i < 3; i++ {
+		received = append(received, receiveMessage(t, messages))
+	}
+
+	closed := make(chan error)
+	go func() {
+		closed <- sub.Close()
+	}()
+
+	// messages are acked one by one, Close should wait for all of them processed by workers
+	for _, msg := range received {
+		select {
+		case <-closed:
+			t.Fatal("Close should wait for the messages being processed")
+		case <-time.After(time.Millisecond * 100):
+		}
+
+		msg.Ack()
+	}
+	require.NoError(t, <-closed)
+
+	info, err := newJetstream(t).ConsumerInfo(topic, "durable")
+	require.NoError(t, err)
+	assert.Equal(t, 0, info.NumAckPending, "messages should be acked")
+	assert.Equal(t, uint64(3), info.AckFloor.Consumer)
+}
+
+func TestUnsubscribe(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		ConsumerType jetstream.ConsumerType
+	}{
+		{Name: "push", ConsumerType: jetstream.PushConsumer},
+		{Name: "pull", ConsumerType: jetstream.PullConsumer},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			topic := newStream(t)
+			otherTopic := newStream(t)
+			pub := newPublisher(t)
+
+			sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+				DurableName:  "durable",
+				ConsumerType: tc.ConsumerType,
+				CloseTimeout: time.Second * 5,
+			})
+
+			messages, err := sub.Subscribe(context.Background(), topic)
+			require.NoError(t, err)
+			otherMessages, err := sub.Subscribe(context.Background(), otherTopic)
+			require.NoError(t, err)
+
+			publishMessages(t, pub, topic, 1)
+
+			var msg *message.Message
+			select {
+			case msg = <-messages:
+			case <-time.After(time.Second * 5):
+				t.Fatal("message not received")
+			}
+
+			unsubscribed := make(chan error)
+			go func() {
+				unsubscribed <- sub.Unsubscribe(topic)
+			}()
+
+			select {
+			case <-unsubscribed:
+				t.Fatal("Unsubscribe should wait for the message being processed")
+			case <-time.After(time.Millisecond * 200):
+			}
+
+			msg.Ack()
+			require.NoError(t, <-unsubscribed)
+
+			_, ok := <-messages
+			assert.False(t, ok, "output channel should be closed")
+
+			info, err := newJetstream(t).ConsumerInfo(topic, "durable")
+			require.NoError(t, err)
+			assert.Equal(t, 0, info.NumAckPending, "message should be acked")
+
+			// subscriptions of other topics are still consuming
+			publishMessages(t, pub, otherTopic, 1)
+			receiveMessages(t, otherMessages, 1)
+
+			assert.ErrorIs(t, sub.Unsubscribe(topic), jetstream.ErrNotSubscribed)
+		})
+	}
+}
+
+func TestStartPaused(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		ConsumerType jetstream.ConsumerType
+	}{
+		{Name: "push", ConsumerType: jetstream.PushConsumer},
+		{Name: "pull", ConsumerType: jetstream.PullConsumer},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			topic := newStream(t)
+			pub := newPublisher(t)
+
+			sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+				DurableName:  "durable",
+				ConsumerType: tc.ConsumerType,
+				StartPaused:  true,
+			})
+
+			messages, err := sub.Subscribe(context.Background(), topic)
+			require.NoError(t, err)
+
+			published := publishMessages(t, pub, topic, 1)
+
+			select {
+			case msg := <-messages:
+				t.Fatalf("message %s should not be processed while paused", msg.UUID)
+			case <-time.After(time.Millisecond * 300):
+			}
+
+			sub.Resume()
+			sub.Resume()
+
+			received := receiveMessages(t, messages, 1)
+			assert.Equal(t, published[0].UUID, received[0].UUID)
+		})
+	}
+}
+
+func TestStartPaused_close(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		StartPaused: true,
+	})
+
+	_, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	publishMessages(t, pub, topic, 1)
+	time.Sleep(time.Millisecond * 100)
+
+	// messages waiting for Resume don't block Close
+	closed := make(chan error)
+	go func() {
+		closed <- sub.Close()
+	}()
+
+	select {
+	case err := <-closed:
+		assert.NoError(t, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("Close should not wait for Resume")
+	}
+}
+
+func TestSubscribeMulti(t *testing.T) {
+	topics := []string{newStream(t), newStream(t)}
+	pub := newPublisher(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{})
+
+	outputs, err := sub.SubscribeMulti(context.Background(), topics)
+	require.NoError(t, err)
+	require.Len(t, outputs, 2)
+
+	for _, topic := range topics {
+		published := publishMessages(t, pub, topic, 1)
+		received := receiveMessages(t, outputs[topic], 1)
+		assert.Equal(t, published[0].UUID, received[0].UUID)
+		assert.Equal(t, topic, received[0].Metadata.Get(jetstream.SubjectKey))
+	}
+}
+
+func TestSubscribeMulti_errors(t *testing.T) {
+	topic := newStream(t)
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{})
+
+	_, err := sub.SubscribeMulti(context.Background(), []string{topic, topic})
+	assert.Error(t, err, "duplicated topic should be rejected")
+
+	// the second topic has no stream
+	_, err = sub.SubscribeMulti(context.Background(), []string{topic, "topic_" + watermill.NewShortUUID()})
+	require.Error(t, err)
+
+	_, err = sub.ConsumerInfo(context.Background())
+	assert.ErrorIs(t, err, jetstream.ErrNotSubscribed, "subscription of the first topic should be closed")
+}
+
+func TestCloseProgress(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	var lock sync.Mutex
+	var progress []int
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:           "durable",
+		DrainOnClose:          true,
+		CloseTimeout:          time.Second * 5,
+		CloseProgressInterval: time.Millisecond * 50,
+		CloseProgress: func(remaining int) {
+			lock.Lock()
+			defer lock.Unlock()
+			progress = append(progress, remaining)
+		},
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	publishMessages(t, pub, topic, 1)
+
+	var msg *message.Message
+	select {
+	case msg = <-messages:
+	case <-time.After(time.Second * 5):
+		t.Fatal("message not received")
+	}
+
+	closed := make(chan error)
+	go func() {
+		closed <- sub.Close()
+	}()
+
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(progress) >= 2
+	}, time.Second*2, time.Millisecond*10)
+
+	msg.Ack()
+	require.NoError(t, <-closed)
+
+	lock.Lock()
+	reported := len(progress)
+	for _, remaining := range progress {
+		assert.Equal(t, 1, remaining)
+	}
+	lock.Unlock()
+
+	// not reported after Close returned
+	time.Sleep(time.Millisecond * 150)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Len(t, progress, reported)
+}
+
+func TestGapDetection(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:  "durable",
+		ConsumerType: jetstream.PullConsumer,
+		FetchTimeout: time.Millisecond * 100,
+		GapDetection: true,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	publishMessages(t, pub, topic, 1)
+
+	// fetching is blocked until the message is acked
+	var first *message.Message
+	select {
+	case first = <-messages:
+	case <-time.After(time.Second * 5):
+		t.Fatal("message not received")
+	}
+
+	// the second message is fetched by another subscriber of the consumer
+	publishMessages(t, pub, topic, 1)
+	otherSub, err := newJetstream(t).PullSubscribe(topic, "durable", nats.Bind(topic, "durable"))
+	require.NoError(t, err)
+	fetched, err := otherSub.Fetch(1, nats.MaxWait(time.Second*5))
+	require.NoError(t, err)
+	require.NoError(t, fetched[0].Ack())
+
+	first.Ack()
+	published := publishMessages(t, pub, topic, 1)
+	received := receiveMessages(t, messages, 1)
+	assert.Equal(t, published[0].UUID, received[0].UUID)
+
+	select {
+	case gap := <-sub.SequenceGaps():
+		assert.Equal(t, jetstream.SequenceGap{Topic: topic, Expected: 2, Actual: 3}, gap)
+	case <-time.After(time.Second * 5):
+		t.Fatal("sequence gap not received")
+	}
+
+	select {
+	case gap := <-sub.SequenceGaps():
+		t.Fatalf("unexpected gap: %s", gap)
+	default:
+	}
+}
+
+func TestInactiveThreshold(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Config jetstream.StreamingSubscriberConfig
+	}{
+		{
+			Name:   "ephemeral",
+			Config: jetstream.StreamingSubscriberConfig{InactiveThreshold: time.Minute},
+		},
+		{
+			Name:   "ordered",
+			Config: jetstream.StreamingSubscriberConfig{Ordered: true, InactiveThreshold: time.Minute},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			topic := newStream(t)
+			js := newJetstream(t)
+
+			sub := newSubscriber(t, tc.Config)
+			_, err := sub.Subscribe(context.Background(), topic)
+			require.NoError(t, err)
+
+			var consumers []*nats.ConsumerInfo
+			for info := range js.ConsumersInfo(topic) {
+				consumers = append(consumers, info)
+			}
+			require.Len(t, consumers, 1)
+			assert.Equal(t, time.Minute, consumers[0].Config.InactiveThreshold)
+		})
+	}
+}
+
+func TestConsumerStorage(t *testing.T) {
+	topic := newStream(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:           "durable",
+		ConsumerReplicas:      1,
+		ConsumerMemoryStorage: true,
+	})
+	_, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	info, err := newJetstream(t).ConsumerInfo(topic, "durable")
+	require.NoError(t, err)
+	assert.Equal(t, 1, info.Config.Replicas)
+	assert.True(t, info.Config.MemoryStorage)
+}
+
+func TestConsumerDescription(t *testing.T) {
+	topic := newStream(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:         "durable",
+		ConsumerDescription: "orders consumer",
+		ConsumerMetadata:    map[string]string{"owner": "orders-service"},
+	})
+	_, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	info, err := newJetstream(t).ConsumerInfo(topic, "durable")
+	require.NoError(t, err)
+	assert.Equal(t, "orders consumer", info.Config.Description)
+	// the server adds its own metadata
+	assert.Equal(t, "orders-service", info.Config.Metadata["owner"])
+}
+
+func TestSampleFrequency(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	conn, err := nats.Connect(getNatsURL())
+	require.NoError(t, err)
+	t.Cleanup(conn.Close)
+
+	advisories, err := conn.SubscribeSync("$JS.EVENT.METRIC.CONSUMER.ACK." + topic + ".durable")
+	require.NoError(t, err)
+	require.NoError(t, conn.Flush())
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:     "durable",
+		SampleFrequency: "100%",
+	})
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	info, err := newJetstream(t).ConsumerInfo(topic, "durable")
+	require.NoError(t, err)
+	assert.Equal(t, "100%", info.Config.SampleFrequency)
+
+	publishMessages(t, pub, topic, 1)
+	receiveMessages(t, messages, 1)
+
+	_, err = advisories.NextMsg(time.Second * 5)
+	assert.NoError(t, err, "the ack should be sampled")
+}
+
+func TestFlowControl(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:   "durable",
+		FlowControl:   true,
+		IdleHeartbeat: time.Second,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	published := publishMessages(t, pub, topic, 3)
+	received := receiveMessages(t, messages, len(published))
+	assert.Equal(t, messageUUIDs(published), messageUUIDs(received))
+
+	info, err := newJetstream(t).ConsumerInfo(topic, "durable")
+	require.NoError(t, err)
+	assert.True(t, info.Config.FlowControl)
+	assert.Equal(t, time.Second, info.Config.Heartbeat)
+}
+
+func TestRateLimitBitsPerSec(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:         "durable",
+		RateLimitBitsPerSec: 1024 * 1024,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	published := publishMessages(t, pub, topic, 3)
+	received := receiveMessages(t, messages, len(published))
+	assert.Equal(t, messageUUIDs(published), messageUUIDs(received))
+
+	info, err := newJetstream(t).ConsumerInfo(topic, "durable")
+	require.NoError(t, err)
+	assert.EqualValues(t, 1024*1024, info.Config.RateLimit)
+}
+
+func TestSubscribe_durable_name_of_other_queue_group(t *testing.T) {
+	topic := newStream(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName: "durable",
+		QueueGroup:  "first_group",
+	})
+	_, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	otherSub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName: "durable",
+		QueueGroup:  "second_group",
+	})
+	_, err = otherSub.Subscribe(context.Background(), topic)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `was created for queue group "first_group"`)
+}
+
+func TestSubscribe_durable_name_of_other_topic(t *testing.T) {
+	js := newJetstream(t)
+
+	stream := "orders_" + watermill.NewShortUUID()
+	require.NoError(t, jetstream.EnsureStream(js, jetstream.StreamConfig{
+		Name:     stream,
+		Subjects: []string{stream + ".>"},
+	}))
+	t.Cleanup(func() {
+		_ = js.DeleteStream(stream)
+	})
+
+	testCases := []struct {
+		Name         string
+		ConsumerType jetstream.ConsumerType
+	}{
+		{Name: "push", ConsumerType: jetstream.PushConsumer},
+		{Name: "pull", ConsumerType: jetstream.PullConsumer},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+				DurableName:  "durable_" + tc.Name,
+				ConsumerType: tc.ConsumerType,
+			})
+
+			_, err := sub.Subscribe(context.Background(), stream+".created")
+			require.NoError(t, err)
+
+			_, err = sub.Subscribe(context.Background(), stream+".shipped")
+			require.Error(t, err)
+			assert.ErrorIs(t, err, jetstream.ErrInvalidConfig)
+			assert.Contains(t, err.Error(), "was created for subjects ["+stream+".created]")
+		})
+	}
+}
+
+func TestMaxAckPending(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		DurableName:      "durable",
+		QueueGroup:       "group",
+		SubscribersCount: 5,
+		MaxAckPending:    2,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	published := publishMessages(t, pub, topic, 5)
+	received := receiveMessages(t, messages, len(published))
+	assert.ElementsMatch(t, messageUUIDs(published), messageUUIDs(received))
+
+	info, err := newJetstream(t).ConsumerInfo(topic, "durable")
+	require.NoError(t, err)
+	assert.Equal(t, 2, info.Config.MaxAckPending)
+}
+
+func TestHandlerConcurrency(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Config jetstream.StreamingSubscriberConfig
+	}{
+		{
+			Name:   "push",
+			Config: jetstream.StreamingSubscriberConfig{HandlerConcurrency: 3},
+		},
+		{
+			Name: "pull",
+			Config: jetstream.StreamingSubscriberConfig{
+				DurableName:        "durable",
+				ConsumerType:       jetstream.PullConsumer,
+				FetchBatchSize:     3,
+				FetchTimeout:       time.Millisecond * 100,
+				HandlerConcurrency: 3,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			topic := newStream(t)
+			pub := newPublisher(t)
+
+			tc.Config.CloseTimeout = time.Second
+			sub := newSubscriber(t, tc.Config)
+
+			messages, err := sub.Subscribe(context.Background(), topic)
+			require.NoError(t, err)
+
+			published := publishMessages(t, pub, topic, 4)
+
+			// a single subscription delivers the next message before previous ones are acked
+			var received []*message.Message
+			for len(received) < 3 {
+				select {
+				case msg := <-messages:
+					received = append(received, msg)
+				case <-time.After(time.Second * 5):
+					t.Fatalf("received %d of 3 messages without acking", len(received))
+				}
+			}
+
+			select {
+			case msg := <-messages:
+				t.Fatalf("message %s received while all workers are busy", msg.UUID)
+			case <-time.After(time.Millisecond * 200):
+			}
+
+			received[0].Ack()
+			received = append(received, receiveMessages(t, messages, 1)...)
+			assert.ElementsMatch(t, messageUUIDs(published), messageUUIDs(received))
+
+			// workers waiting for acks are drained within CloseTimeout
+			start := time.Now()
+			require.NoError(t, sub.Close())
+			assert.Less(t, time.Since(start), time.Second*2)
+
+			_, open := <-messages
+			assert.False(t, open)
+		})
+	}
+}
+
+func TestOutputChannelBuffer(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		HandlerConcurrency:  3,
+		OutputChannelBuffer: 3,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	published := publishMessages(t, pub, topic, 3)
+
+	// messages are buffered before they are received
+	assert.Eventually(t, func() bool {
+		return len(messages) == len(published)
+	}, time.Second*5, time.Millisecond*10)
+	assert.Equal(t, 3, cap(messages))
+
+	received := receiveMessages(t, messages, len(published))
+	assert.ElementsMatch(t, messageUUIDs(published), messageUUIDs(received))
+}
+
+func TestOrdered(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		Ordered:          true,
+		SubscribersCount: 4,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	published := publishMessages(t, pub, topic, 50)
+
+	received := receiveMessages(t, messages, len(published))
+	for _, msg := range received {
+		msg.Ack()
+	}
+
+	assert.Equal(t, messageUUIDs(published), messageUUIDs(received))
+}
+
+func TestAckPolicy(t *testing.T) {
+	testCases := []struct {
+		Name              string
+		Config            jetstream.StreamingSubscriberConfig
+		ExpectedAckPolicy nats.AckPolicy
+	}{
+		{
+			Name:              "explicit",
+			Config:            jetstream.StreamingSubscriberConfig{DurableName: "durable"},
+			ExpectedAckPolicy: nats.AckExplicitPolicy,
+		},
+		{
+			Name: "all",
+			Config: jetstream.StreamingSubscriberConfig{
+				DurableName: "durable",
+				AckPolicy:   jetstream.AckAll,
+			},
+			ExpectedAckPolicy: nats.AckAllPolicy,
+		},
+		{
+			Name: "none",
+			Config: jetstream.StreamingSubscriberConfig{
+				DurableName: "durable",
+				AckPolicy:   jetstream.AckNone,
+			},
+			ExpectedAckPolicy: nats.AckNonePolicy,
+		},
+		{
+			Name: "none_pull",
+			Config: jetstream.StreamingSubscriberConfig{
+				DurableName:  "durable",
+				ConsumerType: jetstream.PullConsumer,
+				AckPolicy:    jetstream.AckNone,
+			},
+			ExpectedAckPolicy: nats.AckNonePolicy,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			topic := newStream(t)
+			pub := newPublisher(t)
+			sub := newSubscriber(t, tc.Config)
+
+			messages, err := sub.Subscribe(context.Background(), topic)
+			require.NoError(t, err)
+
+			published := publishMessages(t, pub, topic, 3)
+			received := receiveMessages(t, messages, len(published))
+			assert.ElementsMatch(t, messageUUIDs(published), messageUUIDs(received))
+
+			info, err := newJetstream(t).ConsumerInfo(topic, "durable")
+			require.NoError(t, err)
+			assert.Equal(t, tc.ExpectedAckPolicy, info.Config.AckPolicy)
+			assert.Equal(t, 0, info.NumAckPending)
+		})
+	}
+}
+
+func TestAckPolicy_none_does_not_wait_for_ack(t *testing.T) {
+	topic := newStream(t)
+	pub := newPublisher(t)
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		AckPolicy:      jetstream.AckNone,
+		AckWaitTimeout: time.Millisecond * 100,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	published := publishMessages(t, pub, topic, 3)
+
+	// messages are not acked, so the next one is received only if the subscriber doesn't wait for the ack
+	var received []*message.Message
+	for len(received) < len(published) {
+		select {
+		case msg := <-messages:
+			received = append(received, msg)
+		case <-time.After(time.Second * 10):
+			t.Fatalf("received %d of %d messages", len(received), len(published))
+		}
+	}
+	assert.Equal(t, messageUUIDs(published), messageUUIDs(received))
+
+	select {
+	case msg := <-messages:
+		t.Fatalf("message %s should not be redelivered", msg.UUID)
+	case <-time.After(time.Millisecond * 300):
+	}
+}
+
+func TestNewStreamingSubscriberWithNatsConn_shared_connection(t *testing.T) {
+	topic := newStream(t)
+
+	conn, err := nats.Connect(getNatsURL())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	pub, err := jetstream.NewStreamingPublisherWithNatsConn(conn, jetstream.StreamingPublisherPublishConfig{
+		Marshaler: jetstream.GobMarshaler{},
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+
+	newSharedSubscriber := func() *jetstream.StreamingSubscriber {
+		sub, err := jetstream.NewStreamingSubscriberWithNatsConn(conn, jetstream.StreamingSubscriberSubscriptionConfig{
+			DurableName: "durable",
+			Unmarshaler: jetstream.GobMarshaler{},
+		}, watermill.NewStdLogger(true, false))
+		require.NoError(t, err)
+
+		return sub
+	}
+
+	sub := newSharedSubscriber()
+	_, err = sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	require.NoError(t, sub.Close())
+	assert.True(t, conn.IsConnected(), "shared connection should not be closed by the subscriber")
+
+	info, err := newJetstream(t).ConsumerInfo(topic, "durable")
+	require.NoError(t, err)
+	assert.False(t, info.PushBound, "subscription should be closed")
+
+	sub = newSharedSubscriber()
+	defer func() {
+		_ = sub.Close()
+	}()
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	published := publishMessages(t, pub, topic, 1)
+	received := receiveMessages(t, messages, 1)
+	assert.Equal(t, published[0].UUID, received[0].UUID)
+}
+
+func messageUUIDs(messages []*message.Message) []string {
+	var uuids []string
+	for _, msg := range messages {
+		uuids = append(uuids, msg.UUID)
+	}
+
+	return uuids
+}
+
+func TestStreamingSubscriberSubscriptionConfig_Validate(t *testing.T) {
+	dedup, err := jetstream.NewMemoryDeduplicationStore(10, time.Minute)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		Name        string
+		Config      jetstream.StreamingSubscriberSubscriptionConfig
+		ExpectedErr bool
+	}{
+		{
+			Name:   "valid",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{},
+		},
+		{
+			Name: "subscribers_count_without_queue_group",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				SubscribersCount: 2,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "durable_name_with_subscribers_count_without_queue_group",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				DurableName:      "durable",
+				SubscribersCount: 2,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "durable_name_with_subscribers_count_and_queue_group",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				DurableName:      "durable",
+				QueueGroup:       "group",
+				SubscribersCount: 2,
+			},
+		},
+		{
+			Name: "valid_durable_name",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				DurableName: "orders-v1_2",
+			},
+		},
+		{
+			Name: "durable_name_with_dot",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				DurableName: "orders.v1",
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "durable_name_with_wildcard",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				DurableName: "orders*",
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "queue_group_with_whitespace",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				QueueGroup: "orders group",
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "queue_group_with_path_separator",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				QueueGroup: "orders/group",
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "gap_detection",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				GapDetection: true,
+			},
+		},
+		{
+			Name: "gap_detection_with_queue_group",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				QueueGroup:   "group",
+				GapDetection: true,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "gap_detection_with_pull_consumer_subscribers",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				DurableName:      "durable",
+				ConsumerType:     jetstream.PullConsumer,
+				SubscribersCount: 2,
+				GapDetection:     true,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "ordered_with_gap_detection",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				Ordered:      true,
+				GapDetection: true,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "inactive_threshold",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				InactiveThreshold: time.Minute,
+			},
+		},
+		{
+			Name: "negative_inactive_threshold",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				InactiveThreshold: -time.Minute,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "inactive_threshold_with_durable_name",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				DurableName:       "durable",
+				InactiveThreshold: time.Minute,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "inactive_threshold_with_queue_group",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				QueueGroup:        "group",
+				InactiveThreshold: time.Minute,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "consumer_replicas",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				ConsumerReplicas:      3,
+				ConsumerMemoryStorage: true,
+			},
+		},
+		{
+			Name: "negative_consumer_replicas",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				ConsumerReplicas: -1,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "consumer_metadata",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				ConsumerDescription: "orders consumer",
+				ConsumerMetadata:    map[string]string{"owner": "orders-service"},
+			},
+		},
+		{
+			Name: "sample_frequency_percent",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				SampleFrequency: "100%",
+			},
+		},
+		{
+			Name: "sample_frequency_number",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				SampleFrequency: "25",
+			},
+		},
+		{
+			Name: "sample_frequency_above_100",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				SampleFrequency: "101%",
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "sample_frequency_not_number",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				SampleFrequency: "all",
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "sample_frequency_with_ack_none",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				AckPolicy:       jetstream.AckNone,
+				SampleFrequency: "100%",
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "rate_limit",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				RateLimitBitsPerSec: 1024,
+			},
+		},
+		{
+			Name: "rate_limit_with_pull_consumer",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				DurableName:         "durable",
+				ConsumerType:        jetstream.PullConsumer,
+				RateLimitBitsPerSec: 1024,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "rate_limit_with_ordered",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				Ordered:             true,
+				RateLimitBitsPerSec: 1024,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "deduplication_store_with_ack_none",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				AckPolicy:          jetstream.AckNone,
+				DeduplicationStore: dedup,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "deduplication_store_with_ordered",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				Ordered:            true,
+				DeduplicationStore: dedup,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "deduplication_store_with_batch_ack",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				ConsumerType:       jetstream.PullConsumer,
+				AckPolicy:          jetstream.AckAll,
+				BatchAck:           true,
+				DeduplicationStore: dedup,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "consumer_metadata_empty_key",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				ConsumerMetadata: map[string]string{"": "orders-service"},
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "consumer_metadata_empty_value",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				ConsumerMetadata: map[string]string{"owner": ""},
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "consumer_description_with_ordered",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				Ordered:             true,
+				ConsumerDescription: "orders consumer",
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "consumer_memory_storage_with_ordered",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				Ordered:               true,
+				ConsumerMemoryStorage: true,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "flow_control",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				FlowControl:   true,
+				IdleHeartbeat: time.Second,
+			},
+		},
+		{
+			Name: "flow_control_without_idle_heartbeat",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				FlowControl: true,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "negative_idle_heartbeat",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				IdleHeartbeat: -time.Second,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "idle_heartbeat_with_queue_group",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				QueueGroup:    "group",
+				IdleHeartbeat: time.Second,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "idle_heartbeat_with_pull_consumer",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				DurableName:   "durable",
+				ConsumerType:  jetstream.PullConsumer,
+				IdleHeartbeat: time.Second,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "ordered_with_flow_control",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				Ordered:       true,
+				FlowControl:   true,
+				IdleHeartbeat: time.Second,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "negative_max_deliver",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				MaxDeliver: -1,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "negative_max_redeliver_then_ack",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				MaxRedeliverThenAck: -1,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "max_redeliver_then_ack_within_max_deliver",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				MaxDeliver:          4,
+				MaxRedeliverThenAck: 2,
+			},
+		},
+		{
+			Name: "max_redeliver_then_ack_exceeding_max_deliver",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				MaxDeliver:          3,
+				MaxRedeliverThenAck: 2,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "batch_ack",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				DurableName:  "durable",
+				ConsumerType: jetstream.PullConsumer,
+				AckPolicy:    jetstream.AckAll,
+				BatchAck:     true,
+			},
+		},
+		{
+			Name: "batch_ack_with_push_consumer",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				AckPolicy: jetstream.AckAll,
+				BatchAck:  true,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "batch_ack_without_ack_all",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				DurableName:  "durable",
+				ConsumerType: jetstream.PullConsumer,
+				AckPolicy:    jetstream.AckExplicit,
+				BatchAck:     true,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "batch_ack_with_subscribers_count",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				DurableName:      "durable",
+				ConsumerType:     jetstream.PullConsumer,
+				AckPolicy:        jetstream.AckAll,
+				BatchAck:         true,
+				SubscribersCount: 2,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "batch_ack_with_max_redeliver_then_ack",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				DurableName:         "durable",
+				ConsumerType:        jetstream.PullConsumer,
+				AckPolicy:           jetstream.AckAll,
+				BatchAck:            true,
+				MaxRedeliverThenAck: 2,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "batch_ack_with_unmarshal_error_ack",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				DurableName:      "durable",
+				ConsumerType:     jetstream.PullConsumer,
+				AckPolicy:        jetstream.AckAll,
+				BatchAck:         true,
+				OnUnmarshalError: jetstream.UnmarshalErrorAck,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "batch_ack_with_nak_delay",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				DurableName:  "durable",
+				ConsumerType: jetstream.PullConsumer,
+				AckPolicy:    jetstream.AckAll,
+				BatchAck:     true,
+				NakDelay:     time.Second,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "back_off_within_max_deliver",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				MaxDeliver: 2,
+				BackOff:    []time.Duration{time.Second, time.Second * 2},
+			},
+		},
+		{
+			Name: "back_off_exceeding_max_deliver",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				MaxDeliver: 1,
+				BackOff:    []time.Duration{time.Second, time.Second * 2},
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "non_positive_back_off",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				BackOff: []time.Duration{0},
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "negative_nak_delay",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				NakDelay: -time.Second,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "ack_progress_interval",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				AckWaitTimeout:      time.Second,
+				AckProgressInterval: time.Millisecond * 100,
+			},
+		},
+		{
+			Name: "ack_progress_interval_not_shorter_than_ack_wait_timeout",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				AckWaitTimeout:      time.Second,
+				AckProgressInterval: time.Second,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "dead_letter_topic",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				MaxDeliver:      3,
+				DeadLetterTopic: "dead_letters",
+			},
+		},
+		{
+			Name: "dead_letter_topic_without_max_deliver",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				DeadLetterTopic: "dead_letters",
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "on_unmarshal_error_dead_letter",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				DeadLetterTopic:  "dead_letters",
+				OnUnmarshalError: jetstream.UnmarshalErrorDeadLetter,
+			},
+		},
+		{
+			Name: "on_unmarshal_error_dead_letter_without_dead_letter_topic",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				OnUnmarshalError: jetstream.UnmarshalErrorDeadLetter,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "unknown_on_unmarshal_error",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				OnUnmarshalError: jetstream.UnmarshalErrorPolicy(100),
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "on_unmarshal_error_with_ack_none",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				AckPolicy:        jetstream.AckNone,
+				OnUnmarshalError: jetstream.UnmarshalErrorTerm,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "on_unmarshal_error_with_ordered",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				Ordered:          true,
+				OnUnmarshalError: jetstream.UnmarshalErrorTerm,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "deliver_by_start_sequence",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				DeliverPolicy: jetstream.DeliverByStartSequence,
+				OptStartSeq:   10,
+			},
+		},
+		{
+			Name: "deliver_by_start_sequence_without_sequence",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				DeliverPolicy: jetstream.DeliverByStartSequence,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "deliver_by_start_time_with_sequence",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				DeliverPolicy: jetstream.DeliverByStartTime,
+				OptStartTime:  time.Now(),
+				OptStartSeq:   10,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "deliver_new_with_start_time",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				DeliverPolicy: jetstream.DeliverNew,
+				OptStartTime:  time.Now(),
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "unknown_deliver_policy",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				DeliverPolicy: jetstream.DeliverPolicy(100),
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "filter_subject_and_filter_subjects",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				FilterSubject:  "orders.created",
+				FilterSubjects: []string{"orders.paid"},
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "negative_max_ack_pending",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				MaxAckPending: -1,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "ordered",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				Ordered: true,
+			},
+		},
+		{
+			Name: "ordered_with_queue_group",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				Ordered:    true,
+				QueueGroup: "group",
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "ordered_with_max_deliver",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				Ordered:    true,
+				MaxDeliver: 3,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "output_channel_buffer",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				OutputChannelBuffer: 10,
+			},
+		},
+		{
+			Name: "negative_output_channel_buffer",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				OutputChannelBuffer: -1,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "ack_sync",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				AckMode: jetstream.AckSync,
+			},
+		},
+		{
+			Name: "unknown_ack_mode",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				AckMode: jetstream.AckMode(10),
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "ack_sync_with_ack_none",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				AckMode:   jetstream.AckSync,
+				AckPolicy: jetstream.AckNone,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "ordered_with_ack_sync",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				Ordered: true,
+				AckMode: jetstream.AckSync,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "ordered_with_handler_concurrency",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				Ordered:            true,
+				HandlerConcurrency: 2,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "ack_wait_jitter",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				AckWaitJitter: time.Second,
+			},
+		},
+		{
+			Name: "negative_ack_wait_jitter",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				AckWaitJitter: -time.Second,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "ack_wait_jitter_with_back_off",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				AckWaitJitter: time.Second,
+				MaxDeliver:    3,
+				BackOff:       []time.Duration{time.Second},
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "ack_wait_jitter_with_ack_progress_interval",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				AckWaitJitter:       time.Second,
+				AckWaitTimeout:      time.Second * 10,
+				AckProgressInterval: time.Second,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "ack_none",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				AckPolicy: jetstream.AckNone,
+			},
+		},
+		{
+			Name: "ack_none_with_max_deliver",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				AckPolicy:  jetstream.AckNone,
+				MaxDeliver: 3,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "unknown_ack_policy",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				AckPolicy: jetstream.AckPolicy(100),
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "ordered_with_ack_policy",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				Ordered:   true,
+				AckPolicy: jetstream.AckAll,
+			},
+			ExpectedErr: true,
+		},
+		{
+			Name: "pull_consumer_without_durable_name",
+			Config: jetstream.StreamingSubscriberSubscriptionConfig{
+				ConsumerType: jetstream.PullConsumer,
+			},
+			ExpectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			tc.Config.Unmarshaler = jetstream.GobMarshaler{}
+
+			err := tc.Config.Validate()
+			if tc.ExpectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package jetstream
+
+import (
+	nats "github.com/nats-io/nats.go"
+
+	"github.com/ThreeDotsLabs/watermill"
+)
+
+// LastError returns the last asynchronous error reported by NATS for subscriptions of topic,
+// for example nats.ErrSlowConsumer or a permissions violation. It's nil when no error was reported.
+//
+// Errors are not cleared when the subscription recovers, they are forgotten only with Unsubscribe.
+func (s *StreamingSubscriber) LastError(topic string) error {
+	s.lastErrorsLock.Lock()
+	defer s.lastErrorsLock.Unlock()
+
+	return s.lastErrors[topic]
+}
+
+// recordSubscriptionError records err as the last error of the topic of sub, it's called by the error handler
+// of the connection (see addConnSubscriber). Errors of subscriptions not made by the subscriber are ignored,
+// as the connection can be shared.
+func (s *StreamingSubscriber) recordSubscriptionError(sub *nats.Subscription, err error) {
+	if sub == nil {
+		return
+	}
+
+	topic, ok := s.subscriptionTopic(sub)
+	if !ok {
+		return
+	}
+
+	s.lastErrorsLock.Lock()
+	s.lastErrors[topic] = err
+	s.lastErrorsLock.Unlock()
+
+	s.logger.Error("Subscription error", err, watermill.LogFields{"topic": topic, "subject": sub.Subject})
+}
+
+func (s *StreamingSubscriber) subscriptionTopic(sub *nats.Subscription) (string, bool) {
+	s.subsLock.RLock()
+	defer s.subsLock.RUnlock()
+
+	for topic, subs := range s.subs {
+		for _, topicSub := range subs {
+			if topicSub.current() == sub {
+				return topic, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// forgetLastError removes the last error of topic after unsubscribing.
+func (s *StreamingSubscriber) forgetLastError(topic string) {
+	s.lastErrorsLock.Lock()
+	defer s.lastErrorsLock.Unlock()
+
+	delete(s.lastErrors, topic)
+}
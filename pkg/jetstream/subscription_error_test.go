@@ -0,0 +1,83 @@
+package jetstream_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+)
+
+func TestLastError(t *testing.T) {
+	handlerErrors := make(chan error, 1)
+	conn, err := nats.Connect(getNatsURL(), nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+		handlerErrors <- err
+	}))
+	require.NoError(t, err)
+	t.Cleanup(conn.Close)
+
+	sub, err := jetstream.NewStreamingSubscriberWithNatsConn(conn, jetstream.StreamingSubscriberSubscriptionConfig{
+		Unmarshaler: jetstream.GobMarshaler{},
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = sub.Close()
+	})
+
+	topic := newStream(t)
+	otherTopic := newStream(t)
+
+	_, err = sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+	_, err = sub.Subscribe(context.Background(), otherTopic)
+	require.NoError(t, err)
+
+	assert.NoError(t, sub.LastError(topic))
+
+	// async errors are reported by nats.go through the error handler of the connection
+	natsSubs := jetstream.Subscriptions(sub, topic)
+	require.NotEmpty(t, natsSubs)
+	conn.Opts.AsyncErrorCB(conn, natsSubs[0], nats.ErrSlowConsumer)
+
+	assert.ErrorIs(t, sub.LastError(topic), nats.ErrSlowConsumer)
+	assert.NoError(t, sub.LastError(otherTopic), "errors of other topics should not be affected")
+	assert.ErrorIs(t, <-handlerErrors, nats.ErrSlowConsumer, "the error handler of the connection should be called")
+
+	require.NoError(t, sub.Unsubscribe(topic))
+	assert.NoError(t, sub.LastError(topic), "the error should be forgotten after Unsubscribe")
+}
+
+func TestLastError_closed_subscriber(t *testing.T) {
+	handlerErrors := make(chan error, 2)
+	conn, err := nats.Connect(getNatsURL(), nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+		handlerErrors <- err
+	}))
+	require.NoError(t, err)
+	t.Cleanup(conn.Close)
+
+	sub, err := jetstream.NewStreamingSubscriberWithNatsConn(conn, jetstream.StreamingSubscriberSubscriptionConfig{
+		Unmarshaler: jetstream.GobMarshaler{},
+	}, nil)
+	require.NoError(t, err)
+
+	topic := newStream(t)
+	_, err = sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	natsSubs := jetstream.Subscriptions(sub, topic)
+	require.NotEmpty(t, natsSubs)
+
+	require.NoError(t, sub.Close())
+	assert.Equal(t, 0, jetstream.ConnSubscribers(conn))
+
+	// the error handler of the shared connection is restored after Close
+	conn.Opts.AsyncErrorCB(conn, natsSubs[0], nats.ErrSlowConsumer)
+
+	assert.ErrorIs(t, <-handlerErrors, nats.ErrSlowConsumer)
+	assert.NoError(t, sub.LastError(topic), "errors should not be recorded by the closed subscriber")
+}
@@ -0,0 +1,60 @@
+package jetstream
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// TLSFiles are paths to PEM encoded files used to build the *tls.Config of a NATS connection.
+type TLSFiles struct {
+	// CertFile is the client certificate, required together with KeyFile for mutual TLS.
+	CertFile string
+
+	// KeyFile is the private key of the client certificate.
+	KeyFile string
+
+	// CAFile is the certificate of CA used to verify the server.
+	// When empty, system CAs are used.
+	CAFile string
+}
+
+// TLSConfig builds *tls.Config which can be used as StreamingPublisherConfig.TLSConfig
+// or StreamingSubscriberConfig.TLSConfig.
+func (f TLSFiles) TLSConfig() (*tls.Config, error) {
+	if f.CertFile == "" && f.KeyFile != "" {
+		return nil, errors.New("TLSFiles.CertFile is missing, it is required when KeyFile is set")
+	}
+	if f.CertFile != "" && f.KeyFile == "" {
+		return nil, errors.New("TLSFiles.KeyFile is missing, it is required when CertFile is set")
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if f.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot load certificate %s with key %s", f.CertFile, f.KeyFile)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if f.CAFile != "" {
+		caPEM, err := os.ReadFile(f.CAFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read CA file %s", f.CAFile)
+		}
+
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, errors.Errorf("no PEM encoded certificates found in CA file %s", f.CAFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,81 @@
+package jetstream_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+)
+
+// writeSelfSignedCert writes a self-signed certificate and its key to dir, returning their paths.
+func writeSelfSignedCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "watermill-jetstream"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	return certFile, keyFile
+}
+
+func TestTLSFiles_TLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSignedCert(t, dir)
+
+	tlsConfig, err := jetstream.TLSFiles{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		CAFile:   certFile,
+	}.TLSConfig()
+	require.NoError(t, err)
+	assert.Len(t, tlsConfig.Certificates, 1)
+	assert.NotNil(t, tlsConfig.RootCAs)
+
+	invalidCAFile := filepath.Join(dir, "invalid.pem")
+	require.NoError(t, os.WriteFile(invalidCAFile, []byte("not a certificate"), 0600))
+
+	testCases := []struct {
+		Name  string
+		Files jetstream.TLSFiles
+	}{
+		{Name: "missing_key_file", Files: jetstream.TLSFiles{CertFile: certFile}},
+		{Name: "missing_cert_file", Files: jetstream.TLSFiles{KeyFile: keyFile}},
+		{Name: "not_existing_ca_file", Files: jetstream.TLSFiles{CAFile: filepath.Join(dir, "missing.pem")}},
+		{Name: "invalid_ca_file", Files: jetstream.TLSFiles{CAFile: invalidCAFile}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := tc.Files.TLSConfig()
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package jetstream
+
+import (
+	"sync"
+)
+
+// topicMarshalers holds marshalers registered with SetTopicMarshaler.
+type topicMarshalers struct {
+	lock       sync.RWMutex
+	marshalers map[string]Marshaler
+}
+
+func newTopicMarshalers() *topicMarshalers {
+	return &topicMarshalers{marshalers: map[string]Marshaler{}}
+}
+
+func (t *topicMarshalers) set(topic string, m Marshaler) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if m == nil {
+		delete(t.marshalers, topic)
+		return
+	}
+	t.marshalers[topic] = m
+}
+
+// get returns the marshaler of topic, or fallback when no marshaler is registered for it.
+func (t *topicMarshalers) get(topic string, fallback Marshaler) Marshaler {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if m, ok := t.marshalers[topic]; ok {
+		return m
+	}
+
+	return fallback
+}
+
+// topicUnmarshalers holds unmarshalers registered with SetTopicUnmarshaler.
+type topicUnmarshalers struct {
+	lock         sync.RWMutex
+	unmarshalers map[string]Unmarshaler
+}
+
+func newTopicUnmarshalers() *topicUnmarshalers {
+	return &topicUnmarshalers{unmarshalers: map[string]Unmarshaler{}}
+}
+
+func (t *topicUnmarshalers) set(topic string, u Unmarshaler) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if u == nil {
+		delete(t.unmarshalers, topic)
+		return
+	}
+	t.unmarshalers[topic] = u
+}
+
+// get returns the unmarshaler of topic, or fallback when no unmarshaler is registered for it.
+func (t *topicUnmarshalers) get(topic string, fallback Unmarshaler) Unmarshaler {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if u, ok := t.unmarshalers[topic]; ok {
+		return u
+	}
+
+	return fallback
+}
+
+// SetTopicMarshaler sets the marshaler used for messages published to topic.
+//
+// The topic marshaler takes precedence over StreamingPublisherConfig.Marshaler, which is used
+// for topics without one. It is also used for ContentTypeHdr and for reference messages of payloads
+// stored in the Object Store. Passing nil m removes the topic marshaler.
+//
+// It's safe to call it concurrently with Publish, it affects messages published after it returns.
+func (p StreamingPublisher) SetTopicMarshaler(topic string, m Marshaler) {
+	p.topicMarshalers.set(topic, m)
+}
+
+// SetTopicUnmarshaler sets the unmarshaler used for messages received from topic,
+// the same topic as passed to Subscribe.
+//
+// The topic unmarshaler takes precedence over StreamingSubscriberConfig.Unmarshaler, which is used
+// for topics without one. Passing nil u removes the topic unmarshaler.
+// Messages published to DeadLetterTopic and replies sent with Reply are still marshaled
+// with StreamingSubscriberConfig.Unmarshaler.
+//
+// It's safe to call it concurrently with Subscribe, it affects messages received after it returns.
+func (s *StreamingSubscriber) SetTopicUnmarshaler(topic string, u Unmarshaler) {
+	s.topicUnmarshalers.set(topic, u)
+}
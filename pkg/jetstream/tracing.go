@@ -0,0 +1,113 @@
+package jetstream
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+const (
+	// OutcomeAttributeKey is the attribute of receive spans with the outcome of processing the message:
+	// "ack", "ack_failed", "nack", "ack_timeout", "discarded", "duplicate" (with DeduplicationStore)
+	// or "forwarded" (with AckNone).
+	OutcomeAttributeKey = attribute.Key("messaging.watermill.outcome")
+
+	messagingSystemKey      = attribute.Key("messaging.system")
+	messagingDestinationKey = attribute.Key("messaging.destination.name")
+	messagingMessageIDKey   = attribute.Key("messaging.message.id")
+)
+
+// noopSpan is returned when tracing is disabled, so spans can be ended unconditionally.
+var noopSpan = trace.SpanFromContext(context.Background())
+
+// headerCarrier adapts nats.Header to propagation.TextMapCarrier.
+type headerCarrier nats.Header
+
+func (c headerCarrier) Get(key string) string {
+	return nats.Header(c).Get(key)
+}
+
+func (c headerCarrier) Set(key string, value string) {
+	nats.Header(c).Set(key, value)
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+func messageAttributes(subject string, messageUUID string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		messagingSystemKey.String("nats"),
+		messagingDestinationKey.String(subject),
+		messagingMessageIDKey.String(messageUUID),
+	}
+}
+
+// startPublishSpan starts the producer span of publishing natsMsg and injects its context into natsMsg headers,
+// using the global propagator (see otel.SetTextMapPropagator).
+func startPublishSpan(ctx context.Context, tracer trace.Tracer, topic string, messageUUID string, natsMsg *nats.Msg) trace.Span {
+	if tracer == nil {
+		return noopSpan
+	}
+
+	ctx, span := tracer.Start(
+		ctx,
+		topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(messageAttributes(topic, messageUUID)...),
+	)
+
+	if natsMsg.Header == nil {
+		natsMsg.Header = nats.Header{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(natsMsg.Header))
+
+	return span
+}
+
+// publishSpanParent returns ctx passed to PublishWithContext when it carries a span, otherwise the context of msg.
+func publishSpanParent(ctx context.Context, msg *message.Message) context.Context {
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+
+	return msg.Context()
+}
+
+// startReceiveSpan starts the consumer span of processing m, linked to the producer span
+// extracted from m headers.
+func startReceiveSpan(ctx context.Context, tracer trace.Tracer, m *nats.Msg, messageUUID string) (context.Context, trace.Span) {
+	if tracer == nil {
+		return ctx, noopSpan
+	}
+
+	producerCtx := otel.GetTextMapPropagator().Extract(context.Background(), headerCarrier(m.Header))
+
+	return tracer.Start(
+		ctx,
+		m.Subject+" receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(trace.LinkFromContext(producerCtx)),
+		trace.WithAttributes(messageAttributes(m.Subject, messageUUID)...),
+	)
+}
+
+// endSpan records err, when it's not nil, and ends span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,74 @@
+package jetstream_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+)
+
+func TestTracing(t *testing.T) {
+	propagator := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(propagator)
+
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("watermill-jetstream")
+
+	topic := newStream(t)
+
+	pub, err := jetstream.NewNatsStreamingPublisher(jetstream.StreamingPublisherConfig{
+		URL:       getNatsURL(),
+		Marshaler: jetstream.GobMarshaler{},
+		Tracer:    tracer,
+	}, watermill.NewStdLogger(true, false))
+	require.NoError(t, err)
+	defer func() {
+		_ = pub.Close()
+	}()
+
+	sub := newSubscriber(t, jetstream.StreamingSubscriberConfig{
+		Tracer: tracer,
+	})
+
+	messages, err := sub.Subscribe(context.Background(), topic)
+	require.NoError(t, err)
+
+	published := message.NewMessage(watermill.NewUUID(), []byte("payload"))
+	require.NoError(t, pub.Publish(topic, published))
+
+	received := receiveMessages(t, messages, 1)[0]
+	receiveSpanContext := trace.SpanContextFromContext(received.Context())
+	assert.True(t, receiveSpanContext.IsValid(), "receive span should be set in the message context")
+
+	require.NoError(t, sub.Close())
+
+	spans := map[trace.SpanKind]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.SpanKind()] = span
+	}
+	require.Len(t, spans, 2)
+
+	publishSpan := spans[trace.SpanKindProducer]
+	assert.Contains(t, publishSpan.Attributes(), attribute.String("messaging.destination.name", topic))
+	assert.Contains(t, publishSpan.Attributes(), attribute.String("messaging.message.id", published.UUID))
+
+	receiveSpan := spans[trace.SpanKindConsumer]
+	assert.Equal(t, receiveSpanContext.SpanID(), receiveSpan.SpanContext().SpanID())
+	assert.Contains(t, receiveSpan.Attributes(), attribute.String("messaging.message.id", published.UUID))
+	assert.Contains(t, receiveSpan.Attributes(), jetstream.OutcomeAttributeKey.String("ack"))
+	require.Len(t, receiveSpan.Links(), 1)
+	assert.Equal(t, publishSpan.SpanContext().SpanID(), receiveSpan.Links()[0].SpanContext.SpanID())
+}
@@ -0,0 +1,57 @@
+package jetstream
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+)
+
+// ValidatingPublisher is a publisher decorated with validation of published messages.
+type ValidatingPublisher struct {
+	pub      message.Publisher
+	validate func(*message.Message) error
+}
+
+// NewValidatingPublisher returns pub publishing messages only when all of them are accepted by validate.
+// Messages are validated before they are passed to pub, so invalid messages are not marshaled.
+func NewValidatingPublisher(pub message.Publisher, validate func(*message.Message) error) (*ValidatingPublisher, error) {
+	if pub == nil {
+		return nil, errors.New("missing publisher")
+	}
+	if validate == nil {
+		return nil, errors.New("missing validate function")
+	}
+
+	return &ValidatingPublisher{pub: pub, validate: validate}, nil
+}
+
+// Publish validates messages and publishes them with the decorated publisher.
+//
+// When one of messages is invalid, none of them are published and the first validation error is returned.
+func (p *ValidatingPublisher) Publish(topic string, messages ...*message.Message) error {
+	for _, msg := range messages {
+		if err := p.validate(msg); err != nil {
+			return errors.Wrapf(err, "message %s is invalid", msg.UUID)
+		}
+	}
+
+	return p.pub.Publish(topic, messages...)
+}
+
+func (p *ValidatingPublisher) Close() error {
+	return p.pub.Close()
+}
+
+// RequireMetadata returns a validate function of NewValidatingPublisher accepting messages
+// which have non-empty metadata with all keys.
+func RequireMetadata(keys ...string) func(*message.Message) error {
+	return func(msg *message.Message) error {
+		for _, key := range keys {
+			if msg.Metadata.Get(key) == "" {
+				return errors.Errorf("missing %s metadata", key)
+			}
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,51 @@
+package jetstream_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/ThreeDotsLabs/watermill-jetstream/pkg/jetstream"
+)
+
+func TestValidatingPublisher(t *testing.T) {
+	topic := newStream(t)
+
+	pub, err := jetstream.NewValidatingPublisher(newPublisher(t), jetstream.RequireMetadata("tenant_id"))
+	require.NoError(t, err)
+
+	newMsg := func(tenantID string) *message.Message {
+		msg := message.NewMessage(watermill.NewUUID(), []byte("payload"))
+		msg.Metadata.Set("tenant_id", tenantID)
+		return msg
+	}
+
+	valid := newMsg("tenant")
+	invalid := newMsg("")
+
+	err = pub.Publish(topic, valid, invalid)
+	assert.ErrorContains(t, err, invalid.UUID)
+	assert.ErrorContains(t, err, "missing tenant_id metadata")
+
+	info, err := newJetstream(t).StreamInfo(topic)
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, info.State.Msgs, "no message should be published when one of them is invalid")
+
+	require.NoError(t, pub.Publish(topic, valid))
+
+	info, err = newJetstream(t).StreamInfo(topic)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, info.State.Msgs)
+}
+
+func TestNewValidatingPublisher_missing_arguments(t *testing.T) {
+	_, err := jetstream.NewValidatingPublisher(nil, jetstream.RequireMetadata("tenant_id"))
+	assert.Error(t, err)
+
+	_, err = jetstream.NewValidatingPublisher(newPublisher(t), nil)
+	assert.Error(t, err)
+}